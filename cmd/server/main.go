@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
 	"vecdb-go/internal/api"
 	"vecdb-go/internal/config"
+	"vecdb-go/internal/embed"
+	"vecdb-go/internal/index"
 	"vecdb-go/internal/vecdb"
 
 	"github.com/gin-gonic/gin"
@@ -26,7 +36,7 @@ func main() {
 	}
 
 	// Load configuration with the selected profile
-	appConfig, err := config.LoadConfigWithProfile(profile)
+	appConfig, err := config.LoadConfigFile(configPath, profile)
 	if err != nil {
 		slog.Error("Error loading config", "error", err, "profile", profile)
 		os.Exit(1)
@@ -40,6 +50,9 @@ func main() {
 	// Configure Gin mode based on log level
 	setupGinMode(appConfig.Server.LogLevel)
 
+	// Bound FAISS threads before any index is created
+	setupFaissThreads(appConfig.Server.FaissNumThreads)
+
 	// Prepare database parameters from config
 	// Initialize VectorDatabase
 	slog.Info("Initializing vector database", "path", appConfig.Database.FilePath, "params", appConfig.Database)
@@ -52,40 +65,129 @@ func main() {
 	// Initialize API handlers with the database
 	api.Initialize(vdb)
 
+	// Let requests send text instead of vectors when an embedding service is configured
+	if appConfig.Embedder.URL != "" {
+		slog.Info("Using embedding service", "url", appConfig.Embedder.URL)
+	}
+	api.SetEmbedder(embed.NewEmbedder(
+		appConfig.Embedder.URL,
+		time.Duration(appConfig.Embedder.TimeoutMs)*time.Millisecond,
+	))
+
+	if appConfig.Server.ScorePrecision != nil {
+		api.SetScorePrecision(*appConfig.Server.ScorePrecision)
+	}
+	api.SetDebugResponses(appConfig.Server.DebugResponses)
+
 	// Initialize Gin router
 	router := gin.Default()
 
 	// Set up API routes with configured URL suffixes
 	setupRoutes(router, appConfig)
 
-	// Start the server
+	// Admin endpoints exist only when a token guards them
+	if appConfig.Server.AdminToken != "" {
+		requireAdmin := api.RequireBearerToken(appConfig.Server.AdminToken)
+		reload := newReloader(configPath, profile, appConfig, vdb)
+		router.POST("/reload", requireAdmin, reload.handle)
+		router.GET("/admin/queries", requireAdmin, api.HandleListQueries)
+		router.POST("/admin/queries/:id/cancel", requireAdmin, api.HandleCancelQuery)
+		router.POST("/admin/verify", requireAdmin, api.HandleVerify)
+		router.POST("/admin/compact", requireAdmin, api.HandleCompactStorage)
+		router.GET("/admin/pending", requireAdmin, api.HandlePendingRecords)
+	}
+
+	// Start the server; SIGINT and SIGTERM shut it down and close the database
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	addr := fmt.Sprintf(":%d", appConfig.Server.Port)
-	slog.Info("Server listening", "address", addr)
-	if err := router.Run(addr); err != nil {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
 		slog.Error("Error starting server", "error", err)
+		vdb.Close()
 		os.Exit(1)
 	}
+
+	slog.Info("Server listening", "address", addr)
+	if err := serve(ctx, listener, router, vdb); err != nil {
+		slog.Error("Server stopped with error", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Server stopped")
 }
 
-func setupLogging(logLevel string) {
-	var level slog.Level
-	switch strings.ToLower(logLevel) {
+// shutdownTimeout bounds how long in-flight requests may run once shutdown starts
+const shutdownTimeout = 10 * time.Second
+
+// serve runs the HTTP server on listener until ctx is done, then stops accepting
+// requests, waits up to shutdownTimeout for the ones in flight and closes the database,
+// which flushes the WAL and applies pending records with a final sync.
+// The database is also closed if the server fails.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler, vdb *vecdb.VectorDatabase) error {
+	srv := &http.Server{Handler: handler}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		slog.Info("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			slog.Warn("Server shutdown did not finish in time", "error", shutdownErr)
+		}
+	}
+
+	if closeErr := vdb.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to close database: %w", closeErr))
+	}
+	return err
+}
+
+// configPath is the config file loaded at startup and on /reload
+const configPath = "config.toml"
+
+// logLevel is the level of the default logger, which /reload can change
+var logLevel slog.LevelVar
+
+func setupLogging(level string) {
+	logLevel.Set(parseLogLevel(level))
+
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: &logLevel,
+	})
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
 	case "debug":
-		level = slog.LevelDebug
+		return slog.LevelDebug
 	case "info":
-		level = slog.LevelInfo
+		return slog.LevelInfo
 	case "warn", "warning":
-		level = slog.LevelWarn
+		return slog.LevelWarn
 	case "error":
-		level = slog.LevelError
+		return slog.LevelError
 	default:
-		level = slog.LevelInfo
+		return slog.LevelInfo
+	}
+}
+
+func setupFaissThreads(numThreads int) {
+	if numThreads <= 0 {
+		slog.Info("Using default FAISS thread count", "omp_num_threads", os.Getenv("OMP_NUM_THREADS"), "num_cpu", runtime.NumCPU())
+		return
 	}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: level,
-	})
-	slog.SetDefault(slog.New(handler))
+	index.SetNumThreads(numThreads)
+	slog.Info("Set FAISS thread count", "faiss_num_threads", numThreads)
 }
 
 func setupGinMode(logLevel string) {
@@ -102,4 +204,40 @@ func setupGinMode(logLevel string) {
 func setupRoutes(router *gin.Engine, cfg *config.AppConfig) {
 	router.POST(cfg.Server.SearchURLSuffix, api.HandleVectorSearch)
 	router.POST(cfg.Server.UpsertURLSuffix, api.HandleVectorUpsert)
+
+	searchByIDURLSuffix := cfg.Server.SearchByIDURLSuffix
+	if searchByIDURLSuffix == "" {
+		searchByIDURLSuffix = "/search_by_id"
+	}
+	router.POST(searchByIDURLSuffix, api.HandleSearchByID)
+
+	scanURLSuffix := cfg.Server.ScanURLSuffix
+	if scanURLSuffix == "" {
+		scanURLSuffix = "/scan"
+	}
+	router.GET(scanURLSuffix, api.HandleScan)
+
+	fieldsURLSuffix := cfg.Server.FieldsURLSuffix
+	if fieldsURLSuffix == "" {
+		fieldsURLSuffix = "/fields"
+	}
+	router.GET(fieldsURLSuffix, api.HandleFields)
+
+	statsURLSuffix := cfg.Server.StatsURLSuffix
+	if statsURLSuffix == "" {
+		statsURLSuffix = "/stats"
+	}
+	router.GET(statsURLSuffix, api.HandleStats)
+
+	docsURLSuffix := cfg.Server.DocsURLSuffix
+	if docsURLSuffix == "" {
+		docsURLSuffix = "/docs"
+	}
+	router.POST(docsURLSuffix, api.HandleGetDocs)
+
+	docURLSuffix := cfg.Server.DocURLSuffix
+	if docURLSuffix == "" {
+		docURLSuffix = "/doc"
+	}
+	router.PATCH(docURLSuffix+"/:id", api.HandleUpdateDoc)
 }
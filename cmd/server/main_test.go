@@ -2,14 +2,27 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+	"vecdb-go/internal/api"
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/common/math"
 	"vecdb-go/internal/config"
+	"vecdb-go/internal/vecdb"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSetupLogging(t *testing.T) {
@@ -89,21 +102,39 @@ func TestSetupRoutes(t *testing.T) {
 				},
 			},
 			expectedRoutes: map[string]string{
-				"/search": "POST",
-				"/upsert": "POST",
+				"/search":       "POST",
+				"/search_by_id": "POST",
+				"/upsert":       "POST",
+				"/scan":         "GET",
+				"/fields":       "GET",
+				"/stats":        "GET",
+				"/docs":         "POST",
+				"/doc/:id":      "PATCH",
 			},
 		},
 		{
 			name: "custom routes",
 			config: &config.AppConfig{
 				Server: config.ServerConfig{
-					SearchURLSuffix: "/api/v1/search",
-					UpsertURLSuffix: "/api/v1/upsert",
+					SearchURLSuffix:     "/api/v1/search",
+					SearchByIDURLSuffix: "/api/v1/search_by_id",
+					UpsertURLSuffix:     "/api/v1/upsert",
+					ScanURLSuffix:       "/api/v1/scan",
+					FieldsURLSuffix:     "/api/v1/fields",
+					StatsURLSuffix:      "/api/v1/stats",
+					DocsURLSuffix:       "/api/v1/docs",
+					DocURLSuffix:        "/api/v1/doc",
 				},
 			},
 			expectedRoutes: map[string]string{
-				"/api/v1/search": "POST",
-				"/api/v1/upsert": "POST",
+				"/api/v1/search":       "POST",
+				"/api/v1/search_by_id": "POST",
+				"/api/v1/upsert":       "POST",
+				"/api/v1/scan":         "GET",
+				"/api/v1/fields":       "GET",
+				"/api/v1/stats":        "GET",
+				"/api/v1/docs":         "POST",
+				"/api/v1/doc/:id":      "PATCH",
 			},
 		},
 	}
@@ -183,3 +214,112 @@ func TestSetupRoutesHandlers(t *testing.T) {
 		})
 	}
 }
+
+func TestReload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig := func(level string, port int) {
+		content := fmt.Sprintf("[dev.database]\ndim = 3\n[dev.server]\nlog_level = %q\nport = %d\n", level, port)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	writeConfig("info", 8080)
+	startup, err := config.LoadConfigFile(path, "dev")
+	require.NoError(t, err)
+	setupLogging(startup.Server.LogLevel)
+
+	router := gin.New()
+	router.POST("/reload", api.RequireBearerToken("secret"), newReloader(path, "dev", startup, nil).handle)
+
+	reload := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	writeConfig("debug", 9090)
+
+	// Without the token nothing is applied
+	assert.Equal(t, http.StatusUnauthorized, reload("").Code)
+	assert.Equal(t, http.StatusUnauthorized, reload("wrong").Code)
+	assert.Equal(t, slog.LevelInfo, logLevel.Level())
+
+	w := reload("secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, slog.LevelDebug, logLevel.Level())
+	assert.True(t, slog.Default().Enabled(context.Background(), slog.LevelDebug))
+
+	var resp struct {
+		Ignored []string `json:"ignored"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []string{"server.port"}, resp.Ignored)
+}
+
+func TestServeShutdownClosesDatabase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:       t.TempDir(),
+		Dim:            3,
+		MetricType:     common.MetricTypeL2,
+		IndexType:      common.IndexTypeFlat,
+		SyncIntervalMs: 3600000, // only the final sync of Close applies pending records
+	}
+	vdb, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	api.Initialize(vdb)
+
+	router := gin.New()
+	setupRoutes(router, &config.AppConfig{
+		Server: config.ServerConfig{SearchURLSuffix: "/search", UpsertURLSuffix: "/upsert"},
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, listener, router, vdb)
+	}()
+
+	resp, err := http.Post("http://"+listener.Addr().String()+"/upsert", "application/json",
+		strings.NewReader(`{"data": [[1.0, 0.0, 0.0]], "docs": [{"name": "a"}]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Still pending when the shutdown starts
+	err = vdb.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 1, 0}},
+		Docs:    []map[string]any{{"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	shutdown()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after shutdown")
+	}
+	assert.ErrorIs(t, vdb.Close(), vecdb.ErrDatabaseClosed)
+
+	reopened, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	results, err := reopened.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0]["name"])
+	assert.Equal(t, "b", results[1]["name"])
+}
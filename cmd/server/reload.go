@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"vecdb-go/internal/api"
+	"vecdb-go/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tunableDatabase is the part of the database a reload can adjust
+type tunableDatabase interface {
+	SetSyncInterval(interval time.Duration)
+	SetDefaultEfSearch(efSearch uint32)
+}
+
+// reloader re-reads the config file and applies the settings that can change at
+// runtime; every other setting keeps its startup value until a restart
+type reloader struct {
+	path    string
+	profile string
+	db      tunableDatabase
+
+	// startup is the config the server started with, which immutable settings keep
+	startup *config.AppConfig
+
+	mu sync.Mutex
+}
+
+func newReloader(path, profile string, startup *config.AppConfig, db tunableDatabase) *reloader {
+	return &reloader{path: path, profile: profile, startup: startup, db: db}
+}
+
+// reload applies the runtime settings of the config file and returns the names of
+// changed settings that need a restart
+func (r *reloader) reload() ([]string, error) {
+	next, err := config.LoadConfigFile(r.path, r.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logLevel.Set(parseLogLevel(next.Server.LogLevel))
+
+	precision := -1
+	if next.Server.ScorePrecision != nil {
+		precision = *next.Server.ScorePrecision
+	}
+	api.SetScorePrecision(precision)
+	api.SetDebugResponses(next.Server.DebugResponses)
+
+	if r.db != nil {
+		r.db.SetSyncInterval(next.Database.EffectiveSyncInterval())
+		var efSearch uint32
+		if next.Database.HnswParams != nil && next.Database.HnswParams.EfSearch > 0 {
+			efSearch = uint32(next.Database.HnswParams.EfSearch)
+		}
+		r.db.SetDefaultEfSearch(efSearch)
+	}
+
+	ignored := immutableChanges(r.startup, next)
+	for _, name := range ignored {
+		slog.Warn("Ignoring config change that needs a restart", "setting", name)
+	}
+
+	slog.Info("Reloaded configuration", "path", r.path, "profile", r.profile, "log_level", next.Server.LogLevel)
+	return ignored, nil
+}
+
+// handle serves POST /reload
+func (r *reloader) handle(c *gin.Context) {
+	ignored, err := r.reload()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ignored == nil {
+		ignored = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"reloaded": true, "ignored": ignored})
+}
+
+// immutableChanges returns the toml names of settings that differ between old and
+// next but can only change with a restart
+func immutableChanges(old, next *config.AppConfig) []string {
+	oldCfg, nextCfg := *old, *next
+	for _, cfg := range []*config.AppConfig{&oldCfg, &nextCfg} {
+		cfg.Server.LogLevel = ""
+		cfg.Server.ScorePrecision = nil
+		cfg.Server.DebugResponses = false
+		cfg.Database.SyncIntervalMs = 0
+		if cfg.Database.HnswParams != nil {
+			hnsw := *cfg.Database.HnswParams
+			hnsw.EfSearch = 0
+			cfg.Database.HnswParams = &hnsw
+		}
+	}
+
+	var changed []string
+	diffFields(reflect.ValueOf(oldCfg), reflect.ValueOf(nextCfg), "", &changed)
+	return changed
+}
+
+// diffFields appends the dotted toml names of the top-level fields of each section that differ
+func diffFields(a, b reflect.Value, prefix string, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix == "" && field.Type.Kind() == reflect.Struct {
+			diffFields(a.Field(i), b.Field(i), name+".", changed)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			*changed = append(*changed, prefix+name)
+		}
+	}
+}
@@ -13,21 +13,44 @@ import (
 func main() {
 	inputFile := flag.String("input", "", "Input WAL file path (required)")
 	outputFile := flag.String("output", "", "Output WAL file path (required)")
-	outputFormat := flag.String("format", "text", "Output format: 'binary' or 'text'")
+	outputFormat := flag.String("format", "text", "Output format: 'binary' or 'text' (with -repair, defaults to the input format)")
+	repair := flag.Bool("repair", false, "Skip corrupt records instead of failing, and write the readable ones")
 	flag.Parse()
 
 	if *inputFile == "" || *outputFile == "" {
-		fmt.Println("Usage: wal_converter -input <file> -output <file> [-format binary|text]")
-		fmt.Println("\nConvert WAL files between binary and text formats")
+		fmt.Println("Usage: wal_converter -input <file> -output <file> [-format binary|text] [-repair]")
+		fmt.Println("\nConvert WAL files between binary and text formats, or repair a damaged WAL")
 		fmt.Println("\nExamples:")
 		fmt.Println("  # Convert binary WAL to text for inspection")
 		fmt.Println("  wal_converter -input data.wal -output data.txt -format text")
 		fmt.Println("\n  # Convert text WAL back to binary")
 		fmt.Println("  wal_converter -input data.txt -output data.wal -format binary")
+		fmt.Println("\n  # Write the readable records of a damaged WAL to a clean one")
+		fmt.Println("  wal_converter -input vdb.log -output vdb.log.repaired -repair")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	if *repair {
+		// Keep the input format unless one was asked for
+		format := ""
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "format" {
+				format = *outputFormat
+			}
+		})
+		if format != "" && format != "binary" && format != "text" {
+			fmt.Printf("Error: format must be 'binary' or 'text', got '%s'\n", format)
+			os.Exit(1)
+		}
+
+		if err := repairWAL(*inputFile, *outputFile, format); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *outputFormat != "binary" && *outputFormat != "text" {
 		fmt.Printf("Error: format must be 'binary' or 'text', got '%s'\n", *outputFormat)
 		os.Exit(1)
@@ -39,10 +62,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Printf("✓ Successfully converted %s to %s (format: %s)\n", 
+	fmt.Printf("✓ Successfully converted %s to %s (format: %s)\n",
 		*inputFile, *outputFile, *outputFormat)
 }
 
+// repairWAL writes the decodable records of a damaged WAL to a clean one, reporting the
+// corrupt spans it skips. An empty outputFormat keeps the format of the input.
+func repairWAL(inputPath, outputPath, outputFormat string) error {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+
+	// Detect the input format as the one that salvages more records
+	inputFormat := "binary"
+	records, corrupt := persistence.SalvageWAL(data, persistence.NewBinaryWALEncoder("v1"))
+	textRecords, textCorrupt := persistence.SalvageWAL(data, persistence.NewTextWALEncoder("v1"))
+	if len(textRecords) > len(records) {
+		inputFormat = "text"
+		records, corrupt = textRecords, textCorrupt
+	}
+
+	for _, region := range corrupt {
+		fmt.Printf("Skipped corrupt bytes %d-%d: %v\n", region.Offset, region.Offset+region.Length, region.Err)
+	}
+	fmt.Printf("Recovered %d records from %s WAL, skipped %d corrupt regions\n",
+		len(records), inputFormat, len(corrupt))
+
+	if outputFormat == "" {
+		outputFormat = inputFormat
+	}
+
+	if err := writeRecords(outputPath, outputFormat, records); err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Wrote repaired WAL to %s (format: %s)\n", outputPath, outputFormat)
+	return nil
+}
+
 func convertWAL(inputPath, outputPath, outputFormat string) error {
 	// Open input file
 	inputFile, err := os.Open(inputPath)
@@ -51,22 +109,26 @@ func convertWAL(inputPath, outputPath, outputFormat string) error {
 	}
 	defer inputFile.Close()
 
-	// Create output file
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outputFile.Close()
-
 	// Try both decoders to auto-detect input format
-	inputReader := bufio.NewReader(inputFile)
-	records, err := readAllRecords(inputReader)
+	records, err := readAllRecords(inputFile)
 	if err != nil {
 		return fmt.Errorf("failed to read input records: %w", err)
 	}
 
 	fmt.Printf("Read %d records from input file\n", len(records))
 
+	return writeRecords(outputPath, outputFormat, records)
+}
+
+// writeRecords writes records to a new WAL file in the given format
+func writeRecords(outputPath, outputFormat string, records []persistence.WALRecord) error {
+	// Create output file
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
 	// Create output encoder
 	var outputEncoder persistence.WALEncoder
 	if outputFormat == "binary" {
@@ -90,39 +152,32 @@ func convertWAL(inputPath, outputPath, outputFormat string) error {
 	return nil
 }
 
-func readAllRecords(reader *bufio.Reader) ([]persistence.WALRecord, error) {
-	var records []persistence.WALRecord
-
+func readAllRecords(input io.ReadSeeker) ([]persistence.WALRecord, error) {
 	// Try binary decoder first
-	binaryDecoder := persistence.NewBinaryWALEncoder("v1")
-	for {
-		record, err := binaryDecoder.DecodeRecord(reader)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			// Binary failed, try text decoder
-			return readAllRecordsText(reader)
-		}
-		records = append(records, *record)
+	records, err := readRecords(input, persistence.NewBinaryWALEncoder("v1"))
+	if err == nil {
+		return records, nil
+	}
+
+	// Binary failed, rewind and try text decoder
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind input: %w", err)
+	}
+
+	records, err = readRecords(input, persistence.NewTextWALEncoder("v1"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode as both binary and text: %w", err)
 	}
 
 	return records, nil
 }
 
-func readAllRecordsText(reader *bufio.Reader) ([]persistence.WALRecord, error) {
-	// Reopen the file for text reading since we already consumed some bytes
-	// In a real implementation, you'd want to seek back or handle this better
+func readRecords(input io.Reader, decoder persistence.WALEncoder) ([]persistence.WALRecord, error) {
 	var records []persistence.WALRecord
-	textDecoder := persistence.NewTextWALEncoder("v1")
 
-	for {
-		record, err := textDecoder.DecodeRecord(reader)
-		if err == io.EOF {
-			break
-		}
+	for record, err := range persistence.NewWALReader(input, decoder) {
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode as both binary and text: %w", err)
+			return nil, err
 		}
 		records = append(records, *record)
 	}
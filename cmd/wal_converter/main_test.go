@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"vecdb-go/internal/persistence"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairWAL(t *testing.T) {
+	tmpDir := t.TempDir()
+	inputPath := filepath.Join(tmpDir, "vdb.log")
+
+	encoder := persistence.NewBinaryWALEncoder("v1")
+	var buf bytes.Buffer
+	var offsets []int
+	for i := uint64(1); i <= 4; i++ {
+		offsets = append(offsets, buf.Len())
+		record := &persistence.WALRecord{
+			LogID:     i,
+			Operation: persistence.Insert,
+			VectorID:  i,
+			Vector:    []float32{float32(i), 0, 0},
+			Doc:       map[string]any{"name": "doc"},
+		}
+		require.NoError(t, encoder.EncodeRecord(&buf, record))
+	}
+
+	// Corrupt the second record in the middle of the file
+	data := buf.Bytes()
+	data[offsets[1]+20] ^= 0xff
+	require.NoError(t, os.WriteFile(inputPath, data, 0644))
+
+	readIDs := func(path string, decoder persistence.WALEncoder) []uint64 {
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+
+		var ids []uint64
+		for record, err := range persistence.NewWALReader(file, decoder) {
+			require.NoError(t, err)
+			ids = append(ids, record.VectorID)
+		}
+		return ids
+	}
+
+	// The repaired WAL keeps the input format and every record after the corruption
+	outputPath := filepath.Join(tmpDir, "vdb.log.repaired")
+	require.NoError(t, repairWAL(inputPath, outputPath, ""))
+	assert.Equal(t, []uint64{1, 3, 4}, readIDs(outputPath, persistence.NewBinaryWALEncoder("v1")))
+
+	textPath := filepath.Join(tmpDir, "vdb.txt")
+	require.NoError(t, repairWAL(inputPath, textPath, "text"))
+	assert.Equal(t, []uint64{1, 3, 4}, readIDs(textPath, persistence.NewTextWALEncoder("v1")))
+}
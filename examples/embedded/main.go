@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"vecdb-go/vecdb"
+)
+
+// Example using the vector database in-process, without config.toml or the HTTP server
+func main() {
+	dir, err := os.MkdirTemp("", "vecdb-embedded")
+	if err != nil {
+		log.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Open a 3-dimensional database scoring by cosine similarity; the index type is left
+	// to its default, flat
+	db, err := vecdb.Open(vecdb.Config{
+		Path:   dir,
+		Dim:    3,
+		Metric: vecdb.MetricCosine,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Upsert three documents, each with a vector and a filterable attribute
+	err = db.Upsert(vecdb.UpsertArgs{
+		Vectors: vecdb.Matrix32{Rows: 3, Cols: 3, Data: []float32{
+			1, 0, 0,
+			0, 1, 0,
+			1, 1, 0,
+		}},
+		Docs: []map[string]any{
+			{"title": "east"},
+			{"title": "north"},
+			{"title": "north-east"},
+		},
+		Attributes: []map[string]any{
+			{"year": 2023},
+			{"year": 2024},
+			{"year": 2024},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to upsert: %v", err)
+	}
+
+	// Query the two nearest documents, then only those from 2024
+	query := vecdb.SearchArgs{Query: []float32{0.9, 0.1, 0}, K: 2}
+	printResults("Nearest documents:", db, query)
+
+	query.FilterInputs = []vecdb.FilterInput{{Field: "year", Op: "equal", Target: 2024}}
+	printResults("Nearest documents from 2024:", db, query)
+}
+
+func printResults(title string, db *vecdb.DB, query vecdb.SearchArgs) {
+	results, err := db.Query(query)
+	if err != nil {
+		log.Fatalf("Failed to query: %v", err)
+	}
+
+	fmt.Println(title)
+	for _, doc := range results {
+		fmt.Printf("  %v (score %v)\n", doc["title"], doc[vecdb.DocFieldScore])
+	}
+}
@@ -22,7 +22,8 @@ func main() {
 		os.RemoveAll(tmpDir)
 	}()
 
-	fmt.Println("=== WAL Encoder Example ===\n")
+	fmt.Println("=== WAL Encoder Example ===")
+	fmt.Println()
 
 	// Example 1: Using Binary Encoder (production default)
 	fmt.Println("1. Creating WAL with Binary Encoder (production default):")
@@ -52,7 +53,7 @@ func createExampleWAL(walPath string, encoder persistence.WALEncoder, encoderTyp
 	defer scalarStorage.Close()
 
 	filterIndex := filter.NewIntFilterIndex()
-	vectorIndex, err := index.NewIndex("flat", 3, "l2", nil)
+	vectorIndex, err := index.NewIndex("flat", 3, "l2", nil, "")
 	if err != nil {
 		log.Fatalf("Failed to create vector index: %v", err)
 	}
@@ -1,71 +1,419 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	gomath "math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"vecdb-go/internal/common"
 	"vecdb-go/internal/common/math"
+	"vecdb-go/internal/embed"
 	"vecdb-go/internal/vecdb"
 
 	"github.com/gin-gonic/gin"
 )
 
 type VectorSearchRequest struct {
-	Query        []float32               `json:"query"`
-	FilterInputs []common.IntFilterInput `json:"filter_inputs,omitempty"`
-	K            int                     `json:"k"`
+	Query        []float32                `json:"query"`
+	Text         string                   `json:"text,omitempty"` // embedded into Query when Query is empty
+	Queries      [][]float32              `json:"queries,omitempty"`
+	Aggregation  common.Aggregation       `json:"aggregation,omitempty"`
+	FilterInputs []common.IntFilterInput  `json:"filter_inputs,omitempty" binding:"dive"`
+	K            int                      `json:"k" binding:"gt=0"`
+	HnswParams   *common.HnswSearchOption `json:"hnsw_params,omitempty"`
+	ExcludeIDs   []uint64                 `json:"exclude_ids,omitempty"`
+	Fields       []string                 `json:"fields,omitempty"`
+	IDsOnly      bool                     `json:"ids_only,omitempty"`
+	TimeoutMs    int                      `json:"timeout_ms,omitempty"`
+	GroupBy      string                   `json:"group_by,omitempty"`
+	// NormalizeScores maps scores to a similarity between 0 and 1 and returns the raw
+	// scores as "_raw_score"
+	NormalizeScores bool `json:"normalize_scores,omitempty"`
+	// ScorePrecision rounds result scores to this many decimal places, overriding the
+	// server default; a negative value keeps full precision
+	ScorePrecision *int `json:"score_precision,omitempty"`
+	// Explain lists with each result the attribute values that matched FilterInputs,
+	// under "_explain"
+	Explain bool `json:"explain,omitempty"`
+	// Exact compares the query with every vector instead of walking the HNSW graph
+	Exact bool `json:"exact,omitempty"`
+}
+
+// SearchByIDRequest searches with the stored vector of a document instead of a query
+// vector; the other fields are those of VectorSearchRequest
+type SearchByIDRequest struct {
+	ID          uint64 `json:"id" binding:"required"`
+	IncludeSelf bool   `json:"include_self,omitempty"` // keep the document itself in the results
+	VectorSearchRequest
+}
+
+// toSearchArgs converts the request payload into database search arguments
+func (r *VectorSearchRequest) toSearchArgs() common.VdbSearchArgs {
+	return common.VdbSearchArgs{
+		Query:        r.Query,
+		Queries:      r.Queries,
+		Aggregation:  r.Aggregation,
+		K:            r.K,
+		FilterInputs: r.FilterInputs,
+		HnswParams:   r.HnswParams,
+		ExcludeIDs:   r.ExcludeIDs,
+		Fields:       r.Fields,
+		IDsOnly:      r.IDsOnly,
+		TimeoutMs:    r.TimeoutMs,
+		GroupBy:      r.GroupBy,
+
+		NormalizeScores: r.NormalizeScores,
+		Explain:         r.Explain,
+		Exact:           r.Exact,
+	}
 }
 
 type VectorUpsertRequest struct {
 	Data       math.Matrix32      `json:"data"`
+	Texts      []string           `json:"texts,omitempty"` // embedded into Data when Data is empty
 	Docs       []map[string]any   `json:"docs,omitempty"`
 	Attributes []map[string]any   `json:"attributes,omitempty"`
 	HnswParams *common.HnswParams `json:"hnsw_params,omitempty"`
 }
 
+// checkLengths verifies that docs and attributes are either omitted or have one entry
+// per data row, and fills omitted docs with empty ones
+func (r *VectorUpsertRequest) checkLengths() error {
+	rows := r.Data.Rows
+	if len(r.Docs) > 0 && len(r.Docs) != rows {
+		return fmt.Errorf("docs has %d entries, expected %d (one per data row)", len(r.Docs), rows)
+	}
+	if len(r.Attributes) > 0 && len(r.Attributes) != rows {
+		return fmt.Errorf("attributes has %d entries, expected %d (one per data row)", len(r.Attributes), rows)
+	}
+	if len(r.Docs) == 0 {
+		r.Docs = make([]map[string]any, rows)
+	}
+	return nil
+}
+
 type VectorSearchResponse struct {
 	Results []common.DocMap `json:"results"`
+	// TookMs and TotalCandidates are set only for debug responses
+	TookMs          *float64 `json:"took_ms,omitempty"`          // wall time of the database query
+	TotalCandidates *int     `json:"total_candidates,omitempty"` // vectors the index could return after filters
 }
 
 type VectorUpsertResponse struct {
 	Message string `json:"message"`
 }
 
-var vdb *vecdb.VectorDatabase
+// ScanResponse is one page of stored documents; NextCursor is passed as the cursor
+// query parameter to fetch the following page and is 0 on the last page
+type ScanResponse struct {
+	Docs       []common.DocMap `json:"docs"`
+	NextCursor uint64          `json:"next_cursor"`
+}
+
+// FieldsResponse lists the attribute fields that can be filtered on
+type FieldsResponse struct {
+	Fields []vecdb.FieldInfo `json:"fields"`
+}
+
+// QueriesResponse lists the vector searches in flight
+type QueriesResponse struct {
+	Queries []vecdb.RunningQuery `json:"queries"`
+}
+
+// MaxPendingLimit caps the number of records a pending WAL request lists
+const MaxPendingLimit = 100
+
+// MaxScanLimit caps the page size of a scan request
+const MaxScanLimit = 1000
+
+// DocsRequest lists the IDs of the documents to fetch
+type DocsRequest struct {
+	IDs []uint64 `json:"ids" binding:"required"`
+}
+
+// DocsResponse holds the documents in the order of the requested IDs, with null for IDs
+// that do not exist; Found tells for each ID whether its document exists
+type DocsResponse struct {
+	Docs  []common.DocMap `json:"docs"`
+	Found []bool          `json:"found"`
+}
+
+// MaxDocsIDs caps the number of IDs of a docs request
+const MaxDocsIDs = 1000
+
+// DocUpdateRequest replaces the document and attributes of a vector; omitted ones are
+// stored as empty
+type DocUpdateRequest struct {
+	Doc        map[string]any `json:"doc,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+var (
+	vdb      *vecdb.VectorDatabase
+	embedder embed.Embedder = embed.NoopEmbedder{}
+
+	// scorePrecision is the number of decimal places search scores are rounded to in
+	// responses; a negative value keeps full precision
+	scorePrecision atomic.Int64
+
+	// debugResponses adds timing info to every search response, not only to those
+	// requested with ?debug=true
+	debugResponses atomic.Bool
+)
+
+func init() {
+	scorePrecision.Store(-1)
+}
 
 func Initialize(db *vecdb.VectorDatabase) {
 	vdb = db
 }
 
+// SetEmbedder sets the embedder used for requests that send text instead of vectors
+func SetEmbedder(e embed.Embedder) {
+	embedder = e
+}
+
+// SetScorePrecision sets the default number of decimal places search scores are
+// rounded to in responses; a negative precision keeps full precision
+func SetScorePrecision(precision int) {
+	scorePrecision.Store(int64(precision))
+}
+
+// SetDebugResponses sets whether every search response includes timing info;
+// otherwise only requests with ?debug=true get it
+func SetDebugResponses(enabled bool) {
+	debugResponses.Store(enabled)
+}
+
+// roundScores rounds the scores of each result to precision decimal places.
+// Rounding only shortens the JSON output; the database always ranks on full precision.
+func roundScores(results []common.DocMap, precision int) {
+	if precision < 0 {
+		return
+	}
+
+	scale := gomath.Pow(10, float64(precision))
+	for _, doc := range results {
+		for _, field := range []string{common.DocFieldScore, common.DocFieldRawScore} {
+			if score, ok := doc[field].(float32); ok {
+				doc[field] = gomath.Round(float64(score)*scale) / scale
+			}
+		}
+	}
+}
+
+// statusFromError maps a database error to an HTTP status code: client mistakes are
+// 400, writes to a read-only database 403, missing documents 404, oversized documents
+// 413, canceled searches and a closed database 503, timed-out searches 504, and
+// anything else is an internal failure
+func statusFromError(err error) int {
+	switch {
+	case errors.Is(err, vecdb.ErrReadOnly):
+		return http.StatusForbidden
+	case errors.Is(err, vecdb.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, vecdb.ErrDocTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, vecdb.ErrQueryTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, vecdb.ErrQueryCanceled),
+		errors.Is(err, vecdb.ErrClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, vecdb.ErrDimMismatch),
+		errors.Is(err, vecdb.ErrUnsupportedFilterOp),
+		errors.Is(err, vecdb.ErrInvalidArgument),
+		errors.Is(err, vecdb.ErrNonFiniteVector),
+		errors.Is(err, vecdb.ErrAttributeTypeMismatch),
+		errors.Is(err, vecdb.ErrUnsupportedAttribute),
+		errors.Is(err, vecdb.ErrFilterLimit),
+		errors.Is(err, vecdb.ErrFieldNotFilterable),
+		errors.Is(err, vecdb.ErrEmptyQuery),
+		errors.Is(err, vecdb.ErrNoRows),
+		errors.Is(err, common.ErrIDOutOfRange),
+		errors.Is(err, embed.ErrEmbedderDisabled):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func HandleVectorSearch(c *gin.Context) {
 	var payload VectorSearchRequest
 
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &payload) {
 		return
 	}
 
-	searchArgs := common.VdbSearchArgs{
-		Query:        payload.Query,
-		K:            payload.K,
-		FilterInputs: payload.FilterInputs,
+	if len(payload.Query) == 0 && len(payload.Queries) == 0 && payload.Text == "" {
+		writeFieldErrors(c, []FieldError{{Field: "query", Rule: "required_without_all", Param: "queries text"}})
+		return
 	}
 
-	results, err := vdb.Query(searchArgs)
+	if payload.Text != "" {
+		if len(payload.Query) > 0 || len(payload.Queries) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "query, queries and text are mutually exclusive"})
+			return
+		}
+
+		mat, err := embedder.Embed([]string{payload.Text})
+		if err != nil {
+			slog.Error("failed to embed search text", "error", err)
+			c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+			return
+		}
+		payload.Query = mat.Data
+	}
+
+	if acceptsNDJSON(c) {
+		streamSearchResponse(c, &payload)
+		return
+	}
+
+	start := time.Now()
+	results, stats, err := vdb.QueryWithStats(payload.toSearchArgs())
 	if err != nil {
 		slog.Error("failed to search", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	writeSearchResponse(c, &payload, results, stats, time.Since(start))
+}
+
+// HandleSearchByID searches for the documents most similar to a stored one, which is
+// left out of the results unless include_self is set
+func HandleSearchByID(c *gin.Context) {
+	var payload SearchByIDRequest
+
+	if !bindJSON(c, &payload) {
+		return
+	}
+
+	if len(payload.Query) > 0 || len(payload.Queries) > 0 || payload.Text != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id cannot be combined with query, queries or text"})
+		return
+	}
+
+	start := time.Now()
+	results, stats, err := vdb.QueryByID(payload.ID, payload.toSearchArgs(), !payload.IncludeSelf)
+	if err != nil {
+		slog.Error("failed to search by id", "error", err, "id", payload.ID)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	writeSearchResponse(c, &payload.VectorSearchRequest, results, stats, time.Since(start))
+}
+
+// writeSearchResponse rounds the result scores and writes them, with timing info for
+// debug responses
+func writeSearchResponse(c *gin.Context, payload *VectorSearchRequest, results []common.DocMap, stats vecdb.QueryStats, took time.Duration) {
+	precision := int(scorePrecision.Load())
+	if payload.ScorePrecision != nil {
+		precision = *payload.ScorePrecision
+	}
+	roundScores(results, precision)
+
+	response := VectorSearchResponse{Results: results}
+	if debugResponses.Load() || c.Query("debug") == "true" {
+		tookMs := float64(took.Microseconds()) / 1000
+		response.TookMs = &tookMs
+		response.TotalCandidates = &stats.Candidates
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// NDJSONContentType is the media type of streamed search responses, which hold one JSON
+// result per line
+const NDJSONContentType = "application/x-ndjson"
+
+// acceptsNDJSON reports whether the request asks for a streamed NDJSON response
+func acceptsNDJSON(c *gin.Context) bool {
+	for _, accepted := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if strings.TrimSpace(mediaType) == NDJSONContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// streamSearchResponse writes the search results as NDJSON, flushing each one as soon as
+// its document is fetched. Errors before the first result get the usual status and JSON
+// body; once results have been sent the status cannot change, so a later error ends the
+// stream with an {"error": ...} line. Debug timing info is not included.
+func streamSearchResponse(c *gin.Context, payload *VectorSearchRequest) {
+	precision := int(scorePrecision.Load())
+	if payload.ScorePrecision != nil {
+		precision = *payload.ScorePrecision
+	}
+
+	encoder := json.NewEncoder(c.Writer)
+	started := false
+	start := func() {
+		if !started {
+			c.Header("Content-Type", NDJSONContentType)
+			c.Status(http.StatusOK)
+			started = true
+		}
+	}
+
+	_, err := vdb.QueryStream(payload.toSearchArgs(), func(result common.DocMap) error {
+		start()
+		roundScores([]common.DocMap{result}, precision)
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to stream search", "error", err)
+		if !started {
+			c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+			return
+		}
+		_ = encoder.Encode(gin.H{"error": err.Error()})
+		c.Writer.Flush()
 		return
 	}
 
-	c.JSON(http.StatusOK, VectorSearchResponse{Results: results})
+	start()
+	c.Writer.WriteHeaderNow()
 }
 
 func HandleVectorUpsert(c *gin.Context) {
 	var payload VectorUpsertRequest
 
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	if !bindJSON(c, &payload) {
+		return
+	}
+
+	if len(payload.Texts) > 0 {
+		if payload.Data.Rows > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "data and texts are mutually exclusive"})
+			return
+		}
+
+		mat, err := embedder.Embed(payload.Texts)
+		if err != nil {
+			slog.Error("failed to embed upsert texts", "error", err)
+			c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+			return
+		}
+		payload.Data = *mat
+	}
+
+	if err := payload.checkLengths(); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -80,9 +428,182 @@ func HandleVectorUpsert(c *gin.Context) {
 	err := vdb.Upsert(upsertArgs)
 	if err != nil {
 		slog.Error("failed to upsert", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, VectorUpsertResponse{Message: "Upsert successful"})
 }
+
+// HandleScan lists stored documents in ID order, one page per request.
+// Query parameters: cursor (the next_cursor of the previous page, 0 to start) and
+// limit (page size, defaults to vecdb.DefaultScanLimit and is capped at MaxScanLimit)
+func HandleScan(c *gin.Context) {
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor: " + err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(vecdb.DefaultScanLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+		return
+	}
+	limit = min(limit, MaxScanLimit)
+
+	docs, next, err := vdb.ScanPage(cursor, limit)
+	if err != nil {
+		slog.Error("failed to scan", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ScanResponse{Docs: docs, NextCursor: next})
+}
+
+// HandleFields lists the indexed attribute fields with their type, value range and
+// number of distinct values
+func HandleFields(c *gin.Context) {
+	fields, err := vdb.FilterFields()
+	if err != nil {
+		slog.Error("failed to list fields", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, FieldsResponse{Fields: fields})
+}
+
+// HandleStats reports the applied operation counts, WAL bytes written and ingest rate
+func HandleStats(c *gin.Context) {
+	stats, err := vdb.OpStats()
+	if err != nil {
+		slog.Error("failed to read stats", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// HandleGetDocs fetches the documents with the requested IDs, after syncing pending
+// writes, so that documents just upserted are found
+func HandleGetDocs(c *gin.Context) {
+	var payload DocsRequest
+	if !bindJSON(c, &payload) {
+		return
+	}
+	if len(payload.IDs) > MaxDocsIDs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ids has %d entries, at most %d are allowed", len(payload.IDs), MaxDocsIDs)})
+		return
+	}
+
+	docs, err := vdb.GetDocs(payload.IDs)
+	if err != nil {
+		slog.Error("failed to get docs", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	found := make([]bool, len(docs))
+	for i, doc := range docs {
+		found[i] = doc != nil
+	}
+
+	c.JSON(http.StatusOK, DocsResponse{Docs: docs, Found: found})
+}
+
+// HandleUpdateDoc replaces the document and attributes of the vector with the ID in the
+// path without re-inserting the vector; an unknown ID returns 404
+func HandleUpdateDoc(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be a non-negative integer"})
+		return
+	}
+
+	var payload DocUpdateRequest
+	if !bindJSON(c, &payload) {
+		return
+	}
+
+	if err := vdb.UpdateDoc(id, payload.Doc, payload.Attributes); err != nil {
+		slog.Error("failed to update doc", "id", id, "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": id})
+}
+
+// HandleListQueries lists the vector searches in flight with their start time
+func HandleListQueries(c *gin.Context) {
+	c.JSON(http.StatusOK, QueriesResponse{Queries: vdb.RunningQueries()})
+}
+
+// HandleCancelQuery cancels the vector search with the ID in the path; its caller gets
+// 503 Service Unavailable
+func HandleCancelQuery(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query id must be a non-negative integer"})
+		return
+	}
+
+	if err := vdb.CancelQuery(id); err != nil {
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	slog.Info("Canceled query", "id", id)
+	c.JSON(http.StatusOK, gin.H{"canceled": id})
+}
+
+// HandleVerify cross-checks the vector index, stored documents and filter index and
+// reports the inconsistent IDs; with ?repair=true it also deletes them
+func HandleVerify(c *gin.Context) {
+	repair := c.Query("repair") == "true"
+
+	report, err := vdb.Verify(repair)
+	if err != nil {
+		slog.Error("failed to verify", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// HandlePendingRecords summarizes the WAL records written but not yet synced, which
+// searches do not see yet; ?limit=N also lists the first N of them, up to MaxPendingLimit
+func HandlePendingRecords(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative integer"})
+		return
+	}
+	limit = min(limit, MaxPendingLimit)
+
+	pending, err := vdb.PendingRecords(limit)
+	if err != nil {
+		slog.Error("failed to list pending records", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, pending)
+}
+
+// HandleCompactStorage merges the scalar storage files to reclaim the space of deleted
+// and replaced documents
+func HandleCompactStorage(c *gin.Context) {
+	start := time.Now()
+	if err := vdb.CompactStorage(); err != nil {
+		slog.Error("failed to compact storage", "error", err)
+		c.JSON(statusFromError(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"took_ms": float64(time.Since(start).Microseconds()) / 1000})
+}
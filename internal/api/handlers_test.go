@@ -1,8 +1,26 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/common/math"
+	"vecdb-go/internal/embed"
+	"vecdb-go/internal/filter"
+	"vecdb-go/internal/persistence"
+	"vecdb-go/internal/vecdb"
+
+	"github.com/gin-gonic/gin"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -179,3 +197,1202 @@ func TestVectorSearchRequest_Unmarshal(t *testing.T) {
 		})
 	}
 }
+
+func TestVectorSearchRequest_ToSearchArgs(t *testing.T) {
+	var req VectorSearchRequest
+	err := json.Unmarshal([]byte(`{
+		"query": [1.0, 2.0, 3.0],
+		"k": 5,
+		"filter_inputs": [{"field": "category", "op": "equal", "target": 1}],
+		"hnsw_params": {"ef_search": 64},
+		"exclude_ids": [7, 9],
+		"fields": ["title"],
+		"ids_only": true,
+		"timeout_ms": 250,
+		"group_by": "category"
+	}`), &req)
+	require.NoError(t, err)
+
+	args := req.toSearchArgs()
+	assert.Equal(t, []float32{1.0, 2.0, 3.0}, args.Query)
+	assert.Equal(t, 5, args.K)
+	assert.Len(t, args.FilterInputs, 1)
+	require.NotNil(t, args.HnswParams, "hnsw_params should be forwarded")
+	assert.Equal(t, uint32(64), args.HnswParams.EfSearch)
+	assert.Equal(t, []uint64{7, 9}, args.ExcludeIDs)
+	assert.Equal(t, []string{"title"}, args.Fields)
+	assert.True(t, args.IDsOnly)
+	assert.Equal(t, 250, args.TimeoutMs)
+	assert.Equal(t, "category", args.GroupBy)
+
+	// Omitted hnsw_params stay nil so the index default applies
+	req = VectorSearchRequest{}
+	err = json.Unmarshal([]byte(`{"query": [1.0], "k": 1}`), &req)
+	require.NoError(t, err)
+	assert.Nil(t, req.toSearchArgs().HnswParams)
+}
+
+func TestStatusFromError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"dimension mismatch", fmt.Errorf("%w: 2 does not match 3", vecdb.ErrDimMismatch), http.StatusBadRequest},
+		{"unsupported filter op", fmt.Errorf("%w: greater", vecdb.ErrUnsupportedFilterOp), http.StatusBadRequest},
+		{"invalid argument", fmt.Errorf("%w: docs", vecdb.ErrInvalidArgument), http.StatusBadRequest},
+		{"not found", fmt.Errorf("doc 7: %w", vecdb.ErrNotFound), http.StatusNotFound},
+		{"doc too large", fmt.Errorf("%w: 20 bytes", vecdb.ErrDocTooLarge), http.StatusRequestEntityTooLarge},
+		{"unsupported attribute", fmt.Errorf("row 0: attribute price: %w: 9.99 is not an integer", vecdb.ErrUnsupportedAttribute), http.StatusBadRequest},
+		{"read only", fmt.Errorf("%w", vecdb.ErrReadOnly), http.StatusForbidden},
+		{"attribute type mismatch", fmt.Errorf("%w: field flag has a bool value, expected int", vecdb.ErrAttributeTypeMismatch), http.StatusBadRequest},
+		{"non-finite vector", fmt.Errorf("%w: row 0 has NaN at column 2", vecdb.ErrNonFiniteVector), http.StatusBadRequest},
+		{"query timeout", fmt.Errorf("%w after 1s", vecdb.ErrQueryTimeout), http.StatusGatewayTimeout},
+		{"query canceled", vecdb.ErrQueryCanceled, http.StatusServiceUnavailable},
+		{"database closed", fmt.Errorf("search: %w", vecdb.ErrDatabaseClosed), http.StatusServiceUnavailable},
+		{"empty query", vecdb.ErrEmptyQuery, http.StatusBadRequest},
+		{"filter limit", fmt.Errorf("row 0: %w: field f would exceed the limit of 8 fields", vecdb.ErrFilterLimit), http.StatusBadRequest},
+		{"no rows", vecdb.ErrNoRows, http.StatusBadRequest},
+		{"id out of range", fmt.Errorf("%w: 18446744073709551615 exceeds 9223372036854775807", common.ErrIDOutOfRange), http.StatusBadRequest},
+		{"internal failure", errors.New("disk full"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, statusFromError(tt.err))
+		})
+	}
+}
+
+func TestHandlersErrorStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"search with wrong dimension", "/search", `{"query": [1.0, 2.0], "k": 1}`, http.StatusBadRequest},
+		{"search with unsupported filter op", "/search",
+			`{"query": [1.0, 2.0, 3.0], "k": 1, "filter_inputs": [{"field": "a", "op": "greater", "target": 1}]}`,
+			http.StatusBadRequest},
+		{"upsert with wrong dimension", "/upsert", `{"data": [[1.0, 2.0]], "docs": [{}]}`, http.StatusBadRequest},
+		{"upsert without docs", "/upsert", `{"data": [[1.0, 2.0, 3.0]]}`, http.StatusOK},
+		{"valid upsert", "/upsert", `{"data": [[1.0, 2.0, 3.0]], "docs": [{}]}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+
+	// A closed database is unavailable, as while the server shuts down
+	require.NoError(t, db.Close())
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query": [1.0, 2.0, 3.0], "k": 1}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHandlersValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		fields []FieldError
+	}{
+		{"search without k", "/search", `{"query": [1, 2, 3]}`,
+			[]FieldError{{Field: "k", Rule: "gt", Param: "0"}}},
+		{"search with negative k", "/search", `{"query": [1, 2, 3], "k": -1}`,
+			[]FieldError{{Field: "k", Rule: "gt", Param: "0"}}},
+		{"search without query", "/search", `{"k": 1}`,
+			[]FieldError{{Field: "query", Rule: "required_without_all", Param: "queries text"}}},
+		{"search with incomplete filter", "/search", `{"query": [1, 2, 3], "k": 1, "filter_inputs": [{"target": 1}]}`,
+			[]FieldError{
+				{Field: "filter_inputs[0].field", Rule: "required"},
+				{Field: "filter_inputs[0].op", Rule: "required"},
+			}},
+		{"search by id without id and k", "/search_by_id", `{}`,
+			[]FieldError{{Field: "id", Rule: "required"}, {Field: "k", Rule: "gt", Param: "0"}}},
+		{"docs without ids", "/docs", `{}`,
+			[]FieldError{{Field: "ids", Rule: "required"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			require.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+			var resp ValidationErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.fields, resp.Fields)
+			assert.True(t, strings.HasPrefix(resp.Error, "invalid request: "), resp.Error)
+		})
+	}
+
+	// Malformed JSON is still reported without a field list
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"k":`))
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NotContains(t, w.Body.String(), `"fields"`)
+}
+
+func TestHandleVectorUpsertLengths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	tests := []struct {
+		name    string
+		body    string
+		want    int
+		wantErr string
+	}{
+		{"docs and attributes omitted", `{"data": [[1, 0, 0], [0, 1, 0]]}`, http.StatusOK, ""},
+		{"docs and attributes per row", `{"data": [[1, 0, 0], [0, 1, 0]], "docs": [{}, {}], "attributes": [{"a": 1}, {"a": 2}]}`,
+			http.StatusOK, ""},
+		{"too few docs", `{"data": [[1, 0, 0], [0, 1, 0]], "docs": [{}]}`,
+			http.StatusBadRequest, "docs has 1 entries, expected 2 (one per data row)"},
+		{"too many attributes", `{"data": [[1, 0, 0]], "attributes": [{"a": 1}, {"a": 2}]}`,
+			http.StatusBadRequest, "attributes has 2 entries, expected 1 (one per data row)"},
+		{"docs without data", `{"docs": [{}]}`,
+			http.StatusBadRequest, "docs has 1 entries, expected 0 (one per data row)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/upsert", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+
+			if tt.wantErr != "" {
+				var resp map[string]string
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Equal(t, tt.wantErr, resp["error"])
+			}
+		})
+	}
+}
+
+// fakeEmbedder embeds each text as a one-hot vector picked by its length
+type fakeEmbedder struct {
+	dim int
+}
+
+func (f fakeEmbedder) Embed(texts []string) (*math.Matrix32, error) {
+	mat := math.NewMatrix32Empty(len(texts), f.dim)
+	for i, text := range texts {
+		mat.Set(i, len(text)%f.dim, 1)
+	}
+	return mat, nil
+}
+
+var _ embed.Embedder = fakeEmbedder{}
+
+func TestHandleVectorSearchEfSearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeHnsw,
+		HnswParams: &common.HnswIndexOption{EFConstruction: 40, M: 8},
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"name": "x"}, {"name": "y"}, {"name": "z"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A per-query efSearch is forwarded to the index and leaves later queries unaffected
+	for _, body := range []string{
+		`{"query": [0.0, 0.9, 0.1], "k": 2, "hnsw_params": {"ef_search": 64}}`,
+		`{"query": [0.0, 0.9, 0.1], "k": 2}`,
+	} {
+		w := post(body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp VectorSearchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Results, 2)
+		assert.Equal(t, "y", resp.Results[0]["name"])
+		assert.Equal(t, "z", resp.Results[1]["name"])
+	}
+
+	w := post(`{"query": [0.0, 0.9, 0.1], "k": 2, "hnsw_params": {"ef_search": -1}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func TestHandlersEmbedText(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+	defer SetEmbedder(embed.NoopEmbedder{})
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without an embedder, text requests are client errors
+	w := post("/search", `{"text": "abc", "k": 1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+	SetEmbedder(fakeEmbedder{dim: 3})
+
+	w = post("/upsert", `{"texts": ["a", "ab"], "docs": [{"name": "a"}, {"name": "ab"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = post("/search", `{"text": "xy", "k": 1}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp VectorSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 1)
+	assert.Equal(t, "ab", resp.Results[0]["name"])
+
+	// Sending both a vector and text is ambiguous
+	w = post("/search", `{"query": [1.0, 0.0, 0.0], "text": "xy", "k": 1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func TestHandleScan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	scan := func(query string) (int, ScanResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scan"+query, nil))
+		var resp ScanResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	// Page through all documents two at a time
+	var names []any
+	cursor := uint64(0)
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3, "scan should finish in two pages")
+
+		code, resp := scan(fmt.Sprintf("?limit=2&cursor=%d", cursor))
+		require.Equal(t, http.StatusOK, code)
+		for _, doc := range resp.Docs {
+			names = append(names, doc["name"])
+		}
+		if resp.NextCursor == 0 {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	assert.Equal(t, []any{"a", "b", "c"}, names)
+
+	code, _ := scan("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = scan("?cursor=abc")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestHandleFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+		Attributes: []map[string]any{{"year": float64(2020), "draft": true}, {"year": float64(2024)}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fields", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"fields": [
+		{"name": "draft", "type": "bool", "min": 1, "max": 1, "distinct": 1},
+		{"name": "year", "type": "int", "min": 2020, "max": 2024, "distinct": 2}
+	]}`, w.Body.String())
+}
+
+func TestHandleStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats vecdb.OpStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, uint64(2), stats.Inserts)
+	assert.Zero(t, stats.Deletes)
+	assert.Positive(t, stats.WALBytes)
+	assert.Positive(t, stats.OpsPerSecond)
+	assert.Equal(t, uint64(2*3*4), stats.Memory.VectorIndexBytes)
+	assert.Positive(t, stats.Memory.TotalBytes)
+}
+
+func TestHandleQueries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	router := gin.New()
+	router.GET("/admin/queries", HandleListQueries)
+	router.POST("/admin/queries/:id/cancel", HandleCancelQuery)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/queries", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"queries": []}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/queries/7/cancel", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/queries/abc/cancel", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleVerify(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/admin/verify", HandleVerify)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/verify?repair=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"vectors": 2,
+		"docs": 2,
+		"missing_docs": [],
+		"orphan_docs": [],
+		"dangling_filter_ids": [],
+		"repaired": false
+	}`, w.Body.String())
+}
+
+func TestHandleCompactStorage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	router := gin.New()
+	router.POST("/admin/compact", HandleCompactStorage)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/compact", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"took_ms"`)
+
+	stats, err := db.OpStats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), stats.StorageCompactions)
+}
+
+func TestHandlePendingRecords(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	// Async writes stay pending until the next background sync
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+		Attributes: []map[string]any{{"category": float64(1)}, {"category": float64(2)}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/admin/pending", RequireBearerToken("secret"), HandlePendingRecords)
+
+	get := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/admin/pending", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Without a limit only the summary is returned
+	w = get("/admin/pending", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var pending persistence.PendingSnapshot
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	assert.Equal(t, 2, pending.Count)
+	assert.Equal(t, map[string]int{"Insert": 2}, pending.Operations)
+	assert.Empty(t, pending.Records)
+
+	w = get("/admin/pending?limit=1", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	pending = persistence.PendingSnapshot{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	assert.Equal(t, 2, pending.Count)
+	require.Len(t, pending.Records, 1)
+	assert.Equal(t, "Insert", pending.Records[0].Operation)
+	assert.Equal(t, 3, pending.Records[0].Dim)
+	assert.Equal(t, "a", pending.Records[0].Doc["name"])
+	assert.Equal(t, map[string]any{"category": float64(1)}, pending.Records[0].Attributes)
+
+	// Large limits are capped rather than rejected
+	w = get(fmt.Sprintf("/admin/pending?limit=%d", MaxPendingLimit*10), "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	pending = persistence.PendingSnapshot{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	assert.Len(t, pending.Records, 2)
+
+	w = get("/admin/pending?limit=-1", "secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Large docs are left out of the listing, with their size
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+		Docs:    []map[string]any{{"text": strings.Repeat("x", persistence.MaxPendingDocBytes)}},
+	})
+	require.NoError(t, err)
+	w = get("/admin/pending?limit=3", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	pending = persistence.PendingSnapshot{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	require.Len(t, pending.Records, 3)
+	assert.Equal(t, "a", pending.Records[0].Doc["name"])
+	assert.False(t, pending.Records[0].DocOmitted)
+	assert.Nil(t, pending.Records[2].Doc)
+	assert.True(t, pending.Records[2].DocOmitted)
+	assert.Greater(t, pending.Records[2].DocBytes, persistence.MaxPendingDocBytes)
+
+	// Synced records are no longer pending
+	require.NoError(t, db.Sync())
+	w = get("/admin/pending", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"count": 0, "inflight": 0, "operations": {}}`, w.Body.String())
+}
+
+func TestHandleGetDocs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	// Not synced yet, but found
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+		Docs:    []map[string]any{{"name": "c"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/docs", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"ids": [3, 9, 1]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp DocsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Docs, 3)
+	assert.Equal(t, []bool{true, false, true}, resp.Found)
+	assert.Equal(t, "c", resp.Docs[0]["name"])
+	assert.Nil(t, resp.Docs[1])
+	assert.Equal(t, "a", resp.Docs[2]["name"])
+
+	w = post(`{"ids": []}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"docs": [], "found": []}`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, post(`{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"ids": [-1]}`).Code)
+
+	ids := make([]uint64, MaxDocsIDs+1)
+	body, err := json.Marshal(DocsRequest{IDs: ids})
+	require.NoError(t, err)
+	w = post(string(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "at most 1000")
+}
+
+func TestHandleUpdateDoc(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+		Docs:       []map[string]any{{"name": "a"}},
+		Attributes: []map[string]any{{"category": 1}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.PATCH("/doc/:id", HandleUpdateDoc)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"update", "/doc/1", `{"doc": {"name": "b"}, "attributes": {"category": 2}}`, http.StatusOK},
+		{"unknown id", "/doc/7", `{"doc": {"name": "b"}}`, http.StatusNotFound},
+		{"invalid id", "/doc/abc", `{}`, http.StatusBadRequest},
+		{"invalid body", "/doc/1", `[]`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+
+	docs, err := db.GetDocs([]uint64{1})
+	require.NoError(t, err)
+	assert.Equal(t, "b", docs[0]["name"])
+	assert.Equal(t, map[string]any{"category": float64(2)}, docs[0][common.DocFieldAttributes])
+}
+
+func TestRoundScores(t *testing.T) {
+	results := []common.DocMap{
+		{common.DocFieldScore: float32(1.4142135)},
+		{common.DocFieldScore: float32(0.0004999)},
+	}
+	roundScores(results, 3)
+	assert.Equal(t, 1.414, results[0][common.DocFieldScore])
+	assert.Equal(t, 0.0, results[1][common.DocFieldScore])
+
+	// A negative precision leaves scores untouched
+	results = []common.DocMap{{common.DocFieldScore: float32(1.4142135)}}
+	roundScores(results, -1)
+	assert.Equal(t, float32(1.4142135), results[0][common.DocFieldScore])
+}
+
+func TestHandleVectorSearchScorePrecision(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}},
+	})
+	require.NoError(t, err)
+
+	SetScorePrecision(2)
+	defer SetScorePrecision(-1)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	search := func(body string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+
+	// The squared L2 distance from (0.1, 0, 0) to (1, 1, 0) is 1.81
+	assert.Contains(t, search(`{"query": [0.1, 0.0, 0.0], "k": 1}`), `"_score":1.81`)
+	assert.Contains(t, search(`{"query": [0.1, 0.0, 0.0], "k": 1, "score_precision": 0}`), `"_score":2`)
+}
+
+func TestHandleVectorSearchDebug(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+		Attributes: []map[string]any{{"category": float64(1)}, {"category": float64(2)}, {"category": float64(1)}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	search := func(target, body string) VectorSearchResponse {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response VectorSearchResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	body := `{"query": [1, 0, 0], "k": 1, "filter_inputs": [{"field": "category", "op": "equal", "target": 1}]}`
+
+	// Timing info is left out unless asked for
+	response := search("/search", body)
+	assert.Nil(t, response.TookMs)
+	assert.Nil(t, response.TotalCandidates)
+
+	response = search("/search?debug=true", body)
+	require.NotNil(t, response.TookMs)
+	assert.GreaterOrEqual(t, *response.TookMs, 0.0)
+	require.NotNil(t, response.TotalCandidates)
+	assert.Equal(t, 2, *response.TotalCandidates)
+
+	SetDebugResponses(true)
+	defer SetDebugResponses(false)
+
+	response = search("/search", `{"query": [1, 0, 0], "k": 1}`)
+	require.NotNil(t, response.TotalCandidates)
+	assert.Equal(t, 3, *response.TotalCandidates)
+}
+
+func TestHandleVectorSearchStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	search := func(body, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := search(`{"query": [1, 0, 0], "k": 2, "score_precision": 2}`, "application/json, application/x-ndjson")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, NDJSONContentType, w.Header().Get("Content-Type"))
+	assert.True(t, w.Flushed)
+
+	// One result per line, best-first
+	var names []any
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var result common.DocMap
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+		assert.Contains(t, result, common.DocFieldScore)
+		names = append(names, result["name"])
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []any{"a", "b"}, names)
+
+	// Errors before the first result keep their status
+	w = search(`{"query": [1, 0], "k": 2}`, NDJSONContentType)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"error"`)
+
+	// No results is an empty stream
+	w = search(`{"query": [1, 0, 0], "k": 2, "exclude_ids": [1, 2, 3]}`, NDJSONContentType)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, w.Body.String())
+
+	// Without the header the response is buffered as before
+	w = search(`{"query": [1, 0, 0], "k": 2}`, "application/json")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response VectorSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Results, 2)
+}
+
+func TestHandleSearchByID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	params := common.DatabaseParams{
+		FilePath:   t.TempDir(),
+		Dim:        3,
+		MetricType: common.MetricTypeL2,
+		IndexType:  common.IndexTypeFlat,
+	}
+	db, err := vecdb.NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	Initialize(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	router := gin.New()
+	SetupRoutes(router)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/search_by_id", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"id": 1, "k": 2}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp VectorSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 1)
+	assert.Equal(t, "b", resp.Results[0]["name"])
+
+	w = post(`{"id": 1, "k": 2, "include_self": true, "ids_only": true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 2)
+	assert.Equal(t, float64(1), resp.Results[0]["id"])
+
+	assert.Equal(t, http.StatusNotFound, post(`{"id": 42, "k": 1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`{"id": 1, "k": 1, "query": [1, 0, 0]}`).Code)
+}
+
+// TestAPIContract pins the JSON field names of the request and response types served by
+// the handlers, so renaming a field, which breaks clients, fails here first
+func TestAPIContract(t *testing.T) {
+	precision := 3
+	tookMs := 1.5
+	candidates := 10
+
+	tests := []struct {
+		name     string
+		value    any
+		wantKeys []string
+	}{
+		{
+			name: "VectorSearchRequest",
+			value: &VectorSearchRequest{
+				Query:           []float32{1, 2},
+				Text:            "hello",
+				Queries:         [][]float32{{1, 2}},
+				Aggregation:     common.AggregationMean,
+				FilterInputs:    []common.IntFilterInput{{Field: "category", Op: "equal", Target: 19.99}},
+				K:               5,
+				HnswParams:      &common.HnswSearchOption{EfSearch: 64},
+				ExcludeIDs:      []uint64{3},
+				Fields:          []string{"name"},
+				IDsOnly:         true,
+				TimeoutMs:       100,
+				GroupBy:         "category",
+				NormalizeScores: true,
+				ScorePrecision:  &precision,
+				Explain:         true,
+				Exact:           true,
+			},
+			wantKeys: []string{
+				"query", "text", "queries", "aggregation", "filter_inputs", "k", "hnsw_params",
+				"exclude_ids", "fields", "ids_only", "timeout_ms", "group_by", "normalize_scores",
+				"score_precision", "explain", "exact",
+			},
+		},
+		{
+			name: "SearchByIDRequest",
+			value: &SearchByIDRequest{
+				ID:                  7,
+				IncludeSelf:         true,
+				VectorSearchRequest: VectorSearchRequest{K: 5},
+			},
+			wantKeys: []string{"id", "include_self", "query", "k"},
+		},
+		{
+			name: "VectorUpsertRequest",
+			value: &VectorUpsertRequest{
+				Data:       math.Matrix32{Rows: 2, Cols: 2, Data: []float32{1, 2, 3, 4}},
+				Texts:      []string{"a", "b"},
+				Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+				Attributes: []map[string]any{{"category": float64(1)}, {"category": float64(2)}},
+				HnswParams: &common.HnswParams{EFConstruction: 40},
+			},
+			wantKeys: []string{"data", "texts", "docs", "attributes", "hnsw_params"},
+		},
+		{
+			name: "VectorSearchResponse",
+			value: &VectorSearchResponse{
+				Results:         []common.DocMap{{common.DocFieldID: float64(1), common.DocFieldScore: 0.5}},
+				TookMs:          &tookMs,
+				TotalCandidates: &candidates,
+			},
+			wantKeys: []string{"results", "took_ms", "total_candidates"},
+		},
+		{
+			name:     "VectorUpsertResponse",
+			value:    &VectorUpsertResponse{Message: "Upsert successful"},
+			wantKeys: []string{"message"},
+		},
+		{
+			name:     "ScanResponse",
+			value:    &ScanResponse{Docs: []common.DocMap{{"name": "a"}}, NextCursor: 2},
+			wantKeys: []string{"docs", "next_cursor"},
+		},
+		{
+			name: "FieldsResponse",
+			value: &FieldsResponse{Fields: []vecdb.FieldInfo{
+				{Name: "category", Type: filter.FieldTypeBool, Min: 0, Max: 1, Distinct: 2},
+			}},
+			wantKeys: []string{"fields"},
+		},
+		{
+			name: "QueriesResponse",
+			value: &QueriesResponse{Queries: []vecdb.RunningQuery{
+				{ID: 1, Started: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), K: 5, Vectors: 1, Filters: 2},
+			}},
+			wantKeys: []string{"queries"},
+		},
+		{
+			name: "DocUpdateRequest",
+			value: &DocUpdateRequest{
+				Doc:        map[string]any{"name": "a"},
+				Attributes: map[string]any{"category": float64(1)},
+			},
+			wantKeys: []string{"doc", "attributes"},
+		},
+		{
+			name:     "DocsRequest",
+			value:    &DocsRequest{IDs: []uint64{1, 2}},
+			wantKeys: []string{"ids"},
+		},
+		{
+			name:     "DocsResponse",
+			value:    &DocsResponse{Docs: []common.DocMap{{"name": "a"}, nil}, Found: []bool{true, false}},
+			wantKeys: []string{"docs", "found"},
+		},
+		{
+			name: "OpStats",
+			value: &vecdb.OpStats{
+				OpStats:               persistence.OpStats{Inserts: 1, Deletes: 2, WALBytes: 3, OpsPerSecond: 4},
+				FilterLimitRejections: 5,
+				StorageCompactions:    6,
+				Memory:                vecdb.MemoryStats{VectorIndexBytes: 7, FilterIndexBytes: 8, PendingWALBytes: 9, TotalBytes: 24},
+			},
+			wantKeys: []string{"inserts", "deletes", "wal_bytes", "ops_per_second", "filter_limit_rejections", "storage_compactions", "memory"},
+		},
+		{
+			name: "VerifyReport",
+			value: &vecdb.VerifyReport{
+				Vectors:           2,
+				Docs:              1,
+				MissingDocs:       []uint64{2},
+				OrphanDocs:        []uint64{},
+				DanglingFilterIDs: []uint64{},
+				Repaired:          true,
+			},
+			wantKeys: []string{"vectors", "docs", "missing_docs", "orphan_docs", "dangling_filter_ids", "repaired"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.value)
+			require.NoError(t, err)
+
+			var fields map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(data, &fields))
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			assert.ElementsMatch(t, tt.wantKeys, keys)
+
+			decoded := reflect.New(reflect.TypeOf(tt.value).Elem()).Interface()
+			require.NoError(t, json.Unmarshal(data, decoded))
+			assert.Equal(t, tt.value, decoded)
+
+			assertSnakeCaseTags(t, reflect.TypeOf(tt.value), map[reflect.Type]bool{})
+		})
+	}
+
+	// Matrices whose data does not match their shape fail to encode instead of panicking
+	for _, m := range []math.Matrix32{
+		{Rows: 2, Cols: 3, Data: []float32{1, 2, 3}},
+		{Rows: -1, Cols: 3},
+	} {
+		_, err := json.Marshal(common.VdbUpsertArgs{Vectors: m})
+		assert.Error(t, err)
+	}
+}
+
+var (
+	snakeCase     = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+)
+
+// assertSnakeCaseTags checks that every JSON field name of typ and the structs it holds
+// is snake_case; types with their own JSON encoding are skipped
+func assertSnakeCaseTags(t *testing.T, typ reflect.Type, seen map[reflect.Type]bool) {
+	t.Helper()
+
+	for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || seen[typ] || reflect.PointerTo(typ).Implements(jsonMarshaler) {
+		return
+	}
+	seen[typ] = true
+
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name != "" {
+			assert.Regexp(t, snakeCase, name, "JSON name of %s.%s", typ.Name(), field.Name)
+		} else if !field.Anonymous {
+			t.Errorf("%s.%s has no JSON tag, so it is served as %q", typ.Name(), field.Name, field.Name)
+		}
+		assertSnakeCaseTags(t, field.Type, seen)
+	}
+}
@@ -6,5 +6,11 @@ import (
 
 func SetupRoutes(router *gin.Engine) {
 	router.POST("/search", HandleVectorSearch)
+	router.POST("/search_by_id", HandleSearchByID)
 	router.POST("/upsert", HandleVectorUpsert)
+	router.GET("/scan", HandleScan)
+	router.GET("/fields", HandleFields)
+	router.GET("/stats", HandleStats)
+	router.POST("/docs", HandleGetDocs)
+	router.PATCH("/doc/:id", HandleUpdateDoc)
 }
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes a request field that failed a binding rule
+type FieldError struct {
+	Field string `json:"field"` // JSON path of the field, e.g. "filter_inputs[0].op"
+	Rule  string `json:"rule"`  // the failed rule, e.g. "required" or "gt"
+	Param string `json:"param,omitempty"`
+}
+
+// ValidationErrorResponse is the body of a 400 response to a request whose fields
+// failed validation
+type ValidationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+func init() {
+	// Report fields under their JSON names rather than their Go names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// bindJSON decodes the request body into payload and checks its binding tags; on
+// failure it writes a 400 response, listing the failed fields if any, and returns false
+func bindJSON(c *gin.Context, payload any) bool {
+	err := c.ShouldBindJSON(payload)
+	if err == nil {
+		return true
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	fields := make([]FieldError, len(verrs))
+	for i, fe := range verrs {
+		fields[i] = FieldError{Field: fieldPath(fe.Namespace()), Rule: fe.Tag(), Param: fe.Param()}
+	}
+	writeFieldErrors(c, fields)
+	return false
+}
+
+// writeFieldErrors responds 400 with the failed fields, summarized in the error message
+func writeFieldErrors(c *gin.Context, fields []FieldError) {
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f.message()
+	}
+	c.JSON(http.StatusBadRequest, ValidationErrorResponse{
+		Error:  "invalid request: " + strings.Join(msgs, "; "),
+		Fields: fields,
+	})
+}
+
+func (f FieldError) message() string {
+	switch f.Rule {
+	case "required":
+		return f.Field + " is required"
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", f.Field, f.Param)
+	case "required_without_all":
+		return fmt.Sprintf("%s is required unless one of %s is set", f.Field, strings.ReplaceAll(f.Param, " ", ", "))
+	default:
+		return fmt.Sprintf("%s failed the %s rule", f.Field, f.Rule)
+	}
+}
+
+// fieldPath turns a validator namespace such as "SearchByIDRequest.VectorSearchRequest.k"
+// into the JSON path "k": the top-level struct and embedded structs keep their Go names,
+// which start with an upper case letter unlike the JSON names of the API
+func fieldPath(namespace string) string {
+	parts := strings.Split(namespace, ".")
+	path := parts[:0]
+	for _, p := range parts[1:] {
+		if p != "" && p[0] >= 'A' && p[0] <= 'Z' {
+			continue
+		}
+		path = append(path, p)
+	}
+	return strings.Join(path, ".")
+}
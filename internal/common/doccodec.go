@@ -0,0 +1,100 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// DocCodec is the format documents are serialized with in scalar storage
+type DocCodec string
+
+const (
+	// DocCodecJSON stores documents as JSON, the default
+	DocCodecJSON DocCodec = "json"
+	// DocCodecMsgpack stores documents as MessagePack, which is smaller and faster to
+	// (de)serialize for docs with many fields
+	DocCodecMsgpack DocCodec = "msgpack"
+)
+
+// docPrefixMsgpack marks a document stored as MessagePack. JSON documents are stored
+// without a prefix, as before codecs existed; they start with '{', and 0xc1 is neither
+// valid JSON nor a valid MessagePack type byte, so documents of both codecs can be told
+// apart when they coexist, e.g. after the codec of a database changed.
+const docPrefixMsgpack byte = 0xc1
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+// ValidateDocCodec returns an error for anything but a known codec; empty selects DocCodecJSON
+func ValidateDocCodec(c DocCodec) error {
+	switch c {
+	case "", DocCodecJSON, DocCodecMsgpack:
+		return nil
+	default:
+		return fmt.Errorf("unsupported doc codec: %s", c)
+	}
+}
+
+// EncodeDoc serializes a stored document with the codec; empty selects DocCodecJSON
+func EncodeDoc(doc map[string]any, c DocCodec) ([]byte, error) {
+	switch c {
+	case "", DocCodecJSON:
+		return json.Marshal(doc)
+	case DocCodecMsgpack:
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(doc); err != nil {
+			return nil, err
+		}
+		return append([]byte{docPrefixMsgpack}, data...), nil
+	default:
+		return nil, fmt.Errorf("unsupported doc codec: %s", c)
+	}
+}
+
+// DecodeDoc deserializes a stored document of either codec. Numbers come back as
+// float64 whatever the codec, as JSON decodes them, so a document reads the same
+// however it was stored.
+func DecodeDoc(data []byte) (DocMap, error) {
+	if len(data) == 0 || data[0] != docPrefixMsgpack {
+		return JSONUnmarshal[DocMap](data)
+	}
+
+	var doc map[string]any
+	if err := codec.NewDecoderBytes(data[1:], msgpackHandle).Decode(&doc); err != nil {
+		return nil, err
+	}
+	for k, v := range doc {
+		doc[k] = jsonNumbers(v)
+	}
+	return doc, nil
+}
+
+// jsonNumbers converts the integers of a decoded MessagePack value to float64, in
+// nested maps and slices too
+func jsonNumbers(v any) any {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = jsonNumbers(e)
+		}
+	}
+	return v
+}
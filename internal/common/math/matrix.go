@@ -3,6 +3,7 @@ package math
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 
 	"github.com/samber/lo"
 )
@@ -46,6 +47,18 @@ func NewMatrix32Empty(rows, cols int) *Matrix32 {
 	}
 }
 
+// Validate checks that the dimensions are not negative and that Data holds exactly
+// Rows*Cols elements, which matrices built as struct literals do not guarantee
+func (m *Matrix32) Validate() error {
+	if m.Rows < 0 || m.Cols < 0 {
+		return fmt.Errorf("invalid matrix dimensions %dx%d", m.Rows, m.Cols)
+	}
+	if len(m.Data) != m.Rows*m.Cols {
+		return fmt.Errorf("matrix of %dx%d has %d elements, expected %d", m.Rows, m.Cols, len(m.Data), m.Rows*m.Cols)
+	}
+	return nil
+}
+
 func (m *Matrix32) Size() int {
 	return m.Rows * m.Cols
 }
@@ -70,6 +83,59 @@ func (m *Matrix32) Set(i, j int, val float32) {
 	m.Data[i*m.Cols+j] = val
 }
 
+// FindNonFinite returns the position of the first NaN or infinite element in row-major
+// order, with found set to false if every element is finite
+func (m *Matrix32) FindNonFinite() (row, col int, found bool) {
+	i := FirstNonFinite(m.Data)
+	if i < 0 || m.Cols == 0 {
+		return 0, 0, false
+	}
+	return i / m.Cols, i % m.Cols, true
+}
+
+// FirstNonFinite returns the index of the first NaN or infinite value, or -1 if there is none
+func FirstNonFinite(values []float32) int {
+	for i, v := range values {
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Normalize scales values in place to unit L2 norm and returns the original norm;
+// a zero vector is left unchanged
+func Normalize(values []float32) float32 {
+	var sum float64
+	for _, v := range values {
+		sum += float64(v) * float64(v)
+	}
+	norm := float32(math.Sqrt(sum))
+	if norm == 0 {
+		return 0
+	}
+	for i := range values {
+		values[i] /= norm
+	}
+	return norm
+}
+
+// MarshalJSON implements json.Marshaler interface
+// Produces the format UnmarshalJSON accepts: [[1.0, 2.0, 3.0], [4.0, 5.0, 6.0]]
+// Matrices that fail Validate are rejected with an error.
+func (m Matrix32) MarshalJSON() ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to marshal matrix: %w", err)
+	}
+
+	rows := make([][]float32, m.Rows)
+	for i := range rows {
+		rows[i] = m.Data[i*m.Cols : (i+1)*m.Cols]
+	}
+	return json.Marshal(rows)
+}
+
 // UnmarshalJSON implements json.Unmarshaler interface
 // Accepts JSON in the format: [[1.0, 2.0, 3.0], [4.0, 5.0, 6.0]]
 func (m *Matrix32) UnmarshalJSON(data []byte) error {
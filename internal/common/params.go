@@ -1,6 +1,11 @@
 package common
 
-import "vecdb-go/internal/common/math"
+import (
+	gomath "math"
+	"time"
+
+	"vecdb-go/internal/common/math"
+)
 
 // IndexType represents the type of vector index
 type IndexType string
@@ -8,6 +13,8 @@ type IndexType string
 const (
 	IndexTypeFlat IndexType = "flat"
 	IndexTypeHnsw IndexType = "hnsw"
+	// IndexTypeCustom builds the index from DatabaseParams.FactoryString
+	IndexTypeCustom IndexType = "custom"
 )
 
 // MetricType represents the distance metric type
@@ -16,23 +23,207 @@ type MetricType string
 const (
 	MetricTypeL2 MetricType = "l2"
 	MetricTypeIP MetricType = "ip"
+	// MetricTypeCosine is inner product over vectors normalized to unit length
+	MetricTypeCosine MetricType = "cosine"
+)
+
+// IsSimilarity reports whether the metric produces similarity scores (larger is closer)
+// rather than distances (smaller is closer)
+func (m MetricType) IsSimilarity() bool {
+	return m == MetricTypeIP || m == MetricTypeCosine
+}
+
+// Better reports whether score a ranks closer to the query than score b under the metric
+func (m MetricType) Better(a, b float32) bool {
+	if m.IsSimilarity() {
+		return a > b
+	}
+	return a < b
+}
+
+// NormalizedScore maps a score of the metric to a similarity between 0 and 1, where
+// larger is closer: 1/(1+d) of the squared L2 distance d, the logistic sigmoid of an
+// inner product, and (1+s)/2 of a cosine similarity s
+func (m MetricType) NormalizedScore(score float32) float32 {
+	switch m {
+	case MetricTypeIP:
+		return float32(1 / (1 + gomath.Exp(-float64(score))))
+	case MetricTypeCosine:
+		return min(max((1+score)/2, 0), 1)
+	default:
+		return 1 / (1 + max(score, 0))
+	}
+}
+
+// Aggregation selects how a multi-vector search combines the scores of a candidate
+type Aggregation string
+
+const (
+	// AggregationMax ranks candidates by their best score across the query vectors,
+	// which is the smallest distance for L2
+	AggregationMax Aggregation = "max"
+	// AggregationMean ranks candidates by their mean score across the query vectors
+	AggregationMean Aggregation = "mean"
+)
+
+// CDCPolicy represents what happens when a change-data-capture subscriber's buffer is full
+type CDCPolicy string
+
+const (
+	CDCPolicyDrop  CDCPolicy = "drop"
+	CDCPolicyBlock CDCPolicy = "block"
+)
+
+// SyncTxMode represents how scalar writes of a sync batch are grouped into transactions
+type SyncTxMode string
+
+const (
+	// SyncTxModeBatch writes the whole batch in one all-or-nothing transaction
+	SyncTxModeBatch SyncTxMode = "batch"
+	// SyncTxModePerRecord writes each record in its own transaction
+	SyncTxModePerRecord SyncTxMode = "per_record"
 )
 
+// DefaultMaxDim is the largest vector dimension accepted when DatabaseParams.MaxDim is unset
+const DefaultMaxDim = 65536
+
+// DefaultMaxDocBytes is the largest serialized document accepted when
+// DatabaseParams.MaxDocBytes is unset. It stays well below the 64MB NutsDB segment
+// size, which a single value must fit in.
+const DefaultMaxDocBytes = 16 << 20
+
+// DefaultSyncIntervalMs is how often pending WAL records are synced in the background
+const DefaultSyncIntervalMs = 5000
+
+// DefaultPostFilterFetchFactor is how many hits per requested result a query with a
+// PostFilter fetches when DatabaseParams.PostFilterFetchFactor is unset
+const DefaultPostFilterFetchFactor = 4
+
+// MaxVectorID is the largest vector ID a database can assign.
+// IDs are cast to int64 FAISS labels, where values above MaxInt64 would turn negative and
+// be mistaken for the -1 "no result" sentinel, and the filter index keeps IDs in 32-bit
+// roaring bitmaps, so the usable ID space is bounded by the smaller of the two.
+const MaxVectorID = 1<<32 - 1
+
 // DatabaseParams contains parameters for database initialization
 type DatabaseParams struct {
-	FilePath    string           `json:"file_path" toml:"file_path"`
-	Dim         int              `json:"dim" toml:"dim"`
-	MetricType  MetricType       `json:"metric_type" toml:"metric_type"`
-	IndexType   IndexType        `json:"index_type" toml:"index_type"`
-	EncoderType string           `json:"encoder_type,omitempty" toml:"encoder_type,omitempty"` // "binary" or "text"
-	HnswParams  *HnswIndexOption `json:"hnsw_params,omitempty" toml:"hnsw_params,omitempty"`
-	Version     string           `json:"version" toml:"version"`
+	FilePath              string            `json:"file_path" toml:"file_path"`
+	Dim                   int               `json:"dim" toml:"dim"`
+	MetricType            MetricType        `json:"metric_type" toml:"metric_type"`
+	IndexType             IndexType         `json:"index_type" toml:"index_type"`
+	EncoderType           string            `json:"encoder_type,omitempty" toml:"encoder_type,omitempty"` // "binary" or "text"
+	WALChecksum           string            `json:"wal_checksum,omitempty" toml:"wal_checksum,omitempty"` // "crc32" (default), "crc32c" or "xxhash"; binary encoder only
+	HnswParams            *HnswIndexOption  `json:"hnsw_params,omitempty" toml:"hnsw_params,omitempty"`
+	FactoryString         string            `json:"factory_string,omitempty" toml:"factory_string,omitempty"` // FAISS index factory string of index_type "custom"
+	TrainSize             int               `json:"train_size,omitempty" toml:"train_size,omitempty"`         // vectors a "custom" index that needs training buffers first; 0 trains on the first insert
+	CDC                   *CDCOption        `json:"cdc,omitempty" toml:"cdc,omitempty"`
+	MaxDim                int               `json:"max_dim,omitempty" toml:"max_dim,omitempty"`           // defaults to DefaultMaxDim
+	SyncTxMode            SyncTxMode        `json:"sync_tx_mode,omitempty" toml:"sync_tx_mode,omitempty"` // "batch" (default) or "per_record"
+	IDOffset              uint64            `json:"id_offset,omitempty" toml:"id_offset,omitempty"`       // first assigned ID is IDOffset+1
+	WarmUp                *WarmUpOption     `json:"warm_up,omitempty" toml:"warm_up,omitempty"`
+	QueryTimeoutMs        int               `json:"query_timeout_ms,omitempty" toml:"query_timeout_ms,omitempty"`                 // 0 means no timeout
+	MaxDocBytes           int               `json:"max_doc_bytes,omitempty" toml:"max_doc_bytes,omitempty"`                       // defaults to DefaultMaxDocBytes
+	SkipFiniteCheck       bool              `json:"skip_finite_check,omitempty" toml:"skip_finite_check,omitempty"`               // accept NaN and Inf vector values unchecked
+	ReplaceExistingIDs    bool              `json:"replace_existing_ids,omitempty" toml:"replace_existing_ids,omitempty"`         // re-inserting an indexed ID replaces its vector; flat indexes only
+	StrictAttributeTypes  bool              `json:"strict_attribute_types,omitempty" toml:"strict_attribute_types,omitempty"`     // the first value of a field fixes its type
+	MaxSyncBatch          int               `json:"max_sync_batch,omitempty" toml:"max_sync_batch,omitempty"`                     // 0 applies all pending records at once
+	FlushEveryN           int               `json:"flush_every_n,omitempty" toml:"flush_every_n,omitempty"`                       // 0 relies on the other flush triggers
+	CompactStorageAfter   int               `json:"compact_storage_after,omitempty" toml:"compact_storage_after,omitempty"`       // deletes and updates between storage compactions, 0 disables them
+	PostFilterFetchFactor int               `json:"post_filter_fetch_factor,omitempty" toml:"post_filter_fetch_factor,omitempty"` // defaults to DefaultPostFilterFetchFactor
+	FailOnRestoreError    bool              `json:"fail_on_restore_error,omitempty" toml:"fail_on_restore_error,omitempty"`       // refuse to open if the WAL cannot be restored
+	MaxCorruptWALBytes    int64             `json:"max_corrupt_wal_bytes,omitempty" toml:"max_corrupt_wal_bytes,omitempty"`       // corrupt WAL bytes FailOnRestoreError tolerates
+	ReadOnly              bool              `json:"read_only,omitempty" toml:"read_only,omitempty"`                               // query-only access, writes fail with ErrReadOnly
+	SyncIntervalMs        int               `json:"sync_interval_ms,omitempty" toml:"sync_interval_ms,omitempty"`                 // background sync period, defaults to DefaultSyncIntervalMs
+	MaxConcurrentSearches int               `json:"max_concurrent_searches,omitempty" toml:"max_concurrent_searches,omitempty"`   // index searches running at once, 0 means unlimited
+	QueryCache            *QueryCacheOption `json:"query_cache,omitempty" toml:"query_cache,omitempty"`
+	MaxFilterFields       int               `json:"max_filter_fields,omitempty" toml:"max_filter_fields,omitempty"` // distinct attribute fields, 0 means unlimited
+	MaxFilterValues       int               `json:"max_filter_values,omitempty" toml:"max_filter_values,omitempty"` // distinct values per attribute field, 0 means unlimited
+	FilterableFields      []string          `json:"filterable_fields,omitempty" toml:"filterable_fields,omitempty"` // attribute fields to index, empty indexes all
+	DocCodec              DocCodec          `json:"doc_codec,omitempty" toml:"doc_codec,omitempty"`                 // "json" (default) or "msgpack"
+	StorageRetry          *RetryOption      `json:"storage_retry,omitempty" toml:"storage_retry,omitempty"`
+	Version               string            `json:"version" toml:"version"`
+}
+
+// CDCOption contains change-data-capture subscription parameters
+type CDCOption struct {
+	BufferSize int       `json:"buffer_size" toml:"buffer_size"`
+	Policy     CDCPolicy `json:"policy" toml:"policy"` // "drop" or "block"
+}
+
+// RetryOption bounds the retries of scalar storage operations failing with a
+// transient error; unset fields keep the defaults of scalar.DefaultRetryPolicy
+type RetryOption struct {
+	MaxAttempts      int `json:"max_attempts" toml:"max_attempts"`             // attempts including the first, 1 disables retries
+	InitialBackoffMs int `json:"initial_backoff_ms" toml:"initial_backoff_ms"` // wait before the first retry, doubled before each further one
+	MaxElapsedMs     int `json:"max_elapsed_ms" toml:"max_elapsed_ms"`         // no retry starts once this long has passed
+}
+
+// WarmUpOption contains parameters for warming up the vector index after restore
+type WarmUpOption struct {
+	Queries   int `json:"queries" toml:"queries"`       // synthetic queries to run, defaults to 32
+	TimeoutMs int `json:"timeout_ms" toml:"timeout_ms"` // upper bound on warm-up time, defaults to 1000
+}
+
+// QueryCacheOption contains parameters of the cache of recent query results
+type QueryCacheOption struct {
+	Size  int `json:"size" toml:"size"`     // most results kept, 0 disables the cache
+	TTLMs int `json:"ttl_ms" toml:"ttl_ms"` // how long a result stays cached, defaults to 1000
+}
+
+// EffectiveMaxDim returns the configured maximum vector dimension, or DefaultMaxDim if unset
+func (p *DatabaseParams) EffectiveMaxDim() int {
+	if p.MaxDim > 0 {
+		return p.MaxDim
+	}
+	return DefaultMaxDim
+}
+
+// EffectiveSyncInterval returns the configured background sync period, or
+// DefaultSyncIntervalMs if unset
+func (p *DatabaseParams) EffectiveSyncInterval() time.Duration {
+	if p.SyncIntervalMs > 0 {
+		return time.Duration(p.SyncIntervalMs) * time.Millisecond
+	}
+	return DefaultSyncIntervalMs * time.Millisecond
+}
+
+// EffectiveMaxDocBytes returns the configured maximum serialized document size, or
+// DefaultMaxDocBytes if unset
+func (p *DatabaseParams) EffectiveMaxDocBytes() int {
+	if p.MaxDocBytes > 0 {
+		return p.MaxDocBytes
+	}
+	return DefaultMaxDocBytes
+}
+
+// EffectivePostFilterFetchFactor returns the configured over-fetch factor of queries with
+// a PostFilter, or DefaultPostFilterFetchFactor if unset
+func (p *DatabaseParams) EffectivePostFilterFetchFactor() int {
+	if p.PostFilterFetchFactor > 0 {
+		return p.PostFilterFetchFactor
+	}
+	return DefaultPostFilterFetchFactor
+}
+
+// HnswParallelInsert reports whether batches are inserted into the vector index in
+// parallel, which applies to HNSW indexes only and is on unless disabled
+func (p *DatabaseParams) HnswParallelInsert() bool {
+	if p.IndexType != IndexTypeHnsw {
+		return false
+	}
+	if p.HnswParams == nil || p.HnswParams.ParallelInsert == nil {
+		return true
+	}
+	return *p.HnswParams.ParallelInsert
 }
 
 // HnswIndexOption contains HNSW index creation parameters
 type HnswIndexOption struct {
-	EFConstruction int `json:"ef_construction" toml:"ef_construction"`
-	M              int `json:"m" toml:"m"`
+	EFConstruction int   `json:"ef_construction" toml:"ef_construction"`
+	M              int   `json:"m" toml:"m"`
+	ParallelInsert *bool `json:"parallel_insert,omitempty" toml:"parallel_insert,omitempty"` // defaults to true
+	EfSearch       int   `json:"ef_search,omitempty" toml:"ef_search,omitempty"`             // efSearch of queries that set none, 0 keeps the FAISS default
+	MaxFilteredEf  int   `json:"max_filtered_ef,omitempty" toml:"max_filtered_ef,omitempty"` // efSearch cap for retrying filtered queries short of K results, 0 disables
 }
 
 // HnswParams contains HNSW insertion parameters
@@ -48,7 +239,18 @@ type HnswSearchOption struct {
 // DocMap represents a document with arbitrary key-value pairs
 type DocMap map[string]any
 
+// Reserved document fields set by the database
+const (
+	DocFieldID         = "id"         // vector ID of the document
+	DocFieldAttributes = "attributes" // filterable attributes the document was upserted with
+	DocFieldScore      = "_score"     // distance (L2) or similarity (IP) of a search result
+	DocFieldRawScore   = "_raw_score" // score before normalization, set when NormalizeScores replaced it
+	DocFieldExplain    = "_explain"   // the filter inputs a search result satisfied, set when Explain is
+)
+
 // VdbUpsertArgs contains arguments for upserting data into the vector database
+// Docs must have one entry per row; Attributes may be omitted, and nil entries of
+// either are treated as empty
 type VdbUpsertArgs struct {
 	Vectors    math.Matrix32    `json:"vectors"`
 	Docs       []map[string]any `json:"docs"`
@@ -56,19 +258,62 @@ type VdbUpsertArgs struct {
 	HnswParams *HnswParams      `json:"hnsw_params,omitempty"`
 }
 
-// IntFilterInput defines an integer field filter
+// IntFilterInput defines a filter on an attribute field. Target is typed the way
+// filter.ParseTarget coerces it: integers, integral floats and booleans compare with the
+// integer attribute values, a fractional float equals none of them, and strings are
+// rejected until string attributes can be indexed. An omitted target compares as 0.
 type IntFilterInput struct {
-	Field  string `json:"field"`
-	Op     string `json:"op"` // "equal" or "not_equal"
-	Target int64  `json:"target"`
+	Field  string `json:"field" binding:"required"`
+	Op     string `json:"op" binding:"required"` // "equal" or "not_equal"
+	Target any    `json:"target"`
+}
+
+// FilterMatch is a filter input a search result satisfied, with the result's attribute
+// value under the filter's field
+type FilterMatch struct {
+	IntFilterInput
+	Value any `json:"value"`
 }
 
 // VdbSearchArgs contains arguments for searching the vector database
 type VdbSearchArgs struct {
 	Query        []float32         `json:"query"`
+	Queries      [][]float32       `json:"queries,omitempty"`     // several query vectors, instead of Query
+	Aggregation  Aggregation       `json:"aggregation,omitempty"` // how the scores of Queries combine, "max" (default) or "mean"
 	K            int               `json:"k"`
 	FilterInputs []IntFilterInput  `json:"filter_inputs,omitempty"`
 	HnswParams   *HnswSearchOption `json:"hnsw_params,omitempty"`
+	ExcludeIDs   []uint64          `json:"exclude_ids,omitempty"`
+	Fields       []string          `json:"fields,omitempty"`     // doc fields to return; all when empty
+	IDsOnly      bool              `json:"ids_only,omitempty"`   // return only id and score, skipping doc retrieval
+	TimeoutMs    int               `json:"timeout_ms,omitempty"` // overrides the database query timeout when set
+	GroupBy      string            `json:"group_by,omitempty"`   // doc or attribute field to keep only the best hit per value of
+	// NormalizeScores replaces each result score with the metric's NormalizedScore and
+	// moves the raw score to DocFieldRawScore; ranking always uses raw scores
+	NormalizeScores bool `json:"normalize_scores,omitempty"`
+	// Explain annotates each result under DocFieldExplain with the FilterInputs it
+	// satisfied and its attribute values that matched them
+	Explain bool `json:"explain,omitempty"`
+	// Exact compares the query with every vector that passes the filters rather than
+	// walking the HNSW graph, so the results are the true top K at a cost linear in the
+	// number of vectors; flat indexes are always exact and custom ones reject it
+	Exact bool `json:"exact,omitempty"`
+	// PostFilter, if set, drops the hits it rejects after the vector search, for
+	// conditions the filter index cannot express; see PostFilter
+	PostFilter PostFilter `json:"-"`
+}
+
+// PostFilter decides whether a search hit is kept, given its stored document, with the
+// attributes under DocFieldAttributes, and its raw score. A query with a PostFilter
+// fetches K times DatabaseParams.PostFilterFetchFactor hits, after FilterInputs and
+// ExcludeIDs are applied, and keeps the best K the PostFilter accepts, so fewer than K
+// come back when it rejects most of them.
+type PostFilter func(doc DocMap, score float32) bool
+
+// SearchHit is a search result without its document
+type SearchHit struct {
+	ID    uint64  `json:"id"`
+	Score float32 `json:"score"`
 }
 
 // Validate checks if VdbUpsertArgs has consistent dimensions
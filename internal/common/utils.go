@@ -1,6 +1,12 @@
 package common
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+var ErrIDOutOfRange = fmt.Errorf("vector ID out of label range")
 
 func JSONUnmarshal[T any](data []byte) (T, error) {
 	var result T
@@ -10,21 +16,27 @@ func JSONUnmarshal[T any](data []byte) (T, error) {
 	return result, err
 }
 
-func ToInt64(intValue any) (int64, bool) {
-	switch v := intValue.(type) {
-	case int:
-		intValue = int64(v)
-	case int64:
-		intValue = v
-	case int32:
-		intValue = int64(v)
-	case uint:
-		intValue = int64(v)
-	case uint32:
-		intValue = int64(v)
-	case uint64:
-		intValue = int64(v)
+// BuildStoredDoc serializes a document the way it is kept in scalar storage: the doc
+// fields plus its vector ID under DocFieldID and its attributes under DocFieldAttributes.
+// Every write path uses it, so a doc is stored identically however it was applied.
+// The input doc is not modified. An empty codec selects DocCodecJSON.
+func BuildStoredDoc(doc map[string]any, attributes map[string]any, id uint64, c DocCodec) ([]byte, error) {
+	stored := make(map[string]any, len(doc)+2)
+	for k, v := range doc {
+		stored[k] = v
 	}
+	stored[DocFieldID] = id
+	stored[DocFieldAttributes] = attributes
+
+	return EncodeDoc(stored, c)
+}
 
-	return 0, false
+// LabelFromID converts a vector ID to a FAISS int64 label.
+// IDs above MaxInt64 would wrap to negative labels, which FAISS treats as "no result",
+// so they are rejected with ErrIDOutOfRange instead of being cast.
+func LabelFromID(id uint64) (int64, error) {
+	if id > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %d exceeds %d", ErrIDOutOfRange, id, int64(math.MaxInt64))
+	}
+	return int64(id), nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
 	"vecdb-go/internal/common"
 
 	"github.com/BurntSushi/toml"
@@ -10,6 +11,7 @@ import (
 type AppConfig struct {
 	Database common.DatabaseParams `toml:"database"`
 	Server   ServerConfig          `toml:"server"`
+	Embedder EmbedderConfig        `toml:"embedder"`
 }
 
 type ProfileConfig struct {
@@ -18,10 +20,27 @@ type ProfileConfig struct {
 }
 
 type ServerConfig struct {
-	SearchURLSuffix string `toml:"search_url_suffix"`
-	UpsertURLSuffix string `toml:"upsert_url_suffix"`
-	Port            uint16 `toml:"port"`
-	LogLevel        string `toml:"log_level"`
+	SearchURLSuffix     string `toml:"search_url_suffix"`
+	SearchByIDURLSuffix string `toml:"search_by_id_url_suffix"` // defaults to "/search_by_id"
+	UpsertURLSuffix     string `toml:"upsert_url_suffix"`
+	ScanURLSuffix       string `toml:"scan_url_suffix"`   // defaults to "/scan"
+	FieldsURLSuffix     string `toml:"fields_url_suffix"` // defaults to "/fields"
+	StatsURLSuffix      string `toml:"stats_url_suffix"`  // defaults to "/stats"
+	DocsURLSuffix       string `toml:"docs_url_suffix"`   // defaults to "/docs"
+	DocURLSuffix        string `toml:"doc_url_suffix"`    // defaults to "/doc", followed by "/:id"
+	Port                uint16 `toml:"port"`
+	LogLevel            string `toml:"log_level"`
+	ScorePrecision      *int   `toml:"score_precision"`   // decimal places of response scores, unset keeps full precision
+	FaissNumThreads     int    `toml:"faiss_num_threads"` // FAISS OpenMP threads, 0 keeps the FAISS default
+	DebugResponses      bool   `toml:"debug_responses"`   // add timing info to every search response
+	AdminToken          string `toml:"admin_token"`       // bearer token of admin endpoints like /reload, unset disables them
+}
+
+// EmbedderConfig configures the external embedding service used for text requests;
+// text requests are rejected when URL is empty
+type EmbedderConfig struct {
+	URL       string `toml:"url"`
+	TimeoutMs int    `toml:"timeout_ms"`
 }
 
 func LoadConfig() (*AppConfig, error) {
@@ -29,17 +48,76 @@ func LoadConfig() (*AppConfig, error) {
 }
 
 func LoadConfigWithProfile(profile string) (*AppConfig, error) {
+	return LoadConfigFile("config.toml", profile)
+}
+
+// LoadConfigFile loads the given profile from the config file at path
+func LoadConfigFile(path, profile string) (*AppConfig, error) {
 	var profileConfig ProfileConfig
-	if _, err := toml.DecodeFile("config.toml", &profileConfig); err != nil {
+	if _, err := toml.DecodeFile(path, &profileConfig); err != nil {
 		return nil, err
 	}
 
+	var cfg *AppConfig
 	switch profile {
 	case "dev":
-		return &profileConfig.Dev, nil
+		cfg = &profileConfig.Dev
 	case "test":
-		return &profileConfig.Test, nil
+		cfg = &profileConfig.Test
 	default:
 		return nil, fmt.Errorf("unknown profile: %s", profile)
 	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s profile in %s: %w", profile, path, err)
+	}
+	return cfg, nil
+}
+
+// Validate fills in defaults for an omitted database metric_type ("l2") and index_type
+// ("flat") and checks the database settings the index is built from, naming the config
+// key of the first invalid value
+func (c *AppConfig) Validate() error {
+	db := &c.Database
+
+	if db.Dim <= 0 {
+		return fmt.Errorf("database.dim must be a positive vector dimension, got %d", db.Dim)
+	}
+
+	switch db.MetricType {
+	case "":
+		db.MetricType = common.MetricTypeL2
+		slog.Info("database.metric_type not set, using the default", "metric_type", db.MetricType)
+	case common.MetricTypeL2, common.MetricTypeIP, common.MetricTypeCosine:
+	default:
+		return fmt.Errorf("database.metric_type %q is not supported, use \"l2\", \"ip\" or \"cosine\"", db.MetricType)
+	}
+
+	switch db.IndexType {
+	case "":
+		db.IndexType = common.IndexTypeFlat
+		slog.Info("database.index_type not set, using the default", "index_type", db.IndexType)
+	case common.IndexTypeFlat:
+	case common.IndexTypeHnsw:
+		if db.HnswParams == nil || db.HnswParams.EFConstruction <= 0 || db.HnswParams.M <= 0 {
+			return fmt.Errorf("database.hnsw_params needs a positive ef_construction and m for index_type \"hnsw\"")
+		}
+	case common.IndexTypeCustom:
+		if db.FactoryString == "" {
+			return fmt.Errorf("database.factory_string is required for index_type \"custom\"")
+		}
+		if db.TrainSize < 0 {
+			return fmt.Errorf("database.train_size must not be negative")
+		}
+	default:
+		return fmt.Errorf("database.index_type %q is not supported, use \"flat\", \"hnsw\" or \"custom\"", db.IndexType)
+	}
+	if r := db.StorageRetry; r != nil && (r.MaxAttempts < 0 || r.InitialBackoffMs < 0 || r.MaxElapsedMs < 0) {
+		return fmt.Errorf("database.storage_retry values must not be negative")
+	}
+	if db.ReplaceExistingIDs && db.IndexType != common.IndexTypeFlat {
+		return fmt.Errorf("database.replace_existing_ids needs index_type \"flat\", the only index that can replace vectors")
+	}
+
+	return nil
 }
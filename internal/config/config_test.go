@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"vecdb-go/internal/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadConfigFileDefaults(t *testing.T) {
+	path := writeConfig(t, "[dev.database]\ndim = 4\n")
+
+	cfg, err := LoadConfigFile(path, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, common.MetricTypeL2, cfg.Database.MetricType)
+	assert.Equal(t, common.IndexTypeFlat, cfg.Database.IndexType)
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"missing dim", "[dev.database]\nmetric_type = \"l2\"\n", "database.dim must be a positive vector dimension, got 0"},
+		{"unknown metric", "[dev.database]\ndim = 4\nmetric_type = \"manhattan\"\n", `database.metric_type "manhattan" is not supported`},
+		{"unknown index", "[dev.database]\ndim = 4\nindex_type = \"ivf\"\n", `database.index_type "ivf" is not supported`},
+		{"hnsw without params", "[dev.database]\ndim = 4\nindex_type = \"hnsw\"\n", "database.hnsw_params needs a positive ef_construction and m"},
+		{"custom without factory string", "[dev.database]\ndim = 4\nindex_type = \"custom\"\n", "database.factory_string is required"},
+		{"replace on hnsw", "[dev.database]\ndim = 4\nindex_type = \"hnsw\"\nreplace_existing_ids = true\n[dev.database.hnsw_params]\nef_construction = 40\nm = 8\n", "database.replace_existing_ids needs index_type \"flat\""},
+		{"negative storage retry", "[dev.database]\ndim = 4\n[dev.database.storage_retry]\nmax_attempts = -1\n", "database.storage_retry values must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfig(t, tt.content), "dev")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Contains(t, err.Error(), "invalid dev profile")
+		})
+	}
+
+	// A complete HNSW configuration is valid
+	path := writeConfig(t, "[dev.database]\ndim = 4\nindex_type = \"hnsw\"\n[dev.database.hnsw_params]\nef_construction = 40\nm = 8\n")
+	_, err := LoadConfigFile(path, "dev")
+	assert.NoError(t, err)
+
+	path = writeConfig(t, "[dev.database]\ndim = 4\nindex_type = \"custom\"\nfactory_string = \"IDMap2,HNSW32,Flat\"\n")
+	_, err = LoadConfigFile(path, "dev")
+	assert.NoError(t, err)
+}
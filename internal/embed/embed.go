@@ -0,0 +1,96 @@
+package embed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"vecdb-go/internal/common/math"
+)
+
+// DefaultHTTPTimeout bounds a call to an external embedding service when no timeout is configured
+const DefaultHTTPTimeout = 10 * time.Second
+
+var ErrEmbedderDisabled = fmt.Errorf("no embedder configured")
+
+// Embedder turns texts into vectors, one matrix row per text in order
+type Embedder interface {
+	Embed(texts []string) (*math.Matrix32, error)
+}
+
+// NewEmbedder returns an HTTPEmbedder for url, or a NoopEmbedder if url is empty
+func NewEmbedder(url string, timeout time.Duration) Embedder {
+	if url == "" {
+		return NoopEmbedder{}
+	}
+	return NewHTTPEmbedder(url, timeout)
+}
+
+// NoopEmbedder is the default embedder; it rejects every call so only raw vectors are accepted
+type NoopEmbedder struct{}
+
+var _ Embedder = NoopEmbedder{}
+
+func (NoopEmbedder) Embed(texts []string) (*math.Matrix32, error) {
+	return nil, ErrEmbedderDisabled
+}
+
+// HTTPEmbedder calls an external embedding service.
+// It POSTs {"texts": [...]} to the URL and expects {"embeddings": [[...], ...]} back.
+type HTTPEmbedder struct {
+	url    string
+	client *http.Client
+}
+
+var _ Embedder = (*HTTPEmbedder)(nil)
+
+type httpEmbedRequest struct {
+	Texts []string `json:"texts"`
+}
+
+type httpEmbedResponse struct {
+	Embeddings math.Matrix32 `json:"embeddings"`
+}
+
+// NewHTTPEmbedder creates an embedder for the service at url; a non-positive timeout
+// selects DefaultHTTPTimeout
+func NewHTTPEmbedder(url string, timeout time.Duration) *HTTPEmbedder {
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	return &HTTPEmbedder{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (e *HTTPEmbedder) Embed(texts []string) (*math.Matrix32, error) {
+	body, err := json.Marshal(httpEmbedRequest{Texts: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %w", err)
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call embedding service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding service returned status %d", resp.StatusCode)
+	}
+
+	var result httpEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+
+	if result.Embeddings.Rows != len(texts) {
+		return nil, fmt.Errorf("embedding service returned %d vectors for %d texts",
+			result.Embeddings.Rows, len(texts))
+	}
+
+	return &result.Embeddings, nil
+}
@@ -0,0 +1,57 @@
+package embed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoopEmbedder(t *testing.T) {
+	_, err := NewEmbedder("", 0).Embed([]string{"hello"})
+	assert.ErrorIs(t, err, ErrEmbedderDisabled)
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req httpEmbedRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		// Embed each text as [length, 1]
+		embeddings := make([][]float32, len(req.Texts))
+		for i, text := range req.Texts {
+			embeddings[i] = []float32{float32(len(text)), 1}
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]any{"embeddings": embeddings}))
+	}))
+	defer server.Close()
+
+	embedder := NewEmbedder(server.URL, 0)
+	mat, err := embedder.Embed([]string{"a", "abc"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, mat.Rows)
+	assert.Equal(t, 2, mat.Cols)
+	assert.Equal(t, []float32{1, 1, 3, 1}, mat.Data)
+}
+
+func TestHTTPEmbedderErrors(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	_, err := NewHTTPEmbedder(failing.URL, 0).Embed([]string{"a"})
+	assert.ErrorContains(t, err, "status 503")
+
+	// A response with the wrong number of vectors is rejected
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"embeddings": [[1.0, 2.0]]}`))
+	}))
+	defer short.Close()
+
+	_, err = NewHTTPEmbedder(short.URL, 0).Embed([]string{"a", "b"})
+	assert.ErrorContains(t, err, "1 vectors for 2 texts")
+}
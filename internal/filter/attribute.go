@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrUnsupportedAttribute is returned for attribute values the filter index cannot index
+var ErrUnsupportedAttribute = fmt.Errorf("unsupported attribute value")
+
+// AttributeValue coerces an attribute value to the integer it is indexed under and its
+// field type. Every path that indexes or unindexes attributes goes through it, so a value
+// is always interpreted the same way:
+//   - Go integer types are indexed as they are; unsigned values above MaxInt64 are rejected
+//   - floats, which JSON decodes every number to, are indexed as integers when they are
+//     integral and within the int64 range; fractional values are rejected until float
+//     filters exist
+//   - booleans are indexed as 0 and 1 under FieldTypeBool
+//   - anything else, such as strings, null, objects and arrays, is rejected
+//
+// Errors wrap ErrUnsupportedAttribute.
+func AttributeValue(value any) (int64, FieldType, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return 1, FieldTypeBool, nil
+		}
+		return 0, FieldTypeBool, nil
+	case int:
+		return int64(v), FieldTypeInt, nil
+	case int8:
+		return int64(v), FieldTypeInt, nil
+	case int16:
+		return int64(v), FieldTypeInt, nil
+	case int32:
+		return int64(v), FieldTypeInt, nil
+	case int64:
+		return v, FieldTypeInt, nil
+	case uint:
+		return uintValue(uint64(v))
+	case uint8:
+		return int64(v), FieldTypeInt, nil
+	case uint16:
+		return int64(v), FieldTypeInt, nil
+	case uint32:
+		return int64(v), FieldTypeInt, nil
+	case uint64:
+		return uintValue(v)
+	case float32:
+		return floatValue(float64(v))
+	case float64:
+		return floatValue(v)
+	default:
+		return 0, 0, fmt.Errorf("%w: type %T", ErrUnsupportedAttribute, value)
+	}
+}
+
+// ValidateAttributes returns an error naming the first attribute AttributeValue rejects
+func ValidateAttributes(attributes map[string]any) error {
+	for key, value := range attributes {
+		if _, _, err := AttributeValue(value); err != nil {
+			return fmt.Errorf("attribute %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func uintValue(v uint64) (int64, FieldType, error) {
+	if v > math.MaxInt64 {
+		return 0, 0, fmt.Errorf("%w: %d is out of int64 range", ErrUnsupportedAttribute, v)
+	}
+	return int64(v), FieldTypeInt, nil
+}
+
+func floatValue(v float64) (int64, FieldType, error) {
+	// -2^63 is exact as a float64, while 2^63 is already out of range
+	if v != math.Trunc(v) {
+		return 0, 0, fmt.Errorf("%w: %v is not an integer", ErrUnsupportedAttribute, v)
+	}
+	if v < math.MinInt64 || v >= -math.MinInt64 {
+		return 0, 0, fmt.Errorf("%w: %v is out of int64 range", ErrUnsupportedAttribute, v)
+	}
+	return int64(v), FieldTypeInt, nil
+}
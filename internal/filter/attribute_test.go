@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributeValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     any
+		want      int64
+		fieldType FieldType
+		wantErr   string
+	}{
+		{"json integral number", float64(3), 3, FieldTypeInt, ""},
+		{"json negative number", float64(-7), -7, FieldTypeInt, ""},
+		{"json integral with fraction syntax", 3.0, 3, FieldTypeInt, ""},
+		{"float32 integral", float32(12), 12, FieldTypeInt, ""},
+		{"int", 5, 5, FieldTypeInt, ""},
+		{"int8", int8(-8), -8, FieldTypeInt, ""},
+		{"int32", int32(32), 32, FieldTypeInt, ""},
+		{"int64 max", int64(math.MaxInt64), math.MaxInt64, FieldTypeInt, ""},
+		{"uint32", uint32(1 << 31), 1 << 31, FieldTypeInt, ""},
+		{"uint64 in range", uint64(42), 42, FieldTypeInt, ""},
+		{"int64 min as float", float64(math.MinInt64), math.MinInt64, FieldTypeInt, ""},
+		{"true", true, 1, FieldTypeBool, ""},
+		{"false", false, 0, FieldTypeBool, ""},
+		{"fractional", 3.5, 0, 0, "3.5 is not an integer"},
+		{"fractional float32", float32(0.25), 0, 0, "0.25 is not an integer"},
+		{"NaN", math.NaN(), 0, 0, "NaN is not an integer"},
+		{"infinity", math.Inf(1), 0, 0, "+Inf is out of int64 range"},
+		{"float above int64", 1e19, 0, 0, "out of int64 range"},
+		{"uint64 above int64", uint64(math.MaxInt64) + 1, 0, 0, "out of int64 range"},
+		{"string", "3", 0, 0, "type string"},
+		{"nil", nil, 0, 0, "type <nil>"},
+		{"object", map[string]any{"a": 1.0}, 0, 0, "type map[string]interface {}"},
+		{"array", []any{1.0}, 0, 0, "type []interface {}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fieldType, err := AttributeValue(tt.value)
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, ErrUnsupportedAttribute)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.fieldType, fieldType)
+		})
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]any
+		wantErr    string
+	}{
+		{"nil", nil, ""},
+		{"empty", map[string]any{}, ""},
+		{"indexable", map[string]any{"category": float64(1), "active": true}, ""},
+		{"fractional", map[string]any{"category": float64(1), "price": 9.99}, "attribute price: unsupported attribute value: 9.99 is not an integer"},
+		{"string", map[string]any{"name": "x"}, "attribute name: unsupported attribute value: type string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAttributes(tt.attributes)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrUnsupportedAttribute)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -36,6 +36,11 @@ func (f *IdFilter) AddAll(ids []uint64) {
 	}
 }
 
+// Remove removes an ID from the filter
+func (f *IdFilter) Remove(id uint64) {
+	f.bitmap.Remove(uint32(id))
+}
+
 // Filter checks if an ID is in the filter
 func (f *IdFilter) Filter(id uint64) bool {
 	return f.bitmap.Contains(uint32(id))
@@ -57,11 +62,57 @@ func (f *IdFilter) AsSelector() (faiss.Selector, error) {
 	return faiss.NewIDSelectorBatch(ids)
 }
 
+// AsExcludeSelector converts the IdFilter to a FAISS IdSelector that matches every ID
+// except those in the filter
+func (f *IdFilter) AsExcludeSelector() (faiss.Selector, error) {
+	if f.IsEmpty() {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, f.bitmap.GetCardinality())
+	iter := f.bitmap.Iterator()
+	for iter.HasNext() {
+		id := iter.Next()
+		ids = append(ids, int64(id))
+	}
+
+	return faiss.NewIDSelectorBatchNot(ids)
+}
+
+// Without returns a new filter with the IDs of other removed
+func (f *IdFilter) Without(other *IdFilter) *IdFilter {
+	return &IdFilter{
+		bitmap: roaring.AndNot(f.bitmap, other.bitmap),
+	}
+}
+
+// Union returns a new filter with the IDs of both filters
+func (f *IdFilter) Union(other *IdFilter) *IdFilter {
+	return &IdFilter{
+		bitmap: roaring.Or(f.bitmap, other.bitmap),
+	}
+}
+
+// IDs returns the IDs in the filter in ascending order
+func (f *IdFilter) IDs() []uint64 {
+	ids := make([]uint64, 0, f.bitmap.GetCardinality())
+	iter := f.bitmap.Iterator()
+	for iter.HasNext() {
+		ids = append(ids, uint64(iter.Next()))
+	}
+	return ids
+}
+
 // GetBitmap returns the underlying roaring bitmap
 func (f *IdFilter) GetBitmap() *roaring.Bitmap {
 	return f.bitmap
 }
 
+// Len returns the number of IDs in the filter
+func (f *IdFilter) Len() int {
+	return int(f.bitmap.GetCardinality())
+}
+
 // IsEmpty returns true if the filter has no IDs
 func (f *IdFilter) IsEmpty() bool {
 	return f.bitmap.GetCardinality() == 0
@@ -1,6 +1,12 @@
 package filter
 
 import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
 	"github.com/RoaringBitmap/roaring"
 )
 
@@ -12,6 +18,66 @@ const (
 	NotEqual
 )
 
+// FieldType is the type of the attribute values a field is indexed with
+type FieldType int
+
+const (
+	FieldTypeInt FieldType = iota
+	FieldTypeBool
+	// FieldTypeMixed is reported for a field that got values of several types in lenient mode
+	FieldTypeMixed
+)
+
+// String returns the name of the field type
+func (t FieldType) String() string {
+	switch t {
+	case FieldTypeInt:
+		return "int"
+	case FieldTypeBool:
+		return "bool"
+	case FieldTypeMixed:
+		return "mixed"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(t))
+	}
+}
+
+// MarshalText encodes the field type as its name, e.g. in JSON responses
+func (t FieldType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a field type from the name MarshalText encodes it as
+func (t *FieldType) UnmarshalText(text []byte) error {
+	for _, ft := range []FieldType{FieldTypeInt, FieldTypeBool, FieldTypeMixed} {
+		if ft.String() == string(text) {
+			*t = ft
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown field type %q", text)
+}
+
+// FieldInfo describes an attribute field that can be filtered on
+type FieldInfo struct {
+	Name     string    `json:"name"`
+	Type     FieldType `json:"type"`
+	Min      int64     `json:"min"`      // smallest indexed value
+	Max      int64     `json:"max"`      // largest indexed value
+	Distinct int       `json:"distinct"` // number of distinct indexed values
+}
+
+// ErrFieldTypeMismatch is returned in strict mode when an attribute value's type differs
+// from the type its field was first indexed with
+var ErrFieldTypeMismatch = fmt.Errorf("attribute type mismatch")
+
+// ErrLimitExceeded is returned when indexing an attribute would exceed the limit on
+// distinct fields or on distinct values per field
+var ErrLimitExceeded = fmt.Errorf("filter index limit exceeded")
+
+// ErrNotFilterable is returned for filters on a field left out of the filterable fields
+var ErrNotFilterable = fmt.Errorf("field is not filterable")
+
 // IntFilterInput defines an integer field filter
 type IntFilterInput struct {
 	Field  string
@@ -20,20 +86,468 @@ type IntFilterInput struct {
 }
 
 // IntFilterIndex manages attribute-based filtering using roaring bitmaps
+// It is safe for concurrent use, so searches can apply filters while a sync upserts
 type IntFilterIndex struct {
+	mu sync.RWMutex
+
 	// intFieldFilters maps field name -> value -> bitmap of IDs
 	intFieldFilters map[string]map[int64]*roaring.Bitmap
+
+	// ranges maps field name -> smallest and largest indexed value, kept up to date on
+	// every change so that Fields does not scan the values
+	ranges map[string]valueRange
+
+	// changed is set by every change to the bitmaps and cleared by Optimize
+	changed atomic.Bool
+
+	// strictTypes rejects attribute values whose type differs from fieldTypes
+	strictTypes bool
+	// fieldTypes maps field name -> type of the values indexed under it; in strict mode
+	// it is the type of the first value, in lenient mode it becomes FieldTypeMixed once
+	// values of another type are indexed
+	fieldTypes map[string]FieldType
+	// reservedTypes maps field name -> type reserved in strict mode by writes that are
+	// logged but not applied yet, which CheckTypes holds new values to like fieldTypes
+	reservedTypes map[string]typeReservation
+
+	// maxFields and maxValuesPerField bound the distinct fields and the distinct values
+	// of each field that CheckLimits admits; 0 means unlimited
+	maxFields         int
+	maxValuesPerField int
+	// reservedValues maps field name -> value not indexed yet -> number of writes that
+	// are logged but not applied yet holding it, which CheckLimits counts like indexed ones
+	reservedValues map[string]map[int64]int
+	// limitRejections counts the attributes CheckLimits rejected
+	limitRejections atomic.Uint64
+
+	// filterable holds the only fields Upsert indexes; nil indexes every field
+	filterable map[string]struct{}
+}
+
+// valueRange is the smallest and largest value indexed under a field
+type valueRange struct {
+	min, max int64
+}
+
+// typeReservation is a field type reserved by ReserveTypes and the number of writes
+// holding it
+type typeReservation struct {
+	fieldType FieldType
+	writes    int
 }
 
 // NewIntFilterIndex creates a new integer filter index
 func NewIntFilterIndex() *IntFilterIndex {
 	return &IntFilterIndex{
 		intFieldFilters: make(map[string]map[int64]*roaring.Bitmap),
+		ranges:          make(map[string]valueRange),
+		fieldTypes:      make(map[string]FieldType),
+		reservedTypes:   make(map[string]typeReservation),
+		reservedValues:  make(map[string]map[int64]int),
 	}
 }
 
-// Upsert adds or updates an ID for a field-value pair
+// SetStrictTypes sets whether the first value indexed under a field fixes the field's
+// type, so that CheckTypes rejects values of other types. In lenient mode, the default,
+// a bool and an int that convert to the same integer match the same filters.
+func (idx *IntFilterIndex) SetStrictTypes(strict bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.strictTypes = strict
+}
+
+// SetLimits sets the most distinct fields and distinct values per field CheckLimits
+// admits, to keep attributes with ever new names or values from growing the index
+// without bound; 0 leaves either unlimited. Values already indexed are kept.
+func (idx *IntFilterIndex) SetLimits(maxFields, maxValuesPerField int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.maxFields = maxFields
+	idx.maxValuesPerField = maxValuesPerField
+}
+
+// SetFilterableFields restricts the index to the given fields: attributes under other
+// fields are ignored by Upsert, CheckTypes and CheckLimits, so they cost no memory and
+// count against no limit. An empty list indexes every field. Values already indexed are kept.
+func (idx *IntFilterIndex) SetFilterableFields(fields []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if len(fields) == 0 {
+		idx.filterable = nil
+		return
+	}
+	idx.filterable = make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		idx.filterable[field] = struct{}{}
+	}
+}
+
+// Filterable reports whether attributes under field are indexed
+func (idx *IntFilterIndex) Filterable(field string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return idx.filterableLocked(field)
+}
+
+// filterableLocked reports whether attributes under field are indexed (caller must hold lock)
+func (idx *IntFilterIndex) filterableLocked(field string) bool {
+	if idx.filterable == nil {
+		return true
+	}
+	_, ok := idx.filterable[field]
+	return ok
+}
+
+// CheckLimits returns an error wrapping ErrLimitExceeded if indexing the attributes
+// would add a field beyond the field limit, or a value beyond its field's value limit.
+// pending holds the values not yet in the index that were admitted earlier in the same
+// batch, by field; the attributes' new values are added to it, to be passed to
+// ReserveValues before the batch is logged. Only fields with at least one indexed or
+// reserved value count, as in Fields.
+// Values that cannot be indexed at all are left for the caller to reject.
+func (idx *IntFilterIndex) CheckLimits(attributes map[string]any, pending map[string]map[int64]struct{}) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.maxFields <= 0 && idx.maxValuesPerField <= 0 {
+		return nil
+	}
+
+	for field, value := range attributes {
+		v, _, err := AttributeValue(value)
+		if err != nil || !idx.filterableLocked(field) {
+			continue
+		}
+
+		if idx.knownValueLocked(field, v) {
+			continue
+		}
+		added, exists := pending[field]
+		if _, ok := added[v]; ok {
+			continue
+		}
+
+		known := idx.valueCountLocked(field)
+		if !exists && known == 0 && idx.maxFields > 0 && idx.pendingFieldCount(pending) >= idx.maxFields {
+			idx.limitRejections.Add(1)
+			return fmt.Errorf("%w: field %s would exceed the limit of %d fields", ErrLimitExceeded, field, idx.maxFields)
+		}
+		if idx.maxValuesPerField > 0 && known+len(added) >= idx.maxValuesPerField {
+			idx.limitRejections.Add(1)
+			return fmt.Errorf("%w: field %s would exceed the limit of %d distinct values", ErrLimitExceeded, field, idx.maxValuesPerField)
+		}
+
+		if !exists {
+			added = make(map[int64]struct{})
+			pending[field] = added
+		}
+		added[v] = struct{}{}
+	}
+
+	return nil
+}
+
+// pendingFieldCount returns the number of fields with indexed or reserved values plus
+// the fields only pending adds values to (caller must hold lock)
+func (idx *IntFilterIndex) pendingFieldCount(pending map[string]map[int64]struct{}) int {
+	count := len(idx.ranges)
+	for field := range idx.reservedValues {
+		if _, exists := idx.ranges[field]; !exists {
+			count++
+		}
+	}
+	for field := range pending {
+		if idx.valueCountLocked(field) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// knownValueLocked reports whether a field's value is indexed or reserved (caller must hold lock)
+func (idx *IntFilterIndex) knownValueLocked(field string, value int64) bool {
+	if _, exists := idx.intFieldFilters[field][value]; exists {
+		return true
+	}
+	_, exists := idx.reservedValues[field][value]
+	return exists
+}
+
+// valueCountLocked returns the number of distinct values indexed or reserved under a
+// field (caller must hold lock)
+func (idx *IntFilterIndex) valueCountLocked(field string) int {
+	return len(idx.intFieldFilters[field]) + len(idx.reservedValues[field])
+}
+
+// ReserveValues reserves the new values collected by CheckLimits for a write about to be
+// logged, until Upsert indexes them once it is applied or ReleaseValues gives them up if
+// it fails. Writes run concurrently and are applied later, so values indexed or reserved
+// since CheckLimits ran are counted again here: it returns an error wrapping
+// ErrLimitExceeded, reserving nothing, if the values no longer fit the limits.
+func (idx *IntFilterIndex) ReserveValues(values map[string]map[int64]struct{}) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.maxFields <= 0 && idx.maxValuesPerField <= 0 {
+		return nil
+	}
+
+	fields := idx.pendingFieldCount(nil)
+	newFields := 0
+	for field, added := range values {
+		known := idx.valueCountLocked(field)
+		newValues := 0
+		for v := range added {
+			if !idx.knownValueLocked(field, v) {
+				newValues++
+			}
+		}
+		if newValues == 0 {
+			continue
+		}
+		if known == 0 {
+			newFields++
+			if idx.maxFields > 0 && fields+newFields > idx.maxFields {
+				idx.limitRejections.Add(1)
+				return fmt.Errorf("%w: field %s would exceed the limit of %d fields", ErrLimitExceeded, field, idx.maxFields)
+			}
+		}
+		if idx.maxValuesPerField > 0 && known+newValues > idx.maxValuesPerField {
+			idx.limitRejections.Add(1)
+			return fmt.Errorf("%w: field %s would exceed the limit of %d distinct values", ErrLimitExceeded, field, idx.maxValuesPerField)
+		}
+	}
+
+	for field, added := range values {
+		for v := range added {
+			if _, indexed := idx.intFieldFilters[field][v]; indexed {
+				continue
+			}
+			reserved, exists := idx.reservedValues[field]
+			if !exists {
+				reserved = make(map[int64]int)
+				idx.reservedValues[field] = reserved
+			}
+			reserved[v]++
+		}
+	}
+	return nil
+}
+
+// ReleaseValues gives up the values ReserveValues reserved for a write that failed
+func (idx *IntFilterIndex) ReleaseValues(values map[string]map[int64]struct{}) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for field, added := range values {
+		reserved, exists := idx.reservedValues[field]
+		if !exists {
+			continue
+		}
+		for v := range added {
+			if reserved[v] <= 1 {
+				delete(reserved, v)
+			} else {
+				reserved[v]--
+			}
+		}
+		if len(reserved) == 0 {
+			delete(idx.reservedValues, field)
+		}
+	}
+}
+
+// LimitRejections returns the number of attributes CheckLimits rejected
+func (idx *IntFilterIndex) LimitRejections() uint64 {
+	return idx.limitRejections.Load()
+}
+
+// CheckTypes returns an error wrapping ErrFieldTypeMismatch if strict types are enabled
+// and an attribute's type differs from its field's registered or reserved type, or from
+// the type in pending, which holds the types seen earlier in the same batch. The
+// attribute types are added to pending, to be passed to ReserveTypes before the batch is
+// logged and to RegisterTypes once it is applied; in lenient mode a field with values of
+// several types is added as FieldTypeMixed.
+// Values that cannot be indexed at all are left for the caller to reject.
+func (idx *IntFilterIndex) CheckTypes(attributes map[string]any, pending map[string]FieldType) error {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	for field, value := range attributes {
+		_, fieldType, err := AttributeValue(value)
+		if err != nil || !idx.filterableLocked(field) {
+			continue
+		}
+
+		expected, exists := pending[field]
+		if !exists && idx.strictTypes {
+			expected, exists = idx.strictTypeLocked(field)
+		}
+		if exists && expected != fieldType {
+			if idx.strictTypes {
+				return fmt.Errorf("%w: field %s has a %s value, expected %s", ErrFieldTypeMismatch, field, fieldType, expected)
+			}
+			fieldType = FieldTypeMixed
+		}
+		pending[field] = fieldType
+	}
+
+	return nil
+}
+
+// strictTypeLocked returns the type a field's values must have in strict mode, the
+// registered one or else the one reserved by a pending write (caller must hold lock)
+func (idx *IntFilterIndex) strictTypeLocked(field string) (FieldType, bool) {
+	if fieldType, exists := idx.fieldTypes[field]; exists {
+		return fieldType, true
+	}
+	reservation, exists := idx.reservedTypes[field]
+	return reservation.fieldType, exists
+}
+
+// ReserveTypes reserves in strict mode the field types collected by CheckTypes for a
+// write about to be logged, until RegisterTypes registers them once it is applied or
+// ReleaseTypes gives them up if it fails. Writes run concurrently and are applied later,
+// so a type registered or reserved since CheckTypes ran is checked again here: it
+// returns an error wrapping ErrFieldTypeMismatch, reserving nothing, if any differs.
+// Lenient mode reserves nothing.
+func (idx *IntFilterIndex) ReserveTypes(types map[string]FieldType) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.strictTypes {
+		return nil
+	}
+	for field, fieldType := range types {
+		if expected, exists := idx.strictTypeLocked(field); exists && expected != fieldType {
+			return fmt.Errorf("%w: field %s has a %s value, expected %s", ErrFieldTypeMismatch, field, fieldType, expected)
+		}
+	}
+	for field, fieldType := range types {
+		if _, registered := idx.fieldTypes[field]; registered {
+			continue
+		}
+		reservation := idx.reservedTypes[field]
+		idx.reservedTypes[field] = typeReservation{fieldType: fieldType, writes: reservation.writes + 1}
+	}
+	return nil
+}
+
+// ReleaseTypes gives up the types ReserveTypes reserved for a write that failed
+func (idx *IntFilterIndex) ReleaseTypes(types map[string]FieldType) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for field := range types {
+		reservation, exists := idx.reservedTypes[field]
+		switch {
+		case !exists:
+		case reservation.writes <= 1:
+			delete(idx.reservedTypes, field)
+		default:
+			reservation.writes--
+			idx.reservedTypes[field] = reservation
+		}
+	}
+}
+
+// RegisterTypes records the types of indexed fields, which replace their reservations.
+// In strict mode the type of a field is fixed once registered; in lenient mode a field
+// registered with another type becomes FieldTypeMixed.
+func (idx *IntFilterIndex) RegisterTypes(types map[string]FieldType) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for field, fieldType := range types {
+		delete(idx.reservedTypes, field)
+		registered, exists := idx.fieldTypes[field]
+		switch {
+		case !exists:
+			idx.fieldTypes[field] = fieldType
+		case registered != fieldType && !idx.strictTypes:
+			idx.fieldTypes[field] = FieldTypeMixed
+		}
+	}
+}
+
+// Fields describes every field with at least one indexed value, sorted by name.
+// Fields whose type was never registered, e.g. when values are upserted directly
+// rather than through a sync, are reported as FieldTypeInt.
+func (idx *IntFilterIndex) Fields() []FieldInfo {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	fields := make([]FieldInfo, 0, len(idx.ranges))
+	for _, name := range slices.Sorted(maps.Keys(idx.ranges)) {
+		r := idx.ranges[name]
+		fields = append(fields, FieldInfo{
+			Name:     name,
+			Type:     idx.fieldTypes[name],
+			Min:      r.min,
+			Max:      r.max,
+			Distinct: len(idx.intFieldFilters[name]),
+		})
+	}
+
+	return fields
+}
+
+// updateRange widens the range of field to include a newly indexed value (caller must hold lock)
+func (idx *IntFilterIndex) updateRange(field string, value int64) {
+	r, exists := idx.ranges[field]
+	if !exists {
+		idx.ranges[field] = valueRange{min: value, max: value}
+		return
+	}
+	idx.ranges[field] = valueRange{min: min(r.min, value), max: max(r.max, value)}
+}
+
+// shrinkRange updates the range of field after its last ID with value was removed,
+// recomputing it from the remaining values only if value was at either end (caller must hold lock)
+func (idx *IntFilterIndex) shrinkRange(field string, value int64) {
+	r := idx.ranges[field]
+	if value != r.min && value != r.max {
+		return
+	}
+
+	values := idx.intFieldFilters[field]
+	if len(values) == 0 {
+		delete(idx.ranges, field)
+		return
+	}
+
+	first := true
+	for v := range values {
+		if first {
+			r = valueRange{min: v, max: v}
+			first = false
+			continue
+		}
+		r = valueRange{min: min(r.min, v), max: max(r.max, v)}
+	}
+	idx.ranges[field] = r
+}
+
+// Upsert adds or updates an ID for a field-value pair, unless the field is not
+// filterable. A reservation of the value ends once it is indexed.
 func (idx *IntFilterIndex) Upsert(field string, value int64, id uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.filterableLocked(field) {
+		return
+	}
+	if reserved, exists := idx.reservedValues[field]; exists {
+		delete(reserved, value)
+		if len(reserved) == 0 {
+			delete(idx.reservedValues, field)
+		}
+	}
+
 	filterMapByValue, exists := idx.intFieldFilters[field]
 	if !exists {
 		filterMapByValue = make(map[int64]*roaring.Bitmap)
@@ -44,13 +558,18 @@ func (idx *IntFilterIndex) Upsert(field string, value int64, id uint64) {
 	if !exists {
 		bitmap = roaring.New()
 		filterMapByValue[value] = bitmap
+		idx.updateRange(field, value)
 	}
 
 	bitmap.Add(uint32(id))
+	idx.changed.Store(true)
 }
 
 // Remove removes an ID from a field-value pair
 func (idx *IntFilterIndex) Remove(field string, value int64, id uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
 	filterMapByValue, exists := idx.intFieldFilters[field]
 	if !exists {
 		return
@@ -64,11 +583,124 @@ func (idx *IntFilterIndex) Remove(field string, value int64, id uint64) {
 	bitmap.Remove(uint32(id))
 	if bitmap.IsEmpty() {
 		delete(filterMapByValue, value)
+		idx.shrinkRange(field, value)
+	}
+	idx.changed.Store(true)
+}
+
+// RemoveID removes an ID from every field-value pair
+func (idx *IntFilterIndex) RemoveID(id uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for field, filterMapByValue := range idx.intFieldFilters {
+		for value, bitmap := range filterMapByValue {
+			bitmap.Remove(uint32(id))
+			if bitmap.IsEmpty() {
+				delete(filterMapByValue, value)
+				idx.shrinkRange(field, value)
+			}
+		}
+	}
+	idx.changed.Store(true)
+}
+
+// RemoveIDs removes every ID in ids from the index, one bitmap operation per value
+func (idx *IntFilterIndex) RemoveIDs(ids *IdFilter) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for field, filterMapByValue := range idx.intFieldFilters {
+		for value, bitmap := range filterMapByValue {
+			bitmap.AndNot(ids.GetBitmap())
+			if bitmap.IsEmpty() {
+				delete(filterMapByValue, value)
+				idx.shrinkRange(field, value)
+			}
+		}
+	}
+	idx.changed.Store(true)
+}
+
+// Optimize converts the runs of consecutive IDs in every bitmap to run-length encoding,
+// which shrinks and speeds up bitmaps of dense ID ranges; bitmaps only switch to runs
+// where that makes them smaller. It skips the work and returns false if the index did
+// not change since it last ran.
+func (idx *IntFilterIndex) Optimize() bool {
+	if !idx.changed.Swap(false) {
+		return false
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, filterMapByValue := range idx.intFieldFilters {
+		for _, bitmap := range filterMapByValue {
+			bitmap.RunOptimize()
+		}
+	}
+	return true
+}
+
+// IDs returns the IDs indexed under any field
+func (idx *IntFilterIndex) IDs() *IdFilter {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	bitmap := roaring.New()
+	for _, filterMapByValue := range idx.intFieldFilters {
+		for _, valueBitmap := range filterMapByValue {
+			bitmap.Or(valueBitmap)
+		}
+	}
+	return NewIdFilterFrom(bitmap)
+}
+
+// SizeInBytes returns the estimated in-memory size of the bitmaps
+func (idx *IntFilterIndex) SizeInBytes() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var size uint64
+	for _, filterMapByValue := range idx.intFieldFilters {
+		for _, bitmap := range filterMapByValue {
+			size += bitmap.GetSizeInBytes()
+		}
+	}
+	return size
+}
+
+// ApplyTarget applies a filter with a typed target to an existing bitmap. Int targets are
+// looked up like in Apply. No integer indexed equals a float target, so it adds nothing
+// with Equal and every ID indexed under the field with NotEqual. The index holds no
+// strings, so string targets fail with ErrUnsupportedTarget.
+func (idx *IntFilterIndex) ApplyTarget(field string, op FilterOp, target Target, bitmap *roaring.Bitmap) (*roaring.Bitmap, error) {
+	switch target.Kind {
+	case TargetInt:
+		return idx.Apply(&IntFilterInput{Field: field, Op: op, Target: target.Int}, bitmap), nil
+	case TargetFloat:
+		if op != NotEqual {
+			return bitmap.Clone(), nil
+		}
+
+		idx.mu.RLock()
+		defer idx.mu.RUnlock()
+
+		resBitmap := bitmap.Clone()
+		for _, curBitmap := range idx.intFieldFilters[field] {
+			resBitmap.Or(curBitmap)
+		}
+		return resBitmap, nil
+	default:
+		return nil, fmt.Errorf("%w: %s targets cannot match integer attributes", ErrUnsupportedTarget, target.Kind)
 	}
 }
 
 // Apply applies the filter to an existing bitmap
 func (idx *IntFilterIndex) Apply(input *IntFilterInput, bitmap *roaring.Bitmap) *roaring.Bitmap {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
 	if input.Op == Equal {
 		valueToMap, exists := idx.intFieldFilters[input.Field]
 		if !exists {
@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntFilterIndexOptimize(t *testing.T) {
+	const n = 100_000
+
+	// A dense dataset: consecutive IDs share a handful of values
+	idx := NewIntFilterIndex()
+	for id := uint64(1); id <= n; id++ {
+		idx.Upsert("bucket", int64(id/20_000), id)
+		idx.Upsert("active", 1, id)
+	}
+	before := idx.SizeInBytes()
+
+	require.True(t, idx.Optimize())
+	after := idx.SizeInBytes()
+	t.Logf("filter index size: %d bytes before, %d bytes after Optimize", before, after)
+	assert.Less(t, after*10, before, "runs of consecutive IDs should shrink the bitmaps tenfold")
+
+	// Nothing changed, so there is nothing to do
+	assert.False(t, idx.Optimize())
+
+	// Filters give the same results on optimized bitmaps, also after further changes
+	idx.Remove("bucket", 0, 5)
+	idx.Upsert("bucket", 0, n+1)
+	result := idx.Apply(&IntFilterInput{Field: "bucket", Op: Equal, Target: 0}, roaring.New())
+	assert.Equal(t, uint64(20_000-1), result.GetCardinality())
+	assert.False(t, result.Contains(5))
+	assert.True(t, result.Contains(n+1))
+	assert.True(t, idx.Optimize())
+}
+
+func TestIntFilterIndexCheckLimits(t *testing.T) {
+	idx := NewIntFilterIndex()
+	idx.Upsert("color", 1, 1)
+	idx.Upsert("color", 2, 2)
+
+	// Without limits anything is admitted
+	require.NoError(t, idx.CheckLimits(map[string]any{"size": 1, "color": 3}, map[string]map[int64]struct{}{}))
+
+	idx.SetLimits(2, 3)
+
+	// Indexed values are always admitted, and one new value fits under the value limit
+	pending := map[string]map[int64]struct{}{}
+	require.NoError(t, idx.CheckLimits(map[string]any{"color": 1}, pending))
+	require.NoError(t, idx.CheckLimits(map[string]any{"color": 3}, pending))
+	require.NoError(t, idx.CheckLimits(map[string]any{"color": 3}, pending))
+
+	// A fourth value, even pending in the same batch, is one too many
+	err := idx.CheckLimits(map[string]any{"color": 4}, pending)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.ErrorContains(t, err, "field color would exceed the limit of 3 distinct values")
+
+	// A second field fits, a third one does not
+	require.NoError(t, idx.CheckLimits(map[string]any{"size": 1}, pending))
+	err = idx.CheckLimits(map[string]any{"weight": 1}, pending)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.ErrorContains(t, err, "field weight would exceed the limit of 2 fields")
+
+	assert.Equal(t, uint64(2), idx.LimitRejections())
+
+	// A field whose values were all removed no longer counts
+	idx.Remove("color", 1, 1)
+	idx.Remove("color", 2, 2)
+	require.NoError(t, idx.CheckLimits(map[string]any{"size": 1, "weight": 1}, map[string]map[int64]struct{}{}))
+}
+
+func TestIntFilterIndexReserveValues(t *testing.T) {
+	idx := NewIntFilterIndex()
+	idx.Upsert("color", 1, 1)
+	idx.SetLimits(2, 2)
+
+	// A pending write reserves color 2 and a new field
+	first := map[string]map[int64]struct{}{}
+	require.NoError(t, idx.CheckLimits(map[string]any{"color": 2, "size": 1}, first))
+	require.NoError(t, idx.ReserveValues(first))
+
+	// Reserved values count like indexed ones for later writes
+	assert.ErrorIs(t, idx.CheckLimits(map[string]any{"color": 3}, map[string]map[int64]struct{}{}), ErrLimitExceeded)
+	assert.ErrorIs(t, idx.CheckLimits(map[string]any{"weight": 1}, map[string]map[int64]struct{}{}), ErrLimitExceeded)
+	require.NoError(t, idx.CheckLimits(map[string]any{"color": 2, "size": 1}, map[string]map[int64]struct{}{}))
+
+	// A write checked before the reservation is checked again when it reserves
+	assert.ErrorIs(t, idx.ReserveValues(map[string]map[int64]struct{}{"color": {3: {}}}), ErrLimitExceeded)
+	assert.ErrorIs(t, idx.ReserveValues(map[string]map[int64]struct{}{"weight": {1: {}}}), ErrLimitExceeded)
+
+	// Indexing a reserved value ends its reservation without counting it twice
+	idx.Upsert("color", 2, 2)
+	assert.ErrorIs(t, idx.CheckLimits(map[string]any{"color": 3}, map[string]map[int64]struct{}{}), ErrLimitExceeded)
+
+	// Once the write fails its other values are free again
+	idx.ReleaseValues(first)
+	require.NoError(t, idx.CheckLimits(map[string]any{"weight": 1}, map[string]map[int64]struct{}{}))
+}
+
+func TestIntFilterIndexFilterableFields(t *testing.T) {
+	idx := NewIntFilterIndex()
+	idx.SetFilterableFields([]string{"category"})
+	idx.SetLimits(1, 0)
+
+	assert.True(t, idx.Filterable("category"))
+	assert.False(t, idx.Filterable("user_id"))
+
+	// Other fields are neither indexed nor counted against the limits
+	attributes := map[string]any{"category": int64(1), "user_id": int64(42)}
+	require.NoError(t, idx.CheckLimits(attributes, map[string]map[int64]struct{}{}))
+	types := map[string]FieldType{}
+	require.NoError(t, idx.CheckTypes(attributes, types))
+	assert.Equal(t, map[string]FieldType{"category": FieldTypeInt}, types)
+
+	idx.Upsert("category", 1, 1)
+	idx.Upsert("user_id", 42, 1)
+	fields := idx.Fields()
+	require.Len(t, fields, 1)
+	assert.Equal(t, "category", fields[0].Name)
+
+	// An empty list indexes every field again
+	idx.SetFilterableFields(nil)
+	assert.True(t, idx.Filterable("user_id"))
+}
+
+func TestIntFilterIndexReserveTypes(t *testing.T) {
+	idx := NewIntFilterIndex()
+	idx.SetStrictTypes(true)
+
+	// A pending write reserves "flag" as bool before it is applied
+	first := make(map[string]FieldType)
+	require.NoError(t, idx.CheckTypes(map[string]any{"flag": true}, first))
+	require.NoError(t, idx.ReserveTypes(first))
+
+	// A concurrent write of an int is rejected by the check or, if it ran before the
+	// reservation, by its own reservation
+	assert.ErrorIs(t, idx.CheckTypes(map[string]any{"flag": int64(1)}, make(map[string]FieldType)), ErrFieldTypeMismatch)
+	assert.ErrorIs(t, idx.ReserveTypes(map[string]FieldType{"flag": FieldTypeInt, "rank": FieldTypeInt}), ErrFieldTypeMismatch)
+	require.NoError(t, idx.CheckTypes(map[string]any{"rank": true}, make(map[string]FieldType)), "a rejected reservation reserves nothing")
+
+	// Once the first write fails, the type is free again
+	idx.ReleaseTypes(first)
+	second := map[string]FieldType{"flag": FieldTypeInt}
+	require.NoError(t, idx.ReserveTypes(second))
+
+	// Registering the type replaces the reservation
+	idx.RegisterTypes(second)
+	idx.ReleaseTypes(second)
+	assert.ErrorIs(t, idx.CheckTypes(map[string]any{"flag": true}, make(map[string]FieldType)), ErrFieldTypeMismatch)
+
+	// Lenient mode reserves nothing
+	lenient := NewIntFilterIndex()
+	require.NoError(t, lenient.ReserveTypes(map[string]FieldType{"flag": FieldTypeBool}))
+	require.NoError(t, lenient.CheckTypes(map[string]any{"flag": int64(1)}, make(map[string]FieldType)))
+}
@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+)
+
+// ErrUnsupportedTarget is returned for filter targets that cannot be compared with
+// attribute values
+var ErrUnsupportedTarget = fmt.Errorf("unsupported filter target")
+
+// TargetKind is the type of a filter target
+type TargetKind int
+
+const (
+	TargetInt TargetKind = iota
+	TargetFloat
+	TargetString
+)
+
+// String returns the name of the target kind
+func (k TargetKind) String() string {
+	switch k {
+	case TargetInt:
+		return "int"
+	case TargetFloat:
+		return "float"
+	case TargetString:
+		return "string"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(k))
+	}
+}
+
+// Target is a filter target coerced to its type by ParseTarget; only the field of its
+// kind is set
+type Target struct {
+	Kind  TargetKind
+	Int   int64
+	Float float64
+	Text  string
+}
+
+// ParseTarget coerces a filter target, such as one decoded from JSON, to its type:
+//   - values AttributeValue accepts, including integral floats and booleans, are int
+//     targets, so a target always matches the attribute value it was copied from
+//   - other finite floats, fractional or beyond the int64 range, are float targets
+//   - strings are string targets
+//   - nil, a target omitted from JSON, is the int target 0, as it was when targets
+//     were always integers
+//
+// Anything else is rejected with an error wrapping ErrUnsupportedTarget.
+func ParseTarget(value any) (Target, error) {
+	if value == nil {
+		return Target{Kind: TargetInt}, nil
+	}
+	if v, _, err := AttributeValue(value); err == nil {
+		return Target{Kind: TargetInt, Int: v}, nil
+	}
+
+	switch v := value.(type) {
+	case float32:
+		return floatTarget(float64(v))
+	case float64:
+		return floatTarget(v)
+	case string:
+		return Target{Kind: TargetString, Text: v}, nil
+	default:
+		return Target{}, fmt.Errorf("%w: type %T", ErrUnsupportedTarget, value)
+	}
+}
+
+func floatTarget(v float64) (Target, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return Target{}, fmt.Errorf("%w: %v is not finite", ErrUnsupportedTarget, v)
+	}
+	return Target{Kind: TargetFloat, Float: v}, nil
+}
+
+// Matches reports whether an indexed attribute value satisfies op with the target
+func (t Target) Matches(op FilterOp, value int64) bool {
+	equal := t.Kind == TargetInt && t.Int == value
+	return equal == (op == Equal)
+}
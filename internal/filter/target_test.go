@@ -0,0 +1,75 @@
+package filter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    Target
+		wantErr string
+	}{
+		{"int", 5, Target{Kind: TargetInt, Int: 5}, ""},
+		{"json negative number", float64(-7), Target{Kind: TargetInt, Int: -7}, ""},
+		{"bool", true, Target{Kind: TargetInt, Int: 1}, ""},
+		{"fractional", 19.99, Target{Kind: TargetFloat, Float: 19.99}, ""},
+		{"negative fractional", -0.5, Target{Kind: TargetFloat, Float: -0.5}, ""},
+		{"float above int64", 1e19, Target{Kind: TargetFloat, Float: 1e19}, ""},
+		{"string", "red", Target{Kind: TargetString, Text: "red"}, ""},
+		{"NaN", math.NaN(), Target{}, "NaN is not finite"},
+		{"nil", nil, Target{Kind: TargetInt, Int: 0}, ""},
+		{"array", []any{1.0}, Target{}, "type []interface {}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTarget(tt.value)
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, ErrUnsupportedTarget)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestIntFilterIndexApplyTarget(t *testing.T) {
+	idx := NewIntFilterIndex()
+	idx.Upsert("temperature", -5, 1)
+	idx.Upsert("temperature", 20, 2)
+	idx.Upsert("other", 1, 3)
+
+	apply := func(op FilterOp, value any) []uint32 {
+		target, err := ParseTarget(value)
+		require.NoError(t, err)
+		bitmap, err := idx.ApplyTarget("temperature", op, target, roaring.New())
+		require.NoError(t, err)
+		return bitmap.ToArray()
+	}
+
+	assert.Equal(t, []uint32{1}, apply(Equal, float64(-5)))
+	assert.Equal(t, []uint32{2}, apply(NotEqual, -5))
+
+	// No integer equals a fractional target
+	assert.Empty(t, apply(Equal, -4.5))
+	assert.Equal(t, []uint32{1, 2}, apply(NotEqual, -4.5))
+
+	_, err := idx.ApplyTarget("temperature", Equal, Target{Kind: TargetString, Text: "hot"}, roaring.New())
+	assert.ErrorIs(t, err, ErrUnsupportedTarget)
+
+	// Matches judges a single value the same way
+	target, err := ParseTarget(-4.5)
+	require.NoError(t, err)
+	assert.False(t, target.Matches(Equal, -5))
+	assert.True(t, target.Matches(NotEqual, -5))
+}
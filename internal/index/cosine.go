@@ -0,0 +1,80 @@
+package index
+
+import (
+	"fmt"
+
+	"vecdb-go/internal/common/math"
+
+	faiss "github.com/blevesearch/go-faiss"
+)
+
+// faissMetric returns the FAISS metric of a metric type; cosine indexes use inner
+// product over normalized vectors
+func faissMetric(metric MetricType) (int, error) {
+	switch metric {
+	case L2:
+		return faiss.MetricL2, nil
+	case IP, Cosine:
+		return faiss.MetricInnerProduct, nil
+	default:
+		return 0, fmt.Errorf("unsupported metric type: %q", metric)
+	}
+}
+
+// vectorNorms records the original L2 norm of every vector in a cosine index. The index
+// only holds normalized copies, so the norms are needed to reconstruct what was inserted.
+// A nil vectorNorms belongs to an index that stores vectors as given.
+type vectorNorms map[int64]float32
+
+// newVectorNorms returns an empty vectorNorms for cosine indexes and nil otherwise
+func newVectorNorms(metric MetricType) vectorNorms {
+	if metric != Cosine {
+		return nil
+	}
+	return make(vectorNorms)
+}
+
+// normalize returns normalized copies of the dim-wide rows of data and records their
+// norms under labels; without norms data is returned unchanged
+func (n vectorNorms) normalize(data []float32, dim int, labels []int64) []float32 {
+	if n == nil {
+		return data
+	}
+	normalized := make([]float32, len(data))
+	copy(normalized, data)
+	for i, label := range labels {
+		n[label] = math.Normalize(normalized[i*dim : (i+1)*dim])
+	}
+	return normalized
+}
+
+// normalizeQuery returns a normalized copy of a query vector; without norms the
+// vector is returned unchanged
+func (n vectorNorms) normalizeQuery(vector []float32) []float32 {
+	if n == nil {
+		return vector
+	}
+	normalized := make([]float32, len(vector))
+	copy(normalized, vector)
+	math.Normalize(normalized)
+	return normalized
+}
+
+// restore scales a reconstructed vector back to the norm it was inserted with
+func (n vectorNorms) restore(label int64, vector []float32) []float32 {
+	if n == nil {
+		return vector
+	}
+	norm := n[label]
+	for i := range vector {
+		vector[i] *= norm
+	}
+	return vector
+}
+
+// remove forgets the norms of removed labels
+func (n vectorNorms) remove(labels []int64) {
+	for _, label := range labels {
+		delete(n, label)
+	}
+}
@@ -0,0 +1,318 @@
+package index
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"vecdb-go/internal/filter"
+
+	faiss "github.com/blevesearch/go-faiss"
+)
+
+// CustomIndex wraps an index built from a FAISS index factory string given by the user,
+// such as "IDMap2,HNSW32,Flat" or "IDMap2,OPQ16,IVF256,PQ16". The index must keep the
+// labels vectors are inserted with, which an ID map, IDMap or IDMap2, guarantees; only
+// IDMap2 can reconstruct vectors.
+//
+// Indexes that need training, like IVF and PQ, buffer the vectors inserted until there
+// are as many as the train size, then are trained on all of them. Searches compare
+// queries with each buffered vector meanwhile. Training fails on too few vectors, at
+// least as many as the IVF lists and, for PQ, at least 256 per sub-quantizer, while
+// FAISS warns below 39 per IVF list; the vectors then stay buffered and training is
+// tried again on the next insert.
+type CustomIndex struct {
+	index  faiss.Index
+	metric MetricType
+	norms  vectorNorms
+	mu     sync.Mutex
+
+	// Not every FAISS index can remove vectors, so removed labels are kept here and
+	// excluded from every search instead, as in HNSWIndex
+	removed *filter.IdFilter
+	// labels holds every label inserted, removed ones included
+	labels *filter.IdFilter
+
+	// untrained holds the vectors inserted, normalized, by label until the index is
+	// trained, and is nil from then on
+	untrained map[int64][]float32
+	// trainSize is the number of vectors buffered before training; 0 trains on the
+	// first insert
+	trainSize int
+	// unusable is set if the trained index turned out not to keep labels, so vectors
+	// stay buffered for good
+	unusable error
+}
+
+var _ Index = (*CustomIndex)(nil)
+
+func NewCustomIndex(dim int, metric MetricType, factoryString string) (*CustomIndex, error) {
+	metricType, err := faissMetric(metric)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := faiss.IndexFactory(dim, factoryString, metricType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidFactoryString, factoryString, err)
+	}
+	ci, err := newCustomIndex(idx, metric)
+	if err != nil {
+		idx.Close()
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidFactoryString, factoryString, err)
+	}
+	return ci, nil
+}
+
+// newCustomIndex wraps an empty FAISS index, checking now that it keeps labels unless it
+// needs training first
+func newCustomIndex(idx faiss.Index, metric MetricType) (*CustomIndex, error) {
+	ci := &CustomIndex{
+		index:   idx,
+		metric:  metric,
+		norms:   newVectorNorms(metric),
+		removed: filter.NewIdFilter(),
+		labels:  filter.NewIdFilter(),
+	}
+	if !idx.IsTrained() {
+		ci.untrained = make(map[int64][]float32)
+		return ci, nil
+	}
+	if err := checkIDMap(idx); err != nil {
+		return nil, err
+	}
+	return ci, nil
+}
+
+// checkIDMap returns an error unless idx, which must be trained and empty, keeps the
+// labels vectors are inserted with: it inserts a vector, searches for its label and
+// empties the index again. ID maps and IVF indexes keep labels, while flat and HNSW
+// indexes reject them.
+func checkIDMap(idx faiss.Index) error {
+	const probeLabel = 1
+
+	vector := make([]float32, idx.D())
+	if err := idx.AddWithIDs(vector, []int64{probeLabel}); err != nil {
+		return fmt.Errorf("the index cannot keep vector IDs, start it with \"IDMap2,\": %v", err)
+	}
+	_, labels, searchErr := idx.Search(vector, 1)
+	if err := idx.Reset(); err != nil {
+		return fmt.Errorf("failed to empty the index: %v", err)
+	}
+	if searchErr != nil {
+		return fmt.Errorf("failed to search the index: %v", searchErr)
+	}
+	if len(labels) != 1 || labels[0] != probeLabel {
+		return fmt.Errorf("the index does not keep vector IDs, start it with \"IDMap2,\"")
+	}
+	return nil
+}
+
+// SetTrainSize sets the number of vectors an index that needs training buffers before
+// it is trained; 0, the default, trains on the first insert
+func (ci *CustomIndex) SetTrainSize(n int) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.trainSize = n
+}
+
+func (ci *CustomIndex) Insert(params *InsertParams) error {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	defer pinThreads()()
+	if err := params.Data.Validate(); err != nil {
+		return err
+	}
+	n, _ := params.Data.Dims()
+	if n != len(params.Labels) {
+		return fmt.Errorf("data and labels length mismatch")
+	}
+	if n == 0 {
+		return nil
+	}
+	// Removed vectors stay in the graph masked by their label, so a label is never reused
+	if params.Replace {
+		return fmt.Errorf("%w: custom indexes cannot replace vectors", ErrLabelExists)
+	}
+	if err := checkNewLabels(params.Labels, func(label int64) bool { return ci.labels.Filter(uint64(label)) }); err != nil {
+		return err
+	}
+	// Get raw data from matrix without copying, unless it has to be normalized
+	flat := ci.norms.normalize(params.Data.RawData(), params.Data.Cols, params.Labels)
+	if ci.untrained != nil {
+		dim := params.Data.Cols
+		for i, label := range params.Labels {
+			ci.untrained[label] = append([]float32(nil), flat[i*dim:(i+1)*dim]...)
+		}
+		addLabels(ci.labels, params.Labels)
+		ci.train()
+		return nil
+	}
+	if err := ci.index.AddWithIDs(flat, params.Labels); err != nil {
+		return fmt.Errorf("failed to insert data: %w", err)
+	}
+	addLabels(ci.labels, params.Labels)
+	return nil
+}
+
+// train trains the index on the buffered vectors once there are enough of them, and
+// moves them into it. Inserts that already succeeded cannot be undone, so a failure is
+// logged and leaves the vectors buffered, to be tried again on the next insert.
+func (ci *CustomIndex) train() {
+	if ci.unusable != nil || len(ci.untrained) < ci.trainSize {
+		return
+	}
+	labels := labelsOf(ci.labels)
+	flat := make([]float32, 0, len(labels)*ci.index.D())
+	for _, label := range labels {
+		flat = append(flat, ci.untrained[label]...)
+	}
+
+	if !ci.index.IsTrained() {
+		if err := ci.index.Train(flat); err != nil {
+			slog.Warn("Failed to train custom index, vectors stay buffered", "vectors", len(labels), "error", err)
+			return
+		}
+		if err := checkIDMap(ci.index); err != nil {
+			slog.Error("Custom index cannot be used, vectors stay buffered", "error", err)
+			ci.unusable = err
+			return
+		}
+	}
+	if err := ci.index.AddWithIDs(flat, labels); err != nil {
+		slog.Warn("Failed to add buffered vectors to custom index", "vectors", len(labels), "error", err)
+		return
+	}
+	slog.Info("Trained custom index", "vectors", len(labels))
+	ci.untrained = nil
+}
+
+// Search searches like FlatIndex; per-query HNSW parameters are ignored, as the kind of
+// index is not known
+func (ci *CustomIndex) Search(query *SearchQuery, k int) (*SearchResult, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	defer pinThreads()()
+	ntotal := ci.index.Ntotal() + int64(len(ci.untrained))
+	if k > int(ntotal) {
+		k = int(ntotal)
+	}
+	if k == 0 {
+		return &SearchResult{Distances: []float32{}, Labels: []int64{}}, nil
+	}
+	var labels []int64
+	var distances []float32
+	var err error
+
+	// Mask removed labels on a copy so the caller's query is left untouched
+	if !ci.removed.IsEmpty() {
+		masked := *query
+		masked.ExcludeFilter = ci.removed
+		if query.ExcludeFilter != nil {
+			masked.ExcludeFilter = query.ExcludeFilter.Union(ci.removed)
+		}
+		query = &masked
+	}
+
+	vector := ci.norms.normalizeQuery(query.Vector)
+	if ci.untrained != nil {
+		candidates := query.exactCandidates(ci.labels)
+		result, err := searchExact(ci.reconstructUntrained, ci.metric, vector, candidates, k)
+		if err != nil {
+			return nil, err
+		}
+		result.Candidates = len(candidates)
+		return result, nil
+	}
+
+	selector, ok, err := query.selector()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &SearchResult{Distances: []float32{}, Labels: []int64{}}, nil
+	}
+
+	if selector != nil {
+		defer selector.Delete()
+		distances, labels, err = ci.index.SearchWithIDs(vector, int64(k), selector, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search with filter: %w", err)
+		}
+	} else {
+		distances, labels, err = ci.index.Search(vector, int64(k))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search: %w", err)
+		}
+	}
+	result := &SearchResult{Distances: distances, Labels: labels, Candidates: query.candidates(ntotal)}
+	if query.StableOrder {
+		result.sortStable(ci.metric)
+	}
+	return result, nil
+}
+
+// Remove masks the given labels out of future searches and returns how many were newly removed
+func (ci *CustomIndex) Remove(labels []int64) (int, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	removed := 0
+	for _, label := range labels {
+		if !ci.removed.Filter(uint64(label)) {
+			ci.removed.Add(uint64(label))
+			removed++
+		}
+	}
+	ci.norms.remove(labels)
+	return removed, nil
+}
+
+// Reconstruct returns the vector inserted with label, scaled back to its original norm
+// in a cosine index. Only IDMap2 indexes can reconstruct, and indexes that compress
+// vectors, like PQ, return an approximation.
+func (ci *CustomIndex) Reconstruct(label int64) ([]float32, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if ci.removed.Filter(uint64(label)) {
+		return nil, fmt.Errorf("%w: %d", ErrLabelNotFound, label)
+	}
+	reconstruct := ci.index.Reconstruct
+	if ci.untrained != nil {
+		reconstruct = ci.reconstructUntrained
+	}
+	vector, err := reconstruct(label)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %d", ErrLabelNotFound, label)
+	}
+	return ci.norms.restore(label, vector), nil
+}
+
+// reconstructUntrained returns a copy of a buffered vector (caller must hold lock)
+func (ci *CustomIndex) reconstructUntrained(label int64) ([]float32, error) {
+	vector, ok := ci.untrained[label]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrLabelNotFound, label)
+	}
+	return append([]float32(nil), vector...), nil
+}
+
+// Labels returns the labels of the vectors in the index that were not removed, in
+// ascending order
+func (ci *CustomIndex) Labels() []int64 {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return labelsOf(ci.labels.Without(ci.removed))
+}
+
+// Contains reports whether a vector with label is in the index and not removed
+func (ci *CustomIndex) Contains(label int64) bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.labels.Filter(uint64(label)) && !ci.removed.Filter(uint64(label))
+}
+
+// Ntotal returns the number of vectors FAISS holds or that are buffered until training,
+// removed ones included
+func (ci *CustomIndex) Ntotal() int64 {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	return ci.index.Ntotal() + int64(len(ci.untrained))
+}
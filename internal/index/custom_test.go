@@ -0,0 +1,160 @@
+package index
+
+import (
+	"errors"
+	"testing"
+
+	faiss "github.com/blevesearch/go-faiss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"vecdb-go/internal/common/math"
+)
+
+func TestCustomIndex(t *testing.T) {
+	index, err := NewIndex("custom", 2, L2, nil, "IDMap2,HNSW32,Flat")
+	require.NoError(t, err)
+	require.IsType(t, &CustomIndex{}, index)
+
+	data := &math.Matrix32{Rows: 3, Cols: 2, Data: []float32{0, 0, 1, 0, 5, 5}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{10, 20, 30})))
+	assert.Equal(t, []int64{10, 20, 30}, index.Labels())
+
+	result, err := index.Search(NewSearchQuery([]float32{0.9, 0}).WithStableOrder(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20, 10}, result.Labels)
+
+	vector, err := index.Reconstruct(30)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{5, 5}, vector)
+
+	// Removed labels are masked out of searches, whatever the factory string
+	n, err := index.Remove([]int64{20, 20})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	result, err = index.Search(NewSearchQuery([]float32{0.9, 0}), 3)
+	require.NoError(t, err)
+	assert.NotContains(t, result.Labels, int64(20))
+	assert.Equal(t, []int64{10, 30}, index.Labels())
+	_, err = index.Reconstruct(20)
+	assert.ErrorIs(t, err, ErrLabelNotFound)
+}
+
+func TestCustomIndexCosine(t *testing.T) {
+	index, err := NewCustomIndex(2, Cosine, "IDMap2,Flat")
+	require.NoError(t, err)
+
+	data := &math.Matrix32{Rows: 2, Cols: 2, Data: []float32{3, 4, 0, 10}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{1, 2})))
+
+	vector, err := index.Reconstruct(1)
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{3, 4}, vector, 1e-5)
+}
+
+func TestCustomIndexFactoryString(t *testing.T) {
+	// Flat and HNSW indexes without an ID map cannot keep labels
+	for _, factoryString := range []string{"", "HNSW32,Flat", "Flat"} {
+		_, err := NewCustomIndex(4, L2, factoryString)
+		assert.ErrorIs(t, err, ErrInvalidFactoryString, factoryString)
+	}
+
+	for _, factoryString := range []string{"IDMap,Flat", "IDMap2,HNSW32,Flat"} {
+		index, err := NewCustomIndex(4, L2, factoryString)
+		require.NoError(t, err, factoryString)
+		assert.Zero(t, index.Ntotal(), "the ID map check leaves the index empty")
+	}
+}
+
+// relabelingIndex stands in for an index that accepts labels but numbers vectors in
+// insertion order instead
+type relabelingIndex struct {
+	faiss.Index
+}
+
+func (ri relabelingIndex) AddWithIDs(x []float32, ids []int64) error {
+	labels := make([]int64, len(ids))
+	for i := range labels {
+		labels[i] = ri.Ntotal() + int64(i)
+	}
+	return ri.Index.AddWithIDs(x, labels)
+}
+
+func TestCustomIndexChecksIDMap(t *testing.T) {
+	inner, err := faiss.IndexFactory(2, "IDMap2,Flat", faiss.MetricL2)
+	require.NoError(t, err)
+
+	_, err = newCustomIndex(relabelingIndex{inner}, L2)
+	assert.ErrorContains(t, err, "does not keep vector IDs")
+}
+
+// trainingIndex stands in for an IVF index: it takes no vectors until it is trained, and
+// training fails on fewer than minTrain vectors
+type trainingIndex struct {
+	faiss.Index
+	minTrain int
+	trained  bool
+	trains   int
+}
+
+func (ti *trainingIndex) IsTrained() bool { return ti.trained }
+
+func (ti *trainingIndex) Train(x []float32) error {
+	ti.trains++
+	if len(x)/ti.D() < ti.minTrain {
+		return errors.New("not enough training points")
+	}
+	ti.trained = true
+	return nil
+}
+
+func (ti *trainingIndex) AddWithIDs(x []float32, ids []int64) error {
+	if !ti.trained {
+		return errors.New("index not trained")
+	}
+	return ti.Index.AddWithIDs(x, ids)
+}
+
+func TestCustomIndexTraining(t *testing.T) {
+	inner, err := faiss.IndexFactory(2, "IDMap2,Flat", faiss.MetricL2)
+	require.NoError(t, err)
+	trainer := &trainingIndex{Index: inner, minTrain: 4}
+	index, err := newCustomIndex(trainer, L2)
+	require.NoError(t, err)
+	index.SetTrainSize(3)
+
+	// Below the train size vectors are buffered, and still found and reconstructed
+	data := &math.Matrix32{Rows: 2, Cols: 2, Data: []float32{0, 0, 1, 0}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{10, 20})))
+	assert.Zero(t, trainer.trains)
+	assert.Equal(t, int64(2), index.Ntotal())
+	result, err := index.Search(NewSearchQuery([]float32{0.9, 0}), 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{20, 10}, result.Labels)
+	vector, err := index.Reconstruct(10)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0, 0}, vector)
+
+	// Training fails on too few vectors without failing the insert, which would only be
+	// retried on the same vectors
+	data = &math.Matrix32{Rows: 1, Cols: 2, Data: []float32{5, 5}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{30})))
+	assert.Equal(t, 1, trainer.trains)
+	assert.Zero(t, inner.Ntotal())
+
+	// The next insert trains the index on every buffered vector, removed ones included
+	_, err = index.Remove([]int64{20})
+	require.NoError(t, err)
+	data = &math.Matrix32{Rows: 1, Cols: 2, Data: []float32{2, 0}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{40})))
+	assert.Equal(t, 2, trainer.trains)
+	assert.Equal(t, int64(4), inner.Ntotal())
+	assert.Equal(t, int64(4), index.Ntotal())
+
+	result, err = index.Search(NewSearchQuery([]float32{0.9, 0}).WithStableOrder(), 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10, 40, 30}, result.Labels)
+	vector, err = index.Reconstruct(30)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{5, 5}, vector)
+}
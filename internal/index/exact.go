@@ -0,0 +1,59 @@
+package index
+
+import (
+	"fmt"
+	"vecdb-go/internal/filter"
+)
+
+// exactCandidates returns the labels an exact search compares the query with: those of
+// indexed, minus removed ones, that pass the query's filters
+func (q *SearchQuery) exactCandidates(indexed *filter.IdFilter) []int64 {
+	candidates := indexed
+	if q.IdFilter != nil && !q.IdFilter.IsEmpty() {
+		candidates = filter.NewIdFilterFrom(candidates.GetBitmap().Clone())
+		candidates.GetBitmap().And(q.IdFilter.GetBitmap())
+	}
+	if q.ExcludeFilter != nil && !q.ExcludeFilter.IsEmpty() {
+		candidates = candidates.Without(q.ExcludeFilter)
+	}
+	return labelsOf(candidates)
+}
+
+// searchExact scores vector against the stored vector of every label in candidates, the
+// way a flat index would, and returns the k best in stable order. Its cost is one
+// reconstruction and one distance per candidate, however few results are asked for.
+func searchExact(reconstruct func(label int64) ([]float32, error), metric MetricType, vector []float32, candidates []int64, k int) (*SearchResult, error) {
+	result := &SearchResult{
+		Distances: make([]float32, len(candidates)),
+		Labels:    candidates,
+	}
+	for i, label := range candidates {
+		stored, err := reconstruct(label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reconstruct %d for exact search: %w", label, err)
+		}
+		result.Distances[i] = distance(metric, vector, stored)
+	}
+	result.sortStable(metric)
+	if len(result.Labels) > k {
+		result.Labels, result.Distances = result.Labels[:k], result.Distances[:k]
+	}
+	return result, nil
+}
+
+// distance returns the FAISS score of a stored vector for a query: the squared distance
+// for L2 and the inner product otherwise, cosine indexes holding normalized vectors
+func distance(metric MetricType, query, stored []float32) float32 {
+	var score float32
+	if metric == L2 {
+		for i, v := range stored {
+			d := v - query[i]
+			score += d * d
+		}
+		return score
+	}
+	for i, v := range stored {
+		score += v * query[i]
+	}
+	return score
+}
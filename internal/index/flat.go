@@ -2,38 +2,45 @@ package index
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
+	"vecdb-go/internal/filter"
 
 	faiss "github.com/blevesearch/go-faiss"
 )
 
 type FlatIndex struct {
-	index faiss.Index
-	mu    sync.Mutex
+	index  faiss.Index
+	metric MetricType
+	norms  vectorNorms
+	mu     sync.Mutex
+
+	// labels holds the labels in the index, which FAISS cannot list
+	labels *filter.IdFilter
 }
 
 var _ Index = (*FlatIndex)(nil)
 
 func NewFlatIndex(dim int, metric MetricType) (*FlatIndex, error) {
-	var metricType int
-	switch metric {
-	case L2:
-		metricType = faiss.MetricL2
-	case IP:
-		metricType = faiss.MetricInnerProduct
-	default:
-		return nil, fmt.Errorf("unsupported metric type")
-	}
-	idx, err := faiss.IndexFactory(dim, "IDMap,Flat", metricType)
+	metricType, err := faissMetric(metric)
+	if err != nil {
+		return nil, err
+	}
+	// IDMap2 keeps the reverse label mapping that Reconstruct needs
+	idx, err := faiss.IndexFactory(dim, "IDMap2,Flat", metricType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize index: %w", err)
 	}
-	return &FlatIndex{index: idx}, nil
+	return &FlatIndex{index: idx, metric: metric, norms: newVectorNorms(metric), labels: filter.NewIdFilter()}, nil
 }
 
 func (fi *FlatIndex) Insert(params *InsertParams) error {
 	fi.mu.Lock()
 	defer fi.mu.Unlock()
+	defer pinThreads()()
+	if err := params.Data.Validate(); err != nil {
+		return err
+	}
 	n, _ := params.Data.Dims()
 	if n != len(params.Labels) {
 		return fmt.Errorf("data and labels length mismatch")
@@ -41,18 +48,85 @@ func (fi *FlatIndex) Insert(params *InsertParams) error {
 	if n == 0 {
 		return nil
 	}
-	// Get raw data from matrix without copying
-	flat := params.Data.RawData()
+	taken := fi.containsLocked
+	if params.Replace {
+		taken = func(int64) bool { return false }
+	}
+	if err := checkNewLabels(params.Labels, taken); err != nil {
+		return err
+	}
+	var replaced *replacedVectors
+	if params.Replace {
+		var err error
+		if replaced, err = fi.replaceLocked(params.Labels); err != nil {
+			return err
+		}
+	}
+	// Get raw data from matrix without copying, unless it has to be normalized
+	flat := fi.norms.normalize(params.Data.RawData(), params.Data.Cols, params.Labels)
 	err := fi.index.AddWithIDs(flat, params.Labels)
 	if err != nil {
+		fi.norms.remove(params.Labels)
+		if replaced != nil {
+			replaced.restore(fi)
+		}
 		return fmt.Errorf("failed to insert data: %w", err)
 	}
+	addLabels(fi.labels, params.Labels)
 	return nil
 }
 
+// replacedVectors holds the vectors a replacing insert removed, as the index stored
+// them, so that they can be put back if the insert fails
+type replacedVectors struct {
+	labels []int64
+	data   []float32
+	norms  []float32
+}
+
+// replaceLocked removes the vectors of the labels already in the index and returns
+// them, nil if there are none (caller must hold lock)
+func (fi *FlatIndex) replaceLocked(labels []int64) (*replacedVectors, error) {
+	replaced := &replacedVectors{}
+	for _, label := range labels {
+		if !fi.containsLocked(label) {
+			continue
+		}
+		stored, err := fi.index.Reconstruct(label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to keep vector %d to replace: %w", label, err)
+		}
+		replaced.labels = append(replaced.labels, label)
+		replaced.data = append(replaced.data, stored...)
+		if fi.norms != nil {
+			replaced.norms = append(replaced.norms, fi.norms[label])
+		}
+	}
+	if len(replaced.labels) == 0 {
+		return nil, nil
+	}
+	if _, err := fi.removeLocked(replaced.labels); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+// restore puts the replaced vectors back after a failed insert (caller must hold lock)
+func (r *replacedVectors) restore(fi *FlatIndex) {
+	if err := fi.index.AddWithIDs(r.data, r.labels); err != nil {
+		slog.Error("Failed to restore replaced vectors", "labels", r.labels, "error", err)
+		return
+	}
+	addLabels(fi.labels, r.labels)
+	for i, norm := range r.norms {
+		fi.norms[r.labels[i]] = norm
+	}
+}
+
 func (fi *FlatIndex) Search(query *SearchQuery, k int) (*SearchResult, error) {
 	fi.mu.Lock()
 	defer fi.mu.Unlock()
+	defer pinThreads()()
 	ntotal := fi.index.Ntotal()
 	if k > int(ntotal) {
 		k = int(ntotal)
@@ -64,21 +138,119 @@ func (fi *FlatIndex) Search(query *SearchQuery, k int) (*SearchResult, error) {
 	var distances []float32
 	var err error
 
-	if query.IdFilter != nil && !query.IdFilter.IsEmpty() {
+	selector, ok, err := query.selector()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &SearchResult{Distances: []float32{}, Labels: []int64{}}, nil
+	}
+
+	vector := fi.norms.normalizeQuery(query.Vector)
+	if selector != nil {
 		// Use FAISS SearchWithIDs for filtering during search (not post-filtering)
-		selector, err := query.IdFilter.AsSelector()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create selector: %w", err)
-		}
-		distances, labels, err = fi.index.SearchWithIDs(query.Vector, int64(k), selector, nil)
+		defer selector.Delete()
+		distances, labels, err = fi.index.SearchWithIDs(vector, int64(k), selector, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to search with filter: %w", err)
 		}
 	} else {
-		distances, labels, err = fi.index.Search(query.Vector, int64(k))
+		distances, labels, err = fi.index.Search(vector, int64(k))
 		if err != nil {
 			return nil, fmt.Errorf("failed to search: %w", err)
 		}
 	}
-	return &SearchResult{Distances: distances, Labels: labels}, nil
+	result := &SearchResult{Distances: distances, Labels: labels, Candidates: query.candidates(ntotal)}
+	if query.StableOrder {
+		result.sortStable(fi.metric)
+	}
+	return result, nil
+}
+
+func (fi *FlatIndex) Remove(labels []int64) (int, error) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.removeLocked(labels)
+}
+
+// removeLocked removes the vectors with the given labels (caller must hold lock)
+func (fi *FlatIndex) removeLocked(labels []int64) (int, error) {
+	if len(labels) == 0 {
+		return 0, nil
+	}
+	selector, err := faiss.NewIDSelectorBatch(labels)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create selector: %w", err)
+	}
+	defer selector.Delete()
+	n, err := fi.index.RemoveIDs(selector.(*faiss.IDSelector))
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove data: %w", err)
+	}
+	fi.norms.remove(labels)
+	removeLabels(fi.labels, labels)
+	return n, nil
+}
+
+// Reconstruct returns the vector inserted with label, scaled back to its original norm
+// in a cosine index
+func (fi *FlatIndex) Reconstruct(label int64) ([]float32, error) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	vector, err := fi.index.Reconstruct(label)
+	if err != nil {
+		// IDMap2 only fails to reconstruct labels it does not hold
+		return nil, fmt.Errorf("%w: %d", ErrLabelNotFound, label)
+	}
+	return fi.norms.restore(label, vector), nil
+}
+
+// Labels returns the labels of the vectors in the index in ascending order
+func (fi *FlatIndex) Labels() []int64 {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return labelsOf(fi.labels)
+}
+
+// addLabels records inserted labels in a label set
+func addLabels(set *filter.IdFilter, labels []int64) {
+	for _, label := range labels {
+		set.Add(uint64(label))
+	}
+}
+
+// removeLabels drops removed labels from a label set
+func removeLabels(set *filter.IdFilter, labels []int64) {
+	for _, label := range labels {
+		set.Remove(uint64(label))
+	}
+}
+
+// labelsOf returns the labels of a label set in ascending order
+func labelsOf(set *filter.IdFilter) []int64 {
+	ids := set.IDs()
+	labels := make([]int64, len(ids))
+	for i, id := range ids {
+		labels[i] = int64(id)
+	}
+	return labels
+}
+
+// Contains reports whether a vector with label is in the index
+func (fi *FlatIndex) Contains(label int64) bool {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.containsLocked(label)
+}
+
+// containsLocked is Contains for callers holding the lock
+func (fi *FlatIndex) containsLocked(label int64) bool {
+	return fi.labels.Filter(uint64(label))
+}
+
+// Ntotal returns the number of vectors FAISS holds
+func (fi *FlatIndex) Ntotal() int64 {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.index.Ntotal()
 }
@@ -1,11 +1,14 @@
 package index
 
 import (
+	"errors"
 	"testing"
 
+	faiss "github.com/blevesearch/go-faiss"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"vecdb-go/internal/common"
 	"vecdb-go/internal/common/math"
 	"vecdb-go/internal/filter"
 )
@@ -49,6 +52,75 @@ func TestFlatInsertMany(t *testing.T) {
 	assert.Equal(t, int64(2), ntotal)
 }
 
+func TestFlatLabels(t *testing.T) {
+	index, data, _, err := setupFlat(3, 4, L2)
+	require.NoError(t, err, "Failed to setup")
+	assert.Empty(t, index.Labels())
+
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{7, 3, 5})))
+	assert.Equal(t, []int64{3, 5, 7}, index.Labels())
+
+	_, err = index.Remove([]int64{5, 9})
+	require.NoError(t, err, "Remove failed")
+	assert.Equal(t, []int64{3, 7}, index.Labels())
+	assert.Equal(t, int64(2), index.Ntotal())
+}
+
+func TestFlatInsertExistingLabel(t *testing.T) {
+	index, data, labels, err := setupFlat(2, 4, L2)
+	require.NoError(t, err, "Failed to setup")
+	require.NoError(t, index.Insert(NewInsertParams(data, labels)))
+	assert.True(t, index.Contains(labels[0]))
+	assert.False(t, index.Contains(99))
+
+	// Re-adding a label fails without adding any of the batch
+	again := &math.Matrix32{Rows: 2, Cols: 4, Data: []float32{0, 0, 0, 0, 9, 9, 9, 9}}
+	err = index.Insert(NewInsertParams(again, []int64{3, labels[1]}))
+	require.ErrorIs(t, err, ErrLabelExists)
+	err = index.Insert(NewInsertParams(again, []int64{3, 3}))
+	require.ErrorIs(t, err, ErrLabelExists)
+	assert.Equal(t, int64(2), index.Ntotal())
+	assert.False(t, index.Contains(3))
+
+	// With Replace the vector of the label is swapped rather than duplicated
+	require.NoError(t, index.Insert(NewInsertParams(again, []int64{3, labels[1]}).WithReplace()))
+	assert.Equal(t, []int64{1, 2, 3}, index.Labels())
+	assert.Equal(t, int64(3), index.Ntotal())
+	vector, err := index.Reconstruct(labels[1])
+	require.NoError(t, err)
+	assert.Equal(t, []float32{9, 9, 9, 9}, vector)
+
+	// A replace whose add fails puts the old vector back
+	index.index = &failingAddIndex{Index: index.index, failures: 1}
+	replacement := &math.Matrix32{Rows: 1, Cols: 4, Data: []float32{7, 7, 7, 7}}
+	assert.Error(t, index.Insert(NewInsertParams(replacement, labels[1:2]).WithReplace()))
+	assert.Equal(t, []int64{1, 2, 3}, index.Labels())
+	vector, err = index.Reconstruct(labels[1])
+	require.NoError(t, err)
+	assert.Equal(t, []float32{9, 9, 9, 9}, vector)
+
+	// A removed label can be added again
+	_, err = index.Remove([]int64{labels[0]})
+	require.NoError(t, err)
+	assert.False(t, index.Contains(labels[0]))
+	require.NoError(t, index.Insert(NewInsertParams(&math.Matrix32{Rows: 1, Cols: 4, Data: []float32{1, 1, 1, 1}}, labels[:1])))
+	assert.True(t, index.Contains(labels[0]))
+}
+
+// failingAddIndex fails the next failures adds to the wrapped index
+type failingAddIndex struct {
+	faiss.Index
+	failures int
+}
+
+func (fi *failingAddIndex) AddWithIDs(x []float32, ids []int64) error {
+	if fi.failures > 0 {
+		fi.failures--
+		return errors.New("out of memory")
+	}
+	return fi.Index.AddWithIDs(x, ids)
+}
+
 func TestFlatSearch(t *testing.T) {
 	index, data, labels, err := setupFlat(2, 4, L2)
 	require.NoError(t, err, "Failed to setup")
@@ -69,6 +141,30 @@ func TestFlatSearch(t *testing.T) {
 	t.Logf("Search result: %v", result)
 }
 
+func TestFlatNumThreads(t *testing.T) {
+	var applied []uint
+	setOMPThreads = func(n uint) { applied = append(applied, n) }
+	t.Cleanup(func() {
+		setOMPThreads = faiss.SetOMPThreads
+		SetNumThreads(0)
+	})
+
+	index, data, labels, err := setupFlat(2, 4, L2)
+	require.NoError(t, err, "Failed to setup")
+
+	// Without a thread count FAISS keeps its default
+	require.NoError(t, index.Insert(NewInsertParams(data, labels)))
+	assert.Empty(t, applied)
+
+	// OpenMP keeps the count per OS thread, so every call applies it to its own
+	SetNumThreads(2)
+	_, err = index.Search(NewSearchQuery([]float32{1, 2, 3, 4}), 1)
+	require.NoError(t, err, "Search failed")
+	_, err = index.Search(NewSearchQuery([]float32{5, 6, 7, 8}), 1)
+	require.NoError(t, err, "Search failed")
+	assert.Equal(t, []uint{2, 2}, applied)
+}
+
 func TestFlatSearchWithParams(t *testing.T) {
 	index, data, labels, err := setupFlat(4, 5, L2)
 	require.NoError(t, err, "Failed to setup")
@@ -122,3 +218,143 @@ func TestFlatSearchWithEmptyFilter(t *testing.T) {
 	// Should return the closest label (label[0]) since filter is empty
 	assert.Equal(t, labels[0], result.Labels[0])
 }
+
+func TestFlatSearchWithExcludeFilter(t *testing.T) {
+	index, data, labels, err := setupFlat(4, 5, L2)
+	require.NoError(t, err, "Failed to setup")
+
+	params := NewInsertParams(data, labels)
+	err = index.Insert(params)
+	require.NoError(t, err, "Insert failed")
+
+	query := []float32{1.1, 2.1, 2.9, 3.9, 5.0}
+	k := 3
+
+	// Exclude the top result
+	excludeFilter := filter.NewIdFilter()
+	excludeFilter.Add(uint64(labels[0]))
+
+	result, err := index.Search(NewSearchQuery(query).WithExcludeFilter(excludeFilter), k)
+	require.NoError(t, err, "Search with exclude filter failed")
+
+	assert.Len(t, result.Labels, k)
+	assert.NotContains(t, result.Labels, labels[0], "Excluded label should be absent")
+	assert.Equal(t, labels[1], result.Labels[0])
+
+	// Excluding every included ID leaves nothing to return
+	idFilter := filter.NewIdFilter()
+	idFilter.Add(uint64(labels[0]))
+
+	result, err = index.Search(NewSearchQuery(query).WithFilter(idFilter).WithExcludeFilter(excludeFilter), k)
+	require.NoError(t, err, "Search with include and exclude filter failed")
+	assert.Empty(t, result.Labels)
+}
+
+func TestFlatSearchCandidates(t *testing.T) {
+	index, data, labels, err := setupFlat(4, 5, L2)
+	require.NoError(t, err, "Failed to setup")
+
+	err = index.Insert(NewInsertParams(data, labels))
+	require.NoError(t, err, "Insert failed")
+
+	query := []float32{1.1, 2.1, 2.9, 3.9, 5.0}
+
+	result, err := index.Search(NewSearchQuery(query), 1)
+	require.NoError(t, err, "Search failed")
+	assert.Equal(t, 4, result.Candidates)
+
+	idFilter := filter.NewIdFilter()
+	idFilter.AddAll([]uint64{1, 2, 3})
+	result, err = index.Search(NewSearchQuery(query).WithFilter(idFilter), 1)
+	require.NoError(t, err, "Search with filter failed")
+	assert.Equal(t, 3, result.Candidates)
+
+	excludeFilter := filter.NewIdFilter()
+	excludeFilter.Add(2)
+	result, err = index.Search(NewSearchQuery(query).WithFilter(idFilter).WithExcludeFilter(excludeFilter), 1)
+	require.NoError(t, err, "Search with include and exclude filter failed")
+	assert.Equal(t, 2, result.Candidates)
+
+	result, err = index.Search(NewSearchQuery(query).WithExcludeFilter(excludeFilter), 1)
+	require.NoError(t, err, "Search with exclude filter failed")
+	assert.Equal(t, 3, result.Candidates)
+}
+
+func TestFlatCosine(t *testing.T) {
+	index, err := NewFlatIndex(2, Cosine)
+	require.NoError(t, err)
+
+	data := &math.Matrix32{Rows: 3, Cols: 2, Data: []float32{3, 4, 0, 10, -2, 0}}
+	original := append([]float32(nil), data.Data...)
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{1, 2, 3})))
+	assert.Equal(t, original, data.Data, "insert must not normalize the caller's data")
+
+	// Scores are cosine similarities, independent of the query's length
+	query := []float32{0, 100}
+	result, err := index.Search(NewSearchQuery(query), 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 1, 3}, result.Labels)
+	assert.InDeltaSlice(t, []float32{1, 0.8, 0}, result.Distances, 1e-6)
+	assert.Equal(t, []float32{0, 100}, query)
+
+	// Reconstruct returns the vectors as inserted, not their normalized copies
+	vector, err := index.Reconstruct(1)
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{3, 4}, vector, 1e-5)
+
+	_, err = index.Remove([]int64{1})
+	require.NoError(t, err)
+	_, err = index.Reconstruct(1)
+	assert.ErrorIs(t, err, ErrLabelNotFound)
+}
+
+func TestFlatStableOrder(t *testing.T) {
+	index, err := NewFlatIndex(2, L2)
+	require.NoError(t, err)
+
+	// Labels 9, 4 and 7 tie at distance 1 from the query, label 2 is closest
+	data := &math.Matrix32{Rows: 4, Cols: 2, Data: []float32{1, 0, 0, 1, -1, 0, 0, 0.5}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{9, 4, 7, 2})))
+
+	for i := 0; i < 5; i++ {
+		result, err := index.Search(NewSearchQuery([]float32{0, 0}).WithStableOrder(), 4)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{2, 4, 7, 9}, result.Labels)
+		assert.Equal(t, []float32{0.25, 1, 1, 1}, result.Distances)
+	}
+
+	// Similarity metrics order ties by label as well, best score first
+	ipIndex, err := NewFlatIndex(2, IP)
+	require.NoError(t, err)
+	require.NoError(t, ipIndex.Insert(NewInsertParams(data, []int64{9, 4, 7, 2})))
+
+	result, err := ipIndex.Search(NewSearchQuery([]float32{1, 1}).WithStableOrder(), 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 9, 2, 7}, result.Labels)
+	assert.Equal(t, []float32{1, 1, 0.5, -1}, result.Distances)
+}
+
+func TestSearchResultSortStable(t *testing.T) {
+	result := &SearchResult{
+		Labels:    []int64{-1, 5, 3, 8},
+		Distances: []float32{0, 2, 2, 1},
+	}
+	result.sortStable(L2)
+	assert.Equal(t, []int64{8, 3, 5, -1}, result.Labels)
+	assert.Equal(t, []float32{1, 2, 2, 0}, result.Distances)
+}
+
+func TestSearchResultHits(t *testing.T) {
+	// Unfilled slots can sit between valid ones, e.g. in results of filtered searches
+	result := &SearchResult{
+		Labels:    []int64{4, -1, 7, -1, 2},
+		Distances: []float32{0.5, 3.4e38, 1.5, 3.4e38, 2.5},
+	}
+	assert.Equal(t, []common.SearchHit{
+		{ID: 4, Score: 0.5},
+		{ID: 7, Score: 1.5},
+		{ID: 2, Score: 2.5},
+	}, result.Hits())
+
+	assert.Empty(t, (&SearchResult{Labels: []int64{-1, -1}, Distances: []float32{0, 0}}).Hits())
+}
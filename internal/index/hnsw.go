@@ -2,38 +2,53 @@ package index
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
+	"vecdb-go/internal/filter"
 
 	faiss "github.com/blevesearch/go-faiss"
 )
 
+// DefaultEfSearch is FAISS's default HNSW efSearch, which a new index starts with
+const DefaultEfSearch = 16
+
 type HNSWIndex struct {
-	index faiss.Index
-	mu    sync.Mutex
+	index  faiss.Index
+	metric MetricType
+	norms  vectorNorms
+	mu     sync.Mutex
+
+	// FAISS HNSW graphs cannot drop nodes, so removed labels are kept here
+	// and excluded from every search instead
+	removed *filter.IdFilter
+	// labels holds every label inserted, removed ones included
+	labels *filter.IdFilter
+	// efSearch is the efSearch set on the index, restored after per-query overrides
+	efSearch float64
 }
 
 var _ Index = (*HNSWIndex)(nil)
 
 func NewHNSWIndex(dim int, metric MetricType, efConstruction int, M int) (*HNSWIndex, error) {
-	var metricType int
-	switch metric {
-	case L2:
-		metricType = faiss.MetricL2
-	case IP:
-		metricType = faiss.MetricInnerProduct
-	default:
-		return nil, fmt.Errorf("unsupported metric type")
-	}
-	idx, err := faiss.IndexFactory(dim, fmt.Sprintf("IDMap,HNSW%d", M), metricType)
+	metricType, err := faissMetric(metric)
+	if err != nil {
+		return nil, err
+	}
+	// IDMap2 keeps the reverse label mapping that Reconstruct needs
+	idx, err := faiss.IndexFactory(dim, fmt.Sprintf("IDMap2,HNSW%d", M), metricType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize index: %w", err)
 	}
-	return &HNSWIndex{index: idx}, nil
+	return &HNSWIndex{index: idx, metric: metric, norms: newVectorNorms(metric), removed: filter.NewIdFilter(), labels: filter.NewIdFilter(), efSearch: DefaultEfSearch}, nil
 }
 
 func (hi *HNSWIndex) Insert(params *InsertParams) error {
 	hi.mu.Lock()
 	defer hi.mu.Unlock()
+	defer pinThreads()()
+	if err := params.Data.Validate(); err != nil {
+		return err
+	}
 	n, _ := params.Data.Dims()
 	if n != len(params.Labels) {
 		return fmt.Errorf("data and labels length mismatch")
@@ -41,11 +56,31 @@ func (hi *HNSWIndex) Insert(params *InsertParams) error {
 	if n == 0 {
 		return nil
 	}
-	// Get raw data from matrix without copying
-	flat := params.Data.RawData()
-	err := hi.index.AddWithIDs(flat, params.Labels)
-	if err != nil {
-		return fmt.Errorf("failed to insert data: %w", err)
+	// Removed vectors stay in the graph masked by their label, so a label is never reused
+	if params.Replace {
+		return fmt.Errorf("%w: HNSW indexes cannot replace vectors", ErrLabelExists)
+	}
+	if err := checkNewLabels(params.Labels, func(label int64) bool { return hi.labels.Filter(uint64(label)) }); err != nil {
+		return err
+	}
+	// Get raw data from matrix without copying, unless it has to be normalized
+	flat := hi.norms.normalize(params.Data.RawData(), params.Data.Cols, params.Labels)
+	if params.HnswParams == nil || params.HnswParams.Parallel {
+		if err := hi.index.AddWithIDs(flat, params.Labels); err != nil {
+			return fmt.Errorf("failed to insert data: %w", err)
+		}
+		addLabels(hi.labels, params.Labels)
+		return nil
+	}
+
+	// FAISS only parallelizes across the rows of one call, so adding them one by one
+	// keeps an insert that turned Parallel off single-threaded
+	dim := params.Data.Cols
+	for i, label := range params.Labels {
+		if err := hi.index.AddWithIDs(flat[i*dim:(i+1)*dim], []int64{label}); err != nil {
+			return fmt.Errorf("failed to insert data: %w", err)
+		}
+		hi.labels.Add(uint64(label))
 	}
 	return nil
 }
@@ -53,6 +88,7 @@ func (hi *HNSWIndex) Insert(params *InsertParams) error {
 func (hi *HNSWIndex) Search(query *SearchQuery, k int) (*SearchResult, error) {
 	hi.mu.Lock()
 	defer hi.mu.Unlock()
+	defer pinThreads()()
 	ntotal := hi.index.Ntotal()
 	if k > int(ntotal) {
 		k = int(ntotal)
@@ -64,21 +100,134 @@ func (hi *HNSWIndex) Search(query *SearchQuery, k int) (*SearchResult, error) {
 	var distances []float32
 	var err error
 
-	if query.IdFilter != nil && !query.IdFilter.IsEmpty() {
-		// Use FAISS SearchWithIDs for filtering during search (not post-filtering)
-		selector, err := query.IdFilter.AsSelector()
+	if query.Exact {
+		candidates := query.exactCandidates(hi.labels.Without(hi.removed))
+		result, err := searchExact(hi.index.Reconstruct, hi.metric, hi.norms.normalizeQuery(query.Vector), candidates, k)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create selector: %w", err)
+			return nil, err
 		}
-		distances, labels, err = hi.index.SearchWithIDs(query.Vector, int64(k), selector, nil)
+		result.Candidates = len(candidates)
+		return result, nil
+	}
+
+	// Apply per-query efSearch and restore the previous value afterwards
+	if query.Hnsw != nil && query.Hnsw.EfSearch > 0 && float64(query.Hnsw.EfSearch) != hi.efSearch {
+		previous := hi.efSearch
+		if err := hi.setEfSearch(float64(query.Hnsw.EfSearch)); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := hi.setEfSearch(previous); err != nil {
+				slog.Warn("Failed to restore efSearch", "ef_search", previous, "error", err)
+			}
+		}()
+	}
+
+	// Mask removed labels on a copy so the caller's query is left untouched
+	if !hi.removed.IsEmpty() {
+		masked := *query
+		masked.ExcludeFilter = hi.removed
+		if query.ExcludeFilter != nil {
+			masked.ExcludeFilter = query.ExcludeFilter.Union(hi.removed)
+		}
+		query = &masked
+	}
+
+	selector, ok, err := query.selector()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &SearchResult{Distances: []float32{}, Labels: []int64{}}, nil
+	}
+
+	vector := hi.norms.normalizeQuery(query.Vector)
+	if selector != nil {
+		// Use FAISS SearchWithIDs for filtering during search (not post-filtering)
+		defer selector.Delete()
+		distances, labels, err = hi.index.SearchWithIDs(vector, int64(k), selector, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to search with filter: %w", err)
 		}
 	} else {
-		distances, labels, err = hi.index.Search(query.Vector, int64(k))
+		distances, labels, err = hi.index.Search(vector, int64(k))
 		if err != nil {
 			return nil, fmt.Errorf("failed to search: %w", err)
 		}
 	}
-	return &SearchResult{Distances: distances, Labels: labels}, nil
+	result := &SearchResult{Distances: distances, Labels: labels, Candidates: query.candidates(ntotal)}
+	if query.StableOrder {
+		result.sortStable(hi.metric)
+	}
+	return result, nil
+}
+
+// setEfSearch sets the HNSW efSearch parameter on the index (caller must hold lock)
+func (hi *HNSWIndex) setEfSearch(efSearch float64) error {
+	ps, err := faiss.NewParameterSpace()
+	if err != nil {
+		return fmt.Errorf("failed to create parameter space: %w", err)
+	}
+	defer ps.Delete()
+
+	if err := ps.SetIndexParameter(hi.index, "efSearch", efSearch); err != nil {
+		return fmt.Errorf("failed to set efSearch: %w", err)
+	}
+
+	hi.efSearch = efSearch
+	return nil
+}
+
+// Remove masks the given labels out of future searches and returns how many were newly removed
+func (hi *HNSWIndex) Remove(labels []int64) (int, error) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	removed := 0
+	for _, label := range labels {
+		if !hi.removed.Filter(uint64(label)) {
+			hi.removed.Add(uint64(label))
+			removed++
+		}
+	}
+	hi.norms.remove(labels)
+	return removed, nil
+}
+
+// Reconstruct returns the vector inserted with label, scaled back to its original norm
+// in a cosine index; removed labels are not found even though the graph still holds them
+func (hi *HNSWIndex) Reconstruct(label int64) ([]float32, error) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	if hi.removed.Filter(uint64(label)) {
+		return nil, fmt.Errorf("%w: %d", ErrLabelNotFound, label)
+	}
+	vector, err := hi.index.Reconstruct(label)
+	if err != nil {
+		// IDMap2 only fails to reconstruct labels it does not hold
+		return nil, fmt.Errorf("%w: %d", ErrLabelNotFound, label)
+	}
+	return hi.norms.restore(label, vector), nil
+}
+
+// Labels returns the labels of the vectors in the index that were not removed, in
+// ascending order
+func (hi *HNSWIndex) Labels() []int64 {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	return labelsOf(hi.labels.Without(hi.removed))
+}
+
+// Contains reports whether a vector with label is in the index and not removed
+func (hi *HNSWIndex) Contains(label int64) bool {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	return hi.labels.Filter(uint64(label)) && !hi.removed.Filter(uint64(label))
+}
+
+// Ntotal returns the number of vectors FAISS holds, removed ones included
+// since FAISS HNSW graphs cannot drop them
+func (hi *HNSWIndex) Ntotal() int64 {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+	return hi.index.Ntotal()
 }
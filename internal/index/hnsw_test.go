@@ -1,8 +1,10 @@
 package index
 
 import (
+	"math/rand"
 	"testing"
 
+	faiss "github.com/blevesearch/go-faiss"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -56,6 +58,63 @@ func TestHNSWInsertMany(t *testing.T) {
 	assert.Equal(t, int64(2), ntotal)
 }
 
+// addCountingIndex counts the AddWithIDs calls made to the FAISS index it wraps
+type addCountingIndex struct {
+	faiss.Index
+	adds int
+}
+
+func (a *addCountingIndex) AddWithIDs(x []float32, xids []int64) error {
+	a.adds++
+	return a.Index.AddWithIDs(x, xids)
+}
+
+func TestHNSWInsertBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   *HnswParams
+		wantAdds int
+	}{
+		{"default", nil, 1},
+		{"parallel", &HnswParams{Parallel: true}, 1},
+		{"sequential", &HnswParams{Parallel: false}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, data, labels, err := setupHNSW(4, 5, L2)
+			require.NoError(t, err, "Failed to setup")
+			counting := &addCountingIndex{Index: index.index}
+			index.index = counting
+
+			params := NewInsertParams(data, labels)
+			if tt.params != nil {
+				params.With(tt.params)
+			}
+			require.NoError(t, index.Insert(params), "Insert failed")
+			assert.Equal(t, tt.wantAdds, counting.adds)
+			assert.Equal(t, int64(4), index.Ntotal())
+		})
+	}
+}
+
+func TestHNSWInsertSequential(t *testing.T) {
+	index, data, labels, err := setupHNSW(4, 5, L2)
+	require.NoError(t, err, "Failed to setup")
+
+	// With the parallel option turned off rows are added one at a time
+	err = index.Insert(NewInsertParams(data, labels).With(&HnswParams{Parallel: false}))
+	require.NoError(t, err, "Insert failed")
+	assert.Equal(t, int64(4), index.index.Ntotal())
+
+	for i, label := range labels {
+		row := data.Data[i*data.Cols : (i+1)*data.Cols]
+		result, err := index.Search(NewSearchQuery(row), 1)
+		require.NoError(t, err, "Search failed")
+		assert.Equal(t, label, result.Labels[0], "row %d should find itself", i)
+	}
+}
+
 func TestHNSWSearch(t *testing.T) {
 	index, data, labels, err := setupHNSW(2, 4, L2)
 	require.NoError(t, err, "Failed to setup")
@@ -76,6 +135,19 @@ func TestHNSWSearch(t *testing.T) {
 	t.Logf("Search result: %v", result)
 }
 
+func TestHNSWSearchRestoresEfSearch(t *testing.T) {
+	index, data, labels, err := setupHNSW(2, 4, L2)
+	require.NoError(t, err, "Failed to setup")
+	require.NoError(t, index.Insert(NewInsertParams(data, labels)))
+	assert.Equal(t, float64(DefaultEfSearch), index.efSearch)
+
+	// A per-query efSearch applies to that query only, whatever was set before it
+	require.NoError(t, index.setEfSearch(32))
+	_, err = index.Search(NewSearchQuery([]float32{1, 2, 3, 4}).With(&HnswSearchOption{EfSearch: 64}), 1)
+	require.NoError(t, err, "Search failed")
+	assert.Equal(t, float64(32), index.efSearch)
+}
+
 func TestHNSWSearchWithParams(t *testing.T) {
 	index, data, labels, err := setupHNSW(4, 5, L2)
 	require.NoError(t, err, "Failed to setup")
@@ -109,3 +181,156 @@ func TestHNSWSearchWithParams(t *testing.T) {
 	assert.Len(t, result.Labels, k)
 	assert.NotEqual(t, labels[0], result.Labels[0], "First label should be filtered out")
 }
+
+func TestHNSWSearchWithExcludeFilter(t *testing.T) {
+	index, data, labels, err := setupHNSW(4, 5, L2)
+	require.NoError(t, err, "Failed to setup")
+
+	params := NewInsertParams(data, labels)
+	err = index.Insert(params)
+	require.NoError(t, err, "Insert failed")
+
+	query := []float32{1.1, 2.1, 2.9, 3.9, 5.0}
+	k := 3
+
+	// Exclude the top result
+	excludeFilter := filter.NewIdFilter()
+	excludeFilter.Add(uint64(labels[0]))
+
+	result, err := index.Search(NewSearchQuery(query).WithExcludeFilter(excludeFilter), k)
+	require.NoError(t, err, "Search with exclude filter failed")
+
+	assert.Len(t, result.Labels, k)
+	assert.NotContains(t, result.Labels, labels[0], "Excluded label should be absent")
+	assert.Equal(t, labels[1], result.Labels[0])
+
+	// Excluding every included ID leaves nothing to return
+	idFilter := filter.NewIdFilter()
+	idFilter.Add(uint64(labels[0]))
+
+	result, err = index.Search(NewSearchQuery(query).WithFilter(idFilter).WithExcludeFilter(excludeFilter), k)
+	require.NoError(t, err, "Search with include and exclude filter failed")
+	assert.Empty(t, result.Labels)
+}
+
+func TestHNSWExactSearch(t *testing.T) {
+	const nrow, dim, k = 200, 8, 10
+	rng := rand.New(rand.NewSource(1))
+	data := make([]float32, nrow*dim)
+	for i := range data {
+		data[i] = rng.Float32()*2 - 1
+	}
+	labels := make([]int64, nrow)
+	for i := range labels {
+		labels[i] = int64(i + 1)
+	}
+	query := make([]float32, dim)
+	for i := range query {
+		query[i] = rng.Float32()*2 - 1
+	}
+
+	include := filter.NewIdFilter()
+	for id := uint64(1); id <= nrow; id += 3 {
+		include.Add(id)
+	}
+	exclude := filter.NewIdFilter()
+	exclude.AddAll([]uint64{4, 7, 10})
+
+	for _, metric := range []MetricType{L2, IP, Cosine} {
+		t.Run(string(metric), func(t *testing.T) {
+			hnsw, err := NewHNSWIndex(dim, metric, 4, 4)
+			require.NoError(t, err)
+			flat, err := NewFlatIndex(dim, metric)
+			require.NoError(t, err)
+			matrix := &math.Matrix32{Rows: nrow, Cols: dim, Data: data}
+			require.NoError(t, hnsw.Insert(NewInsertParams(matrix, labels)))
+			require.NoError(t, flat.Insert(NewInsertParams(matrix, labels)))
+
+			// A removed label stays in the graph but must not be compared
+			_, err = hnsw.Remove([]int64{1})
+			require.NoError(t, err)
+			_, err = flat.Remove([]int64{1})
+			require.NoError(t, err)
+
+			queries := map[string]func() *SearchQuery{
+				"unfiltered": func() *SearchQuery { return NewSearchQuery(query) },
+				"filtered":   func() *SearchQuery { return NewSearchQuery(query).WithFilter(include) },
+				"excluded": func() *SearchQuery {
+					return NewSearchQuery(query).WithFilter(include).WithExcludeFilter(exclude)
+				},
+			}
+			for name, newQuery := range queries {
+				want, err := flat.Search(newQuery().WithStableOrder(), k)
+				require.NoError(t, err)
+				got, err := hnsw.Search(newQuery().WithExact(), k)
+				require.NoError(t, err, name)
+
+				assert.Equal(t, want.Labels, got.Labels, name)
+				assert.InDeltaSlice(t, want.Distances, got.Distances, 1e-4, name)
+				assert.NotContains(t, got.Labels, int64(1), name)
+			}
+		})
+	}
+}
+
+func TestHNSWRemove(t *testing.T) {
+	index, data, labels, err := setupHNSW(3, 4, L2)
+	require.NoError(t, err, "Failed to setup")
+
+	err = index.Insert(NewInsertParams(data, labels))
+	require.NoError(t, err, "Insert failed")
+
+	n, err := index.Remove([]int64{labels[0], labels[0]})
+	require.NoError(t, err, "Remove failed")
+	assert.Equal(t, 1, n, "a label removed twice should be counted once")
+
+	// The removed label is masked even when it is the nearest neighbor
+	result, err := index.Search(NewSearchQuery([]float32{1, 2, 3, 4}), 3)
+	require.NoError(t, err, "Search failed")
+	assert.NotContains(t, result.Labels, labels[0])
+	assert.Contains(t, result.Labels, labels[1])
+
+	// Nor is it listed, though the graph still holds it
+	assert.Equal(t, labels[1:], index.Labels())
+	assert.Equal(t, int64(len(labels)), index.Ntotal())
+}
+
+func TestHNSWInsertExistingLabel(t *testing.T) {
+	index, data, labels, err := setupHNSW(2, 4, L2)
+	require.NoError(t, err, "Failed to setup")
+	require.NoError(t, index.Insert(NewInsertParams(data, labels)))
+	assert.True(t, index.Contains(labels[0]))
+
+	one := &math.Matrix32{Rows: 1, Cols: 4, Data: []float32{9, 9, 9, 9}}
+	require.ErrorIs(t, index.Insert(NewInsertParams(one, labels[1:])), ErrLabelExists)
+
+	// The graph keeps removed vectors masked by their label, so it cannot be reused
+	_, err = index.Remove(labels[:1])
+	require.NoError(t, err)
+	assert.False(t, index.Contains(labels[0]))
+	require.ErrorIs(t, index.Insert(NewInsertParams(one, labels[:1])), ErrLabelExists)
+
+	// Nor can vectors be replaced
+	require.ErrorIs(t, index.Insert(NewInsertParams(one, []int64{3}).WithReplace()), ErrLabelExists)
+	assert.Equal(t, int64(2), index.Ntotal())
+}
+
+func TestHNSWReconstruct(t *testing.T) {
+	index, err := NewHNSWIndex(2, Cosine, 40, 16)
+	require.NoError(t, err, "Failed to setup")
+
+	data := &math.Matrix32{Rows: 2, Cols: 2, Data: []float32{3, 4, 0, 10}}
+	require.NoError(t, index.Insert(NewInsertParams(data, []int64{1, 2})))
+
+	vector, err := index.Reconstruct(2)
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{0, 10}, vector, 1e-5)
+
+	// Removed labels stay in the graph but can no longer be reconstructed
+	_, err = index.Remove([]int64{2})
+	require.NoError(t, err)
+	_, err = index.Reconstruct(2)
+	assert.ErrorIs(t, err, ErrLabelNotFound)
+	_, err = index.Reconstruct(3)
+	assert.ErrorIs(t, err, ErrLabelNotFound)
+}
@@ -2,12 +2,22 @@ package index
 
 import (
 	"fmt"
+	"runtime"
+	"sort"
+	"sync/atomic"
 	"vecdb-go/internal/common"
+
+	faiss "github.com/blevesearch/go-faiss"
 )
 
 var (
 	ErrInvalidHNSWParams    = fmt.Errorf("invalid HNSW parameters")
 	ErrUnsupportedIndexType = fmt.Errorf("unsupported index type")
+	ErrLabelNotFound        = fmt.Errorf("label not found in index")
+	ErrInvalidFactoryString = fmt.Errorf("invalid index factory string")
+	// ErrLabelExists is returned by inserts of a label the index already holds, which
+	// FAISS would otherwise add a second time without error
+	ErrLabelExists = fmt.Errorf("label already in index")
 )
 
 type HNSWParams struct {
@@ -18,9 +28,63 @@ type HNSWParams struct {
 type Index interface {
 	Insert(params *InsertParams) error
 	Search(query *SearchQuery, k int) (*SearchResult, error)
+	// Remove deletes the vectors with the given labels and returns how many were removed
+	Remove(labels []int64) (int, error)
+	// Reconstruct returns the vector inserted with label, or ErrLabelNotFound
+	Reconstruct(label int64) ([]float32, error)
+	// Labels returns the labels of the vectors in the index in ascending order
+	Labels() []int64
+	// Ntotal returns the number of vectors FAISS holds, including removed ones it keeps
+	Ntotal() int64
+	// Contains reports whether a vector with label is in the index and not removed
+	Contains(label int64) bool
+}
+
+// numThreads is the OpenMP thread count set by SetNumThreads, zero for the FAISS default
+var numThreads atomic.Int64
+
+// setOMPThreads is replaced in tests to observe the applied thread count
+var setOMPThreads = faiss.SetOMPThreads
+
+// SetNumThreads bounds the number of OpenMP threads FAISS uses for searches and inserts.
+// The setting applies to every index; an n of zero or less keeps the FAISS default, which
+// uses all cores unless OMP_NUM_THREADS is set.
+func SetNumThreads(n int) {
+	numThreads.Store(int64(max(n, 0)))
+}
+
+// pinThreads applies the thread count of SetNumThreads before a FAISS call and returns
+// the function to call after it. OpenMP keeps the count per OS thread rather than per
+// process, so the goroutine stays on the thread it was applied to until then.
+func pinThreads() func() {
+	n := numThreads.Load()
+	if n == 0 {
+		return func() {}
+	}
+	runtime.LockOSThread()
+	setOMPThreads(uint(n))
+	return runtime.UnlockOSThread
+}
+
+// checkNewLabels returns ErrLabelExists for the first label that is taken, as reported by
+// taken, or that appears twice in labels
+func checkNewLabels(labels []int64, taken func(label int64) bool) error {
+	seen := make(map[int64]struct{}, len(labels))
+	for _, label := range labels {
+		if _, ok := seen[label]; ok {
+			return fmt.Errorf("%w: %d appears twice in the insert", ErrLabelExists, label)
+		}
+		seen[label] = struct{}{}
+		if taken(label) {
+			return fmt.Errorf("%w: %d", ErrLabelExists, label)
+		}
+	}
+	return nil
 }
 
-func NewIndex(indexType string, dim int, metric MetricType, hnswParams *HNSWParams) (Index, error) {
+// NewIndex creates an index of the given type; factoryString is the FAISS index factory
+// string of a "custom" index and ignored otherwise
+func NewIndex(indexType string, dim int, metric MetricType, hnswParams *HNSWParams, factoryString string) (Index, error) {
 	switch indexType {
 	case "flat":
 		return NewFlatIndex(dim, metric)
@@ -29,19 +93,66 @@ func NewIndex(indexType string, dim int, metric MetricType, hnswParams *HNSWPara
 			return nil, ErrInvalidHNSWParams
 		}
 		return NewHNSWIndex(dim, metric, hnswParams.EFConstruction, hnswParams.M)
+	case "custom":
+		return NewCustomIndex(dim, metric, factoryString)
 	default:
-		return nil, ErrUnsupportedIndexType
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedIndexType, indexType)
 	}
 }
 
 type MetricType = common.MetricType
 
 var (
-	L2 MetricType = common.MetricTypeL2
-	IP MetricType = common.MetricTypeIP
+	L2     MetricType = common.MetricTypeL2
+	IP     MetricType = common.MetricTypeIP
+	Cosine MetricType = common.MetricTypeCosine
 )
 
 type SearchResult struct {
 	Distances []float32
 	Labels    []int64
+	// Candidates is the number of indexed vectors the search could return once its
+	// filters are applied; excluded IDs that are not in the index still count against it
+	Candidates int
+}
+
+// Hits returns the labels of the result as IDs, each paired with its own distance, in
+// result order. The -1 labels FAISS fills unused slots with are dropped along with their
+// distances wherever they appear, not only at the end, so the pairs never shift.
+func (r *SearchResult) Hits() []common.SearchHit {
+	hits := make([]common.SearchHit, 0, len(r.Labels))
+	for i, label := range r.Labels {
+		if label < 0 || i >= len(r.Distances) {
+			continue
+		}
+		hits = append(hits, common.SearchHit{ID: uint64(label), Score: r.Distances[i]})
+	}
+	return hits
+}
+
+// sortStable orders the results best-first for the metric, breaking ties by ascending
+// label, with the -1 labels of unfilled slots last
+func (r *SearchResult) sortStable(metric MetricType) {
+	order := make([]int, len(r.Labels))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if (r.Labels[i] < 0) != (r.Labels[j] < 0) {
+			return r.Labels[j] < 0
+		}
+		if r.Distances[i] != r.Distances[j] {
+			return metric.Better(r.Distances[i], r.Distances[j])
+		}
+		return r.Labels[i] < r.Labels[j]
+	})
+
+	labels := make([]int64, len(order))
+	distances := make([]float32, len(order))
+	for dst, src := range order {
+		labels[dst] = r.Labels[src]
+		distances[dst] = r.Distances[src]
+	}
+	r.Labels, r.Distances = labels, distances
 }
@@ -1,14 +1,25 @@
 package index
 
 import (
+	"fmt"
 	"vecdb-go/internal/common/math"
 	"vecdb-go/internal/filter"
+
+	faiss "github.com/blevesearch/go-faiss"
 )
 
 type SearchQuery struct {
-	Vector   []float32
-	IdFilter *filter.IdFilter
-	Hnsw     *HnswSearchOption
+	Vector        []float32
+	IdFilter      *filter.IdFilter
+	ExcludeFilter *filter.IdFilter
+	Hnsw          *HnswSearchOption
+	// StableOrder sorts the results best-first and breaks ties by ascending label,
+	// since FAISS does not order tied results the same way on every run
+	StableOrder bool
+	// Exact makes an HNSW index compare the query with every vector that passes the
+	// filters instead of walking its graph, which finds the true nearest neighbours at a
+	// cost linear in the index size. Flat indexes are always exact and custom ones ignore it.
+	Exact bool
 }
 
 type SearchOption interface {
@@ -40,13 +51,82 @@ func (q *SearchQuery) WithFilter(filter *filter.IdFilter) *SearchQuery {
 	return q
 }
 
+// WithExcludeFilter adds an IdFilter of IDs that must not appear in the results
+func (q *SearchQuery) WithExcludeFilter(filter *filter.IdFilter) *SearchQuery {
+	q.ExcludeFilter = filter
+	return q
+}
+
+// WithStableOrder makes the search return results in a deterministic order
+func (q *SearchQuery) WithStableOrder() *SearchQuery {
+	q.StableOrder = true
+	return q
+}
+
+// WithExact makes the search compare the query with every candidate vector
+func (q *SearchQuery) WithExact() *SearchQuery {
+	q.Exact = true
+	return q
+}
+
+// candidates returns how many of ntotal indexed vectors pass the query's filters,
+// counting every ID of an inclusion filter as indexed
+func (q *SearchQuery) candidates(ntotal int64) int {
+	include := q.IdFilter != nil && !q.IdFilter.IsEmpty()
+	exclude := q.ExcludeFilter != nil && !q.ExcludeFilter.IsEmpty()
+
+	n := int(ntotal)
+	switch {
+	case include && exclude:
+		n = min(n, q.IdFilter.Without(q.ExcludeFilter).Len())
+	case include:
+		n = min(n, q.IdFilter.Len())
+	case exclude:
+		n = max(n-q.ExcludeFilter.Len(), 0)
+	}
+	return n
+}
+
+// selector builds the FAISS selector for the query's inclusion and exclusion filters.
+// It returns a nil selector when the query is unfiltered, and ok is false when the
+// filters leave no ID that could match.
+func (q *SearchQuery) selector() (selector faiss.Selector, ok bool, err error) {
+	include := q.IdFilter != nil && !q.IdFilter.IsEmpty()
+	exclude := q.ExcludeFilter != nil && !q.ExcludeFilter.IsEmpty()
+
+	switch {
+	case include && exclude:
+		remaining := q.IdFilter.Without(q.ExcludeFilter)
+		if remaining.IsEmpty() {
+			return nil, false, nil
+		}
+		selector, err = remaining.AsSelector()
+	case include:
+		selector, err = q.IdFilter.AsSelector()
+	case exclude:
+		selector, err = q.ExcludeFilter.AsExcludeSelector()
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create selector: %w", err)
+	}
+
+	return selector, true, nil
+}
+
 type InsertParams struct {
 	Data       *math.Matrix32
 	Labels     []int64
 	HnswParams *HnswParams
+	// Replace removes the vectors of labels already in the index before adding the new
+	// ones, rather than failing with ErrLabelExists; only flat indexes can replace
+	Replace bool
 }
 
+// HnswParams contains HNSW insertion options
 type HnswParams struct {
+	// Parallel adds the whole batch in one FAISS call, which builds the graph on all
+	// OpenMP threads, as inserts without HnswParams do; set to false, rows are added
+	// one at a time on a single thread
 	Parallel bool
 }
 
@@ -62,6 +142,12 @@ func (p *InsertParams) With(option InsertOption) *InsertParams {
 	return p
 }
 
+// WithReplace makes the insert replace the vectors of labels already in the index
+func (p *InsertParams) WithReplace() *InsertParams {
+	p.Replace = true
+	return p
+}
+
 type InsertOption interface {
 	SetParams(params *InsertParams)
 }
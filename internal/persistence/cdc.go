@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"log/slog"
+	"sync"
+
+	"vecdb-go/internal/common"
+)
+
+const (
+	DefaultSubscriberBufferSize = 1024
+)
+
+// subscriber receives WAL records once they are durably written
+type subscriber struct {
+	ch     chan WALRecord
+	done   chan struct{}
+	policy common.CDCPolicy
+}
+
+// Subscribe registers a change-data-capture subscriber that receives every WAL record
+// after it has been flushed and fsynced to disk. Records are buffered up to bufferSize;
+// when the buffer is full, the drop policy discards the record and the block policy
+// stalls the writer until the subscriber catches up or unsubscribes. Close delivers the
+// records it flushes before closing the channel, so a blocking subscriber has to keep
+// reading until then.
+// The returned function unsubscribes and closes the channel; it is safe to call more than once.
+// Record docs and attributes are shared with the writer and must not be modified.
+func (p *Persistence) Subscribe(bufferSize int, policy common.CDCPolicy) (<-chan WALRecord, func()) {
+	if bufferSize <= 0 {
+		bufferSize = DefaultSubscriberBufferSize
+	}
+
+	sub := &subscriber{
+		ch:     make(chan WALRecord, bufferSize),
+		done:   make(chan struct{}),
+		policy: policy,
+	}
+
+	p.subMu.Lock()
+	// Close marks the persistence closed before it closes subscribers, so a subscriber
+	// added here is always closed by it
+	if p.closed.Load() {
+		p.subMu.Unlock()
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	p.subscribers[sub] = struct{}{}
+	p.subMu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			// Release a writer blocked on this subscriber before taking the lock
+			close(sub.done)
+
+			p.subMu.Lock()
+			defer p.subMu.Unlock()
+
+			if _, ok := p.subscribers[sub]; ok {
+				delete(p.subscribers, sub)
+				close(sub.ch)
+			}
+		})
+	}
+
+	return sub.ch, unsubscribe
+}
+
+// deliver publishes the records in the outbox to all subscribers. It must be called
+// without the WAL lock, after any call that may have flushed; whoever holds subMu keeps
+// delivering until the outbox is empty, so records queued meanwhile are not left behind.
+func (p *Persistence) deliver() {
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+
+	for {
+		p.outboxMu.Lock()
+		records := p.outbox
+		p.outbox = nil
+		p.outboxMu.Unlock()
+
+		if len(records) == 0 {
+			return
+		}
+		p.publishLocked(records)
+	}
+}
+
+// publishLocked delivers durable records to all subscribers (caller must hold subMu)
+func (p *Persistence) publishLocked(records []WALRecord) {
+	for sub := range p.subscribers {
+		dropped := 0
+		for _, record := range records {
+			if sub.policy == common.CDCPolicyBlock {
+				select {
+				case sub.ch <- record:
+				case <-sub.done:
+					// Unsubscribed while the writer was waiting
+				}
+				continue
+			}
+
+			select {
+			case sub.ch <- record:
+			default:
+				dropped++
+			}
+		}
+
+		if dropped > 0 {
+			slog.Warn("CDC subscriber buffer full, dropped WAL records", "dropped", dropped)
+		}
+	}
+}
+
+// closeSubscribers closes all subscriber channels
+func (p *Persistence) closeSubscribers() {
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+
+	for sub := range p.subscribers {
+		delete(p.subscribers, sub)
+		close(sub.ch)
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"hash/crc32"
 	"io"
 	"math"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // WALEncoder defines the interface for encoding and decoding WAL records
@@ -25,110 +27,138 @@ type WALEncoder interface {
 	Name() string
 }
 
-func EncoderFactory(encoderType, version string) WALEncoder {
+// EncoderFactory returns the encoder for encoderType; checksum only applies to the
+// binary encoder, since text records carry no checksum
+func EncoderFactory(encoderType, version string, checksum ChecksumAlgorithm) WALEncoder {
 	var encoder WALEncoder
 
 	if encoderType == "text" {
 		encoder = NewTextWALEncoder(version)
 	} else {
 		// Default to binary encoder
-		encoder = NewBinaryWALEncoder(version)
+		encoder = NewBinaryWALEncoderWithChecksum(version, checksum)
 	}
 
 	return encoder
 }
 
-// BinaryWALEncoder implements binary encoding with CRC32 checksum
-type BinaryWALEncoder struct {
-	version string
+// maxPooledBufferSize bounds the buffers kept in encodeBufferPool, so that encoding one
+// huge record does not pin its memory
+const maxPooledBufferSize = 1 << 20
+
+// encodeBuffer is the scratch space of one EncodeRecord call, reused across calls
+type encodeBuffer struct {
+	out     []byte        // the encoded record
+	scratch []byte        // intermediate bytes, e.g. the raw vector of a text record
+	json    bytes.Buffer  // the doc and attributes JSON
+	enc     *json.Encoder // writes to json
 }
 
-// NewBinaryWALEncoder creates a new binary WAL encoder
-func NewBinaryWALEncoder(version string) *BinaryWALEncoder {
-	return &BinaryWALEncoder{version: version}
+var encodeBufferPool = sync.Pool{
+	New: func() any {
+		buf := &encodeBuffer{}
+		buf.enc = json.NewEncoder(&buf.json)
+		return buf
+	},
 }
 
-func (e *BinaryWALEncoder) Name() string {
-	return "binary"
+func getEncodeBuffer() *encodeBuffer {
+	buf := encodeBufferPool.Get().(*encodeBuffer)
+	buf.json.Reset()
+	return buf
 }
 
-func (e *BinaryWALEncoder) EncodeRecord(writer io.Writer, record *WALRecord) error {
-	// Serialize doc and attributes
-	docBytes, err := json.Marshal(record.Doc)
-	if err != nil {
-		return fmt.Errorf("failed to marshal doc: %w", err)
+func putEncodeBuffer(buf *encodeBuffer) {
+	if cap(buf.out) > maxPooledBufferSize || cap(buf.scratch) > maxPooledBufferSize || buf.json.Cap() > maxPooledBufferSize {
+		return
 	}
+	encodeBufferPool.Put(buf)
+}
 
-	attrBytes, err := json.Marshal(record.Attributes)
-	if err != nil {
-		return fmt.Errorf("failed to marshal attributes: %w", err)
+// marshalJSON encodes doc and attributes like json.Marshal, into the buffer's JSON space.
+// The returned slices are valid until the buffer is reused.
+func (buf *encodeBuffer) marshalJSON(doc, attributes map[string]any) ([]byte, []byte, error) {
+	if err := buf.enc.Encode(doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal doc: %w", err)
 	}
+	// Encode terminates each value with a newline, which json.Marshal does not write
+	buf.json.Truncate(buf.json.Len() - 1)
+	docEnd := buf.json.Len()
 
-	dim := len(record.Vector)
-	vectorBytes := dim * 4
-
-	// Calculate total record size
-	recordSize := 4 + 8 + 1 + 8 + 4 + vectorBytes + 4 + len(docBytes) + 4 + len(attrBytes) + 4
-
-	// Write record length
-	if err := binary.Write(writer, binary.BigEndian, uint32(recordSize-4)); err != nil {
-		return err
+	if err := buf.enc.Encode(attributes); err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal attributes: %w", err)
 	}
+	buf.json.Truncate(buf.json.Len() - 1)
 
-	// Start checksum calculation
-	crc := crc32.NewIEEE()
-	multiWriter := io.MultiWriter(writer, crc)
+	data := buf.json.Bytes()
+	return data[:docEnd], data[docEnd:], nil
+}
 
-	// Write log ID
-	if err := binary.Write(multiWriter, binary.BigEndian, record.LogID); err != nil {
-		return err
-	}
+// BinaryWALEncoder implements binary encoding with a per-record checksum
+type BinaryWALEncoder struct {
+	version  string
+	checksum ChecksumAlgorithm // algorithm of written records; any is accepted on read
+}
 
-	// Write operation
-	if err := binary.Write(multiWriter, binary.BigEndian, uint8(record.Operation)); err != nil {
-		return err
-	}
+// NewBinaryWALEncoder creates a new binary WAL encoder with CRC32 checksums
+func NewBinaryWALEncoder(version string) *BinaryWALEncoder {
+	return NewBinaryWALEncoderWithChecksum(version, ChecksumCRC32)
+}
 
-	// Write vector ID
-	if err := binary.Write(multiWriter, binary.BigEndian, record.VectorID); err != nil {
-		return err
-	}
+// NewBinaryWALEncoderWithChecksum creates a new binary WAL encoder writing checksums
+// with the given algorithm
+func NewBinaryWALEncoderWithChecksum(version string, checksum ChecksumAlgorithm) *BinaryWALEncoder {
+	return &BinaryWALEncoder{version: version, checksum: checksum}
+}
 
-	// Write dimension
-	if err := binary.Write(multiWriter, binary.BigEndian, uint32(dim)); err != nil {
-		return err
-	}
+func (e *BinaryWALEncoder) Name() string {
+	return "binary"
+}
 
-	// Write vector data
-	for _, val := range record.Vector {
-		if err := binary.Write(multiWriter, binary.BigEndian, val); err != nil {
-			return err
-		}
+func (e *BinaryWALEncoder) EncodeRecord(writer io.Writer, record *WALRecord) error {
+	if record.Operation > operationMask {
+		return fmt.Errorf("operation %d does not fit the WAL operation byte", record.Operation)
 	}
 
-	// Write doc length and data
-	if err := binary.Write(multiWriter, binary.BigEndian, uint32(len(docBytes))); err != nil {
-		return err
-	}
-	if _, err := multiWriter.Write(docBytes); err != nil {
-		return err
-	}
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
 
-	// Write attributes length and data
-	if err := binary.Write(multiWriter, binary.BigEndian, uint32(len(attrBytes))); err != nil {
+	// Serialize doc and attributes
+	docBytes, attrBytes, err := buf.marshalJSON(record.Doc, record.Attributes)
+	if err != nil {
 		return err
 	}
-	if _, err := multiWriter.Write(attrBytes); err != nil {
-		return err
+
+	dim := len(record.Vector)
+
+	// Record length, excluding the length field itself
+	recordLen := 8 + 1 + 8 + 4 + dim*4 + 4 + len(docBytes) + 4 + len(attrBytes) + e.checksum.size()
+
+	// Encode the whole record into one buffer so it takes a single write
+	out := slices.Grow(buf.out[:0], 4+recordLen)
+	out = binary.BigEndian.AppendUint32(out, uint32(recordLen))
+	out = binary.BigEndian.AppendUint64(out, record.LogID)
+	// Operation, with the checksum algorithm in its top bits
+	out = append(out, uint8(e.checksum)<<checksumShift|uint8(record.Operation))
+	out = binary.BigEndian.AppendUint64(out, record.VectorID)
+	out = binary.BigEndian.AppendUint32(out, uint32(dim))
+	for _, val := range record.Vector {
+		out = binary.BigEndian.AppendUint32(out, math.Float32bits(val))
 	}
+	out = binary.BigEndian.AppendUint32(out, uint32(len(docBytes)))
+	out = append(out, docBytes...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(attrBytes)))
+	out = append(out, attrBytes...)
 
-	// Write checksum
-	checksum := crc.Sum32()
-	if err := binary.Write(writer, binary.BigEndian, checksum); err != nil {
+	// The checksum covers everything after the record length
+	out, err = e.checksum.appendSum(out, out[4:])
+	if err != nil {
 		return err
 	}
+	buf.out = out
 
-	return nil
+	_, err = writer.Write(out)
+	return err
 }
 
 func (e *BinaryWALEncoder) DecodeRecord(reader *bufio.Reader) (*WALRecord, error) {
@@ -144,19 +174,29 @@ func (e *BinaryWALEncoder) DecodeRecord(reader *bufio.Reader) (*WALRecord, error
 		return nil, fmt.Errorf("failed to read record data: %w", err)
 	}
 
-	// Extract checksum (last 4 bytes)
-	if len(recordData) < 4 {
+	// The checksum algorithm is in the operation byte after the log ID; the checksum
+	// itself is the last bytes of the record
+	if len(recordData) < 9 {
+		return nil, fmt.Errorf("record too short")
+	}
+
+	algorithm := ChecksumAlgorithm(recordData[8] >> checksumShift)
+	checksum, err := algorithm.newHash()
+	if err != nil {
+		return nil, err
+	}
+	if len(recordData) < 9+algorithm.size() {
 		return nil, fmt.Errorf("record too short")
 	}
 
-	checksumBytes := recordData[len(recordData)-4:]
-	dataBytes := recordData[:len(recordData)-4]
+	checksumBytes := recordData[len(recordData)-algorithm.size():]
+	dataBytes := recordData[:len(recordData)-algorithm.size()]
 
-	expectedChecksum := binary.BigEndian.Uint32(checksumBytes)
-	actualChecksum := crc32.ChecksumIEEE(dataBytes)
+	checksum.Write(dataBytes)
+	actualChecksum := checksum.Sum(nil)
 
-	if expectedChecksum != actualChecksum {
-		return nil, fmt.Errorf("checksum mismatch: expected %d, got %d", expectedChecksum, actualChecksum)
+	if !bytes.Equal(checksumBytes, actualChecksum) {
+		return nil, fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, checksumBytes, actualChecksum)
 	}
 
 	// Parse record data
@@ -168,7 +208,7 @@ func (e *BinaryWALEncoder) DecodeRecord(reader *bufio.Reader) (*WALRecord, error
 	offset += 8
 
 	// Read operation
-	record.Operation = WALOperation(dataBytes[offset])
+	record.Operation = WALOperation(dataBytes[offset] & operationMask)
 	offset += 1
 
 	// Read vector ID
@@ -224,45 +264,59 @@ func (e *TextWALEncoder) Name() string {
 }
 
 func (e *TextWALEncoder) EncodeRecord(writer io.Writer, record *WALRecord) error {
-	// Convert vector to base64
-	vectorBuf := new(bytes.Buffer)
-	for _, val := range record.Vector {
-		if err := binary.Write(vectorBuf, binary.BigEndian, val); err != nil {
-			return fmt.Errorf("failed to encode vector: %w", err)
-		}
-	}
-	vectorBase64 := base64.StdEncoding.EncodeToString(vectorBuf.Bytes())
+	buf := getEncodeBuffer()
+	defer putEncodeBuffer(buf)
 
 	// Marshal doc and attributes as compressed JSON (no indentation)
-	docJSON, err := json.Marshal(record.Doc)
+	docJSON, attrJSON, err := buf.marshalJSON(record.Doc, record.Attributes)
 	if err != nil {
-		return fmt.Errorf("failed to marshal doc: %w", err)
+		return err
 	}
 
-	attrJSON, err := json.Marshal(record.Attributes)
-	if err != nil {
-		return fmt.Errorf("failed to marshal attributes: %w", err)
+	// Big-endian vector bytes, written as base64
+	vectorBytes := buf.scratch[:0]
+	for _, val := range record.Vector {
+		vectorBytes = binary.BigEndian.AppendUint32(vectorBytes, math.Float32bits(val))
 	}
-
-	// Escape any quotes or newlines in JSON strings for CSV compatibility
-	docStr := strings.ReplaceAll(string(docJSON), "\"", "\\\"")
-	docStr = strings.ReplaceAll(docStr, "\n", "\\n")
-	attrStr := strings.ReplaceAll(string(attrJSON), "\"", "\\\"")
-	attrStr = strings.ReplaceAll(attrStr, "\n", "\\n")
+	buf.scratch = vectorBytes
 
 	// Write CSV-like format: log_id,version,operation,vector_id,vector_base64,doc_json,attributes_json
-	_, err = fmt.Fprintf(writer, "%d,%s,%s,%d,%s,\"%s\",\"%s\"\n",
-		record.LogID,
-		record.Version,
-		record.Operation.String(),
-		record.VectorID,
-		vectorBase64,
-		docStr,
-		attrStr,
-	)
+	out := buf.out[:0]
+	out = strconv.AppendUint(out, record.LogID, 10)
+	out = append(out, ',')
+	out = append(out, record.Version...)
+	out = append(out, ',')
+	out = append(out, record.Operation.String()...)
+	out = append(out, ',')
+	out = strconv.AppendUint(out, record.VectorID, 10)
+	out = append(out, ',')
+	out = base64.StdEncoding.AppendEncode(out, vectorBytes)
+	out = append(out, ",\""...)
+	out = appendCSVEscaped(out, docJSON)
+	out = append(out, "\",\""...)
+	out = appendCSVEscaped(out, attrJSON)
+	out = append(out, "\"\n"...)
+	buf.out = out
+
+	_, err = writer.Write(out)
 	return err
 }
 
+// appendCSVEscaped appends JSON to b with quotes and newlines escaped for CSV compatibility
+func appendCSVEscaped(b []byte, data []byte) []byte {
+	for _, c := range data {
+		switch c {
+		case '"':
+			b = append(b, '\\', '"')
+		case '\n':
+			b = append(b, '\\', 'n')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
 func (e *TextWALEncoder) DecodeRecord(reader *bufio.Reader) (*WALRecord, error) {
 	// Read one line (CSV format)
 	line, err := reader.ReadString('\n')
@@ -300,6 +354,10 @@ func (e *TextWALEncoder) DecodeRecord(reader *bufio.Reader) (*WALRecord, error)
 		record.Operation = Insert
 	} else if opStr == "Delete" || opStr == "delete" {
 		record.Operation = Delete
+	} else if opStr == "Begin" || opStr == "begin" {
+		record.Operation = Begin
+	} else if opStr == "Update" || opStr == "update" {
+		record.Operation = Update
 	} else {
 		return nil, fmt.Errorf("unknown operation: %s", opStr)
 	}
@@ -398,6 +456,10 @@ func (op WALOperation) String() string {
 		return "Insert"
 	case Delete:
 		return "Delete"
+	case Begin:
+		return "Begin"
+	case Update:
+		return "Update"
 	default:
 		return fmt.Sprintf("Unknown(%d)", op)
 	}
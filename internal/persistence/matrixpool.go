@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"math/bits"
+	"sync"
+
+	commonMath "vecdb-go/internal/common/math"
+)
+
+// matrixPools recycles the matrices Sync copies the vectors of a batch into before
+// handing them to the vector index, which copies them again. Pool i holds matrices
+// whose backing slice has a capacity of 1<<i elements, so every batch reuses one large
+// enough for it without pinning a huge slice for a small batch.
+var matrixPools [bits.UintSize]sync.Pool
+
+// capacityClass returns the pool holding backing slices large enough for n elements
+func capacityClass(n int) int {
+	return bits.Len(uint(n - 1))
+}
+
+// vectorMatrix returns a rows x dim matrix holding vectors, taken from matrixPools when
+// one is free. Vectors shorter than dim are padded with zeros, as in a fresh matrix.
+// The caller hands it back with releaseMatrix once the index no longer reads it.
+func vectorMatrix(vectors [][]float32, dim int) *commonMath.Matrix32 {
+	n := len(vectors) * dim
+	if n == 0 {
+		return commonMath.NewMatrix32Empty(len(vectors), dim)
+	}
+
+	class := capacityClass(n)
+	mat, ok := matrixPools[class].Get().(*commonMath.Matrix32)
+	if !ok {
+		mat = &commonMath.Matrix32{Data: make([]float32, 0, 1<<class)}
+	}
+	mat.Rows, mat.Cols, mat.Data = len(vectors), dim, mat.Data[:n]
+
+	for i, vec := range vectors {
+		row := mat.Data[i*dim : (i+1)*dim]
+		clear(row[copy(row, vec):])
+	}
+	return mat
+}
+
+// releaseMatrix returns a matrix built by vectorMatrix to its pool
+func releaseMatrix(mat *commonMath.Matrix32) {
+	c := cap(mat.Data)
+	if c == 0 || c&(c-1) != 0 {
+		return
+	}
+	matrixPools[capacityClass(c)].Put(mat)
+}
@@ -0,0 +1,89 @@
+package persistence
+
+import (
+	"encoding/json"
+	"maps"
+)
+
+// MaxPendingDocBytes is the largest JSON-encoded doc PendingRecords lists; larger docs are
+// left out, so listing records does not copy documents of any size
+const MaxPendingDocBytes = 4096
+
+// PendingRecord describes a WAL record that has not been applied yet. The vector is
+// left out; Dim gives its length. A doc larger than MaxPendingDocBytes is left out too,
+// with DocBytes giving its JSON size.
+type PendingRecord struct {
+	LogID      uint64         `json:"log_id"`
+	Operation  string         `json:"operation"`
+	VectorID   uint64         `json:"vector_id"`
+	Dim        int            `json:"dim,omitempty"`
+	Doc        map[string]any `json:"doc,omitempty"`
+	DocBytes   int            `json:"doc_bytes,omitempty"`
+	DocOmitted bool           `json:"doc_omitted,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// PendingSnapshot summarizes the WAL records written but not yet applied to the
+// database components, and lists the oldest of them
+type PendingSnapshot struct {
+	Count      int             `json:"count"`      // pending records, not counting those being applied
+	Inflight   int             `json:"inflight"`   // records taken by a Sync that is applying them
+	Operations map[string]int  `json:"operations"` // pending records by operation
+	Records    []PendingRecord `json:"records,omitempty"`
+}
+
+// PendingRecords snapshots the pending WAL records, listing at most limit of them in the
+// order they will be applied. Only the counts and the listed records are taken under the
+// lock; their docs are measured and copied after it is released, which is safe since
+// records are not modified once pending.
+func (p *Persistence) PendingRecords(limit int) PendingSnapshot {
+	snapshot, listed := p.pendingSnapshot(limit)
+
+	for i := range listed {
+		record := &listed[i]
+		pending := PendingRecord{
+			LogID:      record.LogID,
+			Operation:  record.Operation.String(),
+			VectorID:   record.VectorID,
+			Dim:        len(record.Vector),
+			Attributes: maps.Clone(record.Attributes),
+		}
+		if record.Doc != nil {
+			pending.DocBytes = docBytes(record.Doc)
+			if pending.DocBytes > MaxPendingDocBytes {
+				pending.DocOmitted = true
+			} else {
+				pending.Doc = maps.Clone(record.Doc)
+				pending.DocBytes = 0
+			}
+		}
+		snapshot.Records = append(snapshot.Records, pending)
+	}
+	return snapshot
+}
+
+// pendingSnapshot counts the pending records and returns the first limit of them
+func (p *Persistence) pendingSnapshot(limit int) (PendingSnapshot, []WALRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := PendingSnapshot{
+		Count:      len(p.pendingLogs),
+		Inflight:   p.inflight,
+		Operations: make(map[string]int),
+	}
+	for i := range p.pendingLogs {
+		snapshot.Operations[p.pendingLogs[i].Operation.String()]++
+	}
+	listed := append([]WALRecord(nil), p.pendingLogs[:min(max(limit, 0), len(p.pendingLogs))]...)
+	return snapshot, listed
+}
+
+// docBytes returns the size of doc encoded as JSON, or 0 if it cannot be encoded
+func docBytes(doc map[string]any) int {
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
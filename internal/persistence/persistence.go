@@ -2,13 +2,13 @@ package persistence
 
 import (
 	"bufio"
-	"encoding/json"
+	"encoding/binary"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"vecdb-go/internal/common"
 	commonMath "vecdb-go/internal/common/math"
@@ -20,7 +20,7 @@ import (
 // WAL record format:
 // [4 bytes: record length]
 // [8 bytes: log ID]
-// [1 byte: operation type]
+// [1 byte: checksum algorithm (top 2 bits) | operation type]
 // [8 bytes: vector ID]
 // [4 bytes: dimension]
 // [dimension * 4 bytes: vector data]
@@ -28,21 +28,79 @@ import (
 // [doc length bytes: doc JSON]
 // [4 bytes: attributes length]
 // [attributes length bytes: attributes JSON]
-// [4 bytes: CRC32 checksum]
+// [4 bytes: CRC32 or CRC32C checksum, or 8 bytes: xxHash64 checksum]
 
 const (
 	WALVersion = "v1"
+
+	// LogIDMarkSuffix is appended to the WAL path to name the file holding the LogID
+	// high-water mark, which keeps LogIDs monotonic after the WAL is truncated
+	LogIDMarkSuffix = ".logid"
+)
+
+var (
+	ErrPersistenceClosed = fmt.Errorf("persistence is closed")
+	// ErrChecksumMismatch is returned when a decoded WAL record fails its integrity check
+	ErrChecksumMismatch = fmt.Errorf("checksum mismatch")
+	// ErrDocTooLarge is returned when a serialized document exceeds the maximum doc size
+	ErrDocTooLarge = fmt.Errorf("document too large")
+	// ErrNonFiniteVector is returned when a vector contains NaN or infinite values
+	ErrNonFiniteVector = fmt.Errorf("vector contains non-finite values")
+	// ErrReadOnly is returned when writing through a read-only persistence layer
+	ErrReadOnly = fmt.Errorf("persistence is read-only")
+	// ErrWALCorrupt is returned by Restore when more of the WAL cannot be decoded than
+	// the limit set with SetMaxCorruptBytes
+	ErrWALCorrupt = fmt.Errorf("WAL is corrupt")
 )
 
 type Persistence struct {
-	filePath    string
-	walWriter   *os.File
-	mu          sync.Mutex
-	version     string
-	counter     atomic.Uint64
-	bufWriter   *bufio.Writer
-	pendingLogs []WALRecord
-	encoder     WALEncoder
+	filePath     string
+	walWriter    *os.File
+	mu           sync.Mutex // guards the WAL writer and pending logs
+	syncMu       sync.Mutex // serializes applying batches to the database components
+	inflight     int        // records taken from pendingLogs and currently being applied
+	version      string
+	counter      atomic.Uint64
+	bufWriter    *bufio.Writer
+	pendingLogs  []WALRecord
+	encoder      WALEncoder
+	txMode       common.SyncTxMode // how scalar writes of a sync batch are grouped
+	maxDocBytes  int               // largest serialized doc written to scalar storage
+	docCodec     common.DocCodec   // format of docs written to scalar storage
+	hnswInsert   *index.HnswParams // HNSW options vectors are inserted with, nil for the index defaults
+	replaceIDs   bool              // inserts of IDs already in the vector index replace their vectors
+	skipFinite   bool              // accept NaN and infinite vector values unchecked
+	maxSyncBatch int               // largest chunk of pending records Sync applies at once, 0 for no limit
+	flushEveryN  int               // records written between WAL flushes, 0 to leave flushing to the callers
+	maxCorrupt   int64             // undecodable WAL bytes Restore tolerates, negative for no limit
+	readOnly     bool              // set by NewReadOnlyPersistence, rejects writes and keeps the WAL intact
+	applyHook    ApplyHook         // told the IDs of every chunk Sync applies
+	closed       atomic.Bool       // set by Close under mu
+
+	// Highest vector ID found in the WAL by Restore
+	restoredMaxID uint64
+
+	// Set while Restore replays the WAL, so attributes that cannot be indexed are
+	// dropped instead of failing the batch; a later Sync only drops attributes whose
+	// type conflicts with another write's. skippedAttributes counts them.
+	restoring         atomic.Bool
+	skippedAttributes atomic.Uint64
+
+	// Bumped before and after each chunk of records is applied
+	epoch atomic.Uint64
+
+	// Operation and WAL byte counters
+	stats opCounters
+
+	// Records written since the last flush, moved to the outbox once durable
+	unflushed  []WALRecord
+	sinceFlush int // records written since the last flush, Begin markers excluded
+	// Durable records waiting to be delivered to subscribers, in WAL order. They are
+	// delivered after the WAL lock is released, so a blocking subscriber does not hold it.
+	outboxMu    sync.Mutex
+	outbox      []WALRecord
+	subMu       sync.Mutex // held while delivering, which keeps deliveries in order
+	subscribers map[*subscriber]struct{}
 }
 
 type WALOperation int
@@ -50,6 +108,12 @@ type WALOperation int
 const (
 	Insert WALOperation = iota
 	Delete
+	// Begin marks the start of an atomic batch; its VectorID holds the number of
+	// records that follow it. Restore drops a batch whose records are not all on disk.
+	Begin
+	// Update replaces the doc and attributes of an existing vector and leaves the
+	// vector itself untouched; it is skipped if the vector no longer has a doc
+	Update
 )
 
 type WALRecord struct {
@@ -64,6 +128,7 @@ type WALRecord struct {
 
 // WALRecordData contains the data components needed to apply a WAL record
 type WALRecordData struct {
+	Operation  WALOperation
 	VectorID   uint64
 	Vector     []float32
 	Doc        map[string]any
@@ -83,14 +148,41 @@ func NewPersistenceWithEncoder(filePath string, encoder WALEncoder) (*Persistenc
 		return nil, fmt.Errorf("failed to open WAL file: %w", err)
 	}
 
+	return newPersistence(filePath, file, encoder)
+}
+
+// NewReadOnlyPersistence opens an existing WAL without write access. Writes fail with
+// ErrReadOnly, and Restore rebuilds only the in-memory indexes from the WAL, leaving
+// both the WAL and scalar storage unchanged.
+func NewReadOnlyPersistence(filePath string, encoder WALEncoder) (*Persistence, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+	}
+
+	p, err := newPersistence(filePath, file, encoder)
+	if err != nil {
+		return nil, err
+	}
+	p.readOnly = true
+
+	return p, nil
+}
+
+// newPersistence creates a persistence layer around an open WAL file
+func newPersistence(filePath string, file *os.File, encoder WALEncoder) (*Persistence, error) {
 	p := &Persistence{
 		filePath:    filePath,
 		walWriter:   file,
 		version:     WALVersion,
-		bufWriter:   bufio.NewWriter(file),
 		pendingLogs: make([]WALRecord, 0, 100),
 		encoder:     encoder,
+		txMode:      common.SyncTxModeBatch,
+		maxDocBytes: common.DefaultMaxDocBytes,
+		maxCorrupt:  -1,
+		subscribers: make(map[*subscriber]struct{}),
 	}
+	p.bufWriter = bufio.NewWriter(walByteCounter{w: file, count: &p.stats.walBytes})
 
 	// Initialize counter from existing WAL if any
 	if err := p.initCounter(); err != nil {
@@ -101,8 +193,139 @@ func NewPersistenceWithEncoder(filePath string, encoder WALEncoder) (*Persistenc
 	return p, nil
 }
 
-// initCounter initializes the counter from existing WAL records
+// SetSyncTxMode sets how scalar writes are grouped into transactions during Sync;
+// an empty mode selects the default batched mode
+func (p *Persistence) SetSyncTxMode(mode common.SyncTxMode) error {
+	switch mode {
+	case "":
+		mode = common.SyncTxModeBatch
+	case common.SyncTxModeBatch, common.SyncTxModePerRecord:
+	default:
+		return fmt.Errorf("unsupported sync transaction mode: %s", mode)
+	}
+
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.txMode = mode
+	return nil
+}
+
+// SetMaxDocBytes sets the largest serialized document Sync writes to scalar storage;
+// zero or a negative size selects common.DefaultMaxDocBytes
+func (p *Persistence) SetMaxDocBytes(size int) {
+	if size <= 0 {
+		size = common.DefaultMaxDocBytes
+	}
+
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.maxDocBytes = size
+}
+
+// SetDocCodec sets the format Sync writes docs to scalar storage in; an empty codec
+// selects JSON. Docs already stored keep their format and are still read.
+func (p *Persistence) SetDocCodec(c common.DocCodec) error {
+	if err := common.ValidateDocCodec(c); err != nil {
+		return err
+	}
+
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.docCodec = c
+	return nil
+}
+
+// SetHnswInsertParams sets the HNSW options Sync inserts vectors with; set them only for
+// HNSW vector indexes, nil inserts with the index defaults
+func (p *Persistence) SetHnswInsertParams(params *index.HnswParams) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.hnswInsert = params
+}
+
+// SetReplaceExistingIDs sets whether Sync replaces the vector of an ID the vector index
+// already holds when a record inserts it again, which only flat indexes can do. Otherwise
+// such records are dropped, as they could never be applied.
+func (p *Persistence) SetReplaceExistingIDs(replace bool) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.replaceIDs = replace
+}
+
+// SetSkipFiniteCheck sets whether Sync accepts vectors with NaN or infinite values
+// unchecked; by default they are rejected, since FAISS cannot index them meaningfully
+func (p *Persistence) SetSkipFiniteCheck(skip bool) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.skipFinite = skip
+}
+
+// SetMaxSyncBatch sets the largest number of pending records Sync applies at once; a
+// larger backlog is applied in chunks. Zero or a negative size applies it all at once.
+func (p *Persistence) SetMaxSyncBatch(size int) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.maxSyncBatch = max(size, 0)
+}
+
+// SetMaxCorruptBytes makes Restore fail with ErrWALCorrupt, before applying any record,
+// if more than limit bytes of the WAL cannot be decoded. Restore stops reading at the
+// first corrupt record, so everything from there to the end of the WAL counts. A negative
+// limit, the default, restores the records before the corruption and drops the rest.
+func (p *Persistence) SetMaxCorruptBytes(limit int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxCorrupt = limit
+}
+
+// ApplyHook receives the IDs of the insert and delete records of a chunk of WAL records
+// once the chunk is applied; the inserts were applied before the deletes
+type ApplyHook func(inserted, deleted []uint64)
+
+// SetApplyHook sets the function Sync calls after applying each chunk of records.
+// Restore replays the WAL through Sync, so the hook also sees every replayed record.
+// It runs under the sync lock and must not call back into the persistence layer.
+func (p *Persistence) SetApplyHook(hook ApplyHook) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+	p.applyHook = hook
+}
+
+// SetFlushEveryN makes the WAL flush and fsync itself once every n records written,
+// independently of the background sync. Zero or a negative n leaves flushing to
+// Flush and to the callers that make writes durable.
+func (p *Persistence) SetFlushEveryN(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.flushEveryN = max(n, 0)
+}
+
+// checkDocSize returns an error wrapping ErrDocTooLarge if a serialized doc exceeds maxDocBytes
+func checkDocSize(vectorID uint64, docBytes []byte, maxDocBytes int) error {
+	if len(docBytes) > maxDocBytes {
+		return fmt.Errorf("%w: doc for vector %d is %d bytes, limit is %d", ErrDocTooLarge, vectorID, len(docBytes), maxDocBytes)
+	}
+	return nil
+}
+
+// initCounter initializes the counter from the LogID high-water mark and existing WAL records
 func (p *Persistence) initCounter() error {
+	// LogIDs handed out before the last truncation are only recorded in the mark
+	mark, err := p.readLogIDMark()
+	if err != nil {
+		return err
+	}
+	p.counter.Store(mark)
+
 	// Get file size
 	stat, err := p.walWriter.Stat()
 	if err != nil {
@@ -110,8 +333,7 @@ func (p *Persistence) initCounter() error {
 	}
 
 	if stat.Size() == 0 {
-		// Empty file, start from 0
-		p.counter.Store(0)
+		// Empty file, continue from the mark
 		return nil
 	}
 
@@ -122,14 +344,9 @@ func (p *Persistence) initCounter() error {
 	}
 	defer reader.Close()
 
-	var maxLogID uint64 = 0
-	bufReader := bufio.NewReader(reader)
+	maxLogID := mark
 
-	for {
-		record, err := p.encoder.DecodeRecord(bufReader)
-		if err == io.EOF {
-			break
-		}
+	for record, err := range NewWALReader(reader, p.encoder) {
 		if err != nil {
 			slog.Warn("Error reading WAL record during init, may be corrupted", "error", err)
 			break
@@ -144,6 +361,58 @@ func (p *Persistence) initCounter() error {
 	return nil
 }
 
+// readLogIDMark reads the persisted LogID high-water mark, or 0 if none was written
+func (p *Persistence) readLogIDMark() (uint64, error) {
+	data, err := os.ReadFile(p.filePath + LogIDMarkSuffix)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read LogID mark: %w", err)
+	}
+
+	if len(data) != 8 {
+		return 0, fmt.Errorf("malformed LogID mark: expected 8 bytes, got %d", len(data))
+	}
+
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// writeLogIDMark durably records the current LogID counter as the high-water mark.
+// The mark is written to a temporary file and renamed so a crash never leaves it torn.
+func (p *Persistence) writeLogIDMark() error {
+	markPath := p.filePath + LogIDMarkSuffix
+	tmpPath := markPath + ".tmp"
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, p.counter.Load())
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create LogID mark: %w", err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write LogID mark: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync LogID mark: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close LogID mark: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, markPath); err != nil {
+		return fmt.Errorf("failed to replace LogID mark: %w", err)
+	}
+
+	return nil
+}
+
 // Write writes a new record to WAL
 // If eager is true, Sync is called immediately after writing
 func (p *Persistence) Write(
@@ -157,41 +426,221 @@ func (p *Persistence) Write(
 	vectorIndex index.Index,
 	dim int,
 ) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	records := []WALRecordData{{
+		VectorID:   vectorID,
+		Vector:     vector,
+		Doc:        doc,
+		Attributes: attributes,
+	}}
 
-	logID := p.counter.Add(1)
+	return p.WriteBatch(records, eager, scalarStorage, filterIndex, vectorIndex, dim)
+}
 
-	record := WALRecord{
-		LogID:      logID,
-		Version:    p.version,
-		Operation:  Insert,
+// WriteDeleteBatch writes one Delete WAL record per vector ID
+// If eager is true, Sync is called once after all records are written
+func (p *Persistence) WriteDeleteBatch(
+	vectorIDs []uint64,
+	eager bool,
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	vectorIndex index.Index,
+	dim int,
+) error {
+	records := make([]WALRecordData, len(vectorIDs))
+	for i, id := range vectorIDs {
+		records[i] = WALRecordData{Operation: Delete, VectorID: id}
+	}
+
+	if err := p.appendRecords(records, false); err != nil {
+		return err
+	}
+
+	if eager {
+		return p.Sync(scalarStorage, filterIndex, vectorIndex, dim)
+	}
+
+	return nil
+}
+
+// WriteUpdate writes an Update WAL record replacing the doc and attributes of vectorID
+// If eager is true, Sync is called immediately after writing
+func (p *Persistence) WriteUpdate(
+	vectorID uint64,
+	doc map[string]any,
+	attributes map[string]any,
+	eager bool,
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	vectorIndex index.Index,
+	dim int,
+) error {
+	records := []WALRecordData{{
+		Operation:  Update,
 		VectorID:   vectorID,
-		Vector:     vector,
 		Doc:        doc,
 		Attributes: attributes,
+	}}
+
+	if err := p.appendRecords(records, false); err != nil {
+		return err
 	}
 
-	if err := p.encoder.EncodeRecord(p.bufWriter, &record); err != nil {
-		return fmt.Errorf("failed to write WAL record: %w", err)
+	if eager {
+		return p.Sync(scalarStorage, filterIndex, vectorIndex, dim)
 	}
 
-	// Store in pending logs for sync
-	p.pendingLogs = append(p.pendingLogs, record)
+	return nil
+}
+
+// WriteBatch writes one WAL record per entry in records
+// If eager is true, Sync is called once after all records are written, so the
+// whole batch is applied with a single scalar write and a single index insert
+func (p *Persistence) WriteBatch(
+	records []WALRecordData,
+	eager bool,
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	vectorIndex index.Index,
+	dim int,
+) error {
+	if err := p.appendRecords(records, false); err != nil {
+		return err
+	}
 
 	// If eager mode, sync immediately
 	if eager {
-		return p.syncLocked(scalarStorage, filterIndex, vectorIndex, dim)
+		return p.Sync(scalarStorage, filterIndex, vectorIndex, dim)
+	}
+
+	return nil
+}
+
+// WriteAtomic writes records, which may mix Insert, Update and Delete operations, as one atomic
+// batch under a contiguous LogID range preceded by a Begin marker. The records are always
+// synced together, and Restore replays them only if all of them reached the WAL.
+// If eager is true, Sync is called once after all records are written
+func (p *Persistence) WriteAtomic(
+	records []WALRecordData,
+	eager bool,
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	vectorIndex index.Index,
+	dim int,
+) error {
+	for _, data := range records {
+		if data.Operation != Insert && data.Operation != Delete && data.Operation != Update {
+			return fmt.Errorf("unsupported operation in atomic batch: %s", data.Operation)
+		}
+	}
+
+	if err := p.appendRecords(records, true); err != nil {
+		return err
+	}
+
+	if eager {
+		return p.Sync(scalarStorage, filterIndex, vectorIndex, dim)
+	}
+
+	return nil
+}
+
+// appendRecords encodes records to the WAL buffer and queues them for sync
+// If atomic is true, the records are preceded by a Begin marker counting them.
+// If encoding or flushing them fails, none of them stays queued, so a write that
+// returned an error is not applied; bytes of it that reached the file may still be
+// replayed by the next Restore.
+func (p *Persistence) appendRecords(records []WALRecordData, atomic bool) error {
+	defer p.deliver()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed.Load() {
+		return ErrPersistenceClosed
+	}
+	if p.readOnly {
+		return ErrReadOnly
 	}
 
+	// Reject IDs that cannot become FAISS labels before anything reaches the WAL
+	for _, data := range records {
+		if data.Operation == Begin {
+			continue
+		}
+		if _, err := common.LabelFromID(data.VectorID); err != nil {
+			return err
+		}
+	}
+
+	if atomic {
+		records = append([]WALRecordData{{Operation: Begin, VectorID: uint64(len(records))}}, records...)
+	}
+
+	queued := p.markQueueLocked()
+	for _, data := range records {
+		logID := p.counter.Add(1)
+
+		record := WALRecord{
+			LogID:      logID,
+			Version:    p.version,
+			Operation:  data.Operation,
+			VectorID:   data.VectorID,
+			Vector:     data.Vector,
+			Doc:        data.Doc,
+			Attributes: data.Attributes,
+		}
+
+		if err := p.encoder.EncodeRecord(p.bufWriter, &record); err != nil {
+			p.unqueueLocked(queued)
+			return fmt.Errorf("failed to write WAL record: %w", err)
+		}
+
+		// Store in pending logs for sync
+		p.pendingLogs = append(p.pendingLogs, record)
+		p.unflushed = append(p.unflushed, record)
+		if record.Operation != Begin {
+			p.sinceFlush++
+		}
+	}
+
+	if err := p.maybeFlushLocked(); err != nil {
+		p.unqueueLocked(queued)
+		return err
+	}
 	return nil
 }
 
+// queueMark records how many records are queued, for unqueueLocked
+type queueMark struct {
+	pending, unflushed, sinceFlush int
+}
+
+// markQueueLocked returns the current queue lengths (caller must hold lock)
+func (p *Persistence) markQueueLocked() queueMark {
+	return queueMark{len(p.pendingLogs), len(p.unflushed), p.sinceFlush}
+}
+
+// unqueueLocked drops the records queued since mark was taken, after writing them
+// failed (caller must hold lock)
+func (p *Persistence) unqueueLocked(mark queueMark) {
+	p.pendingLogs = p.pendingLogs[:mark.pending]
+	p.unflushed = p.unflushed[:mark.unflushed]
+	p.sinceFlush = mark.sinceFlush
+}
+
 // WriteOnly writes a record to WAL without syncing (for testing)
 func (p *Persistence) WriteOnly(vectorID uint64, vector []float32, doc map[string]any, attributes map[string]any) error {
+	defer p.deliver()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.closed.Load() {
+		return ErrPersistenceClosed
+	}
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	queued := p.markQueueLocked()
 	logID := p.counter.Add(1)
 
 	record := WALRecord{
@@ -210,15 +659,41 @@ func (p *Persistence) WriteOnly(vectorID uint64, vector []float32, doc map[strin
 
 	// Store in pending logs for sync
 	p.pendingLogs = append(p.pendingLogs, record)
+	p.unflushed = append(p.unflushed, record)
+	p.sinceFlush++
 
+	if err := p.maybeFlushLocked(); err != nil {
+		p.unqueueLocked(queued)
+		return err
+	}
 	return nil
 }
 
+// maybeFlushLocked flushes the WAL once flushEveryN records were written since the
+// last flush (caller must hold lock)
+func (p *Persistence) maybeFlushLocked() error {
+	if p.flushEveryN == 0 || p.sinceFlush < p.flushEveryN {
+		return nil
+	}
+	return p.flushLocked()
+}
+
 // Flush flushes buffered WAL data to disk
 func (p *Persistence) Flush() error {
+	defer p.deliver()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.closed.Load() {
+		return ErrPersistenceClosed
+	}
+	return p.flushLocked()
+}
+
+// flushLocked flushes buffered WAL data to disk and queues the newly durable records
+// for subscribers; the caller delivers them with deliver once it released the lock
+// (caller must hold lock)
+func (p *Persistence) flushLocked() error {
 	if err := p.bufWriter.Flush(); err != nil {
 		return fmt.Errorf("failed to flush buffer: %w", err)
 	}
@@ -227,16 +702,31 @@ func (p *Persistence) Flush() error {
 		return fmt.Errorf("failed to sync WAL file: %w", err)
 	}
 
+	if len(p.unflushed) > 0 {
+		p.outboxMu.Lock()
+		p.outbox = append(p.outbox, p.unflushed...)
+		p.outboxMu.Unlock()
+		p.unflushed = p.unflushed[:0]
+	}
+	p.sinceFlush = 0
+
 	return nil
 }
 
 // Close closes the persistence layer
 func (p *Persistence) Close() error {
+	// Subscribers receive the records flushed here before their channels are closed
+	defer p.closeSubscribers()
+	defer p.deliver()
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if p.bufWriter != nil {
-		if err := p.bufWriter.Flush(); err != nil {
+	if p.closed.Swap(true) {
+		return ErrPersistenceClosed
+	}
+
+	if p.bufWriter != nil && p.walWriter != nil {
+		if err := p.flushLocked(); err != nil {
 			return err
 		}
 	}
@@ -250,96 +740,290 @@ func (p *Persistence) Close() error {
 
 // Sync applies all pending WAL records to the database components
 // Order: Scalar storage -> Filter index -> Vector index (vector last for easier rollback)
+//
+// The phases always run in this order. If any phase fails, the scalar entries and
+// filter entries already applied for the batch are removed again, the vector index is
+// left untouched, and the batch stays pending so the next Sync retries it.
+//
+// If a max sync batch size is set, a larger backlog is applied in chunks of at most that
+// many records, which bounds the memory and FAISS call of each chunk. An atomic batch is
+// never split across chunks. Chunks applied before a failing one stay applied, and only
+// the failing chunk and those after it stay pending.
+//
+// The pending batch is swapped out under the WAL lock and applied without it, so writers
+// can keep appending while a large batch is applied. Records become visible to searches
+// only once they reach the vector index, after their docs and attributes are in place.
+//
+// Delete records go through the same phases right after the inserts of each phase.
+// The docs they remove are read before anything is written, so a failure restores
+// them together with rolling back the inserts, and a batch mixing inserts and deletes
+// is applied either completely or not at all. Update records are applied the same way,
+// between the inserts and the deletes, so a vector deleted in the same batch stays deleted.
 func (p *Persistence) Sync(
 	scalarStorage scalar.ScalarStorage,
 	filterIndex *filter.IntFilterIndex,
 	vectorIndex index.Index,
 	dim int,
 ) error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	batch, err := p.takePending()
+	if err != nil || len(batch) == 0 {
+		return err
+	}
+
+	for len(batch) > 0 {
+		n := syncChunkLen(batch, p.maxSyncBatch)
+		err = p.applyBatch(batch[:n], scalarStorage, filterIndex, vectorIndex, dim)
+		if err != nil {
+			break
+		}
+		p.stats.countApplied(batch[:n], time.Now())
+		if p.applyHook != nil {
+			p.applyHook(appliedIDs(batch[:n]))
+		}
+
+		// Drop the applied records so their vectors and docs can be freed right away
+		clear(batch[:n])
+		batch = batch[n:]
+
+		p.mu.Lock()
+		p.inflight = len(batch)
+		p.mu.Unlock()
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.syncLocked(scalarStorage, filterIndex, vectorIndex, dim)
+
+	p.inflight = 0
+	if err != nil {
+		// Put the batch back ahead of records written meanwhile so it is retried in order
+		p.pendingLogs = append(batch, p.pendingLogs...)
+		return err
+	}
+
+	return nil
 }
 
-// syncLocked applies all pending WAL records (caller must hold lock)
-func (p *Persistence) syncLocked(
+// Replay applies records read from another WAL, such as those a WALTailer reads from the
+// WAL of a primary database, the way Sync applies records written to this one, without
+// writing them to this WAL. Records that fail to apply stay pending for the next Sync,
+// like written ones.
+func (p *Persistence) Replay(
+	records []WALRecord,
 	scalarStorage scalar.ScalarStorage,
 	filterIndex *filter.IntFilterIndex,
 	vectorIndex index.Index,
 	dim int,
 ) error {
-	if len(p.pendingLogs) == 0 {
+	if len(records) == 0 {
 		return nil
 	}
 
-	slog.Info("Syncing WAL records", "count", len(p.pendingLogs))
+	p.mu.Lock()
+	if p.closed.Load() {
+		p.mu.Unlock()
+		return ErrPersistenceClosed
+	}
+	if p.readOnly {
+		p.mu.Unlock()
+		return ErrReadOnly
+	}
+	p.pendingLogs = append(p.pendingLogs, records...)
+	p.mu.Unlock()
+
+	return p.Sync(scalarStorage, filterIndex, vectorIndex, dim)
+}
+
+// appliedIDs returns the IDs of the insert and delete records of an applied chunk
+func appliedIDs(batch []WALRecord) (inserted, deleted []uint64) {
+	for _, record := range batch {
+		switch record.Operation {
+		case Insert:
+			inserted = append(inserted, record.VectorID)
+		case Delete:
+			deleted = append(deleted, record.VectorID)
+		}
+	}
+	return inserted, deleted
+}
+
+// syncChunkLen returns the number of records at the start of batch to apply as one chunk:
+// at most maxLen, unless that would split an atomic batch, which is always applied whole
+func syncChunkLen(batch []WALRecord, maxLen int) int {
+	if maxLen <= 0 || len(batch) <= maxLen {
+		return len(batch)
+	}
+
+	n := 0
+	for n < len(batch) {
+		size := 1
+		if batch[n].Operation == Begin {
+			size += int(batch[n].VectorID)
+		}
+		if n > 0 && n+size > maxLen {
+			break
+		}
+		n += size
+	}
+
+	return min(n, len(batch))
+}
+
+// takePending flushes the WAL to disk and takes ownership of the pending records
+func (p *Persistence) takePending() ([]WALRecord, error) {
+	defer p.deliver()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed.Load() {
+		return nil, ErrPersistenceClosed
+	}
+
+	if len(p.pendingLogs) == 0 {
+		return nil, nil
+	}
 
 	// First flush WAL to disk for durability
-	if err := p.bufWriter.Flush(); err != nil {
-		return fmt.Errorf("failed to flush WAL buffer: %w", err)
+	if err := p.flushLocked(); err != nil {
+		return nil, fmt.Errorf("failed to flush WAL: %w", err)
 	}
-	if err := p.walWriter.Sync(); err != nil {
-		return fmt.Errorf("failed to sync WAL to disk: %w", err)
+
+	batch := p.pendingLogs
+	p.pendingLogs = make([]WALRecord, 0, 100)
+	p.inflight = len(batch)
+
+	return batch, nil
+}
+
+// applyBatch applies a batch of WAL records, rolling back on failure (caller must hold syncMu)
+func (p *Persistence) applyBatch(
+	batch []WALRecord,
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	vectorIndex index.Index,
+	dim int,
+) error {
+	slog.Info("Syncing WAL records", "count", len(batch))
+
+	// Bump on both sides of the change, so a read that overlaps it sees a different epoch
+	p.epoch.Add(1)
+	defer p.epoch.Add(1)
+
+	batch = foldUpdates(batch)
+	batch = dropExistingInserts(batch, vectorIndex, p.replaceIDs)
+
+	// Read the docs of deleted vectors before anything is written, so they can be restored
+	deleted, err := snapshotDeletes(batch, scalarStorage)
+	if err != nil {
+		return err
+	}
+
+	// Validate updates and read the docs they replace, also before anything is written
+	fieldTypes := make(map[string]filter.FieldType)
+	updated, err := p.prepareUpdates(batch, scalarStorage, filterIndex, fieldTypes)
+	if err != nil {
+		return err
 	}
 
 	// Track successfully applied records for rollback
-	appliedScalar := make([]uint64, 0, len(p.pendingLogs))
-	appliedFilter := make([]WALRecordData, 0, len(p.pendingLogs))
+	appliedScalar := make([]uint64, 0, len(batch))
+	appliedFilter := make([]WALRecordData, 0, len(batch))
+	updatedScalar := false
+	updatedFilter := false
+	deletedScalar := false
+	deletedFilter := false
+
+	rollback := func() {
+		p.rollbackScalar(scalarStorage, appliedScalar)
+		p.rollbackFilter(filterIndex, appliedFilter)
+		p.restoreUpdated(scalarStorage, filterIndex, updated, updatedScalar, updatedFilter)
+		p.restoreDeleted(scalarStorage, filterIndex, deleted, deletedScalar, deletedFilter)
+	}
 
 	// Phase 1: Apply to scalar storage
-	for _, record := range p.pendingLogs {
+	keys := make([][]byte, 0, len(batch))
+	values := make([][]byte, 0, len(batch))
+	for _, record := range batch {
 		if record.Operation == Insert {
-			doc := make(map[string]any)
-			for k, v := range record.Doc {
-				doc[k] = v
-			}
-			doc["id"] = record.VectorID
-			doc["attributes"] = record.Attributes
+			p.skippedAttributes.Add(dropInvalidAttributes(record, filterIndex, fieldTypes, p.restoring.Load()))
 
-			docBytes, err := json.Marshal(doc)
+			docBytes, err := common.BuildStoredDoc(record.Doc, record.Attributes, record.VectorID, p.docCodec)
 			if err != nil {
-				// Rollback scalar storage
-				p.rollbackScalar(scalarStorage, appliedScalar)
 				return fmt.Errorf("failed to marshal doc for vector %d: %w", record.VectorID, err)
 			}
 
-			key := scalar.EncodeID(record.VectorID)
-			if err := scalarStorage.Put(scalar.NamespaceDocs, key, docBytes); err != nil {
-				// Rollback scalar storage
-				p.rollbackScalar(scalarStorage, appliedScalar)
-				return fmt.Errorf("failed to insert scalar data for vector %d: %w", record.VectorID, err)
+			// Reject oversized docs before anything is written, NutsDB fails opaquely on them
+			if err := checkDocSize(record.VectorID, docBytes, p.maxDocBytes); err != nil {
+				return err
+			}
+
+			if !p.skipFinite {
+				if col := commonMath.FirstNonFinite(record.Vector); col >= 0 {
+					return fmt.Errorf("%w: vector %d has %v at column %d", ErrNonFiniteVector, record.VectorID, record.Vector[col], col)
+				}
+			}
+
+			if err := filter.ValidateAttributes(record.Attributes); err != nil {
+				return fmt.Errorf("vector %d: %w", record.VectorID, err)
+			}
+			if err := filterIndex.CheckTypes(record.Attributes, fieldTypes); err != nil {
+				return fmt.Errorf("vector %d: %w", record.VectorID, err)
 			}
 
+			keys = append(keys, scalar.EncodeID(record.VectorID))
+			values = append(values, docBytes)
 			appliedScalar = append(appliedScalar, record.VectorID)
 		}
 	}
 
+	if p.txMode == common.SyncTxModePerRecord {
+		// One transaction per record; on failure only the records already written are removed
+		for i, key := range keys {
+			if err := scalarStorage.Put(scalar.NamespaceDocs, key, values[i]); err != nil {
+				p.rollbackScalar(scalarStorage, appliedScalar[:i])
+				return fmt.Errorf("failed to insert scalar data for vector %d: %w", appliedScalar[i], err)
+			}
+		}
+	} else if err := scalarStorage.MultiPut(scalar.NamespaceDocs, keys, values); err != nil {
+		// The single transaction is all-or-nothing, so there is nothing to roll back
+		return fmt.Errorf("failed to insert scalar data: %w", err)
+	}
+
+	// Updates are written before deletes, so a vector deleted in the same batch stays deleted
+	if len(updated.keys) > 0 {
+		if err := scalarStorage.MultiPut(scalar.NamespaceDocs, updated.keys, updated.docs); err != nil {
+			rollback()
+			return fmt.Errorf("failed to update scalar data: %w", err)
+		}
+		updatedScalar = true
+	}
+
+	if len(deleted.keys) > 0 {
+		if err := scalarStorage.MultiDelete(scalar.NamespaceDocs, deleted.keys); err != nil {
+			rollback()
+			return fmt.Errorf("failed to delete scalar data: %w", err)
+		}
+		deletedScalar = true
+	}
+
 	// Phase 2: Apply to filter index
-	for _, record := range p.pendingLogs {
+	// All attributes of a record are converted before any is upserted, so a record
+	// is either fully indexed (and tracked for rollback) or not indexed at all
+	for _, record := range batch {
 		if record.Operation == Insert && len(record.Attributes) > 0 {
+			intValues := make(map[string]int64, len(record.Attributes))
 			for key, value := range record.Attributes {
-				var intValue int64
-				switch v := value.(type) {
-				case int:
-					intValue = int64(v)
-				case int64:
-					intValue = v
-				case float64:
-					if v == float64(int64(v)) {
-						intValue = int64(v)
-					} else {
-						// Rollback
-						p.rollbackScalar(scalarStorage, appliedScalar)
-						p.rollbackFilter(filterIndex, appliedFilter)
-						return fmt.Errorf("unsupported attribute type for key %s: %v", key, value)
-					}
-				default:
-					// Rollback
-					p.rollbackScalar(scalarStorage, appliedScalar)
-					p.rollbackFilter(filterIndex, appliedFilter)
-					return fmt.Errorf("unsupported attribute type for key %s: %T", key, value)
+				intValue, _, err := filter.AttributeValue(value)
+				if err != nil {
+					rollback()
+					return fmt.Errorf("vector %d: attribute %s: %w", record.VectorID, key, err)
 				}
+				intValues[key] = intValue
+			}
 
+			for key, intValue := range intValues {
 				filterIndex.Upsert(key, intValue, record.VectorID)
 			}
 
@@ -350,62 +1034,373 @@ func (p *Persistence) syncLocked(
 		}
 	}
 
+	for i, id := range updated.ids {
+		reindexAttributes(filterIndex, id, updated.oldValues[i], updated.values[i])
+	}
+	updatedFilter = true
+
+	for _, id := range deleted.ids {
+		filterIndex.RemoveID(id)
+	}
+	deletedFilter = true
+
 	// Phase 3: Apply to vector index (last operation)
 	// Prepare batch data for vector insertion
-	vectorIDs := make([]uint64, 0, len(p.pendingLogs))
-	vectors := make([][]float32, 0, len(p.pendingLogs))
+	vectorIDs := make([]uint64, 0, len(batch))
+	vectors := make([][]float32, 0, len(batch))
 
-	for _, record := range p.pendingLogs {
+	for _, record := range batch {
 		if record.Operation == Insert {
 			vectorIDs = append(vectorIDs, record.VectorID)
 			vectors = append(vectors, record.Vector)
 		}
 	}
 
+	labels := make([]int64, len(vectorIDs))
 	if len(vectors) > 0 {
-		// Create matrix from vectors
-		mat := commonMath.NewMatrix32Empty(len(vectors), dim)
-		for i, vec := range vectors {
-			for j, val := range vec {
-				mat.Set(i, j, val)
-			}
-		}
+		// The index copies the vectors on insert, so the matrix goes back to the pool after
+		mat := vectorMatrix(vectors, dim)
+		defer releaseMatrix(mat)
 
 		// Convert uint64 IDs to int64 labels for FAISS
-		labels := make([]int64, len(vectorIDs))
 		for i, id := range vectorIDs {
-			labels[i] = int64(id)
+			label, err := common.LabelFromID(id)
+			if err != nil {
+				rollback()
+				return fmt.Errorf("failed to convert vector ID to label: %w", err)
+			}
+			labels[i] = label
 		}
 
-		insertParams := &index.InsertParams{
-			Data:   mat,
-			Labels: labels,
+		insertParams := index.NewInsertParams(mat, labels)
+		if p.hnswInsert != nil {
+			insertParams.With(p.hnswInsert)
+		}
+		if p.replaceIDs {
+			insertParams.WithReplace()
 		}
 
 		if err := vectorIndex.Insert(insertParams); err != nil {
 			// Rollback all changes
-			p.rollbackScalar(scalarStorage, appliedScalar)
-			p.rollbackFilter(filterIndex, appliedFilter)
+			rollback()
 			// Note: Vector index cannot be easily rolled back, but since it's last,
 			// we haven't inserted anything yet
 			return fmt.Errorf("failed to insert vectors: %w", err)
 		}
 	}
 
-	// Clear pending logs after successful sync
-	p.pendingLogs = make([]WALRecord, 0, 100)
+	if len(deleted.labels) > 0 {
+		if _, err := vectorIndex.Remove(deleted.labels); err != nil {
+			// Take back the vectors inserted above so the index matches the rolled back storage
+			if len(labels) > 0 {
+				if _, rmErr := vectorIndex.Remove(labels); rmErr != nil {
+					slog.Error("Failed to roll back vector index changes", "error", rmErr)
+				}
+			}
+			rollback()
+			return fmt.Errorf("failed to remove vectors: %w", err)
+		}
+	}
+
+	// Fix the types of newly indexed fields only once nothing can be rolled back
+	filterIndex.RegisterTypes(fieldTypes)
 
 	slog.Info("Successfully synced WAL records")
 	return nil
 }
 
+// deleteSnapshot holds the docs removed by the Delete records of a batch
+type deleteSnapshot struct {
+	ids    []uint64
+	labels []int64
+	keys   [][]byte
+	// docs holds the stored doc of each ID, nil if it was not stored
+	docs [][]byte
+}
+
+// snapshotDeletes reads the stored docs of the Delete records in batch
+func snapshotDeletes(batch []WALRecord, scalarStorage scalar.ScalarStorage) (*deleteSnapshot, error) {
+	snapshot := &deleteSnapshot{}
+	for _, record := range batch {
+		if record.Operation != Delete {
+			continue
+		}
+
+		label, err := common.LabelFromID(record.VectorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert vector ID to label: %w", err)
+		}
+
+		key := scalar.EncodeID(record.VectorID)
+		doc, err := scalarStorage.Get(scalar.NamespaceDocs, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scalar data for vector %d: %w", record.VectorID, err)
+		}
+
+		snapshot.ids = append(snapshot.ids, record.VectorID)
+		snapshot.labels = append(snapshot.labels, label)
+		snapshot.keys = append(snapshot.keys, key)
+		snapshot.docs = append(snapshot.docs, doc)
+	}
+	return snapshot, nil
+}
+
+// foldUpdates returns batch with only the last Update record of each vector ID, merged
+// into the Insert record of the ID if the batch inserts it, as when Restore replays a
+// vector inserted and then updated, so that the update does not depend on the insert
+// having reached scalar storage. batch itself is left unchanged.
+func foldUpdates(batch []WALRecord) []WALRecord {
+	last := make(map[uint64]int)
+	for i, record := range batch {
+		if record.Operation == Update {
+			last[record.VectorID] = i
+		}
+	}
+	if len(last) == 0 {
+		return batch
+	}
+
+	folded := make([]WALRecord, 0, len(batch))
+	inserted := make(map[uint64]int)
+	for i, record := range batch {
+		switch record.Operation {
+		case Insert:
+			inserted[record.VectorID] = len(folded)
+		case Update:
+			if last[record.VectorID] != i {
+				continue
+			}
+			if j, ok := inserted[record.VectorID]; ok {
+				folded[j].Doc = record.Doc
+				folded[j].Attributes = record.Attributes
+				continue
+			}
+		}
+		folded = append(folded, record)
+	}
+	return folded
+}
+
+// dropExistingInserts returns batch without the Insert records that the vector index
+// would reject with index.ErrLabelExists: those of IDs it already holds, unless replace
+// is set, and all but one insert of an ID the batch inserts several times, the first or,
+// when replacing, the last. Such a record would fail every sync, so retrying it would
+// hold up every record logged after it. batch itself is left unchanged.
+func dropExistingInserts(batch []WALRecord, vectorIndex index.Index, replace bool) []WALRecord {
+	kept := make(map[uint64]int)
+	for i, record := range batch {
+		if record.Operation != Insert {
+			continue
+		}
+		if _, seen := kept[record.VectorID]; seen && !replace {
+			continue
+		}
+		if label, err := common.LabelFromID(record.VectorID); err == nil && !replace && vectorIndex.Contains(label) {
+			continue
+		}
+		kept[record.VectorID] = i
+	}
+
+	filtered := make([]WALRecord, 0, len(batch))
+	for i, record := range batch {
+		if j, ok := kept[record.VectorID]; record.Operation == Insert && (!ok || j != i) {
+			slog.Warn("Dropping insert of a vector ID already in the index", "vector_id", record.VectorID)
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	return filtered
+}
+
+// updateSnapshot holds the docs written by the Update records of a batch and the
+// docs they replace
+type updateSnapshot struct {
+	ids  []uint64
+	keys [][]byte
+	// docs holds the doc written for each ID and oldDocs the doc it replaces
+	docs    [][]byte
+	oldDocs [][]byte
+	// values and oldValues hold the indexed attribute values of docs and oldDocs
+	values    []map[string]int64
+	oldValues []map[string]int64
+}
+
+// prepareUpdates validates the Update records of batch and reads the docs they replace.
+// Records for vectors without a stored doc, which were deleted since they were
+// written, are skipped.
+func (p *Persistence) prepareUpdates(
+	batch []WALRecord,
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	fieldTypes map[string]filter.FieldType,
+) (*updateSnapshot, error) {
+	snapshot := &updateSnapshot{}
+	for _, record := range batch {
+		if record.Operation != Update {
+			continue
+		}
+
+		key := scalar.EncodeID(record.VectorID)
+		oldDoc, err := scalarStorage.Get(scalar.NamespaceDocs, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scalar data for vector %d: %w", record.VectorID, err)
+		}
+		if oldDoc == nil {
+			slog.Warn("Skipping update of missing vector", "vector_id", record.VectorID)
+			continue
+		}
+
+		p.skippedAttributes.Add(dropInvalidAttributes(record, filterIndex, fieldTypes, p.restoring.Load()))
+
+		doc, err := common.BuildStoredDoc(record.Doc, record.Attributes, record.VectorID, p.docCodec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal doc for vector %d: %w", record.VectorID, err)
+		}
+		if err := checkDocSize(record.VectorID, doc, p.maxDocBytes); err != nil {
+			return nil, err
+		}
+		if err := filter.ValidateAttributes(record.Attributes); err != nil {
+			return nil, fmt.Errorf("vector %d: %w", record.VectorID, err)
+		}
+		if err := filterIndex.CheckTypes(record.Attributes, fieldTypes); err != nil {
+			return nil, fmt.Errorf("vector %d: %w", record.VectorID, err)
+		}
+
+		values, err := attributeValues(record.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("vector %d: %w", record.VectorID, err)
+		}
+
+		parsed, err := common.DecodeDoc(oldDoc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse doc of vector %d: %w", record.VectorID, err)
+		}
+		oldAttributes, _ := parsed[common.DocFieldAttributes].(map[string]any)
+		// Values that cannot be indexed were never indexed, so there is nothing to remove
+		oldValues := make(map[string]int64, len(oldAttributes))
+		for key, value := range oldAttributes {
+			if intValue, _, err := filter.AttributeValue(value); err == nil {
+				oldValues[key] = intValue
+			}
+		}
+
+		snapshot.ids = append(snapshot.ids, record.VectorID)
+		snapshot.keys = append(snapshot.keys, key)
+		snapshot.docs = append(snapshot.docs, doc)
+		snapshot.oldDocs = append(snapshot.oldDocs, oldDoc)
+		snapshot.values = append(snapshot.values, values)
+		snapshot.oldValues = append(snapshot.oldValues, oldValues)
+	}
+	return snapshot, nil
+}
+
+// attributeValues converts attributes to the values the filter index holds
+func attributeValues(attributes map[string]any) (map[string]int64, error) {
+	values := make(map[string]int64, len(attributes))
+	for key, value := range attributes {
+		intValue, _, err := filter.AttributeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", key, err)
+		}
+		values[key] = intValue
+	}
+	return values, nil
+}
+
+// reindexAttributes moves id from the filter index buckets of from to those of to
+func reindexAttributes(filterIndex *filter.IntFilterIndex, id uint64, from, to map[string]int64) {
+	for key, value := range from {
+		filterIndex.Remove(key, value, id)
+	}
+	for key, value := range to {
+		filterIndex.Upsert(key, value, id)
+	}
+}
+
+// restoreUpdated puts back the docs and filter entries replaced by the Update records of a batch
+func (p *Persistence) restoreUpdated(
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	snapshot *updateSnapshot,
+	scalarDone bool,
+	filterDone bool,
+) {
+	if len(snapshot.ids) == 0 || (!scalarDone && !filterDone) {
+		return
+	}
+
+	slog.Warn("Restoring updated records", "count", len(snapshot.ids))
+
+	if filterDone {
+		for i, id := range snapshot.ids {
+			reindexAttributes(filterIndex, id, snapshot.values[i], snapshot.oldValues[i])
+		}
+	}
+
+	if scalarDone {
+		if err := scalarStorage.MultiPut(scalar.NamespaceDocs, snapshot.keys, snapshot.oldDocs); err != nil {
+			slog.Error("Failed to restore updated scalar data", "error", err)
+		}
+	}
+}
+
+// restoreDeleted puts back the docs and filter entries removed by the Delete records of a batch
+func (p *Persistence) restoreDeleted(
+	scalarStorage scalar.ScalarStorage,
+	filterIndex *filter.IntFilterIndex,
+	snapshot *deleteSnapshot,
+	scalarDone bool,
+	filterDone bool,
+) {
+	if len(snapshot.ids) == 0 || (!scalarDone && !filterDone) {
+		return
+	}
+
+	slog.Warn("Restoring deleted records", "count", len(snapshot.ids))
+
+	keys := make([][]byte, 0, len(snapshot.keys))
+	values := make([][]byte, 0, len(snapshot.keys))
+	for i, doc := range snapshot.docs {
+		if doc == nil {
+			continue
+		}
+
+		keys = append(keys, snapshot.keys[i])
+		values = append(values, doc)
+
+		if !filterDone {
+			continue
+		}
+
+		parsed, err := common.DecodeDoc(doc)
+		if err != nil {
+			slog.Error("Failed to parse deleted doc", "id", snapshot.ids[i], "error", err)
+			continue
+		}
+		attributes, _ := parsed[common.DocFieldAttributes].(map[string]any)
+		for key, value := range attributes {
+			if intValue, _, err := filter.AttributeValue(value); err == nil {
+				filterIndex.Upsert(key, intValue, snapshot.ids[i])
+			}
+		}
+	}
+
+	if scalarDone && len(keys) > 0 {
+		if err := scalarStorage.MultiPut(scalar.NamespaceDocs, keys, values); err != nil {
+			slog.Error("Failed to restore deleted scalar data", "error", err)
+		}
+	}
+}
+
 // rollbackScalar removes scalar storage entries
 func (p *Persistence) rollbackScalar(scalarStorage scalar.ScalarStorage, ids []uint64) {
 	slog.Warn("Rolling back scalar storage changes", "count", len(ids))
-	for _, id := range ids {
-		key := scalar.EncodeID(id)
-		// Best effort deletion, ignore errors
-		_ = scalarStorage.Put(scalar.NamespaceDocs, key, nil)
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = scalar.EncodeID(id)
+	}
+	if err := scalarStorage.MultiDelete(scalar.NamespaceDocs, keys); err != nil {
+		slog.Error("Failed to roll back scalar storage changes", "error", err)
 	}
 }
 
@@ -414,8 +1409,8 @@ func (p *Persistence) rollbackFilter(filterIndex *filter.IntFilterIndex, records
 	slog.Warn("Rolling back filter index changes", "count", len(records))
 	for _, record := range records {
 		for key, value := range record.Attributes {
-			intValue, ok := common.ToInt64(value)
-			if !ok {
+			intValue, _, err := filter.AttributeValue(value)
+			if err != nil {
 				continue
 			}
 
@@ -434,6 +1429,10 @@ func (p *Persistence) Restore(
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.closed.Load() {
+		return ErrPersistenceClosed
+	}
+
 	slog.Info("Restoring from WAL", "file", p.filePath)
 
 	// Check if WAL file exists and has content
@@ -454,17 +1453,21 @@ func (p *Persistence) Restore(
 	}
 	defer reader.Close()
 
-	bufReader := bufio.NewReader(reader)
+	// Track the offset where the last decoded record ends, to measure a corrupt tail
+	counter := &readCounter{r: reader}
+	bufReader := bufio.NewReader(counter)
+	decodedBytes := int64(0)
+
 	records := make([]WALRecord, 0)
 	recordCount := 0
 	corruptedCount := 0
 
+	// Position and remaining record count of the last atomic batch that is not yet complete
+	batchStart := 0
+	batchRemaining := uint64(0)
+
 	// Read all records with checksum verification
-	for {
-		record, err := p.encoder.DecodeRecord(bufReader)
-		if err == io.EOF {
-			break
-		}
+	for record, err := range NewWALReader(bufReader, p.encoder) {
 		if err != nil {
 			slog.Warn("Skipping corrupted WAL record", "error", err, "position", recordCount)
 			corruptedCount++
@@ -474,10 +1477,31 @@ func (p *Persistence) Restore(
 
 		records = append(records, *record)
 		recordCount++
+		decodedBytes = counter.n - int64(bufReader.Buffered())
+
+		if record.Operation == Begin {
+			batchStart = len(records) - 1
+			batchRemaining = record.VectorID
+			continue
+		}
+		if batchRemaining > 0 {
+			batchRemaining--
+		}
+		p.restoredMaxID = max(p.restoredMaxID, record.VectorID)
+	}
+
+	// A crash in the middle of writing an atomic batch must not apply part of it
+	if batchRemaining > 0 {
+		slog.Warn("Dropping incomplete atomic batch from WAL", "records", len(records)-batchStart-1)
+		records = records[:batchStart]
 	}
 
 	slog.Info("Read WAL records", "total", recordCount, "corrupted", corruptedCount)
 
+	if corruptBytes := stat.Size() - decodedBytes; corruptedCount > 0 && p.maxCorrupt >= 0 && corruptBytes > p.maxCorrupt {
+		return fmt.Errorf("%w: %d bytes after record %d cannot be decoded, limit is %d", ErrWALCorrupt, corruptBytes, recordCount, p.maxCorrupt)
+	}
+
 	if len(records) == 0 {
 		return nil
 	}
@@ -485,11 +1509,18 @@ func (p *Persistence) Restore(
 	// Store records as pending logs
 	p.pendingLogs = records
 
+	// A read-only WAL may only rebuild the in-memory indexes
+	if p.readOnly {
+		scalarStorage = indexOnlyStorage{scalarStorage}
+	}
+
 	// Unlock before calling Sync (which will re-acquire the lock)
 	p.mu.Unlock()
 
-	// Apply all records using Sync (not syncLocked, as we don't hold the lock)
+	// Apply all records using Sync, which takes the lock itself
+	p.restoring.Store(true)
 	err = p.Sync(scalarStorage, filterIndex, vectorIndex, dim)
+	p.restoring.Store(false)
 
 	// Re-lock before returning
 	p.mu.Lock()
@@ -498,7 +1529,11 @@ func (p *Persistence) Restore(
 		return fmt.Errorf("failed to apply WAL records during restore: %w", err)
 	}
 
-	slog.Info("Successfully restored from WAL", "records", recordCount)
+	slog.Info("Successfully restored from WAL", "records", recordCount, "skipped_attributes", p.skippedAttributes.Load())
+
+	if p.readOnly {
+		return nil
+	}
 
 	// Truncate WAL file after successful restore
 	if err := p.truncateWAL(); err != nil {
@@ -508,8 +1543,49 @@ func (p *Persistence) Restore(
 	return nil
 }
 
+// dropInvalidAttributes removes the attributes of an insert or update record whose type
+// conflicts with the field's type in strict mode and, if unsupported is set, those whose
+// value is unsupported, and returns how many were removed. The record itself is kept.
+// Writes reserve their types before logging records, so outside Restore a conflict only
+// arises for records logged without a reservation, and must not fail Sync for good.
+func dropInvalidAttributes(record WALRecord, filterIndex *filter.IntFilterIndex, fieldTypes map[string]filter.FieldType, unsupported bool) uint64 {
+	dropped := uint64(0)
+	for key, value := range record.Attributes {
+		attribute := map[string]any{key: value}
+		err := filter.ValidateAttributes(attribute)
+		if err != nil && !unsupported {
+			continue
+		}
+		if err == nil {
+			err = filterIndex.CheckTypes(attribute, fieldTypes)
+		}
+		if err != nil {
+			slog.Warn("Skipping attribute of WAL record", "vector_id", record.VectorID, "error", err)
+			delete(record.Attributes, key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// indexOnlyStorage discards writes to scalar storage, so that replaying a read-only WAL
+// only rebuilds the in-memory indexes; reads go to the wrapped storage
+type indexOnlyStorage struct {
+	scalar.ScalarStorage
+}
+
+func (indexOnlyStorage) Put(string, []byte, []byte) error          { return nil }
+func (indexOnlyStorage) MultiPut(string, [][]byte, [][]byte) error { return nil }
+func (indexOnlyStorage) Delete(string, []byte) error               { return nil }
+func (indexOnlyStorage) MultiDelete(string, [][]byte) error        { return nil }
+
 // truncateWAL truncates the WAL file after successful restore/sync
+// The LogID high-water mark is persisted first, so LogIDs keep increasing after truncation
 func (p *Persistence) truncateWAL() error {
+	if err := p.writeLogIDMark(); err != nil {
+		return err
+	}
+
 	// Close current writer
 	if err := p.bufWriter.Flush(); err != nil {
 		return err
@@ -530,14 +1606,40 @@ func (p *Persistence) truncateWAL() error {
 	}
 
 	p.walWriter = file
-	p.bufWriter = bufio.NewWriter(file)
+	p.bufWriter = bufio.NewWriter(walByteCounter{w: file, count: &p.stats.walBytes})
 
 	return nil
 }
 
-// GetPendingCount returns the number of pending WAL records
+// RestoredMaxVectorID returns the highest vector ID read from the WAL by Restore, or 0 if none.
+// IDs up to it were handed out before the restart, so they must never be generated again.
+func (p *Persistence) RestoredMaxVectorID() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restoredMaxID
+}
+
+// SkippedAttributes returns the number of attributes dropped from applied records, by
+// Restore or a later Sync, because they could not be indexed
+func (p *Persistence) SkippedAttributes() uint64 {
+	return p.skippedAttributes.Load()
+}
+
+// Epoch returns a counter that changes whenever WAL records are applied to the database
+// components. A result computed between two reads of the same epoch reflects a state
+// no record was applied in.
+func (p *Persistence) Epoch() uint64 {
+	return p.epoch.Load()
+}
+
+// Stats returns the operation and WAL byte counters
+func (p *Persistence) Stats() OpStats {
+	return p.stats.snapshot(time.Now())
+}
+
+// GetPendingCount returns the number of pending WAL records, including any being applied
 func (p *Persistence) GetPendingCount() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return len(p.pendingLogs)
+	return len(p.pendingLogs) + p.inflight
 }
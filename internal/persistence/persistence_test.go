@@ -1,10 +1,19 @@
 package persistence
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/RoaringBitmap/roaring"
+
+	"vecdb-go/internal/common"
+	commonMath "vecdb-go/internal/common/math"
 	"vecdb-go/internal/filter"
 	"vecdb-go/internal/index"
 	"vecdb-go/internal/scalar"
@@ -336,6 +345,97 @@ func TestPersistenceChecksumValidation(t *testing.T) {
 	}
 }
 
+func TestPersistenceMaxCorruptBytes(t *testing.T) {
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+
+	// Write two records and note where the first one ends
+	var firstSize int64
+	{
+		p, err := NewPersistence(walPath)
+		if err != nil {
+			t.Fatalf("Failed to create persistence: %v", err)
+		}
+		for id := uint64(1); id <= 2; id++ {
+			if err := p.WriteOnly(id, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "hello"}, nil); err != nil {
+				t.Fatalf("Failed to write record: %v", err)
+			}
+			if err := p.Flush(); err != nil {
+				t.Fatalf("Failed to flush: %v", err)
+			}
+			if id == 1 {
+				info, err := os.Stat(walPath)
+				if err != nil {
+					t.Fatalf("Failed to stat WAL file: %v", err)
+				}
+				firstSize = info.Size()
+			}
+		}
+		p.Close()
+	}
+
+	// Corrupt the second record, so everything after the first one cannot be decoded
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("Failed to read WAL file: %v", err)
+	}
+	data[firstSize+20] ^= 0xFF
+	if err := os.WriteFile(walPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write corrupted WAL file: %v", err)
+	}
+	corruptBytes := int64(len(data)) - firstSize
+
+	restore := func(limit int64) (scalar.ScalarStorage, error) {
+		p, err := NewPersistence(walPath)
+		if err != nil {
+			t.Fatalf("Failed to create persistence: %v", err)
+		}
+		defer p.Close()
+		p.SetMaxCorruptBytes(limit)
+
+		scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+			DIR:     filepath.Join(tmpDir, "scalar.db"),
+			Buckets: []string{scalar.NamespaceDocs},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create scalar storage: %v", err)
+		}
+
+		vectorIndex, err := index.NewFlatIndex(3, index.L2)
+		if err != nil {
+			t.Fatalf("Failed to create vector index: %v", err)
+		}
+
+		return scalarStorage, p.Restore(scalarStorage, filter.NewIntFilterIndex(), vectorIndex, 3)
+	}
+
+	// Over the limit, nothing is applied and the WAL is kept for salvage
+	scalarStorage, err := restore(corruptBytes - 1)
+	if !errors.Is(err, ErrWALCorrupt) {
+		t.Fatalf("Expected ErrWALCorrupt, got %v", err)
+	}
+	if doc, _ := scalarStorage.GetValue(scalar.NamespaceDocs, 1); doc != nil {
+		t.Errorf("Expected no data after a failed restore, got %v", doc)
+	}
+	if info, err := os.Stat(walPath); err != nil || info.Size() != int64(len(data)) {
+		t.Fatalf("Expected the WAL to be left intact, got %v, %v", info, err)
+	}
+	scalarStorage.Close()
+
+	// Within the limit, the records before the corruption are restored
+	scalarStorage, err = restore(corruptBytes)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if doc, _ := scalarStorage.GetValue(scalar.NamespaceDocs, 1); doc == nil {
+		t.Errorf("Expected the first record to be restored")
+	}
+	if doc, _ := scalarStorage.GetValue(scalar.NamespaceDocs, 2); doc != nil {
+		t.Errorf("Expected the corrupt record to be dropped, got %v", doc)
+	}
+	scalarStorage.Close()
+}
+
 func TestPersistenceRollback(t *testing.T) {
 	// Create temporary directory for test
 	tmpDir := t.TempDir()
@@ -396,32 +496,208 @@ func TestPersistenceRollback(t *testing.T) {
 
 	t.Logf("Sync failed as expected: %v", err)
 
-	// The rollback was called (we can see from logs), which is the important part
-	// Actual deletion in NutsDB is done via Put(key, nil) which is a best-effort approach
-	// The important thing is that the error was caught and rollback was attempted
-	t.Logf("Rollback mechanism was triggered successfully")
+	// The valid record's scalar and filter entries must have been rolled back
+	assertRolledBack(t, scalarStorage, filterIndex, 1, "category", 1)
 }
 
-func TestPersistenceEmptyWAL(t *testing.T) {
-	// Create temporary directory for test
+// failingIndex wraps an index and fails every Insert
+type failingIndex struct {
+	index.Index
+}
+
+func (f *failingIndex) Insert(params *index.InsertParams) error {
+	return errors.New("insert failed")
+}
+
+func TestPersistenceRollbackOnVectorInsertFailure(t *testing.T) {
 	tmpDir := t.TempDir()
-	walPath := filepath.Join(tmpDir, "test.wal")
 
-	// Create empty WAL file
-	f, err := os.Create(walPath)
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
 	if err != nil {
-		t.Fatalf("Failed to create WAL file: %v", err)
+		t.Fatalf("Failed to create persistence: %v", err)
 	}
-	f.Close()
+	defer p.Close()
 
-	// Create persistence layer
-	p, err := NewPersistence(walPath)
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	err = p.WriteOnly(1, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "hello"}, map[string]any{"category": int64(1)})
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	err = p.WriteOnly(2, []float32{4.0, 5.0, 6.0}, map[string]any{"text": "world"}, map[string]any{"category": float64(2)})
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	// Scalar and filter phases succeed, then the vector insert fails
+	err = p.Sync(scalarStorage, filterIndex, &failingIndex{Index: flatIndex}, 3)
+	if err == nil {
+		t.Fatal("Expected sync to fail due to vector insert failure")
+	}
+
+	assertRolledBack(t, scalarStorage, filterIndex, 1, "category", 1)
+	assertRolledBack(t, scalarStorage, filterIndex, 2, "category", 2)
+
+	// The batch stays pending and applies cleanly once the index accepts it
+	if p.GetPendingCount() != 2 {
+		t.Errorf("Expected 2 pending records after failed sync, got %d", p.GetPendingCount())
+	}
+
+	err = p.Sync(scalarStorage, filterIndex, flatIndex, 3)
+	if err != nil {
+		t.Fatalf("Failed to retry sync: %v", err)
+	}
+
+	doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, 2)
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if doc["text"] != "world" {
+		t.Errorf("Expected text=world after retry, got %v", doc["text"])
+	}
+}
+
+// assertRolledBack checks that no scalar doc or filter entry remains for id
+func assertRolledBack(t *testing.T, scalarStorage scalar.ScalarStorage, filterIndex *filter.IntFilterIndex,
+	id uint64, field string, value int64) {
+	t.Helper()
+
+	raw, err := scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(id))
+	if err != nil {
+		t.Fatalf("Failed to get doc %d: %v", id, err)
+	}
+	if raw != nil {
+		t.Errorf("Expected doc %d to be removed by rollback, got %s", id, raw)
+	}
+
+	result := filterIndex.Apply(&filter.IntFilterInput{
+		Field:  field,
+		Op:     filter.Equal,
+		Target: value,
+	}, filter.NewIdFilter().GetBitmap())
+	if result.Contains(uint32(id)) {
+		t.Errorf("Expected filter entry %s=%d for id %d to be removed by rollback", field, value, id)
+	}
+}
+
+// faultyScalar wraps scalar storage and fails the write of the record at failAt
+type faultyScalar struct {
+	scalar.ScalarStorage
+	failAt int
+	puts   int
+}
+
+func (f *faultyScalar) Put(namespace string, key []byte, value []byte) error {
+	f.puts++
+	if f.puts-1 == f.failAt {
+		return errors.New("put failed")
+	}
+	return f.ScalarStorage.Put(namespace, key, value)
+}
+
+func (f *faultyScalar) MultiPut(namespace string, keys [][]byte, values [][]byte) error {
+	// An empty key is rejected inside the transaction, after earlier keys were staged
+	faulty := append([][]byte(nil), keys...)
+	if f.failAt < len(faulty) {
+		faulty[f.failAt] = []byte{}
+	}
+	return f.ScalarStorage.MultiPut(namespace, faulty, values)
+}
+
+func TestPersistenceSyncTxModeRollback(t *testing.T) {
+	for _, mode := range []common.SyncTxMode{common.SyncTxModeBatch, common.SyncTxModePerRecord} {
+		t.Run(string(mode), func(t *testing.T) {
+			tmpDir := t.TempDir()
+
+			p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+			if err != nil {
+				t.Fatalf("Failed to create persistence: %v", err)
+			}
+			defer p.Close()
+
+			if err := p.SetSyncTxMode(mode); err != nil {
+				t.Fatalf("Failed to set sync transaction mode: %v", err)
+			}
+
+			scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+				DIR:     filepath.Join(tmpDir, "scalar.db"),
+				Buckets: []string{scalar.NamespaceDocs},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create scalar storage: %v", err)
+			}
+			defer scalarStorage.Close()
+
+			filterIndex := filter.NewIntFilterIndex()
+
+			flatIndex, err := index.NewFlatIndex(3, index.L2)
+			if err != nil {
+				t.Fatalf("Failed to create vector index: %v", err)
+			}
+
+			for id := uint64(1); id <= 4; id++ {
+				err = p.WriteOnly(id, []float32{float32(id), 0, 0}, map[string]any{"n": id}, map[string]any{"category": int64(id)})
+				if err != nil {
+					t.Fatalf("Failed to write record: %v", err)
+				}
+			}
+
+			// The third record's scalar write fails after the first two were written
+			err = p.Sync(&faultyScalar{ScalarStorage: scalarStorage, failAt: 2}, filterIndex, flatIndex, 3)
+			if err == nil {
+				t.Fatal("Expected sync to fail due to scalar write failure")
+			}
+
+			for id := uint64(1); id <= 4; id++ {
+				assertRolledBack(t, scalarStorage, filterIndex, id, "category", int64(id))
+			}
+
+			if p.GetPendingCount() != 4 {
+				t.Errorf("Expected 4 pending records after failed sync, got %d", p.GetPendingCount())
+			}
+
+			// Retrying against healthy storage applies the whole batch
+			if err := p.Sync(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+				t.Fatalf("Failed to retry sync: %v", err)
+			}
+
+			docs, err := scalarStorage.MultiGetValue(scalar.NamespaceDocs, []uint64{1, 2, 3, 4})
+			if err != nil {
+				t.Fatalf("Failed to get docs: %v", err)
+			}
+			for i, doc := range docs {
+				if len(doc) == 0 {
+					t.Errorf("Expected doc %d after retry", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistenceSyncRejectsOversizedDoc(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
 	if err != nil {
 		t.Fatalf("Failed to create persistence: %v", err)
 	}
 	defer p.Close()
+	p.SetMaxDocBytes(256)
 
-	// Create minimal dependencies
 	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
 		DIR:     filepath.Join(tmpDir, "scalar.db"),
 		Buckets: []string{scalar.NamespaceDocs},
@@ -433,14 +709,1602 @@ func TestPersistenceEmptyWAL(t *testing.T) {
 
 	filterIndex := filter.NewIntFilterIndex()
 
-	vectorIndex, err := index.NewFlatIndex(3, index.L2)
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
 	if err != nil {
 		t.Fatalf("Failed to create vector index: %v", err)
 	}
 
-	// Restore from empty WAL should succeed without error
-	err = p.Restore(scalarStorage, filterIndex, vectorIndex, 3)
+	err = p.WriteOnly(1, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "small"}, nil)
 	if err != nil {
-		t.Fatalf("Failed to restore from empty WAL: %v", err)
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	err = p.WriteOnly(2, []float32{4.0, 5.0, 6.0}, map[string]any{"text": strings.Repeat("x", 512)}, nil)
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	err = p.Sync(scalarStorage, filterIndex, flatIndex, 3)
+	if !errors.Is(err, ErrDocTooLarge) {
+		t.Fatalf("Expected ErrDocTooLarge, got %v", err)
+	}
+
+	// Nothing of the batch reaches scalar storage
+	raw, err := scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(1))
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if raw != nil {
+		t.Errorf("Expected doc 1 not to be written, got %s", raw)
+	}
+
+	if p.GetPendingCount() != 2 {
+		t.Errorf("Expected 2 pending records after failed sync, got %d", p.GetPendingCount())
+	}
+}
+
+func TestPersistenceSyncRejectsNonFiniteVector(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	// Records in the WAL bypass the upsert validation, e.g. when written by an older version
+	err = p.WriteOnly(1, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "finite"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	err = p.WriteOnly(2, []float32{4.0, float32(math.Inf(1)), 6.0}, map[string]any{"text": "infinite"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	err = p.Sync(scalarStorage, filterIndex, flatIndex, 3)
+	if !errors.Is(err, ErrNonFiniteVector) {
+		t.Fatalf("Expected ErrNonFiniteVector, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "vector 2 has +Inf at column 1") {
+		t.Errorf("Expected the error to name the vector and column, got %v", err)
+	}
+	if labels := indexedLabels(t, flatIndex); len(labels) != 0 {
+		t.Errorf("Expected no vectors to be inserted, got %v", labels)
+	}
+
+	// Skipping the check lets the batch through
+	p.SetSkipFiniteCheck(true)
+	if err := p.Sync(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+		t.Fatalf("Expected sync to succeed with the check skipped, got %v", err)
+	}
+}
+
+func TestPersistenceSetSyncTxModeInvalid(t *testing.T) {
+	p, err := NewPersistence(filepath.Join(t.TempDir(), "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	if err := p.SetSyncTxMode("nested"); err == nil {
+		t.Error("Expected error for unsupported sync transaction mode")
+	}
+	if err := p.SetSyncTxMode(""); err != nil {
+		t.Errorf("Expected empty mode to select the default, got %v", err)
+	}
+}
+
+func TestPersistenceUseAfterClose(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Failed to close persistence: %v", err)
+	}
+
+	vector := []float32{1.0, 2.0, 3.0}
+	checks := map[string]error{
+		"Write":      p.Write(1, vector, nil, nil, false, scalarStorage, filterIndex, flatIndex, 3),
+		"WriteBatch": p.WriteBatch([]WALRecordData{{VectorID: 1, Vector: vector}}, true, scalarStorage, filterIndex, flatIndex, 3),
+		"WriteOnly":  p.WriteOnly(1, vector, nil, nil),
+		"Flush":      p.Flush(),
+		"Sync":       p.Sync(scalarStorage, filterIndex, flatIndex, 3),
+		"Restore":    p.Restore(scalarStorage, filterIndex, flatIndex, 3),
+		"Close":      p.Close(),
+	}
+	for name, err := range checks {
+		if !errors.Is(err, ErrPersistenceClosed) {
+			t.Errorf("%s after Close: expected ErrPersistenceClosed, got %v", name, err)
+		}
+	}
+
+	ch, unsubscribe := p.Subscribe(1, common.CDCPolicyDrop)
+	defer unsubscribe()
+	if _, ok := <-ch; ok {
+		t.Error("Expected Subscribe after Close to return a closed channel")
+	}
+}
+
+func TestPersistenceLargeVectorID(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	const tooLarge = uint64(1) << 63
+
+	// Supplied IDs above MaxInt64 never reach the WAL
+	err = p.WriteBatch([]WALRecordData{{VectorID: tooLarge, Vector: []float32{1.0, 2.0, 3.0}}},
+		true, scalarStorage, filterIndex, flatIndex, 3)
+	if !errors.Is(err, common.ErrIDOutOfRange) {
+		t.Fatalf("Expected ErrIDOutOfRange, got %v", err)
+	}
+	if p.GetPendingCount() != 0 {
+		t.Errorf("Expected no pending records, got %d", p.GetPendingCount())
+	}
+
+	// A replayed record with such an ID fails the sync and is rolled back
+	err = p.WriteOnly(tooLarge, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "big"}, map[string]any{"category": int64(1)})
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	err = p.Sync(scalarStorage, filterIndex, flatIndex, 3)
+	if !errors.Is(err, common.ErrIDOutOfRange) {
+		t.Fatalf("Expected ErrIDOutOfRange from sync, got %v", err)
+	}
+
+	raw, err := scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(tooLarge))
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if raw != nil {
+		t.Errorf("Expected doc to be rolled back, got %s", raw)
+	}
+
+	// The largest representable ID is accepted
+	label, err := common.LabelFromID(tooLarge - 1)
+	if err != nil || label != int64(tooLarge-1) {
+		t.Errorf("Expected MaxInt64 to convert, got %d, %v", label, err)
+	}
+}
+
+func TestPersistenceEmptyWAL(t *testing.T) {
+	// Create temporary directory for test
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+
+	// Create empty WAL file
+	f, err := os.Create(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL file: %v", err)
+	}
+	f.Close()
+
+	// Create persistence layer
+	p, err := NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	// Create minimal dependencies
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	vectorIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	// Restore from empty WAL should succeed without error
+	err = p.Restore(scalarStorage, filterIndex, vectorIndex, 3)
+	if err != nil {
+		t.Fatalf("Failed to restore from empty WAL: %v", err)
+	}
+}
+
+func TestPersistenceLogIDMonotonicAcrossTruncation(t *testing.T) {
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	// writeAndReopen writes one record, closes the WAL and restores it in a new
+	// persistence layer, which truncates the WAL, and returns the LogID written
+	writeAndReopen := func(p *Persistence, vectorID uint64) (*Persistence, uint64) {
+		if err := p.WriteOnly(vectorID, []float32{1.0, 2.0, 3.0}, map[string]any{}, map[string]any{}); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+
+		file, err := os.Open(walPath)
+		if err != nil {
+			t.Fatalf("Failed to open WAL: %v", err)
+		}
+		var logID uint64
+		for record, err := range NewWALReader(file, NewBinaryWALEncoder(WALVersion)) {
+			if err != nil {
+				t.Fatalf("Failed to read WAL: %v", err)
+			}
+			logID = record.LogID
+		}
+		file.Close()
+		p.Close()
+
+		p, err = NewPersistence(walPath)
+		if err != nil {
+			t.Fatalf("Failed to create persistence: %v", err)
+		}
+
+		vectorIndex, err := index.NewFlatIndex(3, index.L2)
+		if err != nil {
+			t.Fatalf("Failed to create vector index: %v", err)
+		}
+		if err := p.Restore(scalarStorage, filter.NewIntFilterIndex(), vectorIndex, 3); err != nil {
+			t.Fatalf("Failed to restore: %v", err)
+		}
+
+		return p, logID
+	}
+
+	p, err := NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+
+	var lastLogID uint64
+	for i := uint64(1); i <= 3; i++ {
+		var logID uint64
+		p, logID = writeAndReopen(p, i)
+		if logID <= lastLogID {
+			t.Errorf("Expected LogID above %d after truncation, got %d", lastLogID, logID)
+		}
+		lastLogID = logID
+	}
+	p.Close()
+
+	if stat, err := os.Stat(walPath); err != nil || stat.Size() != 0 {
+		t.Fatalf("Expected WAL to be truncated after restore, got %v, %v", stat, err)
+	}
+}
+
+// countingIndex wraps an index and counts Insert calls
+type countingIndex struct {
+	index.Index
+	inserts int
+}
+
+func (c *countingIndex) Insert(params *index.InsertParams) error {
+	c.inserts++
+	return c.Index.Insert(params)
+}
+
+func TestPersistenceWriteBatchEager(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+	vectorIndex := &countingIndex{Index: flatIndex}
+
+	records := []WALRecordData{
+		{VectorID: 1, Vector: []float32{1.0, 2.0, 3.0}, Doc: map[string]any{"text": "a"}, Attributes: map[string]any{"category": int64(1)}},
+		{VectorID: 2, Vector: []float32{4.0, 5.0, 6.0}, Doc: map[string]any{"text": "b"}, Attributes: map[string]any{"category": int64(2)}},
+		{VectorID: 3, Vector: []float32{7.0, 8.0, 9.0}, Doc: map[string]any{"text": "c"}, Attributes: map[string]any{"category": int64(1)}},
+	}
+
+	err = p.WriteBatch(records, true, scalarStorage, filterIndex, vectorIndex, 3)
+	if err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	if vectorIndex.inserts != 1 {
+		t.Errorf("Expected 1 index insert for the batch, got %d", vectorIndex.inserts)
+	}
+
+	if p.GetPendingCount() != 0 {
+		t.Errorf("Expected 0 pending records after eager batch, got %d", p.GetPendingCount())
+	}
+
+	for _, record := range records {
+		doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, record.VectorID)
+		if err != nil {
+			t.Fatalf("Failed to get doc: %v", err)
+		}
+		if doc["text"] != record.Doc["text"] {
+			t.Errorf("Expected text=%v, got %v", record.Doc["text"], doc["text"])
+		}
+	}
+
+	result, err := vectorIndex.Search(index.NewSearchQuery([]float32{4.0, 5.0, 6.0}), 1)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(result.Labels) != 1 || result.Labels[0] != 2 {
+		t.Errorf("Expected search to return label [2], got %v", result.Labels)
+	}
+}
+
+func BenchmarkPersistenceEagerPerRow(b *testing.B) {
+	benchmarkPersistenceEager(b, false)
+}
+
+func BenchmarkPersistenceEagerBatch(b *testing.B) {
+	benchmarkPersistenceEager(b, true)
+}
+
+func benchmarkPersistenceEager(b *testing.B, batched bool) {
+	const rows, dim = 1000, 8
+
+	tmpDir := b.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "bench.wal"))
+	if err != nil {
+		b.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		b.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	vectorIndex, err := index.NewFlatIndex(dim, index.L2)
+	if err != nil {
+		b.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	var nextID uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		records := make([]WALRecordData, rows)
+		for r := range records {
+			nextID++
+			records[r] = WALRecordData{
+				VectorID:   nextID,
+				Vector:     make([]float32, dim),
+				Doc:        map[string]any{"n": r},
+				Attributes: map[string]any{"category": int64(r % 10)},
+			}
+		}
+
+		if batched {
+			err = p.WriteBatch(records, true, scalarStorage, filterIndex, vectorIndex, dim)
+			if err != nil {
+				b.Fatalf("Failed to write batch: %v", err)
+			}
+			continue
+		}
+
+		for _, record := range records {
+			err = p.Write(record.VectorID, record.Vector, record.Doc, record.Attributes, true,
+				scalarStorage, filterIndex, vectorIndex, dim)
+			if err != nil {
+				b.Fatalf("Failed to write record: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkPersistenceSync(b *testing.B) {
+	for _, indexType := range []string{"flat", "hnsw"} {
+		for _, dim := range []int{8, 128} {
+			for _, rows := range []int{100, 1000} {
+				b.Run(fmt.Sprintf("%s/dim=%d/rows=%d", indexType, dim, rows), func(b *testing.B) {
+					benchmarkPersistenceSync(b, indexType, dim, rows)
+				})
+			}
+		}
+	}
+}
+
+func benchmarkPersistenceSync(b *testing.B, indexType string, dim, rows int) {
+	tmpDir := b.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "bench.wal"))
+	if err != nil {
+		b.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		b.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	vectorIndex, err := index.NewIndex(indexType, dim, index.L2, &index.HNSWParams{EFConstruction: 200, M: 16}, "")
+	if err != nil {
+		b.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	var nextID uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Only the Sync call is measured, not appending the records to the WAL
+		b.StopTimer()
+		records := make([]WALRecordData, rows)
+		for r := range records {
+			nextID++
+			vector := make([]float32, dim)
+			for d := range vector {
+				vector[d] = float32((int(nextID) + d) % 97)
+			}
+			records[r] = WALRecordData{
+				VectorID:   nextID,
+				Vector:     vector,
+				Doc:        map[string]any{"n": r},
+				Attributes: map[string]any{"category": int64(r % 10)},
+			}
+		}
+		if err := p.WriteBatch(records, false, scalarStorage, filterIndex, vectorIndex, dim); err != nil {
+			b.Fatalf("Failed to write batch: %v", err)
+		}
+		b.StartTimer()
+
+		if err := p.Sync(scalarStorage, filterIndex, vectorIndex, dim); err != nil {
+			b.Fatalf("Failed to sync: %v", err)
+		}
+	}
+}
+
+func TestVectorMatrixReuse(t *testing.T) {
+	vectors := [][]float32{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}
+	mat := vectorMatrix(vectors, 3)
+	if mat.Rows != 3 || mat.Cols != 3 {
+		t.Fatalf("Expected a 3x3 matrix, got %dx%d", mat.Rows, mat.Cols)
+	}
+	for i, vec := range vectors {
+		for j, val := range vec {
+			if got := mat.At(i, j); got != val {
+				t.Errorf("Element (%d, %d): expected %v, got %v", i, j, val, got)
+			}
+		}
+	}
+	releaseMatrix(mat)
+
+	// A smaller batch in the same capacity class must not see the previous vectors,
+	// and a short vector is padded with zeros
+	mat = vectorMatrix([][]float32{{10, 11, 12}, {13}}, 3)
+	defer releaseMatrix(mat)
+	if mat.Rows != 2 || mat.Cols != 3 || len(mat.Data) != 6 {
+		t.Fatalf("Expected a 2x3 matrix, got %dx%d with %d elements", mat.Rows, mat.Cols, len(mat.Data))
+	}
+	expected := []float32{10, 11, 12, 13, 0, 0}
+	for i, val := range expected {
+		if mat.Data[i] != val {
+			t.Errorf("Element %d: expected %v, got %v", i, val, mat.Data[i])
+		}
+	}
+}
+
+func TestPersistenceSyncReusesMatrices(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+	vectorIndex, err := index.NewIndex("flat", 2, index.L2, nil, "")
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	// Batches of decreasing size reuse the matrix of the first one
+	var nextID uint64
+	for _, rows := range []int{8, 5, 2} {
+		records := make([]WALRecordData, rows)
+		for r := range records {
+			nextID++
+			records[r] = WALRecordData{VectorID: nextID, Vector: []float32{float32(nextID), -float32(nextID)}}
+		}
+		if err := p.WriteBatch(records, false, scalarStorage, filterIndex, vectorIndex, 2); err != nil {
+			t.Fatalf("Failed to write batch: %v", err)
+		}
+		if err := p.Sync(scalarStorage, filterIndex, vectorIndex, 2); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+	}
+
+	for id := uint64(1); id <= nextID; id++ {
+		vector, err := vectorIndex.Reconstruct(int64(id))
+		if err != nil {
+			t.Fatalf("Failed to reconstruct vector %d: %v", id, err)
+		}
+		if vector[0] != float32(id) || vector[1] != -float32(id) {
+			t.Errorf("Vector %d: expected [%d -%d], got %v", id, id, id, vector)
+		}
+	}
+}
+
+// BenchmarkVectorMatrix compares building the matrix of a large sync batch from the
+// pool against allocating a fresh one, as Sync did before
+func BenchmarkVectorMatrix(b *testing.B) {
+	const rows, dim = 1000, 128
+	vectors := make([][]float32, rows)
+	for i := range vectors {
+		vectors[i] = make([]float32, dim)
+		for d := range vectors[i] {
+			vectors[i][d] = float32((i + d) % 97)
+		}
+	}
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			releaseMatrix(vectorMatrix(vectors, dim))
+		}
+	})
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			mat := commonMath.NewMatrix32Empty(rows, dim)
+			for r, vec := range vectors {
+				for d, val := range vec {
+					mat.Set(r, d, val)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkPersistenceSyncTxMode(b *testing.B) {
+	for _, mode := range []common.SyncTxMode{common.SyncTxModeBatch, common.SyncTxModePerRecord} {
+		b.Run(string(mode), func(b *testing.B) {
+			benchmarkPersistenceSyncTxMode(b, mode)
+		})
+	}
+}
+
+func benchmarkPersistenceSyncTxMode(b *testing.B, mode common.SyncTxMode) {
+	const rows, dim = 1000, 8
+
+	tmpDir := b.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "bench.wal"))
+	if err != nil {
+		b.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	if err := p.SetSyncTxMode(mode); err != nil {
+		b.Fatalf("Failed to set sync transaction mode: %v", err)
+	}
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		b.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	vectorIndex, err := index.NewFlatIndex(dim, index.L2)
+	if err != nil {
+		b.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	var nextID uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		records := make([]WALRecordData, rows)
+		for r := range records {
+			nextID++
+			records[r] = WALRecordData{
+				VectorID:   nextID,
+				Vector:     make([]float32, dim),
+				Doc:        map[string]any{"n": r},
+				Attributes: map[string]any{"category": int64(r % 10)},
+			}
+		}
+		if err := p.WriteBatch(records, false, scalarStorage, filterIndex, vectorIndex, dim); err != nil {
+			b.Fatalf("Failed to write batch: %v", err)
+		}
+		b.StartTimer()
+
+		if err := p.Sync(scalarStorage, filterIndex, vectorIndex, dim); err != nil {
+			b.Fatalf("Failed to sync: %v", err)
+		}
+	}
+}
+
+func TestPersistenceSubscribe(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	records, unsubscribe := p.Subscribe(10, common.CDCPolicyBlock)
+	defer unsubscribe()
+
+	err = p.WriteOnly(1, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "hello"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	// Nothing is delivered until the record is durable
+	select {
+	case record := <-records:
+		t.Fatalf("Unexpected record before flush: %v", record)
+	default:
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	select {
+	case record := <-records:
+		if record.VectorID != 1 {
+			t.Errorf("Expected vector ID 1, got %d", record.VectorID)
+		}
+	default:
+		t.Fatal("Expected a record after flush")
+	}
+
+	// Unsubscribing closes the channel and is idempotent
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-records; ok {
+		t.Error("Expected channel to be closed after unsubscribe")
+	}
+}
+
+func TestPersistenceSubscribeDropPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+
+	records, unsubscribe := p.Subscribe(1, common.CDCPolicyDrop)
+	defer unsubscribe()
+
+	for i := uint64(1); i <= 3; i++ {
+		if err := p.WriteOnly(i, []float32{1.0, 2.0, 3.0}, nil, nil); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	// Flush must not block even though the subscriber can only hold one record
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	p.Close()
+
+	received := 0
+	for range records {
+		received++
+	}
+	if received != 1 {
+		t.Errorf("Expected 1 buffered record, got %d", received)
+	}
+}
+
+func TestPersistenceSubscribeBlockPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+
+	records, unsubscribe := p.Subscribe(1, common.CDCPolicyBlock)
+	defer unsubscribe()
+
+	for i := uint64(1); i <= 2; i++ {
+		if err := p.WriteOnly(i, []float32{1.0, 2.0, 3.0}, nil, nil); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	// The flush waits for the subscriber to take the second record
+	flushed := make(chan error, 1)
+	go func() { flushed <- p.Flush() }()
+	for len(records) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// It waits without the WAL lock, so another write reaches the WAL meanwhile and only
+	// waits for the subscriber afterwards
+	written := make(chan error, 1)
+	go func() { written <- p.WriteOnly(3, []float32{1.0, 2.0, 3.0}, nil, nil) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.GetPendingCount() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a write to reach the WAL while the subscriber is blocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for want := uint64(1); want <= 2; want++ {
+		if record := <-records; record.VectorID != want {
+			t.Errorf("Expected vector ID %d, got %d", want, record.VectorID)
+		}
+	}
+	if err := <-flushed; err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	// Close flushes the last record and delivers it before closing the channel
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close() }()
+	var rest []uint64
+	for record := range records {
+		rest = append(rest, record.VectorID)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if len(rest) != 1 || rest[0] != 3 {
+		t.Errorf("Expected the record flushed by Close, got %v", rest)
+	}
+}
+
+// failingRemoveIndex wraps an index and fails the first Remove
+type failingRemoveIndex struct {
+	index.Index
+	failed bool
+}
+
+func (f *failingRemoveIndex) Remove(labels []int64) (int, error) {
+	if !f.failed {
+		f.failed = true
+		return 0, errors.New("remove failed")
+	}
+	return f.Index.Remove(labels)
+}
+
+// indexedLabels returns the labels currently stored in a 3-dimensional index
+func indexedLabels(t *testing.T, vectorIndex index.Index) []int64 {
+	t.Helper()
+
+	result, err := vectorIndex.Search(index.NewSearchQuery([]float32{0, 0, 0}), 10)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+
+	labels := make([]int64, 0, len(result.Labels))
+	for _, label := range result.Labels {
+		if label >= 0 {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+func TestPersistenceWriteAtomicRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	err = p.Write(1, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "old"}, map[string]any{"category": int64(1)},
+		true, scalarStorage, filterIndex, flatIndex, 3)
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	// Replace vector 1 with vector 2; the delete fails in the vector index after every
+	// other phase of both operations has been applied
+	replace := []WALRecordData{
+		{Operation: Delete, VectorID: 1},
+		{Operation: Insert, VectorID: 2, Vector: []float32{4.0, 5.0, 6.0}, Doc: map[string]any{"text": "new"}, Attributes: map[string]any{"category": int64(2)}},
+	}
+	err = p.WriteAtomic(replace, true, scalarStorage, filterIndex, &failingRemoveIndex{Index: flatIndex}, 3)
+	if err == nil {
+		t.Fatal("Expected atomic write to fail due to vector remove failure")
+	}
+
+	// The insert is rolled back and the deleted vector is restored
+	assertRolledBack(t, scalarStorage, filterIndex, 2, "category", 2)
+
+	doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, 1)
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if doc == nil || doc["text"] != "old" {
+		t.Errorf("Expected doc 1 to be restored, got %v", doc)
+	}
+
+	result := filterIndex.Apply(&filter.IntFilterInput{Field: "category", Op: filter.Equal, Target: 1}, filter.NewIdFilter().GetBitmap())
+	if !result.Contains(1) {
+		t.Error("Expected filter entry category=1 for id 1 to be restored")
+	}
+
+	if labels := indexedLabels(t, flatIndex); len(labels) != 1 || labels[0] != 1 {
+		t.Errorf("Expected only label 1 in the vector index, got %v", labels)
+	}
+
+	// The whole batch, including its Begin marker, stays pending and applies on retry
+	if p.GetPendingCount() != 3 {
+		t.Errorf("Expected 3 pending records after failed sync, got %d", p.GetPendingCount())
+	}
+
+	if err := p.Sync(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+		t.Fatalf("Failed to retry sync: %v", err)
+	}
+
+	doc, err = scalarStorage.GetValue(scalar.NamespaceDocs, 1)
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if doc != nil {
+		t.Errorf("Expected doc 1 to be deleted after retry, got %v", doc)
+	}
+
+	doc, err = scalarStorage.GetValue(scalar.NamespaceDocs, 2)
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if doc == nil || doc["text"] != "new" {
+		t.Errorf("Expected doc 2 after retry, got %v", doc)
+	}
+
+	if labels := indexedLabels(t, flatIndex); len(labels) != 1 || labels[0] != 2 {
+		t.Errorf("Expected only label 2 in the vector index, got %v", labels)
+	}
+}
+
+func TestPersistenceWriteUpdate(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	matches := func(id uint64, category int64) bool {
+		result := filterIndex.Apply(&filter.IntFilterInput{Field: "category", Op: filter.Equal, Target: category}, filter.NewIdFilter().GetBitmap())
+		return result.Contains(uint32(id))
+	}
+	docText := func(id uint64) any {
+		doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, id)
+		if err != nil {
+			t.Fatalf("Failed to get doc: %v", err)
+		}
+		return doc["text"]
+	}
+
+	for id := uint64(1); id <= 2; id++ {
+		err = p.Write(id, []float32{float32(id), 0, 0}, map[string]any{"text": "old"}, map[string]any{"category": int64(1)},
+			true, scalarStorage, filterIndex, flatIndex, 3)
+		if err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	// The update is rolled back with the batch when the delete fails in the vector index
+	batch := []WALRecordData{
+		{Operation: Update, VectorID: 1, Doc: map[string]any{"text": "new"}, Attributes: map[string]any{"category": int64(2)}},
+		{Operation: Delete, VectorID: 2},
+	}
+	err = p.WriteAtomic(batch, true, scalarStorage, filterIndex, &failingRemoveIndex{Index: flatIndex}, 3)
+	if err == nil {
+		t.Fatal("Expected atomic write to fail due to vector remove failure")
+	}
+	if text := docText(1); text != "old" {
+		t.Errorf("Expected doc 1 to be restored, got text %v", text)
+	}
+	if !matches(1, 1) || matches(1, 2) {
+		t.Error("Expected id 1 to be back under category=1 only")
+	}
+
+	if err := p.Sync(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+		t.Fatalf("Failed to retry sync: %v", err)
+	}
+	if text := docText(1); text != "new" {
+		t.Errorf("Expected doc 1 to be updated after retry, got text %v", text)
+	}
+	if matches(1, 1) || !matches(1, 2) {
+		t.Error("Expected id 1 to move from category=1 to category=2")
+	}
+	if labels := indexedLabels(t, flatIndex); len(labels) != 1 || labels[0] != 1 {
+		t.Errorf("Expected the update to leave label 1 in the vector index, got %v", labels)
+	}
+
+	// An update synced together with the insert it follows is folded into the insert
+	if err := p.WriteOnly(3, []float32{3, 0, 0}, map[string]any{"text": "old"}, map[string]any{"category": int64(1)}); err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	err = p.WriteUpdate(3, map[string]any{"text": "new"}, map[string]any{"category": int64(2)}, true, scalarStorage, filterIndex, flatIndex, 3)
+	if err != nil {
+		t.Fatalf("Failed to write update: %v", err)
+	}
+	if text := docText(3); text != "new" {
+		t.Errorf("Expected doc 3 to be updated, got text %v", text)
+	}
+	if matches(3, 1) || !matches(3, 2) {
+		t.Error("Expected id 3 to be indexed under category=2 only")
+	}
+
+	// An update of a vector that no longer exists is skipped
+	err = p.WriteUpdate(2, map[string]any{"text": "new"}, nil, true, scalarStorage, filterIndex, flatIndex, 3)
+	if err != nil {
+		t.Fatalf("Failed to write update: %v", err)
+	}
+	if text := docText(2); text != nil {
+		t.Errorf("Expected deleted doc 2 to stay deleted, got text %v", text)
+	}
+}
+
+func TestPersistenceRestoreDropsIncompleteAtomicBatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+
+	p, err := NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+
+	err = p.WriteOnly(1, []float32{1.0, 2.0, 3.0}, map[string]any{"text": "a"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	batch := []WALRecordData{
+		{Operation: Delete, VectorID: 1},
+		{Operation: Insert, VectorID: 2, Vector: []float32{4.0, 5.0, 6.0}, Doc: map[string]any{"text": "b"}},
+	}
+	if err := p.WriteAtomic(batch, false, nil, nil, nil, 3); err != nil {
+		t.Fatalf("Failed to write atomic batch: %v", err)
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// Simulate a crash while the last record of the batch was being written
+	stat, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("Failed to stat WAL: %v", err)
+	}
+	if err := os.Truncate(walPath, stat.Size()-4); err != nil {
+		t.Fatalf("Failed to truncate WAL: %v", err)
+	}
+
+	p, err = NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	if err := p.Restore(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	// The record before the batch is replayed; the delete of the torn batch is not
+	doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, 1)
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if doc == nil || doc["text"] != "a" {
+		t.Errorf("Expected doc 1 to survive the incomplete batch, got %v", doc)
+	}
+
+	doc, err = scalarStorage.GetValue(scalar.NamespaceDocs, 2)
+	if err != nil {
+		t.Fatalf("Failed to get doc: %v", err)
+	}
+	if doc != nil {
+		t.Errorf("Expected doc 2 of the incomplete batch to be dropped, got %v", doc)
+	}
+
+	if labels := indexedLabels(t, flatIndex); len(labels) != 1 || labels[0] != 1 {
+		t.Errorf("Expected only label 1 in the vector index, got %v", labels)
+	}
+}
+
+// insertSizesIndex records the number of vectors of each Insert call
+type insertSizesIndex struct {
+	index.Index
+	sizes []int
+}
+
+func (c *insertSizesIndex) Insert(params *index.InsertParams) error {
+	c.sizes = append(c.sizes, len(params.Labels))
+	return c.Index.Insert(params)
+}
+
+func TestPersistenceSyncMaxSyncBatch(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+	p.SetMaxSyncBatch(4)
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+	vectorIndex := &insertSizesIndex{Index: flatIndex}
+
+	for i := uint64(1); i <= 10; i++ {
+		if err := p.WriteOnly(i, []float32{float32(i), 0, 0}, map[string]any{"n": i}, nil); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	// The atomic batch of 3 records plus its marker does not fit after the last 2 records
+	atomic := []WALRecordData{
+		{Operation: Insert, VectorID: 11, Vector: []float32{11, 0, 0}},
+		{Operation: Insert, VectorID: 12, Vector: []float32{12, 0, 0}},
+		{Operation: Insert, VectorID: 13, Vector: []float32{13, 0, 0}},
+	}
+	if err := p.WriteAtomic(atomic, false, scalarStorage, filterIndex, vectorIndex, 3); err != nil {
+		t.Fatalf("Failed to write atomic batch: %v", err)
+	}
+
+	if err := p.Sync(scalarStorage, filterIndex, vectorIndex, 3); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	if want := []int{4, 4, 2, 3}; fmt.Sprint(vectorIndex.sizes) != fmt.Sprint(want) {
+		t.Errorf("Expected chunks of %v vectors, got %v", want, vectorIndex.sizes)
+	}
+	if p.GetPendingCount() != 0 {
+		t.Errorf("Expected no pending records, got %d", p.GetPendingCount())
+	}
+	for id := uint64(1); id <= 13; id++ {
+		raw, err := scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(id))
+		if err != nil || raw == nil {
+			t.Errorf("Expected doc %d to be stored, got %s, %v", id, raw, err)
+		}
+	}
+
+	// A failing chunk leaves the chunks before it applied and itself and the rest pending
+	vectorIndex.sizes = nil
+	for i := uint64(14); i <= 22; i++ {
+		attributes := map[string]any{"category": float64(1)}
+		if i == 19 {
+			attributes = map[string]any{"category": "not an int"}
+		}
+		if err := p.WriteOnly(i, []float32{float32(i), 0, 0}, nil, attributes); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+
+	if err := p.Sync(scalarStorage, filterIndex, vectorIndex, 3); err == nil {
+		t.Fatal("Expected sync to fail on the unsupported attribute")
+	}
+	if want := []int{4}; fmt.Sprint(vectorIndex.sizes) != fmt.Sprint(want) {
+		t.Errorf("Expected chunks of %v vectors, got %v", want, vectorIndex.sizes)
+	}
+	if p.GetPendingCount() != 5 {
+		t.Errorf("Expected 5 pending records after failed sync, got %d", p.GetPendingCount())
+	}
+}
+
+func TestPersistenceFlushEveryN(t *testing.T) {
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+
+	p, err := NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+	p.SetFlushEveryN(3)
+
+	walSize := func() int64 {
+		stat, err := os.Stat(walPath)
+		if err != nil {
+			t.Fatalf("Failed to stat WAL: %v", err)
+		}
+		return stat.Size()
+	}
+
+	var flushed int64
+	for i := uint64(1); i <= 6; i++ {
+		if err := p.WriteOnly(i, []float32{float32(i), 0, 0}, map[string]any{"n": i}, nil); err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+
+		size := walSize()
+		if i%3 == 0 {
+			if size <= flushed {
+				t.Errorf("Expected WAL to be flushed after record %d, size stayed %d", i, size)
+			}
+			flushed = size
+		} else if size != flushed {
+			t.Errorf("Expected WAL to stay at %d bytes after record %d, got %d", flushed, i, size)
+		}
+	}
+
+	// Records of an atomic batch count, its Begin marker does not
+	atomic := []WALRecordData{
+		{Operation: Insert, VectorID: 7, Vector: []float32{7, 0, 0}},
+		{Operation: Insert, VectorID: 8, Vector: []float32{8, 0, 0}},
+	}
+	if err := p.WriteAtomic(atomic, false, nil, nil, nil, 3); err != nil {
+		t.Fatalf("Failed to write atomic batch: %v", err)
+	}
+	if size := walSize(); size != flushed {
+		t.Errorf("Expected 2 records not to flush the WAL, size went from %d to %d", flushed, size)
+	}
+	if err := p.WriteOnly(9, []float32{9, 0, 0}, nil, nil); err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	if size := walSize(); size <= flushed {
+		t.Errorf("Expected WAL to be flushed after the third record, size stayed %d", size)
+	}
+}
+
+func TestPersistenceFailedFlushNotQueued(t *testing.T) {
+	p, err := NewPersistence(filepath.Join(t.TempDir(), "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+	p.SetFlushEveryN(1)
+
+	if err := p.WriteOnly(1, []float32{1, 0, 0}, nil, nil); err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	// Closing the file underneath makes every later flush fail
+	if err := p.walWriter.Close(); err != nil {
+		t.Fatalf("Failed to close WAL file: %v", err)
+	}
+	if err := p.WriteOnly(2, []float32{2, 0, 0}, nil, nil); err == nil {
+		t.Fatal("Expected write to fail when the WAL cannot be flushed")
+	}
+	atomic := []WALRecordData{
+		{Operation: Insert, VectorID: 3, Vector: []float32{3, 0, 0}},
+		{Operation: Insert, VectorID: 4, Vector: []float32{4, 0, 0}},
+	}
+	if err := p.WriteAtomic(atomic, false, nil, nil, nil, 3); err == nil {
+		t.Fatal("Expected atomic write to fail when the WAL cannot be flushed")
+	}
+
+	// Only the record written before the failure stays pending
+	if count := p.GetPendingCount(); count != 1 {
+		t.Errorf("Expected 1 pending record after failed writes, got %d", count)
+	}
+	if len(p.unflushed) != 0 || p.sinceFlush != 0 {
+		t.Errorf("Expected no unflushed records, got %d (%d counted)", len(p.unflushed), p.sinceFlush)
+	}
+}
+
+func TestOpRateWindow(t *testing.T) {
+	var rate opRate
+	start := time.Unix(1000, 0)
+
+	rate.add(start, 30)
+	rate.add(start.Add(500*time.Millisecond), 10)
+	rate.add(start.Add(3*time.Second), 20)
+
+	if got, want := rate.perSecond(start.Add(3*time.Second)), 60/StatsWindow.Seconds(); got != want {
+		t.Errorf("rate within the window = %v, want %v", got, want)
+	}
+
+	// Buckets older than the window no longer count, even once reused
+	if got, want := rate.perSecond(start.Add(StatsWindow)), 20/StatsWindow.Seconds(); got != want {
+		t.Errorf("rate after the first second left the window = %v, want %v", got, want)
+	}
+	rate.add(start.Add(StatsWindow), 5)
+	if got, want := rate.perSecond(start.Add(StatsWindow)), 25/StatsWindow.Seconds(); got != want {
+		t.Errorf("rate after reusing a bucket = %v, want %v", got, want)
+	}
+	if got := rate.perSecond(start.Add(time.Hour)); got != 0 {
+		t.Errorf("rate long after the last operation = %v, want 0", got)
+	}
+}
+
+func TestPersistenceRestoreSkipsInvalidAttributes(t *testing.T) {
+	tmpDir := t.TempDir()
+	walPath := filepath.Join(tmpDir, "test.wal")
+
+	p, err := NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+
+	// A WAL written before strict types were enabled: "price" is an int, then a bool,
+	// and "tag" holds a string the filter index cannot index
+	records := []struct {
+		id         uint64
+		attributes map[string]any
+	}{
+		{1, map[string]any{"price": 10, "rank": 1}},
+		{2, map[string]any{"price": true, "rank": 2}},
+		{3, map[string]any{"tag": "red", "rank": 3}},
+	}
+	for _, r := range records {
+		err := p.WriteOnly(r.id, []float32{float32(r.id), 0, 0}, map[string]any{"text": "doc"}, r.attributes)
+		if err != nil {
+			t.Fatalf("Failed to write record %d: %v", r.id, err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	p, err = NewPersistence(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+	filterIndex.SetStrictTypes(true)
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	if err := p.Restore(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	if got := p.SkippedAttributes(); got != 2 {
+		t.Errorf("Expected 2 skipped attributes, got %d", got)
+	}
+
+	// Every record is restored, only the bad attributes are missing
+	if labels := indexedLabels(t, flatIndex); len(labels) != 3 {
+		t.Errorf("Expected 3 labels in the vector index, got %v", labels)
+	}
+	for _, r := range records {
+		doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, r.id)
+		if err != nil {
+			t.Fatalf("Failed to get doc %d: %v", r.id, err)
+		}
+		if doc == nil {
+			t.Errorf("Expected doc %d to be restored", r.id)
+		}
+	}
+
+	// "tag" was never indexed, "price" only for vector 1
+	fields := filterIndex.Fields()
+	if len(fields) != 2 || fields[0].Name != "price" || fields[1].Name != "rank" {
+		t.Fatalf("Expected fields price and rank, got %+v", fields)
+	}
+	if fields[0].Type != filter.FieldTypeInt || fields[0].Distinct != 1 {
+		t.Errorf("Expected price to hold one int value, got %+v", fields[0])
+	}
+	if fields[1].Distinct != 3 {
+		t.Errorf("Expected rank to hold 3 values, got %+v", fields[1])
+	}
+	price := filterIndex.Apply(&filter.IntFilterInput{Field: "price", Op: filter.Equal, Target: 10}, roaring.New())
+	if !price.Contains(1) {
+		t.Errorf("Expected vector 1 to match price == 10, got %v", price.ToArray())
+	}
+}
+
+func TestPersistenceSyncExistingIDs(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	write := func(id uint64, x float32, text string) {
+		t.Helper()
+		if err := p.WriteOnly(id, []float32{x, 0, 0}, map[string]any{"text": text}, nil); err != nil {
+			t.Fatalf("Failed to write record %d: %v", id, err)
+		}
+	}
+	sync := func() {
+		t.Helper()
+		if err := p.Sync(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+			t.Fatalf("Expected sync to succeed, got %v", err)
+		}
+		if got := p.GetPendingCount(); got != 0 {
+			t.Fatalf("Expected no pending records, got %d", got)
+		}
+	}
+	expect := func(id uint64, x float32, text string) {
+		t.Helper()
+		vector, err := flatIndex.Reconstruct(int64(id))
+		if err != nil {
+			t.Fatalf("Failed to reconstruct %d: %v", id, err)
+		}
+		doc, err := scalarStorage.GetValue(scalar.NamespaceDocs, id)
+		if err != nil {
+			t.Fatalf("Failed to get doc %d: %v", id, err)
+		}
+		if vector[0] != x || doc["text"] != text {
+			t.Errorf("Expected vector %d at %v with text %q, got %v and %v", id, x, text, vector, doc)
+		}
+	}
+
+	// An insert of an ID the index holds, or inserts twice in one batch, could never be
+	// applied, so they are dropped rather than retried by every sync
+	write(1, 1, "a")
+	sync()
+	write(1, 2, "b")
+	write(2, 3, "c")
+	write(2, 4, "d")
+	sync()
+	expect(1, 1, "a")
+	expect(2, 3, "c")
+	if got := flatIndex.Ntotal(); got != 2 {
+		t.Errorf("Expected 2 vectors in the index, got %d", got)
+	}
+
+	// With replacing enabled the last insert wins
+	p.SetReplaceExistingIDs(true)
+	write(1, 5, "e")
+	write(1, 6, "f")
+	sync()
+	expect(1, 6, "f")
+	if got := flatIndex.Ntotal(); got != 2 {
+		t.Errorf("Expected 2 vectors in the index, got %d", got)
+	}
+}
+
+func TestPersistenceSyncSkipsConflictingTypes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	p, err := NewPersistence(filepath.Join(tmpDir, "test.wal"))
+	if err != nil {
+		t.Fatalf("Failed to create persistence: %v", err)
+	}
+	defer p.Close()
+
+	scalarStorage, err := scalar.NewScalarStorage(&scalar.ScalarOption{
+		DIR:     filepath.Join(tmpDir, "scalar.db"),
+		Buckets: []string{scalar.NamespaceDocs},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create scalar storage: %v", err)
+	}
+	defer scalarStorage.Close()
+
+	filterIndex := filter.NewIntFilterIndex()
+	filterIndex.SetStrictTypes(true)
+
+	flatIndex, err := index.NewFlatIndex(3, index.L2)
+	if err != nil {
+		t.Fatalf("Failed to create vector index: %v", err)
+	}
+
+	// Two pending records logged without reserving their types disagree on "price";
+	// failing the sync would leave them pending, and every later sync failing too
+	if err := p.WriteOnly(1, []float32{1, 0, 0}, map[string]any{"text": "a"}, map[string]any{"price": 10}); err != nil {
+		t.Fatalf("Failed to write record 1: %v", err)
+	}
+	if err := p.WriteOnly(2, []float32{2, 0, 0}, map[string]any{"text": "b"}, map[string]any{"price": true, "rank": 2}); err != nil {
+		t.Fatalf("Failed to write record 2: %v", err)
+	}
+
+	if err := p.Sync(scalarStorage, filterIndex, flatIndex, 3); err != nil {
+		t.Fatalf("Expected sync to skip the conflicting attribute, got %v", err)
+	}
+	if got := p.GetPendingCount(); got != 0 {
+		t.Errorf("Expected no pending records, got %d", got)
+	}
+	if got := p.SkippedAttributes(); got != 1 {
+		t.Errorf("Expected 1 skipped attribute, got %d", got)
+	}
+	if labels := indexedLabels(t, flatIndex); len(labels) != 2 {
+		t.Errorf("Expected 2 labels in the vector index, got %v", labels)
+	}
+	price := filterIndex.Apply(&filter.IntFilterInput{Field: "price", Op: filter.Equal, Target: 10}, roaring.New())
+	if price.GetCardinality() != 1 || !price.Contains(1) {
+		t.Errorf("Expected only vector 1 to match price == 10, got %v", price.ToArray())
 	}
 }
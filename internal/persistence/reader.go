@@ -0,0 +1,44 @@
+package persistence
+
+import (
+	"bufio"
+	"io"
+	"iter"
+)
+
+// NewWALReader returns an iterator that decodes WAL records from r with the given encoder
+// Iteration stops cleanly at io.EOF; any other decode error is yielded once and ends iteration
+func NewWALReader(r io.Reader, encoder WALEncoder) iter.Seq2[*WALRecord, error] {
+	bufReader, ok := r.(*bufio.Reader)
+	if !ok {
+		bufReader = bufio.NewReader(r)
+	}
+
+	return func(yield func(*WALRecord, error) bool) {
+		for {
+			record, err := encoder.DecodeRecord(bufReader)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(record, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readCounter counts the bytes read through it
+type readCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *readCounter) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,178 @@
+package persistence
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWALReader(t *testing.T) {
+	for _, encoder := range []WALEncoder{NewBinaryWALEncoder(WALVersion), NewTextWALEncoder(WALVersion)} {
+		t.Run(encoder.Name(), func(t *testing.T) {
+			var buf bytes.Buffer
+			for i := uint64(1); i <= 3; i++ {
+				record := &WALRecord{
+					LogID:      i,
+					Version:    WALVersion,
+					Operation:  Insert,
+					VectorID:   i * 10,
+					Vector:     []float32{float32(i), 2.0, 3.0},
+					Doc:        map[string]any{"text": "hello"},
+					Attributes: map[string]any{"category": float64(i)},
+				}
+				if err := encoder.EncodeRecord(&buf, record); err != nil {
+					t.Fatalf("Failed to encode record: %v", err)
+				}
+			}
+
+			var vectorIDs []uint64
+			for record, err := range NewWALReader(&buf, encoder) {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				vectorIDs = append(vectorIDs, record.VectorID)
+			}
+
+			if len(vectorIDs) != 3 || vectorIDs[0] != 10 || vectorIDs[2] != 30 {
+				t.Errorf("Expected vector IDs [10 20 30], got %v", vectorIDs)
+			}
+		})
+	}
+}
+
+func TestWALReaderStopsOnCorruption(t *testing.T) {
+	encoder := NewBinaryWALEncoder(WALVersion)
+
+	var buf bytes.Buffer
+	record := &WALRecord{LogID: 1, VectorID: 1, Vector: []float32{1.0}}
+	if err := encoder.EncodeRecord(&buf, record); err != nil {
+		t.Fatalf("Failed to encode record: %v", err)
+	}
+	if err := encoder.EncodeRecord(&buf, record); err != nil {
+		t.Fatalf("Failed to encode record: %v", err)
+	}
+
+	// Corrupt the second record's payload
+	data := buf.Bytes()
+	data[len(data)-10] ^= 0xFF
+
+	count := 0
+	errCount := 0
+	for _, err := range NewWALReader(bytes.NewReader(data), encoder) {
+		if err != nil {
+			errCount++
+			continue
+		}
+		count++
+	}
+
+	if count != 1 || errCount != 1 {
+		t.Errorf("Expected 1 record then 1 error, got %d records and %d errors", count, errCount)
+	}
+}
+
+// encodeRecords encodes records with vector IDs 1..n and returns the data with the
+// offset at which each record starts
+func encodeRecords(t *testing.T, encoder WALEncoder, records []WALRecord) ([]byte, []int) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	offsets := make([]int, len(records))
+	for i := range records {
+		offsets[i] = buf.Len()
+		if err := encoder.EncodeRecord(&buf, &records[i]); err != nil {
+			t.Fatalf("Failed to encode record: %v", err)
+		}
+	}
+	return buf.Bytes(), offsets
+}
+
+func salvageTestRecords(n int) []WALRecord {
+	records := make([]WALRecord, n)
+	for i := range records {
+		id := uint64(i + 1)
+		records[i] = WALRecord{
+			LogID:     id,
+			Version:   WALVersion,
+			Operation: Insert,
+			VectorID:  id,
+			Vector:    []float32{float32(id), 2.0, 3.0},
+			Doc:       map[string]any{"text": "hello"},
+		}
+	}
+	return records
+}
+
+func salvagedIDs(records []WALRecord) []uint64 {
+	ids := make([]uint64, len(records))
+	for i, record := range records {
+		ids[i] = record.VectorID
+	}
+	return ids
+}
+
+func TestSalvageWALBinary(t *testing.T) {
+	encoder := NewBinaryWALEncoder(WALVersion)
+	data, offsets := encodeRecords(t, encoder, salvageTestRecords(6))
+
+	// Record 2 keeps its length prefix, so decoding resumes right after it; record 4
+	// loses its length prefix, so the next record is found by scanning
+	data[offsets[1]+20] ^= 0xff
+	data[offsets[3]] = 0xff
+
+	records, corrupt := SalvageWAL(data, encoder)
+
+	if got := salvagedIDs(records); fmt.Sprint(got) != "[1 3 5 6]" {
+		t.Errorf("Expected records [1 3 5 6], got %v", got)
+	}
+	if len(corrupt) != 2 {
+		t.Fatalf("Expected 2 corrupt regions, got %d: %v", len(corrupt), corrupt)
+	}
+	if corrupt[0].Offset != int64(offsets[1]) || corrupt[0].Length != int64(offsets[2]-offsets[1]) {
+		t.Errorf("Expected first corrupt region to span record 2, got %+v", corrupt[0])
+	}
+	if corrupt[1].Offset != int64(offsets[3]) || corrupt[1].Length != int64(offsets[4]-offsets[3]) {
+		t.Errorf("Expected second corrupt region to span record 4, got %+v", corrupt[1])
+	}
+}
+
+func TestSalvageWALText(t *testing.T) {
+	encoder := NewTextWALEncoder(WALVersion)
+	data, offsets := encodeRecords(t, encoder, salvageTestRecords(3))
+
+	// Break the operation of the second line
+	copy(data[offsets[1]:], "x,")
+
+	records, corrupt := SalvageWAL(data, encoder)
+
+	if got := salvagedIDs(records); fmt.Sprint(got) != "[1 3]" {
+		t.Errorf("Expected records [1 3], got %v", got)
+	}
+	if len(corrupt) != 1 || corrupt[0].Offset != int64(offsets[1]) {
+		t.Errorf("Expected one corrupt region at offset %d, got %v", offsets[1], corrupt)
+	}
+}
+
+func TestSalvageWALDropsBrokenBatch(t *testing.T) {
+	encoder := NewBinaryWALEncoder(WALVersion)
+
+	records := salvageTestRecords(5)
+	// Records 2-4 are an atomic batch of a Begin marker and two records
+	records[1] = WALRecord{LogID: 2, Version: WALVersion, Operation: Begin, VectorID: 2}
+	data, offsets := encodeRecords(t, encoder, records)
+
+	// Losing one record of the batch drops the rest of it too
+	data[offsets[2]+20] ^= 0xff
+
+	salvaged, _ := SalvageWAL(data, encoder)
+	if got := salvagedIDs(salvaged); fmt.Sprint(got) != "[1 5]" {
+		t.Errorf("Expected records [1 5], got %v", got)
+	}
+
+	// An intact batch is kept
+	data, _ = encodeRecords(t, encoder, records)
+	salvaged, corrupt := SalvageWAL(data, encoder)
+	if len(corrupt) != 0 || len(salvaged) != 5 {
+		t.Errorf("Expected all 5 records and no corruption, got %d records and %v", len(salvaged), corrupt)
+	}
+}
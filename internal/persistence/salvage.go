@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// minBinaryRecordLen is the length, after the length prefix, of a binary record with
+// an empty vector, doc and attributes and the shortest checksum
+const minBinaryRecordLen = 8 + 1 + 8 + 4 + 4 + 4 + 4
+
+// CorruptRegion is a span of a WAL that could not be decoded
+type CorruptRegion struct {
+	Offset int64 // byte offset of the span in the WAL
+	Length int64 // length of the span in bytes
+	Err    error // decode error at the start of the span
+}
+
+// SalvageWAL decodes every readable record of a WAL, unlike Restore, which stops at
+// the first corrupt record. Undecodable spans are skipped and reported.
+//
+// Binary WALs are resynchronized after a corrupt record by trusting its length prefix
+// if a valid record follows it, and otherwise by searching byte by byte for the next
+// offset where a record with a valid checksum starts. Text WALs skip corrupt lines.
+// Atomic batches that lost any of their records are dropped as a whole, so the salvaged
+// records never apply part of a batch.
+func SalvageWAL(data []byte, encoder WALEncoder) ([]WALRecord, []CorruptRegion) {
+	var records []WALRecord
+	var corrupt []CorruptRegion
+
+	switch encoder.(type) {
+	case *TextWALEncoder:
+		records, corrupt = salvageText(data, encoder)
+	default:
+		records, corrupt = salvageBinary(data, encoder)
+	}
+
+	return dropBrokenBatches(records), corrupt
+}
+
+// salvageBinary decodes length-prefixed binary records, resynchronizing after corruption
+func salvageBinary(data []byte, encoder WALEncoder) ([]WALRecord, []CorruptRegion) {
+	var records []WALRecord
+	var corrupt []CorruptRegion
+
+	offset := 0
+	for offset < len(data) {
+		record, size, err := decodeBinaryAt(data, offset, encoder)
+		if err == nil {
+			records = append(records, *record)
+			offset += size
+			continue
+		}
+
+		// Trust the length prefix if a valid record starts right after it,
+		// otherwise search for the next offset where one does
+		next := -1
+		if skip := declaredBinarySize(data, offset); skip > 0 && offset+skip < len(data) {
+			if _, _, err := decodeBinaryAt(data, offset+skip, encoder); err == nil {
+				next = offset + skip
+			}
+		}
+		if next < 0 {
+			next = len(data)
+			for candidate := offset + 1; candidate < len(data); candidate++ {
+				if _, _, err := decodeBinaryAt(data, candidate, encoder); err == nil {
+					next = candidate
+					break
+				}
+			}
+		}
+
+		corrupt = append(corrupt, CorruptRegion{Offset: int64(offset), Length: int64(next - offset), Err: err})
+		offset = next
+	}
+
+	return records, corrupt
+}
+
+// declaredBinarySize returns the full size of the record at offset according to its
+// length prefix, or 0 if the prefix cannot describe a record within data
+func declaredBinarySize(data []byte, offset int) int {
+	if len(data)-offset < 4 {
+		return 0
+	}
+	recordLen := int(binary.BigEndian.Uint32(data[offset:]))
+	if recordLen < minBinaryRecordLen || recordLen > len(data)-offset-4 {
+		return 0
+	}
+	return 4 + recordLen
+}
+
+// decodeBinaryAt decodes the record starting at offset and returns it with its size.
+// The length prefix is checked against data first, so garbage never triggers a large allocation.
+func decodeBinaryAt(data []byte, offset int, encoder WALEncoder) (*WALRecord, int, error) {
+	size := declaredBinarySize(data, offset)
+	if size == 0 {
+		return nil, 0, fmt.Errorf("invalid record length at offset %d", offset)
+	}
+
+	record, err := encoder.DecodeRecord(bufio.NewReader(bytes.NewReader(data[offset : offset+size])))
+	if err != nil {
+		return nil, 0, err
+	}
+	return record, size, nil
+}
+
+// salvageText decodes newline-separated text records, skipping lines that fail to decode
+func salvageText(data []byte, encoder WALEncoder) ([]WALRecord, []CorruptRegion) {
+	var records []WALRecord
+	var corrupt []CorruptRegion
+
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+
+		line := data[offset:end]
+		if len(bytes.TrimSpace(line)) > 0 {
+			record, err := encoder.DecodeRecord(bufio.NewReader(bytes.NewReader(line)))
+			if err != nil {
+				corrupt = append(corrupt, CorruptRegion{Offset: int64(offset), Length: int64(len(line)), Err: err})
+			} else {
+				records = append(records, *record)
+			}
+		}
+
+		offset = end
+	}
+
+	return records, corrupt
+}
+
+// dropBrokenBatches removes atomic batches, Begin marker included, that have fewer
+// records than their marker announces or whose records do not directly follow it
+func dropBrokenBatches(records []WALRecord) []WALRecord {
+	kept := make([]WALRecord, 0, len(records))
+	for i := 0; i < len(records); {
+		record := records[i]
+		if record.Operation != Begin {
+			kept = append(kept, record)
+			i++
+			continue
+		}
+
+		// Batch records have LogIDs following the marker without gaps
+		count := int(record.VectorID)
+		complete := i+count < len(records)
+		for j := 1; complete && j <= count; j++ {
+			member := records[i+j]
+			complete = member.Operation != Begin && member.LogID == record.LogID+uint64(j)
+		}
+
+		if complete {
+			kept = append(kept, records[i:i+1+count]...)
+			i += 1 + count
+			continue
+		}
+
+		// Skip the marker and whichever of its records survived
+		i++
+		for i < len(records) && records[i].Operation != Begin && records[i].LogID <= record.LogID+uint64(count) {
+			i++
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// StatsWindow is the span over which OpStats.OpsPerSecond is averaged
+const StatsWindow = 10 * time.Second
+
+// OpStats counts the records applied to the database components and the bytes written
+// to the WAL since the persistence layer was opened. Records replayed from the WAL on
+// open count as applied.
+type OpStats struct {
+	Inserts      uint64  `json:"inserts"`        // insert records applied
+	Deletes      uint64  `json:"deletes"`        // delete records applied
+	WALBytes     uint64  `json:"wal_bytes"`      // bytes written to the WAL
+	OpsPerSecond float64 `json:"ops_per_second"` // inserts and deletes applied per second over StatsWindow
+}
+
+// opCounters maintains OpStats; the totals are atomic and the rate has its own lock
+type opCounters struct {
+	inserts  atomic.Uint64
+	deletes  atomic.Uint64
+	walBytes atomic.Uint64
+	rate     opRate
+}
+
+// countApplied adds the insert and delete records of an applied batch
+func (c *opCounters) countApplied(batch []WALRecord, now time.Time) {
+	var inserts, deletes uint64
+	for _, record := range batch {
+		switch record.Operation {
+		case Insert:
+			inserts++
+		case Delete:
+			deletes++
+		}
+	}
+	c.inserts.Add(inserts)
+	c.deletes.Add(deletes)
+	c.rate.add(now, inserts+deletes)
+}
+
+func (c *opCounters) snapshot(now time.Time) OpStats {
+	return OpStats{
+		Inserts:      c.inserts.Load(),
+		Deletes:      c.deletes.Load(),
+		WALBytes:     c.walBytes.Load(),
+		OpsPerSecond: c.rate.perSecond(now),
+	}
+}
+
+// windowSeconds is the number of one-second buckets in StatsWindow
+const windowSeconds = int(StatsWindow / time.Second)
+
+// opRate counts operations in one-second buckets over a sliding StatsWindow
+type opRate struct {
+	mu      sync.Mutex
+	counts  [windowSeconds]uint64
+	seconds [windowSeconds]int64 // Unix second each bucket counts
+}
+
+func (r *opRate) add(now time.Time, n uint64) {
+	if n == 0 {
+		return
+	}
+	sec := now.Unix()
+	i := int(sec % int64(windowSeconds))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seconds[i] != sec {
+		r.seconds[i] = sec
+		r.counts[i] = 0
+	}
+	r.counts[i] += n
+}
+
+// perSecond returns the operations of the buckets within the window ending at now,
+// averaged over the window
+func (r *opRate) perSecond(now time.Time) float64 {
+	sec := now.Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total uint64
+	for i, s := range r.seconds {
+		if s > sec-int64(windowSeconds) && s <= sec {
+			total += r.counts[i]
+		}
+	}
+	return float64(total) / StatsWindow.Seconds()
+}
+
+// walByteCounter counts the bytes written through it to the WAL file
+type walByteCounter struct {
+	w     io.Writer
+	count *atomic.Uint64
+}
+
+func (c walByteCounter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.count.Add(uint64(n))
+	return n, err
+}
+
+// PendingBytes estimates the memory held by the pending WAL records: the records
+// themselves, their vectors, and the keys and values of their docs and attributes.
+// Records taken by a Sync that is applying them are not counted.
+func (p *Persistence) PendingBytes() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var size uint64
+	for i := range p.pendingLogs {
+		record := &p.pendingLogs[i]
+		size += uint64(unsafe.Sizeof(*record)) + uint64(len(record.Vector))*4
+		size += valueBytes(record.Doc) + valueBytes(record.Attributes)
+	}
+	return size
+}
+
+// valueBytes roughly estimates the memory of a decoded JSON value: the length of strings
+// and map keys, and 8 bytes for every other scalar
+func valueBytes(value any) uint64 {
+	switch v := value.(type) {
+	case string:
+		return uint64(len(v))
+	case map[string]any:
+		var size uint64
+		for key, item := range v {
+			size += uint64(len(key)) + valueBytes(item)
+		}
+		return size
+	case []any:
+		var size uint64
+		for _, item := range v {
+			size += valueBytes(item)
+		}
+		return size
+	default:
+		return 8
+	}
+}
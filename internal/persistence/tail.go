@@ -0,0 +1,144 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// WALTailer follows a WAL file that another Persistence appends to, such as the WAL of
+// a primary database that a replica follows, returning the records appended since it
+// last read. It only returns whole atomic batches, and a record partly written at the
+// end of the file is left for a later read.
+//
+// The writer truncates its WAL after replaying it on restart and then appends to it
+// again. A file shorter than what was read, or a record where the last one ended that
+// does not decode or does not carry the next LogID, is taken for such a truncation, and
+// the file is read again from the start; LogIDs, which keep increasing across
+// truncations, tell the records already returned apart. A WALTailer is not safe for
+// concurrent use.
+type WALTailer struct {
+	path    string
+	encoder WALEncoder
+
+	// offset is where the last complete record read ended
+	offset int64
+	// lastLogID is the highest LogID returned
+	lastLogID uint64
+}
+
+// NewWALTailer returns a WALTailer that reads the WAL at path from its start
+func NewWALTailer(path string, encoder WALEncoder) *WALTailer {
+	return &WALTailer{path: path, encoder: encoder}
+}
+
+// Next returns the records appended to the WAL since the last call, in order. It returns
+// no records and no error if there are none, and an error if the file cannot be read or
+// holds a record that does not decode.
+func (t *WALTailer) Next() ([]WALRecord, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	if stat.Size() < t.offset {
+		slog.Info("WAL was truncated, reading it from the start", "file", t.path)
+		t.offset = 0
+	}
+
+	records, err := t.read(file)
+	if err != nil && len(records) == 0 && t.offset > 0 {
+		slog.Info("WAL does not continue where it was last read, reading it from the start", "file", t.path, "error", err)
+		t.offset = 0
+		records, err = t.read(file)
+	}
+	return records, err
+}
+
+// read decodes the complete records from offset on and advances offset past them,
+// returning those not returned before along with the decode error that stopped it, if any
+func (t *WALTailer) read(file *os.File) ([]WALRecord, error) {
+	if _, err := file.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek WAL file: %w", err)
+	}
+
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+
+	records := make([]WALRecord, 0)
+	complete := 0
+	completeOffset := t.offset
+	batchRemaining := uint64(0)
+
+	start := t.offset
+	var decodeErr error
+	for {
+		record, err := t.encoder.DecodeRecord(reader)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			decodeErr = err
+			break
+		}
+
+		// A record that does not follow the last one returned was written after a truncation
+		if len(records) == 0 && start > 0 && record.LogID != t.lastLogID+1 {
+			return nil, fmt.Errorf("%w: record %d follows record %d", errWALDiscontinuity, record.LogID, t.lastLogID)
+		}
+
+		records = append(records, *record)
+		if record.Operation == Begin {
+			batchRemaining = record.VectorID
+		} else if batchRemaining > 0 {
+			batchRemaining--
+		}
+
+		// Records are only taken once no atomic batch is left open
+		if batchRemaining == 0 {
+			complete = len(records)
+			completeOffset = t.offset + counter.n - int64(reader.Buffered())
+		}
+	}
+	t.offset = completeOffset
+
+	// A batch is either returned whole or not at all, so skipping by LogID never splits one
+	fresh := make([]WALRecord, 0, complete)
+	for _, record := range records[:complete] {
+		if record.LogID > t.lastLogID {
+			fresh = append(fresh, record)
+		}
+	}
+	for _, record := range fresh {
+		t.lastLogID = max(t.lastLogID, record.LogID)
+	}
+
+	if decodeErr != nil {
+		return fresh, fmt.Errorf("failed to decode WAL record: %w", decodeErr)
+	}
+	return fresh, nil
+}
+
+// errWALDiscontinuity is returned by WALTailer.read when the record at its offset does
+// not follow the last record returned
+var errWALDiscontinuity = fmt.Errorf("WAL does not continue from the last record read")
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,131 @@
+package persistence
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendWALRecords appends encoded records to the WAL file at path
+func appendWALRecords(t *testing.T, path string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatalf("Failed to write WAL: %v", err)
+	}
+}
+
+// encodeWALRecords encodes records one after the other
+func encodeWALRecords(t *testing.T, encoder WALEncoder, records ...WALRecord) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for i := range records {
+		if err := encoder.EncodeRecord(&buf, &records[i]); err != nil {
+			t.Fatalf("Failed to encode record: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func insertRecord(logID uint64) WALRecord {
+	return WALRecord{LogID: logID, Version: WALVersion, Operation: Insert, VectorID: logID, Vector: []float32{float32(logID)}}
+}
+
+func tailedLogIDs(t *testing.T, tailer *WALTailer) []uint64 {
+	t.Helper()
+	records, err := tailer.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	logIDs := make([]uint64, len(records))
+	for i, record := range records {
+		logIDs[i] = record.LogID
+	}
+	return logIDs
+}
+
+func TestWALTailer(t *testing.T) {
+	encoder := NewBinaryWALEncoder(WALVersion)
+	path := filepath.Join(t.TempDir(), "vdb.log")
+	tailer := NewWALTailer(path, encoder)
+
+	if _, err := tailer.Next(); err == nil {
+		t.Fatal("Expected an error for a missing WAL")
+	}
+
+	appendWALRecords(t, path, encodeWALRecords(t, encoder, insertRecord(1), insertRecord(2)))
+	if got := tailedLogIDs(t, tailer); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Expected records [1 2], got %v", got)
+	}
+	if got := tailedLogIDs(t, tailer); len(got) != 0 {
+		t.Errorf("Expected no new records, got %v", got)
+	}
+
+	// A record partly written is left for a later read
+	third := encodeWALRecords(t, encoder, insertRecord(3))
+	appendWALRecords(t, path, third[:len(third)/2])
+	if got := tailedLogIDs(t, tailer); len(got) != 0 {
+		t.Errorf("Expected no records while the third is incomplete, got %v", got)
+	}
+	appendWALRecords(t, path, third[len(third)/2:])
+	if got := tailedLogIDs(t, tailer); len(got) != 1 || got[0] != 3 {
+		t.Errorf("Expected record [3], got %v", got)
+	}
+
+	// An atomic batch is only returned once all its records are written
+	begin := WALRecord{LogID: 4, Version: WALVersion, Operation: Begin, VectorID: 2}
+	appendWALRecords(t, path, encodeWALRecords(t, encoder, begin, insertRecord(5)))
+	if got := tailedLogIDs(t, tailer); len(got) != 0 {
+		t.Errorf("Expected no records while the batch is incomplete, got %v", got)
+	}
+	appendWALRecords(t, path, encodeWALRecords(t, encoder, insertRecord(6)))
+	if got := tailedLogIDs(t, tailer); len(got) != 3 || got[0] != 4 || got[2] != 6 {
+		t.Errorf("Expected records [4 5 6], got %v", got)
+	}
+}
+
+func TestWALTailerTruncation(t *testing.T) {
+	encoder := NewBinaryWALEncoder(WALVersion)
+
+	t.Run("shorter", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "vdb.log")
+		tailer := NewWALTailer(path, encoder)
+
+		appendWALRecords(t, path, encodeWALRecords(t, encoder, insertRecord(1), insertRecord(2), insertRecord(3)))
+		if got := tailedLogIDs(t, tailer); len(got) != 3 {
+			t.Fatalf("Expected 3 records, got %v", got)
+		}
+
+		if err := os.Truncate(path, 0); err != nil {
+			t.Fatalf("Failed to truncate WAL: %v", err)
+		}
+		appendWALRecords(t, path, encodeWALRecords(t, encoder, insertRecord(4)))
+		if got := tailedLogIDs(t, tailer); len(got) != 1 || got[0] != 4 {
+			t.Errorf("Expected record [4] after truncation, got %v", got)
+		}
+	})
+
+	t.Run("regrown", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "vdb.log")
+		tailer := NewWALTailer(path, encoder)
+
+		appendWALRecords(t, path, encodeWALRecords(t, encoder, insertRecord(1), insertRecord(2)))
+		if got := tailedLogIDs(t, tailer); len(got) != 2 {
+			t.Fatalf("Expected 2 records, got %v", got)
+		}
+
+		// The WAL grew past the last read position again before the tailer noticed
+		if err := os.Truncate(path, 0); err != nil {
+			t.Fatalf("Failed to truncate WAL: %v", err)
+		}
+		appendWALRecords(t, path, encodeWALRecords(t, encoder, insertRecord(3), insertRecord(4), insertRecord(5)))
+		if got := tailedLogIDs(t, tailer); len(got) != 3 || got[0] != 3 || got[2] != 5 {
+			t.Errorf("Expected records [3 4 5] after truncation, got %v", got)
+		}
+	})
+}
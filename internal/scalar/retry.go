@@ -0,0 +1,176 @@
+package scalar
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"vecdb-go/internal/common"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+// RetryPolicy bounds the retries of scalar storage operations that fail with a transient
+// error. Every operation is one NutsDB transaction, which is rolled back on failure, so
+// retrying it never applies a write twice.
+type RetryPolicy struct {
+	MaxAttempts    int           // attempts including the first; 1 or less disables retries
+	InitialBackoff time.Duration // wait before the first retry, doubled before each further one
+	MaxElapsed     time.Duration // no retry starts once this much time has passed; 0 means no limit
+	Retryable      []error       // transient errors, matched with errors.Is
+}
+
+// DefaultRetryPolicy retries operations that collide with a NutsDB merge (compaction).
+// Reads and writes wait for a running merge instead of failing, so the collision it
+// retries is a Merge overlapping the one NutsDB runs on its own schedule, which fails
+// with ErrIsMerging.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxElapsed:     time.Second,
+	Retryable:      []error{nutsdb.ErrIsMerging},
+}
+
+// retryingStorage retries the operations of the wrapped storage according to its policy
+type retryingStorage struct {
+	storage ScalarStorage
+	policy  RetryPolicy
+}
+
+var _ ScalarStorage = (*retryingStorage)(nil)
+
+// NewRetryingStorage wraps storage so that operations failing with one of the policy's
+// retryable errors are retried with exponential backoff. Other errors, such as invalid
+// arguments or corrupted data, are returned at once; missing keys are not errors at all.
+func NewRetryingStorage(storage ScalarStorage, policy RetryPolicy) ScalarStorage {
+	return &retryingStorage{storage: storage, policy: policy}
+}
+
+// retry runs op until it succeeds, fails with an error that is not retryable, or the
+// policy's attempts or time run out, and returns its last error
+func (s *retryingStorage) retry(name string, op func() error) error {
+	start := time.Now()
+	backoff := s.policy.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !s.retryable(err) || attempt >= s.policy.MaxAttempts {
+			return err
+		}
+		if s.policy.MaxElapsed > 0 && time.Since(start)+backoff > s.policy.MaxElapsed {
+			return err
+		}
+
+		slog.Warn("Retrying scalar storage operation", "operation", name, "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// retryable reports whether err matches one of the policy's transient errors
+func (s *retryingStorage) retryable(err error) bool {
+	for _, target := range s.policy.Retryable {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *retryingStorage) Put(namespace string, key []byte, value []byte) error {
+	return s.retry("put", func() error {
+		return s.storage.Put(namespace, key, value)
+	})
+}
+
+func (s *retryingStorage) MultiPut(namespace string, keys [][]byte, values [][]byte) error {
+	return s.retry("multi-put", func() error {
+		return s.storage.MultiPut(namespace, keys, values)
+	})
+}
+
+func (s *retryingStorage) Delete(namespace string, key []byte) error {
+	return s.retry("delete", func() error {
+		return s.storage.Delete(namespace, key)
+	})
+}
+
+func (s *retryingStorage) MultiDelete(namespace string, keys [][]byte) error {
+	return s.retry("multi-delete", func() error {
+		return s.storage.MultiDelete(namespace, keys)
+	})
+}
+
+func (s *retryingStorage) Get(namespace string, key []byte) ([]byte, error) {
+	var value []byte
+	err := s.retry("get", func() error {
+		var err error
+		value, err = s.storage.Get(namespace, key)
+		return err
+	})
+	return value, err
+}
+
+func (s *retryingStorage) GetValue(namespace string, id uint64) (common.DocMap, error) {
+	var doc common.DocMap
+	err := s.retry("get", func() error {
+		var err error
+		doc, err = s.storage.GetValue(namespace, id)
+		return err
+	})
+	return doc, err
+}
+
+func (s *retryingStorage) MultiGetValue(namespace string, ids []uint64) ([]common.DocMap, error) {
+	var docs []common.DocMap
+	err := s.retry("multi-get", func() error {
+		var err error
+		docs, err = s.storage.MultiGetValue(namespace, ids)
+		return err
+	})
+	return docs, err
+}
+
+func (s *retryingStorage) GenIncrIDs(namespace string, count int, maxID uint64) ([]uint64, error) {
+	var ids []uint64
+	err := s.retry("generate IDs", func() error {
+		var err error
+		ids, err = s.storage.GenIncrIDs(namespace, count, maxID)
+		return err
+	})
+	return ids, err
+}
+
+func (s *retryingStorage) EnsureIDMax(namespace string, id uint64) error {
+	return s.retry("ensure ID max", func() error {
+		return s.storage.EnsureIDMax(namespace, id)
+	})
+}
+
+func (s *retryingStorage) Iterator(namespace string) (ScalarIterator, error) {
+	var iter ScalarIterator
+	err := s.retry("iterate", func() error {
+		var err error
+		iter, err = s.storage.Iterator(namespace)
+		return err
+	})
+	return iter, err
+}
+
+func (s *retryingStorage) RangeIterator(namespace string, start []byte, end []byte, limit int) (ScalarIterator, error) {
+	var iter ScalarIterator
+	err := s.retry("range scan", func() error {
+		var err error
+		iter, err = s.storage.RangeIterator(namespace, start, end, limit)
+		return err
+	})
+	return iter, err
+}
+
+func (s *retryingStorage) Merge() error {
+	return s.retry("merge", s.storage.Merge)
+}
+
+func (s *retryingStorage) Close() error {
+	return s.storage.Close()
+}
@@ -0,0 +1,133 @@
+package scalar
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+// flakyStorage fails its first failures Put calls with err and then succeeds
+type flakyStorage struct {
+	ScalarStorage
+	err      error
+	failures int
+	calls    int
+	values   map[string][]byte
+}
+
+func (s *flakyStorage) Put(namespace string, key []byte, value []byte) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.err
+	}
+	s.values[string(key)] = value
+	return nil
+}
+
+func TestRetryingStorage(t *testing.T) {
+	transient := fmt.Errorf("failed to put key-value: %w", nutsdb.ErrIsMerging)
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Retryable:      []error{nutsdb.ErrIsMerging},
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		failures  int
+		policy    RetryPolicy
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "transient error once", err: transient, failures: 1, policy: policy, wantCalls: 2},
+		{name: "logical error", err: errors.New("invalid key"), failures: 1, policy: policy, wantErr: true, wantCalls: 1},
+		{name: "attempts exhausted", err: transient, failures: 5, policy: policy, wantErr: true, wantCalls: 3},
+		{
+			name:      "time exhausted",
+			err:       transient,
+			failures:  1,
+			policy:    RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxElapsed: 10 * time.Millisecond, Retryable: policy.Retryable},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &flakyStorage{err: tt.err, failures: tt.failures, values: map[string][]byte{}}
+			storage := NewRetryingStorage(fake, tt.policy)
+
+			err := storage.Put(NamespaceDocs, []byte("key"), []byte("value"))
+			if tt.wantErr {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Expected error %v, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected Put to succeed after retrying, got %v", err)
+			}
+			if fake.calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, fake.calls)
+			}
+			if !tt.wantErr && string(fake.values["key"]) != "value" {
+				t.Errorf("Expected value to be stored, got %q", fake.values["key"])
+			}
+		})
+	}
+}
+
+func TestOperationsDuringMerge(t *testing.T) {
+	// Reads and writes wait for a running merge rather than fail, so they need no retries
+	db, err := NewScalarStorage(&ScalarOption{
+		DIR:         t.TempDir(),
+		Buckets:     []string{NamespaceDocs},
+		Retry:       &RetryPolicy{MaxAttempts: 1},
+		SegmentSize: 64 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer db.Close()
+
+	value := make([]byte, 512)
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(NamespaceDocs, EncodeID(uint64(i%100)), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	failed := make(chan error, 1)
+	go func() {
+		defer close(failed)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := EncodeID(uint64(i % 100))
+			if err := db.Put(NamespaceDocs, key, value); err != nil {
+				failed <- err
+				return
+			}
+			if _, err := db.Get(NamespaceDocs, key); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := db.Merge(); err != nil {
+			t.Errorf("Merge failed: %v", err)
+		}
+	}
+	close(stop)
+	if err := <-failed; err != nil {
+		t.Errorf("Expected operations during a merge to succeed without retries, got %v", err)
+	}
+}
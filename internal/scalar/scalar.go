@@ -1,8 +1,9 @@
 package scalar
 
 import (
+	"bytes"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"iter"
 	"log/slog"
@@ -13,11 +14,26 @@ import (
 
 const (
 	NamespaceDocs = "docs"
+	// NamespaceWals is reserved; the WAL is kept in its own file rather than in a bucket
 	NamespaceWals = "wals"
+	// NamespaceTombstones holds a key per ID marked by a soft delete
+	NamespaceTombstones = "tombstones"
+
+	// idKeyLen is the length of keys produced by EncodeID
+	idKeyLen = 8
 )
 
 var (
+	// keyIDMax holds the ID counter of GenIncrIDs inside the bucket it counts for, so
+	// every bucket has its own counter
 	keyIDMax = []byte("__id_max__")
+
+	ErrMalformedIDKey = fmt.Errorf("malformed ID key")
+	// ErrBucketNotFound is returned by operations on a namespace the storage was not
+	// opened with
+	ErrBucketNotFound = fmt.Errorf("bucket not found")
+	// ErrIDsExhausted is returned by GenIncrIDs when the IDs would exceed its bound
+	ErrIDsExhausted = fmt.Errorf("IDs exhausted")
 )
 
 type KVPair[T any] struct {
@@ -33,59 +49,107 @@ type ScalarStorage interface {
 	// Put stores a key-value pair in the specified namespace
 	Put(namespace string, key []byte, value []byte) error
 
+	// MultiPut stores multiple key-value pairs in the specified namespace in one transaction
+	MultiPut(namespace string, keys [][]byte, values [][]byte) error
+
+	// Delete removes a key from the specified namespace; missing keys are ignored
+	Delete(namespace string, key []byte) error
+
+	// MultiDelete removes multiple keys from the specified namespace in one transaction; missing keys are ignored
+	MultiDelete(namespace string, keys [][]byte) error
+
 	// Get retrieves a value by key from the specified namespace
 	Get(namespace string, key []byte) ([]byte, error)
 
 	// GetValue retrieves a document by ID from the specified namespace
 	GetValue(namespace string, id uint64) (common.DocMap, error)
 
-	// MultiGetValue retrieves multiple documents by IDs from the specified namespace
+	// MultiGetValue retrieves multiple documents by IDs from the specified namespace,
+	// in the order of ids, with nil for IDs that do not exist
 	MultiGetValue(namespace string, ids []uint64) ([]common.DocMap, error)
 
-	// GenIncrIDs generates a sequence of unique IDs for a namespace
-	GenIncrIDs(namespace string, count int) ([]uint64, error)
+	// GenIncrIDs generates a sequence of unique IDs for a namespace, none above maxID
+	// unless it is zero; if they would exceed it, no ID is consumed
+	GenIncrIDs(namespace string, count int, maxID uint64) ([]uint64, error)
+
+	// EnsureIDMax raises the ID counter of a namespace to at least id; it never lowers it
+	EnsureIDMax(namespace string, id uint64) error
 
 	// Iterator returns an iterator for all key-value pairs in the specified namespace
 	Iterator(namespace string) (ScalarIterator, error)
 
+	// RangeIterator returns an iterator for the first limit key-value pairs with
+	// start <= key <= end in the specified namespace, in key order; a limit of zero or
+	// less returns all of them
+	RangeIterator(namespace string, start []byte, end []byte, limit int) (ScalarIterator, error)
+
+	// Merge rewrites the data files without the entries of deleted and overwritten keys,
+	// reclaiming their disk space; it does nothing while there is a single data file
+	Merge() error
+
 	// Close closes the database
 	Close() error
 }
 
 type ScalarOption struct {
-	DIR     string   `toml:"dir"`
+	DIR string `toml:"dir"`
+	// Buckets are the namespaces the storage serves; the ones the database files lack
+	// are created on open, and operations on any other namespace fail with ErrBucketNotFound
 	Buckets []string `toml:"buckets"`
+	// Retry bounds the retries of operations failing with transient NutsDB errors,
+	// defaulting to DefaultRetryPolicy
+	Retry *RetryPolicy `toml:"-"`
+	// SegmentSize is the size of each NutsDB data file, defaulting to DefaultSegmentSize.
+	// Merge only reclaims space once there is more than one file.
+	SegmentSize int64 `toml:"-"`
 }
 
+// DefaultSegmentSize is the size of each NutsDB data file unless ScalarOption sets another
+const DefaultSegmentSize = 64 * 1024 * 1024
+
 type ScalarIterator KVIterator[[]byte]
 
 // nutsDBStorage implements ScalarStorage using NutsDB
 type nutsDBStorage struct {
-	db *nutsdb.DB
+	db      *nutsdb.DB
+	buckets map[string]struct{}
 }
 
 var _ ScalarStorage = (*nutsDBStorage)(nil)
 
-// NewScalarStorage creates a new NutsDB-based scalar storage
+// NewScalarStorage creates a new NutsDB-based scalar storage, retrying operations that
+// fail with a transient error according to opts.Retry
 // This matches the new_scalar_storage function in Rust
 func NewScalarStorage(opts *ScalarOption) (ScalarStorage, error) {
 	nutsdbOpts := nutsdb.DefaultOptions
 	nutsdbOpts.Dir = opts.DIR
 	nutsdbOpts.EntryIdxMode = nutsdb.HintKeyValAndRAMIdxMode // Better performance for key-value operations
-	nutsdbOpts.SegmentSize = 64 * 1024 * 1024                // 64MB segments
+	nutsdbOpts.SegmentSize = DefaultSegmentSize
+	if opts.SegmentSize > 0 {
+		nutsdbOpts.SegmentSize = opts.SegmentSize
+	}
+
+	buckets := make(map[string]struct{}, len(opts.Buckets))
+	for _, bucket := range opts.Buckets {
+		if bucket == "" {
+			return nil, fmt.Errorf("bucket name must not be empty")
+		}
+		buckets[bucket] = struct{}{}
+	}
 
 	db, err := nutsdb.Open(nutsdbOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open nutsdb: %w. Config: %+v", err, nutsdbOpts)
 	}
 
-	for _, bucket := range opts.Buckets {
+	for bucket := range buckets {
 		if err = db.Update(func(tx *nutsdb.Tx) error {
 			exists := tx.ExistBucket(nutsdb.DataStructureBTree, bucket)
 			if exists {
 				return nil
 			}
 
+			slog.Info("Creating scalar storage bucket", "bucket", bucket)
 			err := tx.NewBucket(nutsdb.DataStructureBTree, bucket)
 			return err
 		}); err != nil {
@@ -95,14 +159,32 @@ func NewScalarStorage(opts *ScalarOption) (ScalarStorage, error) {
 	}
 
 	storage := &nutsDBStorage{
-		db: db,
+		db:      db,
+		buckets: buckets,
 	}
 
-	return storage, nil
+	policy := DefaultRetryPolicy
+	if opts.Retry != nil {
+		policy = *opts.Retry
+	}
+
+	return NewRetryingStorage(storage, policy), nil
+}
+
+// checkBucket returns ErrBucketNotFound if the storage was not opened with namespace
+func (s *nutsDBStorage) checkBucket(namespace string) error {
+	if _, ok := s.buckets[namespace]; !ok {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, namespace)
+	}
+	return nil
 }
 
 // Put stores a key-value pair in the specified namespace
 func (s *nutsDBStorage) Put(namespace string, key []byte, value []byte) error {
+	if err := s.checkBucket(namespace); err != nil {
+		return err
+	}
+
 	err := s.db.Update(func(tx *nutsdb.Tx) error {
 		return tx.Put(namespace, key, value, 0) // 0 means no TTL
 	})
@@ -113,8 +195,74 @@ func (s *nutsDBStorage) Put(namespace string, key []byte, value []byte) error {
 	return nil
 }
 
+// MultiPut stores multiple key-value pairs in the specified namespace in one transaction
+func (s *nutsDBStorage) MultiPut(namespace string, keys [][]byte, values [][]byte) error {
+	if err := s.checkBucket(namespace); err != nil {
+		return err
+	}
+
+	if len(keys) != len(values) {
+		return fmt.Errorf("keys and values length mismatch: %d != %d", len(keys), len(values))
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *nutsdb.Tx) error {
+		for i, key := range keys {
+			if err := tx.Put(namespace, key, values[i], 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to multi-put key-values: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes a key from the specified namespace; missing keys are ignored
+func (s *nutsDBStorage) Delete(namespace string, key []byte) error {
+	if err := s.checkBucket(namespace); err != nil {
+		return err
+	}
+	return s.MultiDelete(namespace, [][]byte{key})
+}
+
+// MultiDelete removes multiple keys from the specified namespace in one transaction; missing keys are ignored
+func (s *nutsDBStorage) MultiDelete(namespace string, keys [][]byte) error {
+	if err := s.checkBucket(namespace); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *nutsdb.Tx) error {
+		for _, key := range keys {
+			if err := tx.Delete(namespace, key); err != nil && err != nutsdb.ErrKeyNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete keys: %w", err)
+	}
+
+	return nil
+}
+
 // Get retrieves a value by key from the specified namespace
 func (s *nutsDBStorage) Get(namespace string, key []byte) ([]byte, error) {
+	if err := s.checkBucket(namespace); err != nil {
+		return nil, err
+	}
+
 	var value []byte
 
 	err := s.db.View(func(tx *nutsdb.Tx) error {
@@ -149,11 +297,16 @@ func (s *nutsDBStorage) GetValue(namespace string, id uint64) (common.DocMap, er
 		return nil, nil
 	}
 
-	return common.JSONUnmarshal[common.DocMap](data)
+	return common.DecodeDoc(data)
 }
 
-// MultiGetValue retrieves multiple documents by IDs from the specified namespace
+// MultiGetValue retrieves multiple documents by IDs from the specified namespace,
+// in the order of ids, with nil for IDs that do not exist
 func (s *nutsDBStorage) MultiGetValue(namespace string, ids []uint64) ([]common.DocMap, error) {
+	if err := s.checkBucket(namespace); err != nil {
+		return nil, err
+	}
+
 	results := make([]common.DocMap, 0, len(ids))
 
 	// NutsDB doesn't have native multi-get, so we do multiple single gets in one transaction
@@ -163,14 +316,14 @@ func (s *nutsDBStorage) MultiGetValue(namespace string, ids []uint64) ([]common.
 			entry, err := tx.Get(namespace, key)
 			if err != nil {
 				if err == nutsdb.ErrKeyNotFound {
-					// Return empty map for missing entries
-					results = append(results, common.DocMap{})
+					// nil rather than an empty map, so a missing doc is told apart from an empty one
+					results = append(results, nil)
 					continue
 				}
 				return err
 			}
 
-			doc, err := common.JSONUnmarshal[common.DocMap](entry)
+			doc, err := common.DecodeDoc(entry)
 			if err != nil {
 				return fmt.Errorf("failed to deserialize doc for id %d: %w", id, err)
 			}
@@ -186,37 +339,39 @@ func (s *nutsDBStorage) MultiGetValue(namespace string, ids []uint64) ([]common.
 	return results, nil
 }
 
-// GenIncrIDs generates a sequence of unique IDs for a namespace
-func (s *nutsDBStorage) GenIncrIDs(namespace string, count int) ([]uint64, error) {
+// GenIncrIDs generates a sequence of unique IDs for a namespace.
+// The counter is monotonic: IDs of deleted documents are never reused, and nothing
+// but EnsureIDMax writes the counter, so it survives restarts, deletes and restores.
+// A future reset or drop of a namespace must keep the counter rather than delete it.
+// IDs above a non-zero maxID fail with ErrIDsExhausted and leave the counter unchanged.
+func (s *nutsDBStorage) GenIncrIDs(namespace string, count int, maxID uint64) ([]uint64, error) {
+	if err := s.checkBucket(namespace); err != nil {
+		return nil, err
+	}
+
 	var ids []uint64
 
 	err := s.db.Update(func(tx *nutsdb.Tx) error {
 		maxIDKey := []byte(keyIDMax)
 
 		// Get current max ID
-		var maxID uint64
-		entry, err := tx.Get(namespace, maxIDKey)
+		currentID, err := getIDMax(tx, namespace)
 		if err != nil {
-			if err == nutsdb.ErrKeyNotFound {
-				maxID = 0
-			} else {
-				return fmt.Errorf("failed to get max id: %w", err)
-			}
-		} else {
-			maxID = binary.BigEndian.Uint64(entry)
+			return err
+		}
+		if maxID > 0 && (currentID > maxID || uint64(count) > maxID-currentID) {
+			return fmt.Errorf("%w: %d more IDs after %d exceed %d", ErrIDsExhausted, count, currentID, maxID)
 		}
 
 		// Generate new IDs
-		newMaxID := maxID + uint64(count)
+		newMaxID := currentID + uint64(count)
 		ids = make([]uint64, count)
 		for i := 0; i < count; i++ {
-			ids[i] = maxID + uint64(i) + 1
+			ids[i] = currentID + uint64(i) + 1
 		}
 
 		// Update max ID
-		newMaxIDBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(newMaxIDBytes, newMaxID)
-		err = tx.Put(namespace, maxIDKey, newMaxIDBytes, 0)
+		err = tx.Put(namespace, maxIDKey, EncodeID(newMaxID), 0)
 		if err != nil {
 			return fmt.Errorf("failed to update max id: %w", err)
 		}
@@ -231,6 +386,58 @@ func (s *nutsDBStorage) GenIncrIDs(namespace string, count int) ([]uint64, error
 	return ids, nil
 }
 
+// EnsureIDMax raises the ID counter of a namespace to at least id; it never lowers it
+func (s *nutsDBStorage) EnsureIDMax(namespace string, id uint64) error {
+	if err := s.checkBucket(namespace); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		maxID, err := getIDMax(tx, namespace)
+		if err != nil {
+			return err
+		}
+
+		if maxID >= id {
+			return nil
+		}
+
+		slog.Info("Raising ID counter", "namespace", namespace, "from", maxID, "to", id)
+		if err := tx.Put(namespace, keyIDMax, EncodeID(id), 0); err != nil {
+			return fmt.Errorf("failed to update max id: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// getIDMax reads the ID counter of a namespace, or 0 if it was never written
+func getIDMax(tx *nutsdb.Tx, namespace string) (uint64, error) {
+	entry, err := tx.Get(namespace, keyIDMax)
+	if err != nil {
+		if err == nutsdb.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get max id: %w", err)
+	}
+
+	// A corrupted counter must not silently restart IDs from 0
+	maxID, err := DecodeIDChecked(entry)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode max id: %w", err)
+	}
+
+	return maxID, nil
+}
+
+// Merge merges the NutsDB data files; a database with a single file needs no merge
+func (s *nutsDBStorage) Merge() error {
+	if err := s.db.Merge(); err != nil && !errors.Is(err, nutsdb.ErrDontNeedMerge) {
+		return fmt.Errorf("failed to merge: %w", err)
+	}
+	return nil
+}
+
 // Close closes the database
 func (s *nutsDBStorage) Close() error {
 	return s.db.Close()
@@ -238,6 +445,10 @@ func (s *nutsDBStorage) Close() error {
 
 // Iterator returns an iterator for all key-value pairs in the specified namespace
 func (s *nutsDBStorage) Iterator(namespace string) (ScalarIterator, error) {
+	if err := s.checkBucket(namespace); err != nil {
+		return nil, err
+	}
+
 	// Create a snapshot of all entries
 	var keys [][]byte
 	var values [][]byte
@@ -263,21 +474,81 @@ func (s *nutsDBStorage) Iterator(namespace string) (ScalarIterator, error) {
 	}, nil
 }
 
+// RangeIterator returns an iterator for the first limit key-value pairs with
+// start <= key <= end in the specified namespace, in key order; a limit of zero or less
+// returns all of them
+func (s *nutsDBStorage) RangeIterator(namespace string, start []byte, end []byte, limit int) (ScalarIterator, error) {
+	if err := s.checkBucket(namespace); err != nil {
+		return nil, err
+	}
+
+	// Create a snapshot of the entries in range, seeking to start so that only the
+	// values returned are read
+	var keys [][]byte
+	var values [][]byte
+
+	err := s.db.View(func(tx *nutsdb.Tx) error {
+		iter := nutsdb.NewIterator(tx, namespace, nutsdb.IteratorOptions{})
+		if iter == nil {
+			// Nothing stored in the namespace yet
+			return nil
+		}
+		defer iter.Release()
+
+		for ok := iter.Seek(start); ok && (limit <= 0 || len(keys) < limit); ok = iter.Next() {
+			key := iter.Key()
+			if bytes.Compare(key, end) > 0 {
+				break
+			}
+			value, err := iter.Value()
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan range: %w", err)
+	}
+
+	return func(yield func(KVPair[[]byte]) bool) {
+		for i, k := range keys {
+			if !yield(KVPair[[]byte]{Key: k, Value: values[i]}) {
+				return
+			}
+		}
+	}, nil
+}
+
 // EncodeID converts a uint64 ID to a byte slice key
 func EncodeID(id uint64) []byte {
-	key := make([]byte, 8)
+	key := make([]byte, idKeyLen)
 	binary.BigEndian.PutUint64(key, id)
 	return key
 }
 
-// DecodeID converts a byte slice key to uint64 ID
+// DecodeID converts a byte slice key to uint64 ID without validation.
+// Keys shorter than 8 bytes decode to 0, which cannot be told apart from a real ID,
+// so only use it on keys known to come from EncodeID and prefer DecodeIDChecked otherwise.
 func DecodeID(key []byte) uint64 {
-	if len(key) < 8 {
+	if len(key) < idKeyLen {
 		return 0
 	}
 	return binary.BigEndian.Uint64(key)
 }
 
+// DecodeIDChecked converts a key produced by EncodeID back to its ID,
+// returning ErrMalformedIDKey if the key is not exactly 8 bytes
+func DecodeIDChecked(key []byte) (uint64, error) {
+	if len(key) != idKeyLen {
+		return 0, fmt.Errorf("%w: expected %d bytes, got %d", ErrMalformedIDKey, idKeyLen, len(key))
+	}
+	return binary.BigEndian.Uint64(key), nil
+}
+
 // DebugPrintDB prints all entries in the database for debugging
 func DebugPrintDB(s ScalarStorage, namespace string) error {
 	iter, err := s.Iterator(namespace)
@@ -291,16 +562,15 @@ func DebugPrintDB(s ScalarStorage, namespace string) error {
 
 		// Check if this is the special max ID key
 		if string(key) == string(keyIDMax) {
-			maxID := binary.BigEndian.Uint64(value)
-			slog.Debug("[SPECIAL]", "key", string(keyIDMax), "value", maxID)
-			continue
+			if maxID, err := DecodeIDChecked(value); err == nil {
+				slog.Debug("[SPECIAL]", "key", string(keyIDMax), "value", maxID)
+				continue
+			}
 		}
 
 		// Try to decode as ID
-		if len(key) == 8 {
-			id := DecodeID(key)
-			var doc common.DocMap
-			err := json.Unmarshal(value, &doc)
+		if id, err := DecodeIDChecked(key); err == nil {
+			doc, err := common.DecodeDoc(value)
 			if err == nil {
 				slog.Debug("[DOC]", "id", id, "value", doc)
 				continue
@@ -2,6 +2,7 @@ package scalar
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -139,10 +140,10 @@ func TestGetValueAndMultiGetValue(t *testing.T) {
 		t.Fatalf("MultiGetValue with non-existent ID failed: %v", err)
 	}
 	if len(docs) != 3 {
-		t.Errorf("Expected 3 documents (with empty map), got %d", len(docs))
+		t.Errorf("Expected 3 documents (with nil), got %d", len(docs))
 	}
-	if len(docs[1]) != 0 {
-		t.Errorf("Expected empty map for non-existent ID, got %v", docs[1])
+	if docs[1] != nil {
+		t.Errorf("Expected nil for non-existent ID, got %v", docs[1])
 	}
 }
 
@@ -151,7 +152,7 @@ func TestGenIncrIDs(t *testing.T) {
 	defer teardownTestDB(db, tmpDir)
 
 	// Test generating IDs from scratch
-	ids1, err := db.GenIncrIDs(NamespaceDocs, 5)
+	ids1, err := db.GenIncrIDs(NamespaceDocs, 5, 0)
 	if err != nil {
 		t.Fatalf("GenIncrIDs failed: %v", err)
 	}
@@ -168,7 +169,7 @@ func TestGenIncrIDs(t *testing.T) {
 	}
 
 	// Generate more IDs - should continue from where we left off
-	ids2, err := db.GenIncrIDs(NamespaceDocs, 3)
+	ids2, err := db.GenIncrIDs(NamespaceDocs, 3, 0)
 	if err != nil {
 		t.Fatalf("Second GenIncrIDs failed: %v", err)
 	}
@@ -185,6 +186,63 @@ func TestGenIncrIDs(t *testing.T) {
 	}
 }
 
+func TestEnsureIDMax(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	defer teardownTestDB(db, tmpDir)
+
+	if _, err := db.GenIncrIDs(NamespaceDocs, 5, 0); err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+
+	// A lower value must not move the counter backwards
+	if err := db.EnsureIDMax(NamespaceDocs, 2); err != nil {
+		t.Fatalf("EnsureIDMax failed: %v", err)
+	}
+	ids, err := db.GenIncrIDs(NamespaceDocs, 1, 0)
+	if err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+	if ids[0] != 6 {
+		t.Errorf("Expected ID 6 after lower EnsureIDMax, got %d", ids[0])
+	}
+
+	// A higher value raises the counter
+	if err := db.EnsureIDMax(NamespaceDocs, 10); err != nil {
+		t.Fatalf("EnsureIDMax failed: %v", err)
+	}
+	ids, err = db.GenIncrIDs(NamespaceDocs, 1, 0)
+	if err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+	if ids[0] != 11 {
+		t.Errorf("Expected ID 11 after raising the counter, got %d", ids[0])
+	}
+}
+
+func TestGenIncrIDsBound(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	defer teardownTestDB(db, tmpDir)
+
+	if _, err := db.GenIncrIDs(NamespaceDocs, 3, 5); err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+
+	// IDs past the bound are rejected and leave the counter where it was
+	if _, err := db.GenIncrIDs(NamespaceDocs, 3, 5); !errors.Is(err, ErrIDsExhausted) {
+		t.Fatalf("Expected ErrIDsExhausted, got %v", err)
+	}
+	ids, err := db.GenIncrIDs(NamespaceDocs, 2, 5)
+	if err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+	if fmt.Sprint(ids) != "[4 5]" {
+		t.Errorf("Expected IDs [4 5] up to the bound, got %v", ids)
+	}
+	if _, err := db.GenIncrIDs(NamespaceDocs, 1, 5); !errors.Is(err, ErrIDsExhausted) {
+		t.Errorf("Expected ErrIDsExhausted at the bound, got %v", err)
+	}
+}
+
 func TestGenIncrIDsConcurrency(t *testing.T) {
 	db, tmpDir := setupTestDB(t)
 	defer teardownTestDB(db, tmpDir)
@@ -198,7 +256,7 @@ func TestGenIncrIDsConcurrency(t *testing.T) {
 
 	for i := 0; i < numGoroutines; i++ {
 		go func() {
-			ids, err := db.GenIncrIDs(NamespaceDocs, idsPerGoroutine)
+			ids, err := db.GenIncrIDs(NamespaceDocs, idsPerGoroutine, 0)
 			if err != nil {
 				errors <- err
 				return
@@ -271,6 +329,66 @@ func TestIterator(t *testing.T) {
 	}
 }
 
+func TestRangeIterator(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	defer teardownTestDB(db, tmpDir)
+
+	for _, id := range []uint64{3, 1, 300, 2} {
+		if err := db.Put(NamespaceDocs, EncodeID(id), []byte(fmt.Sprintf("doc%d", id))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	// The ID counter lives in the same namespace but outside the ID key range
+	if _, err := db.GenIncrIDs(NamespaceDocs, 1, 0); err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+
+	rangeIDs := func(start, end uint64, limit int) []uint64 {
+		t.Helper()
+		iter, err := db.RangeIterator(NamespaceDocs, EncodeID(start), EncodeID(end), limit)
+		if err != nil {
+			t.Fatalf("RangeIterator creation failed: %v", err)
+		}
+
+		var ids []uint64
+		for pair := range iter {
+			id, err := DecodeIDChecked(pair.Key)
+			if err != nil {
+				t.Fatalf("Unexpected key %q in range", pair.Key)
+			}
+			if string(pair.Value) != fmt.Sprintf("doc%d", id) {
+				t.Errorf("Unexpected value %q for ID %d", pair.Value, id)
+			}
+			ids = append(ids, id)
+		}
+		return ids
+	}
+
+	expected := []uint64{2, 3, 300}
+	if ids := rangeIDs(2, common.MaxVectorID, 0); fmt.Sprint(ids) != fmt.Sprint(expected) {
+		t.Errorf("Expected IDs %v in key order, got %v", expected, ids)
+	}
+
+	// A limit returns the first entries of the range, and the end is inclusive
+	expected = []uint64{2, 3}
+	if ids := rangeIDs(2, common.MaxVectorID, 2); fmt.Sprint(ids) != fmt.Sprint(expected) {
+		t.Errorf("Expected IDs %v with a limit, got %v", expected, ids)
+	}
+	expected = []uint64{1, 2, 3}
+	if ids := rangeIDs(0, 3, 10); fmt.Sprint(ids) != fmt.Sprint(expected) {
+		t.Errorf("Expected IDs %v up to the end, got %v", expected, ids)
+	}
+
+	// An empty range is not an error
+	iter, err := db.RangeIterator(NamespaceDocs, EncodeID(1000), EncodeID(2000), 0)
+	if err != nil {
+		t.Fatalf("RangeIterator on empty range failed: %v", err)
+	}
+	for pair := range iter {
+		t.Errorf("Expected no entries in empty range, got key %v", pair.Key)
+	}
+}
+
 func TestUpdateExistingKey(t *testing.T) {
 	db, tmpDir := setupTestDB(t)
 	defer teardownTestDB(db, tmpDir)
@@ -301,3 +419,236 @@ func TestUpdateExistingKey(t *testing.T) {
 		t.Errorf("Expected %s, got %s", value2, retrieved)
 	}
 }
+
+func TestMultiPut(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	defer teardownTestDB(db, tmpDir)
+
+	keys := [][]byte{EncodeID(1), EncodeID(2), EncodeID(3)}
+	values := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+
+	if err := db.MultiPut(NamespaceDocs, keys, values); err != nil {
+		t.Fatalf("MultiPut failed: %v", err)
+	}
+
+	for i, key := range keys {
+		retrieved, err := db.Get(NamespaceDocs, key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if string(retrieved) != string(values[i]) {
+			t.Errorf("Expected %s, got %s", values[i], retrieved)
+		}
+	}
+
+	// Mismatched lengths should be rejected
+	if err := db.MultiPut(NamespaceDocs, keys, values[:2]); err == nil {
+		t.Error("Expected error for mismatched keys and values")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	defer teardownTestDB(db, tmpDir)
+
+	keys := [][]byte{EncodeID(1), EncodeID(2)}
+	values := [][]byte{[]byte("one"), []byte("two")}
+	if err := db.MultiPut(NamespaceDocs, keys, values); err != nil {
+		t.Fatalf("MultiPut failed: %v", err)
+	}
+
+	if err := db.Delete(NamespaceDocs, EncodeID(1)); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Missing keys are ignored
+	if err := db.MultiDelete(NamespaceDocs, [][]byte{EncodeID(2), EncodeID(99)}); err != nil {
+		t.Fatalf("MultiDelete failed: %v", err)
+	}
+
+	for _, key := range keys {
+		retrieved, err := db.Get(NamespaceDocs, key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if retrieved != nil {
+			t.Errorf("Expected nil after delete, got %s", retrieved)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	db, err := NewScalarStorage(&ScalarOption{
+		DIR:         tmpDir,
+		Buckets:     []string{NamespaceDocs},
+		SegmentSize: 64 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	dirSize := func() int64 {
+		var size int64
+		err := filepath.WalkDir(tmpDir, func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to measure database size: %v", err)
+		}
+		return size
+	}
+
+	// A single data file needs no merge
+	if err := db.Merge(); err != nil {
+		t.Fatalf("Merge of a single file failed: %v", err)
+	}
+
+	value := make([]byte, 1024)
+	for i := uint64(1); i <= 500; i++ {
+		if err := db.Put(NamespaceDocs, EncodeID(i), value); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	keys := make([][]byte, 0, 490)
+	for i := uint64(11); i <= 500; i++ {
+		keys = append(keys, EncodeID(i))
+	}
+	if err := db.MultiDelete(NamespaceDocs, keys); err != nil {
+		t.Fatalf("MultiDelete failed: %v", err)
+	}
+
+	before := dirSize()
+	if err := db.Merge(); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if after := dirSize(); after >= before/2 {
+		t.Errorf("Expected merge to reclaim the deleted entries, size went from %d to %d bytes", before, after)
+	}
+
+	for i := uint64(1); i <= 500; i++ {
+		retrieved, err := db.Get(NamespaceDocs, EncodeID(i))
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if kept := i <= 10; kept != (retrieved != nil) {
+			t.Errorf("Expected key %d to be kept: %v, got %v", i, kept, retrieved != nil)
+		}
+	}
+}
+
+func TestDecodeIDChecked(t *testing.T) {
+	for _, id := range []uint64{0, 1, 42, 1<<64 - 1} {
+		decoded, err := DecodeIDChecked(EncodeID(id))
+		if err != nil {
+			t.Fatalf("DecodeIDChecked failed for %d: %v", id, err)
+		}
+		if decoded != id {
+			t.Errorf("Expected %d, got %d", id, decoded)
+		}
+	}
+
+	for _, key := range [][]byte{nil, {0x01}, make([]byte, 7), make([]byte, 9)} {
+		if _, err := DecodeIDChecked(key); !errors.Is(err, ErrMalformedIDKey) {
+			t.Errorf("Expected ErrMalformedIDKey for %d-byte key, got %v", len(key), err)
+		}
+	}
+
+	// The lenient version masks short keys as ID 0
+	if id := DecodeID([]byte{0x01}); id != 0 {
+		t.Errorf("Expected DecodeID to return 0 for short key, got %d", id)
+	}
+}
+
+func TestGenIncrIDsCorruptedCounter(t *testing.T) {
+	db, tmpDir := setupTestDB(t)
+	defer teardownTestDB(db, tmpDir)
+
+	if err := db.Put(NamespaceDocs, keyIDMax, []byte{0x01, 0x02}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, err := db.GenIncrIDs(NamespaceDocs, 1, 0); !errors.Is(err, ErrMalformedIDKey) {
+		t.Errorf("Expected ErrMalformedIDKey for corrupted counter, got %v", err)
+	}
+}
+
+func TestExtraBuckets(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewScalarStorage(&ScalarOption{DIR: dir, Buckets: []string{NamespaceDocs}})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if _, err := db.GenIncrIDs(NamespaceDocs, 3, 0); err != nil {
+		t.Fatalf("GenIncrIDs failed: %v", err)
+	}
+
+	// Namespaces the storage was not opened with are refused
+	if err := db.Put("vectors", EncodeID(1), []byte("v")); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound for put, got %v", err)
+	}
+	if _, err := db.GenIncrIDs("vectors", 1, 0); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound for GenIncrIDs, got %v", err)
+	}
+	if _, err := db.Iterator("vectors"); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound for iterator, got %v", err)
+	}
+	if err := db.Delete("vectors", EncodeID(1)); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound for delete, got %v", err)
+	}
+	if err := db.MultiDelete("vectors", nil); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Expected ErrBucketNotFound for multi-delete, got %v", err)
+	}
+	db.Close()
+
+	// Reopening with more buckets creates the missing ones and keeps the existing data
+	db, err = NewScalarStorage(&ScalarOption{DIR: dir, Buckets: []string{NamespaceDocs, "vectors", "metadata"}})
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Put("vectors", EncodeID(1), []byte("v1")); err != nil {
+		t.Fatalf("Put to vectors failed: %v", err)
+	}
+	if err := db.Put("metadata", []byte("owner"), []byte("m1")); err != nil {
+		t.Fatalf("Put to metadata failed: %v", err)
+	}
+	if value, err := db.Get("vectors", EncodeID(1)); err != nil || string(value) != "v1" {
+		t.Errorf("Expected v1 from vectors, got %q, %v", value, err)
+	}
+	if value, err := db.Get(NamespaceDocs, EncodeID(1)); err != nil || value != nil {
+		t.Errorf("Expected key 1 to be missing from docs, got %q, %v", value, err)
+	}
+
+	// Each bucket counts its IDs on its own
+	ids, err := db.GenIncrIDs("vectors", 2, 0)
+	if err != nil {
+		t.Fatalf("GenIncrIDs on vectors failed: %v", err)
+	}
+	if ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("Expected IDs [1 2] from vectors, got %v", ids)
+	}
+	ids, err = db.GenIncrIDs(NamespaceDocs, 1, 0)
+	if err != nil {
+		t.Fatalf("GenIncrIDs on docs failed: %v", err)
+	}
+	if ids[0] != 4 {
+		t.Errorf("Expected ID 4 from docs, got %v", ids)
+	}
+
+	if _, err := NewScalarStorage(&ScalarOption{DIR: t.TempDir(), Buckets: []string{""}}); err == nil {
+		t.Error("Expected an error for an empty bucket name")
+	}
+}
@@ -0,0 +1,148 @@
+package vecdb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/common/math"
+	"vecdb-go/internal/filter"
+	"vecdb-go/internal/persistence"
+	"vecdb-go/internal/scalar"
+)
+
+// OperationType is the kind of change an Operation makes
+type OperationType int
+
+const (
+	OpInsert OperationType = iota
+	OpDelete
+)
+
+// Operation is one change in a Batch: an insert of Vector with its Doc and Attributes,
+// which gets a newly generated ID like Upsert, or a delete of the vector with ID.
+type Operation struct {
+	Type       OperationType
+	Vector     []float32
+	Doc        map[string]any
+	Attributes map[string]any
+	ID         uint64
+}
+
+// Batch applies inserts and deletes as one unit, for example to replace a vector.
+// The operations are written to the WAL as one atomic batch and synced before returning;
+// either all of them are applied or, on failure, none are. After a crash the batch is
+// replayed only if it was completely written. Deleting an ID that does not exist is ignored.
+func (db *VectorDatabase) Batch(ops []Operation) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	inserts := 0
+	for i, op := range ops {
+		switch op.Type {
+		case OpInsert:
+			if len(op.Vector) > db.params.EffectiveMaxDim() {
+				return fmt.Errorf("%w: %d exceeds maximum dimension %d", ErrDimMismatch, len(op.Vector), db.params.EffectiveMaxDim())
+			}
+			if len(op.Vector) != db.params.Dim {
+				return fmt.Errorf("%w: %d does not match database dimension %d", ErrDimMismatch, len(op.Vector), db.params.Dim)
+			}
+			if !db.params.SkipFiniteCheck {
+				if col := math.FirstNonFinite(op.Vector); col >= 0 {
+					return fmt.Errorf("%w: operation %d has %v at column %d", ErrNonFiniteVector, i, op.Vector[col], col)
+				}
+			}
+			inserts++
+		case OpDelete:
+		default:
+			return fmt.Errorf("%w: unknown type %d of operation %d", ErrInvalidArgument, op.Type, i)
+		}
+	}
+
+	ids, err := db.scalarStorage.GenIncrIDs(scalar.NamespaceDocs, inserts, common.MaxVectorID-db.params.IDOffset)
+	if errors.Is(err, scalar.ErrIDsExhausted) {
+		return fmt.Errorf("new vector IDs exceed maximum vector ID %d: %w", uint64(common.MaxVectorID), err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate IDs: %w", err)
+	}
+	for i := range ids {
+		ids[i] += db.params.IDOffset
+	}
+
+	records := make([]persistence.WALRecordData, len(ops))
+	fieldTypes := make(map[string]filter.FieldType)
+	newValues := make(map[string]map[int64]struct{})
+	for i, op := range ops {
+		if op.Type == OpDelete {
+			records[i] = persistence.WALRecordData{Operation: persistence.Delete, VectorID: op.ID}
+			continue
+		}
+
+		doc := op.Doc
+		if doc == nil {
+			doc = make(map[string]any)
+		}
+		attributes := op.Attributes
+		if attributes == nil {
+			attributes = make(map[string]any)
+		}
+
+		if err := db.checkDocSize(ids[0], doc, attributes); err != nil {
+			return err
+		}
+		if err := filter.ValidateAttributes(attributes); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+		if err := db.filterIndex.CheckTypes(attributes, fieldTypes); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+		if err := db.filterIndex.CheckLimits(attributes, newValues); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		records[i] = persistence.WALRecordData{
+			Operation:  persistence.Insert,
+			VectorID:   ids[0],
+			Vector:     op.Vector,
+			Doc:        doc,
+			Attributes: attributes,
+		}
+		ids = ids[1:]
+	}
+
+	release, err := db.reserveAttributes(fieldTypes, newValues)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Applying batch", "operations", len(ops), "inserts", inserts)
+
+	if err := db.persistence.WriteAtomic(
+		records,
+		true,
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		release()
+		return fmt.Errorf("failed to write to WAL: %w", err)
+	}
+	db.countStorageGarbage(len(ops) - inserts)
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package vecdb
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/common/math"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	benchIndexTypes = []common.IndexType{common.IndexTypeFlat, common.IndexTypeHnsw}
+	benchDims       = []int{8, 128}
+)
+
+// newRandomUpsertArgs builds rows random vectors with a doc and a category attribute each
+func newRandomUpsertArgs(rng *rand.Rand, rows, dim int) common.VdbUpsertArgs {
+	data := make([]float32, rows*dim)
+	for i := range data {
+		data[i] = rng.Float32()
+	}
+
+	docs := make([]map[string]any, rows)
+	attrs := make([]map[string]any, rows)
+	for i := 0; i < rows; i++ {
+		docs[i] = map[string]any{"name": fmt.Sprintf("doc%d", i)}
+		attrs[i] = map[string]any{"category": float64(i % 10)}
+	}
+
+	return common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: rows, Cols: dim, Data: data},
+		Docs:       docs,
+		Attributes: attrs,
+	}
+}
+
+// newBenchmarkDatabase opens an empty L2 database of the given index type and dimension
+func newBenchmarkDatabase(b *testing.B, indexType common.IndexType, dim int) *VectorDatabase {
+	tp := newTestPath()
+	b.Cleanup(tp.cleanup)
+
+	params := createTestIndexParams(common.MetricTypeL2, indexType, tp.path())
+	params.Dim = dim
+
+	db, err := NewVectorDatabase(&params)
+	require.NoError(b, err)
+	b.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func BenchmarkVectorDatabaseUpsertSingle(b *testing.B) {
+	for _, indexType := range benchIndexTypes {
+		for _, dim := range benchDims {
+			b.Run(fmt.Sprintf("%s/dim=%d", indexType, dim), func(b *testing.B) {
+				db := newBenchmarkDatabase(b, indexType, dim)
+				args := newRandomUpsertArgs(rand.New(rand.NewPCG(1, 2)), 1, dim)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					require.NoError(b, db.Upsert(args))
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkVectorDatabaseUpsertBatch(b *testing.B) {
+	for _, indexType := range benchIndexTypes {
+		for _, dim := range benchDims {
+			for _, rows := range []int{100, 1000} {
+				b.Run(fmt.Sprintf("%s/dim=%d/rows=%d", indexType, dim, rows), func(b *testing.B) {
+					db := newBenchmarkDatabase(b, indexType, dim)
+					args := newRandomUpsertArgs(rand.New(rand.NewPCG(1, 2)), rows, dim)
+
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						require.NoError(b, db.Upsert(args))
+					}
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkVectorDatabaseQuery(b *testing.B) {
+	for _, indexType := range benchIndexTypes {
+		for _, dim := range benchDims {
+			for _, size := range []int{1000, 10000} {
+				name := fmt.Sprintf("%s/dim=%d/size=%d", indexType, dim, size)
+				b.Run(name, func(b *testing.B) {
+					benchmarkQuery(b, indexType, dim, size, nil)
+				})
+				b.Run(name+"/filter", func(b *testing.B) {
+					benchmarkQuery(b, indexType, dim, size, []common.IntFilterInput{
+						{Field: "category", Op: "equal", Target: 3},
+					})
+				})
+			}
+		}
+	}
+}
+
+func benchmarkQuery(b *testing.B, indexType common.IndexType, dim, size int, filterInputs []common.IntFilterInput) {
+	db := newBenchmarkDatabase(b, indexType, dim)
+	rng := rand.New(rand.NewPCG(1, 2))
+	require.NoError(b, db.Upsert(newRandomUpsertArgs(rng, size, dim)))
+
+	query := make([]float32, dim)
+	for i := range query {
+		query[i] = rng.Float32()
+	}
+	args := common.VdbSearchArgs{Query: query, K: 10, FilterInputs: filterInputs}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := db.Query(args)
+		require.NoError(b, err)
+	}
+}
+
+func BenchmarkVectorDatabaseQueryIDsOnly(b *testing.B) {
+	const dim, size, k = 128, 10000, 100
+
+	db := newBenchmarkDatabase(b, common.IndexTypeFlat, dim)
+	rng := rand.New(rand.NewPCG(1, 2))
+	require.NoError(b, db.Upsert(newRandomUpsertArgs(rng, size, dim)))
+
+	query := make([]float32, dim)
+	for i := range query {
+		query[i] = rng.Float32()
+	}
+
+	for _, idsOnly := range []bool{false, true} {
+		b.Run(fmt.Sprintf("ids_only=%t", idsOnly), func(b *testing.B) {
+			args := common.VdbSearchArgs{Query: query, K: k, IDsOnly: idsOnly}
+			for i := 0; i < b.N; i++ {
+				_, err := db.Query(args)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func BenchmarkVectorDatabaseHnswParallelInsert(b *testing.B) {
+	const dim, rows = 128, 10000
+
+	args := newRandomUpsertArgs(rand.New(rand.NewPCG(1, 2)), rows, dim)
+	for _, parallel := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel=%t", parallel), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tp := newTestPath()
+				params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeHnsw, tp.path())
+				params.Dim = dim
+				params.HnswParams.ParallelInsert = &parallel
+				db, err := NewVectorDatabase(&params)
+				require.NoError(b, err)
+				b.StartTimer()
+
+				require.NoError(b, db.Upsert(args))
+
+				b.StopTimer()
+				db.Close()
+				tp.cleanup()
+			}
+		})
+	}
+}
@@ -1,21 +1,28 @@
 package vecdb
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"vecdb-go/internal/common"
-	"vecdb-go/internal/common/math"
 	"vecdb-go/internal/filter"
 	"vecdb-go/internal/index"
 	"vecdb-go/internal/persistence"
 	"vecdb-go/internal/scalar"
 )
 
+// filterOptimizeInterval is how often the background goroutine run-length encodes the
+// filter index bitmaps, if they changed since the last time
+const filterOptimizeInterval = time.Minute
+
 const (
 	ScalarDBFileSuffix = "scalar.db"
 	IndexFileSuffix    = "index.bin"
@@ -23,9 +30,57 @@ const (
 	WalFileSuffix      = "vdb.log"
 )
 
+// Errors returned by VectorDatabase, wrapped with details where useful; match them with errors.Is
+var (
+	// ErrClosed is returned by operations on a closed database
+	ErrClosed = fmt.Errorf("database is closed")
+	// ErrDatabaseClosed is an alias of ErrClosed
+	ErrDatabaseClosed = ErrClosed
+	// ErrDimMismatch is returned when a vector does not have the database dimension
+	ErrDimMismatch = fmt.Errorf("vector dimension mismatch")
+	// ErrNotFound is returned when a document looked up by ID does not exist
+	ErrNotFound = fmt.Errorf("not found")
+	// ErrUnsupportedFilterOp is returned when a filter input has an unknown operation
+	ErrUnsupportedFilterOp = fmt.Errorf("unsupported filter operation")
+	// ErrInvalidArgument is returned when request arguments are inconsistent or missing
+	ErrInvalidArgument = fmt.Errorf("invalid argument")
+	// ErrQueryTimeout is returned when a vector search overruns the query timeout
+	ErrQueryTimeout = fmt.Errorf("query timed out")
+	// ErrQueryCanceled is returned by a vector search cancelled with CancelQuery
+	ErrQueryCanceled = fmt.Errorf("query canceled")
+	// ErrDocTooLarge is returned when a serialized document exceeds the maximum doc size
+	ErrDocTooLarge = persistence.ErrDocTooLarge
+	// ErrNonFiniteVector is returned when a vector contains NaN or infinite values
+	ErrNonFiniteVector = persistence.ErrNonFiniteVector
+	// ErrAttributeTypeMismatch is returned in strict attribute type mode when an attribute's
+	// type differs from the type its field was first indexed with
+	ErrAttributeTypeMismatch = filter.ErrFieldTypeMismatch
+	// ErrUnsupportedAttribute is returned for attribute values that cannot be indexed
+	ErrUnsupportedAttribute = filter.ErrUnsupportedAttribute
+	// ErrFilterLimit is returned when an attribute would add a field or value beyond
+	// MaxFilterFields or MaxFilterValues
+	ErrFilterLimit = filter.ErrLimitExceeded
+	// ErrFieldNotFilterable is returned for filters on an attribute field left out of
+	// FilterableFields
+	ErrFieldNotFilterable = filter.ErrNotFilterable
+	// ErrReadOnly is returned by writes to a database opened in read-only mode
+	ErrReadOnly = fmt.Errorf("database is read-only")
+	// ErrEmptyQuery is returned by searches with a query vector that has no elements
+	ErrEmptyQuery = fmt.Errorf("empty query vector")
+	// ErrNoRows is returned by upserts whose matrix has no rows
+	ErrNoRows = fmt.Errorf("no rows to insert")
+	// ErrWALCorrupt is returned by NewVectorDatabase with FailOnRestoreError set when
+	// more of the WAL cannot be decoded than MaxCorruptWALBytes bytes
+	ErrWALCorrupt = persistence.ErrWALCorrupt
+)
+
 // VectorDatabase is the main database structure managing scalar data, vector index, and filters
 type VectorDatabase struct {
-	mu sync.RWMutex
+	// mu guards the database lifecycle: operations hold the read lock and Close holds
+	// the write lock. Each component synchronizes its own state, so queries are not
+	// blocked while pending WAL records are synced.
+	mu     sync.RWMutex
+	closed atomic.Bool
 
 	params        *common.DatabaseParams
 	scalarStorage scalar.ScalarStorage
@@ -34,18 +89,112 @@ type VectorDatabase struct {
 	persistence   *persistence.Persistence
 
 	// Background sync control
+	syncNow  chan struct{}
 	stopSync chan struct{}
 	syncDone sync.WaitGroup
+
+	// Tunables that can change at runtime
+	syncInterval        atomic.Int64 // background sync period in nanoseconds
+	syncIntervalChanged chan struct{}
+	defaultEfSearch     atomic.Uint32 // efSearch of queries that set none, 0 keeps the FAISS default
+
+	// searchSlots bounds the index searches running at once; nil means unlimited
+	searchSlots chan struct{}
+
+	// queryCache keeps recent query results; nil when disabled
+	queryCache *queryCache
+
+	// queries tracks the index searches in flight
+	queries queryRegistry
+
+	// live holds the IDs of the vectors in the index, kept up to date by persistence
+	live *liveSet
+
+	// tombstones holds the IDs marked by SoftDelete. The set is never changed once
+	// stored, so searches use it without locking; tombstoneMu serializes the writers
+	// that replace it.
+	tombstones  atomic.Pointer[filter.IdFilter]
+	tombstoneMu sync.Mutex
+	// tombstoneEpoch is bumped before and after the tombstones are replaced
+	tombstoneEpoch atomic.Uint64
+
+	// storageGarbage counts the documents deleted or replaced since the last storage
+	// compaction; reaching CompactStorageAfter sends on compactNow
+	storageGarbage     atomic.Int64
+	storageCompactions atomic.Uint64
+	compactNow         chan struct{}
+}
+
+// storageFeature is a feature keeping data in a scalar storage bucket of its own
+type storageFeature struct {
+	bucket  string
+	enabled func(params *common.DatabaseParams) bool
+}
+
+// storageFeatures lists the storage features; one storing data of its own adds an entry
+var storageFeatures = []storageFeature{
+	// Documents and the ID counter
+	{scalar.NamespaceDocs, func(*common.DatabaseParams) bool { return true }},
+	// Marks of SoftDelete, which every database supports and reads on open
+	{scalar.NamespaceTombstones, func(*common.DatabaseParams) bool { return true }},
+}
+
+// scalarBuckets returns the buckets of the storage features params enable; opening an
+// existing database creates the ones it lacks
+func scalarBuckets(params *common.DatabaseParams) []string {
+	var buckets []string
+	for _, feature := range storageFeatures {
+		if feature.enabled(params) {
+			buckets = append(buckets, feature.bucket)
+		}
+	}
+	return buckets
+}
+
+// storageRetryPolicy returns scalar.DefaultRetryPolicy with the values opt sets
+func storageRetryPolicy(opt *common.RetryOption) *scalar.RetryPolicy {
+	policy := scalar.DefaultRetryPolicy
+	if opt == nil {
+		return &policy
+	}
+	if opt.MaxAttempts > 0 {
+		policy.MaxAttempts = opt.MaxAttempts
+	}
+	if opt.InitialBackoffMs > 0 {
+		policy.InitialBackoff = time.Duration(opt.InitialBackoffMs) * time.Millisecond
+	}
+	if opt.MaxElapsedMs > 0 {
+		policy.MaxElapsed = time.Duration(opt.MaxElapsedMs) * time.Millisecond
+	}
+	return &policy
 }
 
 // NewVectorDatabase creates a new vector database instance
 func NewVectorDatabase(params *common.DatabaseParams) (*VectorDatabase, error) {
+	// Validate dimension before allocating anything
+	if params.Dim <= 0 || params.Dim > params.EffectiveMaxDim() {
+		return nil, fmt.Errorf("database dimension %d out of range: must be between 1 and %d",
+			params.Dim, params.EffectiveMaxDim())
+	}
+
+	// IDs are assigned after the offset, so it must leave room for at least one ID
+	if params.IDOffset >= common.MaxVectorID {
+		return nil, fmt.Errorf("id offset %d out of range: must be below %d", params.IDOffset, uint64(common.MaxVectorID))
+	}
+
+	// Refuse a directory written in another layout before touching any of its files
+	versionMissing, err := checkLayoutVersion(params.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize scalar storage
 	scalarDBPath := filepath.Join(params.FilePath, ScalarDBFileSuffix)
 	scalarStorage, err := scalar.NewScalarStorage(
 		&scalar.ScalarOption{
 			DIR:     scalarDBPath,
-			Buckets: []string{scalar.NamespaceDocs, scalar.NamespaceWals},
+			Buckets: scalarBuckets(params),
+			Retry:   storageRetryPolicy(params.StorageRetry),
 		})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create scalar storage: %w", err)
@@ -66,80 +215,245 @@ func NewVectorDatabase(params *common.DatabaseParams) (*VectorDatabase, error) {
 		params.Dim,
 		params.MetricType,
 		hnswParams,
+		params.FactoryString,
 	)
 	if err != nil {
 		scalarStorage.Close()
 		return nil, fmt.Errorf("failed to create vector index: %w", err)
 	}
+	if custom, ok := vectorIndex.(*index.CustomIndex); ok {
+		custom.SetTrainSize(params.TrainSize)
+	}
 
 	// Initialize filter index
 	filterIndex := filter.NewIntFilterIndex()
+	filterIndex.SetStrictTypes(params.StrictAttributeTypes)
+	filterIndex.SetLimits(params.MaxFilterFields, params.MaxFilterValues)
+	filterIndex.SetFilterableFields(params.FilterableFields)
 
 	// Initialize persistence layer with encoder based on config
 	walPath := filepath.Join(params.FilePath, WalFileSuffix)
-	encoder := persistence.EncoderFactory(params.EncoderType, persistence.WALVersion)
-	slog.Info("Using encoder for persistence", "encoder_type", encoder.Name())
-
-	pers, err := persistence.NewPersistenceWithEncoder(walPath, encoder)
+	checksum, err := persistence.ParseChecksumAlgorithm(params.WALChecksum)
+	if err != nil {
+		scalarStorage.Close()
+		return nil, err
+	}
+	encoder := persistence.EncoderFactory(params.EncoderType, persistence.WALVersion, checksum)
+	slog.Info("Using encoder for persistence", "encoder_type", encoder.Name(), "checksum", checksum)
+
+	// NutsDB has no read-only mode, so in read-only mode the scalar store is opened as
+	// usual but never written; only the WAL is opened without write access
+	var pers *persistence.Persistence
+	if params.ReadOnly {
+		pers, err = persistence.NewReadOnlyPersistence(walPath, encoder)
+	} else {
+		pers, err = persistence.NewPersistenceWithEncoder(walPath, encoder)
+	}
 	if err != nil {
 		scalarStorage.Close()
 		return nil, fmt.Errorf("failed to create persistence layer: %w", err)
 	}
 
+	if err := pers.SetSyncTxMode(params.SyncTxMode); err != nil {
+		pers.Close()
+		scalarStorage.Close()
+		return nil, fmt.Errorf("failed to configure persistence layer: %w", err)
+	}
+	if err := pers.SetDocCodec(params.DocCodec); err != nil {
+		pers.Close()
+		scalarStorage.Close()
+		return nil, fmt.Errorf("failed to configure persistence layer: %w", err)
+	}
+	pers.SetMaxDocBytes(params.MaxDocBytes)
+	if params.IndexType == common.IndexTypeHnsw {
+		pers.SetHnswInsertParams(&index.HnswParams{Parallel: params.HnswParallelInsert()})
+	}
+	pers.SetSkipFiniteCheck(params.SkipFiniteCheck)
+	pers.SetReplaceExistingIDs(params.ReplaceExistingIDs)
+	pers.SetMaxSyncBatch(params.MaxSyncBatch)
+	pers.SetFlushEveryN(params.FlushEveryN)
+	if params.FailOnRestoreError {
+		pers.SetMaxCorruptBytes(params.MaxCorruptWALBytes)
+	}
+
+	// The directory exists by now, so mark it with the layout version if it is unmarked
+	if versionMissing && !params.ReadOnly {
+		if err := writeLayoutVersion(params.FilePath); err != nil {
+			pers.Close()
+			scalarStorage.Close()
+			return nil, err
+		}
+	}
+
 	db := &VectorDatabase{
 		params:        params,
 		scalarStorage: scalarStorage,
 		vectorIndex:   vectorIndex,
 		filterIndex:   filterIndex,
 		persistence:   pers,
+		syncNow:       make(chan struct{}, 1),
 		stopSync:      make(chan struct{}),
+		compactNow:    make(chan struct{}, 1),
+
+		syncIntervalChanged: make(chan struct{}, 1),
+		queryCache:          newQueryCache(params.QueryCache),
+		live:                newLiveSet(),
+	}
+	if params.MaxConcurrentSearches > 0 {
+		db.searchSlots = make(chan struct{}, params.MaxConcurrentSearches)
+	}
+	db.syncInterval.Store(int64(params.EffectiveSyncInterval()))
+	if params.HnswParams != nil && params.HnswParams.EfSearch > 0 {
+		db.defaultEfSearch.Store(uint32(params.HnswParams.EfSearch))
 	}
 
-	// Restore from WAL if exists
+	tombstones, err := loadTombstones(scalarStorage)
+	if err != nil {
+		pers.Close()
+		scalarStorage.Close()
+		return nil, err
+	}
+	db.tombstones.Store(tombstones)
+	pers.SetApplyHook(db.live.apply)
+
+	// Restore from WAL if exists; serving without the records that failed is only
+	// acceptable if the caller opted out of FailOnRestoreError
 	if err := pers.Restore(scalarStorage, filterIndex, vectorIndex, params.Dim); err != nil {
+		if params.FailOnRestoreError {
+			pers.Close()
+			scalarStorage.Close()
+			return nil, fmt.Errorf("failed to restore from WAL: %w", err)
+		}
 		slog.Warn("Failed to restore from WAL, continuing with empty database", "error", err)
 	}
+	// Replaying the WAL indexes the attributes of soft-deleted vectors again
+	filterIndex.RemoveIDs(tombstones)
+	filterIndex.Optimize()
+
+	// The scalar store may be behind the WAL, e.g. if it was lost or copied from an older
+	// state, so make sure IDs replayed from the WAL are never generated again
+	if maxID := pers.RestoredMaxVectorID(); maxID > params.IDOffset && !params.ReadOnly {
+		if err := scalarStorage.EnsureIDMax(scalar.NamespaceDocs, maxID-params.IDOffset); err != nil {
+			pers.Close()
+			scalarStorage.Close()
+			return nil, fmt.Errorf("failed to reconcile ID counter: %w", err)
+		}
+	}
+
+	// Run synthetic queries so the first real query does not hit a cold index
+	if params.WarmUp != nil {
+		db.warmUp(params.WarmUp)
+	}
 
-	// Start background sync goroutine
-	db.syncDone.Add(1)
-	go db.backgroundSync()
+	// A read-only database has nothing to sync
+	if !params.ReadOnly {
+		db.syncDone.Add(1)
+		go db.backgroundSync()
+	}
 
 	return db, nil
 }
 
-// Upsert inserts or updates vectors and their associated documents/attributes
+// Upsert inserts or updates vectors and their associated documents/attributes.
+// Records are written to the WAL and synced to all indexes before returning.
+//
+// A nil doc is stored as an empty doc, and nil or omitted attributes are stored as an
+// empty "attributes" object that indexes nothing, so each row of a batch stands alone.
 func (db *VectorDatabase) Upsert(args common.VdbUpsertArgs) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.upsert(args, true)
+}
+
+// UpsertAsync writes vectors and their associated documents/attributes to the WAL
+// and returns as soon as the WAL append is durable on disk, leaving the background
+// sync to apply them to the indexes.
+//
+// The new records are not visible to Query until they are synced. Query only
+// nudges the background sync and searches the already-synced state, so call Sync
+// after UpsertAsync when the next Query must observe the write.
+func (db *VectorDatabase) UpsertAsync(args common.VdbUpsertArgs) error {
+	if err := db.upsert(args, false); err != nil {
+		return err
+	}
+
+	if err := db.persistence.Flush(); err != nil {
+		return fmt.Errorf("failed to flush WAL: %w", err)
+	}
+
+	return nil
+}
+
+// upsert validates the arguments and writes each row to the WAL as one batch.
+// If eager is true, the batch is synced to the indexes before returning.
+func (db *VectorDatabase) upsert(args common.VdbUpsertArgs, eager bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return ErrReadOnly
+	}
 
 	// Validate input arguments
+	if err := args.Vectors.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	if args.Vectors.Rows == 0 {
+		return ErrNoRows
+	}
 	if field, got, expected := args.Validate(); field != "" {
-		return fmt.Errorf("unexpected length of field %s: %d, expected length is %d", field, got, expected)
+		return fmt.Errorf("%w: unexpected length of field %s: %d, expected length is %d", ErrInvalidArgument, field, got, expected)
+	}
+
+	// Reject oversized vectors before comparing against the database dimension
+	if args.Vectors.Cols > db.params.EffectiveMaxDim() {
+		return fmt.Errorf("%w: %d exceeds maximum dimension %d", ErrDimMismatch, args.Vectors.Cols, db.params.EffectiveMaxDim())
 	}
 
 	// Validate vector dimensions match database parameters
 	if args.Vectors.Cols != db.params.Dim {
-		return fmt.Errorf("vector dimension %d does not match database dimension %d", args.Vectors.Cols, db.params.Dim)
+		return fmt.Errorf("%w: %d does not match database dimension %d", ErrDimMismatch, args.Vectors.Cols, db.params.Dim)
+	}
+
+	// FAISS either fails cryptically or corrupts the index on NaN and Inf
+	if !db.params.SkipFiniteCheck {
+		if row, col, found := args.Vectors.FindNonFinite(); found {
+			return fmt.Errorf("%w: row %d has %v at column %d", ErrNonFiniteVector, row, args.Vectors.At(row, col), col)
+		}
 	}
 
-	// Generate unique IDs for the new vectors
-	ids, err := db.scalarStorage.GenIncrIDs(scalar.NamespaceDocs, args.Vectors.Rows)
+	// Generate unique IDs for the new vectors; each database keeps its own counter,
+	// and the configured offset keeps its IDs apart from other collections
+	ids, err := db.scalarStorage.GenIncrIDs(scalar.NamespaceDocs, args.Vectors.Rows, common.MaxVectorID-db.params.IDOffset)
+	if errors.Is(err, scalar.ErrIDsExhausted) {
+		return fmt.Errorf("new vector IDs exceed maximum vector ID %d: %w", uint64(common.MaxVectorID), err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to generate IDs: %w", err)
 	}
+	for i := range ids {
+		ids[i] += db.params.IDOffset
+	}
 
-	slog.Info("Upserting vector data", "ids", ids)
+	slog.Info("Upserting vector data", "ids", ids, "eager", eager)
 
-	// Process attributes - ensure we have a slice of the right length
-	attributes := args.Attributes
-	if len(attributes) == 0 {
-		attributes = make([]map[string]any, args.Vectors.Rows)
-		for i := range attributes {
+	// Process attributes - ensure we have a slice of the right length with no nil rows,
+	// so every stored doc carries an "attributes" object even when nothing is indexed
+	attributes := make([]map[string]any, args.Vectors.Rows)
+	for i := range attributes {
+		if i < len(args.Attributes) && args.Attributes[i] != nil {
+			attributes[i] = args.Attributes[i]
+		} else {
 			attributes[i] = make(map[string]any)
 		}
 	}
 
-	// Write each record to WAL instead of directly inserting
+	// Build one WAL record per row
+	records := make([]persistence.WALRecordData, args.Vectors.Rows)
+	fieldTypes := make(map[string]filter.FieldType)
+	newValues := make(map[string]map[int64]struct{})
 	for i := 0; i < args.Vectors.Rows; i++ {
 		var doc map[string]any
 
@@ -149,234 +463,827 @@ func (db *VectorDatabase) Upsert(args common.VdbUpsertArgs) error {
 			doc = make(map[string]any)
 		}
 
-		attr := attributes[i]
-
 		// Extract vector for this row
 		vector := make([]float32, args.Vectors.Cols)
 		for j := 0; j < args.Vectors.Cols; j++ {
 			vector[j] = args.Vectors.At(i, j)
 		}
 
-		// Write to WAL with eager=true to maintain backward compatibility with tests
-		// This will immediately sync the data to scalar storage, filter index, and vector index
-		if err := db.persistence.Write(
-			ids[i],
-			vector,
-			doc,
-			attr,
-			true, // eager mode for backward compatibility
-			db.scalarStorage,
-			db.filterIndex,
-			db.vectorIndex,
-			db.params.Dim,
-		); err != nil {
-			return fmt.Errorf("failed to write to WAL for id %d: %w", ids[i], err)
+		if err := db.checkDocSize(ids[i], doc, attributes[i]); err != nil {
+			return err
+		}
+		if err := filter.ValidateAttributes(attributes[i]); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := db.filterIndex.CheckTypes(attributes[i], fieldTypes); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+		if err := db.filterIndex.CheckLimits(attributes[i], newValues); err != nil {
+			return fmt.Errorf("row %d: %w", i, err)
+		}
+
+		records[i] = persistence.WALRecordData{
+			VectorID:   ids[i],
+			Vector:     vector,
+			Doc:        doc,
+			Attributes: attributes[i],
 		}
 	}
 
+	// Reserve the field types and new values until the records are applied, so
+	// concurrent writes cannot log conflicting types or values beyond the limits
+	release, err := db.reserveAttributes(fieldTypes, newValues)
+	if err != nil {
+		return err
+	}
+
+	// In eager mode the whole batch is synced to scalar storage, filter index,
+	// and vector index at once; otherwise the records stay pending until the next sync
+	if err := db.persistence.WriteBatch(
+		records,
+		eager,
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		release()
+		return fmt.Errorf("failed to write to WAL: %w", err)
+	}
+
 	return nil
 }
 
-// insertVectors inserts vectors into the vector index
-func (db *VectorDatabase) insertVectors(ids []uint64, mat *math.Matrix32) error {
-	// Validate vector dimensions match database parameters
-	if mat.Cols != db.params.Dim {
-		return fmt.Errorf("vector dimension %d does not match database dimension %d", mat.Cols, db.params.Dim)
+// reserveAttributes reserves the field types and new attribute values a write was
+// checked with, so that concurrent writes are checked against them until it is applied.
+// The returned function gives them up if the write fails.
+func (db *VectorDatabase) reserveAttributes(fieldTypes map[string]filter.FieldType, newValues map[string]map[int64]struct{}) (func(), error) {
+	if err := db.filterIndex.ReserveTypes(fieldTypes); err != nil {
+		return nil, err
+	}
+	if err := db.filterIndex.ReserveValues(newValues); err != nil {
+		db.filterIndex.ReleaseTypes(fieldTypes)
+		return nil, err
 	}
+	return func() {
+		db.filterIndex.ReleaseTypes(fieldTypes)
+		db.filterIndex.ReleaseValues(newValues)
+	}, nil
+}
 
-	// Convert uint64 IDs to int64 labels
-	labels := make([]int64, len(ids))
-	for i, id := range ids {
-		labels[i] = int64(id)
+// checkDocSize rejects a doc whose stored form would exceed the maximum doc size,
+// so it never reaches the WAL or scalar storage
+func (db *VectorDatabase) checkDocSize(id uint64, doc map[string]any, attributes map[string]any) error {
+	docBytes, err := common.BuildStoredDoc(doc, attributes, id, db.params.DocCodec)
+	if err != nil {
+		return fmt.Errorf("%w: unable to serialize doc for vector %d: %v", ErrInvalidArgument, id, err)
+	}
+	if len(docBytes) > db.params.EffectiveMaxDocBytes() {
+		return fmt.Errorf("%w: doc for vector %d is %d bytes, limit is %d", ErrDocTooLarge, id, len(docBytes), db.params.EffectiveMaxDocBytes())
 	}
+	return nil
+}
 
-	insertParams := &index.InsertParams{
-		Data:   mat,
-		Labels: labels,
+// Sync applies all pending WAL records to the scalar storage, filter index and vector index
+func (db *VectorDatabase) Sync() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return ErrDatabaseClosed
 	}
 
-	if err := db.vectorIndex.Insert(insertParams); err != nil {
-		return fmt.Errorf("unable to upsert vector data: %w", err)
+	if err := db.persistence.Sync(
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
 	}
 
 	return nil
 }
 
-// insertDoc inserts a document into scalar storage
-func (db *VectorDatabase) insertDoc(doc common.DocMap, attributes map[string]any, id uint64) error {
-	// Add id and attributes to the document
-	doc["id"] = id
-	doc["attributes"] = attributes
+// SetSyncInterval changes how often pending WAL records are synced in the background;
+// a zero or negative interval restores DefaultSyncIntervalMs
+func (db *VectorDatabase) SetSyncInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = common.DefaultSyncIntervalMs * time.Millisecond
+	}
+	db.syncInterval.Store(int64(interval))
+
+	select {
+	case db.syncIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// SyncInterval returns how often pending WAL records are synced in the background
+func (db *VectorDatabase) SyncInterval() time.Duration {
+	return time.Duration(db.syncInterval.Load())
+}
+
+// SetDefaultEfSearch changes the HNSW efSearch of queries that do not set their own;
+// zero keeps the FAISS default. Flat indexes ignore it.
+func (db *VectorDatabase) SetDefaultEfSearch(efSearch uint32) {
+	db.defaultEfSearch.Store(efSearch)
+}
+
+// DefaultEfSearch returns the HNSW efSearch of queries that do not set their own
+func (db *VectorDatabase) DefaultEfSearch() uint32 {
+	return db.defaultEfSearch.Load()
+}
+
+// requestSync wakes the background sync goroutine without waiting for it
+func (db *VectorDatabase) requestSync() {
+	select {
+	case db.syncNow <- struct{}{}:
+	default:
+	}
+}
+
+// Query searches the vector database
+// Queries run against the already-synced state and never wait for pending WAL records;
+// if any are pending, the background sync is woken to apply them.
+// Results come back best-first whatever the metric: by ascending distance for L2 and by
+// descending score for IP and cosine, so clients need not re-sort by "_score".
+// Each result carries its score under "_score"; if Fields is set, only those doc fields
+// plus "id" and "_score" are returned, and if IDsOnly is set, documents are not fetched at all
+// unless GroupBy or Explain needs them. If GroupBy is set, only the best hit per distinct
+// value of that field is returned, for up to K groups. If Explain is set, each result lists
+// under "_explain" the filter inputs it satisfied, any one of which lets it through, with
+// the attribute values that matched them.
+func (db *VectorDatabase) Query(searchArgs common.VdbSearchArgs) ([]common.DocMap, error) {
+	result, _, err := db.QueryWithStats(searchArgs)
+	return result, err
+}
+
+// QueryStats describes how the vector index executed a query
+type QueryStats struct {
+	// Candidates is the number of indexed vectors the search could return once
+	// filters and excluded IDs are applied
+	Candidates int
+}
+
+// QueryWithStats searches the vector database like Query and also reports how the
+// vector index executed the search.
+// With the query cache enabled, a query identical to a recent one gets its result
+// back unless a write was applied since.
+func (db *VectorDatabase) QueryWithStats(searchArgs common.VdbSearchArgs) ([]common.DocMap, QueryStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, QueryStats{}, ErrDatabaseClosed
+	}
+
+	return db.queryCached(searchArgs)
+}
 
-	// Serialize document to JSON
-	docBytes, err := json.Marshal(doc)
+// QueryByID searches for the documents most similar to the stored vector with the given
+// ID, like Query with that vector, whose fields are used as given apart from Query and
+// Queries, which must be empty. The document itself is left out of the results if
+// excludeSelf is set. It returns ErrNotFound if the ID does not exist or is
+// soft-deleted, and common.ErrIDOutOfRange if it cannot be a FAISS label; records
+// written with UpsertAsync are found only once they are synced.
+func (db *VectorDatabase) QueryByID(id uint64, searchArgs common.VdbSearchArgs, excludeSelf bool) ([]common.DocMap, QueryStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, QueryStats{}, ErrDatabaseClosed
+	}
+
+	if len(searchArgs.Query) > 0 || len(searchArgs.Queries) > 0 {
+		return nil, QueryStats{}, fmt.Errorf("%w: query vectors cannot be combined with a query ID", ErrInvalidArgument)
+	}
+
+	label, err := common.LabelFromID(id)
 	if err != nil {
-		return fmt.Errorf("unable to serialize doc data: %w", err)
+		return nil, QueryStats{}, err
+	}
+	vector, err := db.vectorIndex.Reconstruct(label)
+	if errors.Is(err, index.ErrLabelNotFound) || db.isSoftDeleted(id) {
+		return nil, QueryStats{}, fmt.Errorf("%w: vector %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, QueryStats{}, fmt.Errorf("failed to load vector %d: %w", id, err)
 	}
 
-	// Store in scalar storage
-	key := scalar.EncodeID(id)
-	if err := db.scalarStorage.Put(scalar.NamespaceDocs, key, docBytes); err != nil {
-		return fmt.Errorf("unable to upsert scalar data: %w", err)
+	searchArgs.Query = vector
+	if excludeSelf {
+		searchArgs.ExcludeIDs = append(slices.Clip(searchArgs.ExcludeIDs), id)
 	}
 
-	return nil
+	return db.queryCached(searchArgs)
 }
 
-// insertAttribute indexes attributes in the filter index
-func (db *VectorDatabase) insertAttribute(attr map[string]any, id uint64) error {
-	for key, value := range attr {
-		switch v := value.(type) {
-		case int:
-			db.filterIndex.Upsert(key, int64(v), id)
-		case int64:
-			db.filterIndex.Upsert(key, v, id)
-		case float64:
-			// JSON numbers are float64, convert to int64 if whole number
-			if v == float64(int64(v)) {
-				db.filterIndex.Upsert(key, int64(v), id)
-			} else {
-				return fmt.Errorf("unsupported attribute type for key %s: %v", key, value)
+// queryCached runs a query, answering it from the query cache if enabled
+// (caller must hold read lock)
+func (db *VectorDatabase) queryCached(searchArgs common.VdbSearchArgs) ([]common.DocMap, QueryStats, error) {
+	if db.queryCache == nil {
+		return db.query(searchArgs)
+	}
+
+	// Read the epoch before querying, so a write applied meanwhile invalidates the result;
+	// soft deletes change the results too, without going through persistence
+	epoch := db.persistence.Epoch() + db.tombstoneEpoch.Load()
+	key, cacheable := queryCacheKey(searchArgs, db.defaultEfSearch.Load())
+	if cacheable {
+		if result, stats, ok := db.queryCache.get(key, epoch); ok {
+			if db.persistence.GetPendingCount() > 0 {
+				db.requestSync()
 			}
-		default:
-			return fmt.Errorf("unsupported attribute type for key %s: %T", key, value)
+			return result, stats, nil
 		}
 	}
 
-	return nil
+	result, stats, err := db.query(searchArgs)
+	if err == nil && cacheable {
+		db.queryCache.put(key, epoch, result, stats)
+	}
+	return result, stats, err
 }
 
-// revertAttributes removes attributes from the filter index (for rollback)
-func (db *VectorDatabase) revertAttributes(attrs []map[string]any, ids []uint64) {
-	for i, attr := range attrs {
-		if i >= len(ids) {
-			break
-		}
-		for key, value := range attr {
-			switch v := value.(type) {
-			case int:
-				db.filterIndex.Remove(key, int64(v), ids[i])
-			case int64:
-				db.filterIndex.Remove(key, v, ids[i])
-			case float64:
-				if v == float64(int64(v)) {
-					db.filterIndex.Remove(key, int64(v), ids[i])
-				}
-			}
+// query runs a query and fetches the result documents (caller must hold read lock)
+func (db *VectorDatabase) query(searchArgs common.VdbSearchArgs) ([]common.DocMap, QueryStats, error) {
+	// Grouping collapses hits, so fetch more than K to still fill K groups
+	k := searchArgs.K
+	if searchArgs.GroupBy != "" {
+		searchArgs.K = k * GroupByFetchFactor
+	}
+	// Likewise the post-filter drops hits after the search
+	if searchArgs.PostFilter != nil {
+		searchArgs.K *= db.params.EffectivePostFilterFetchFactor()
+	}
+
+	hits, candidates, err := db.search(searchArgs)
+	if err != nil {
+		return nil, QueryStats{}, err
+	}
+	stats := QueryStats{Candidates: candidates}
+
+	if len(hits) == 0 {
+		return []common.DocMap{}, stats, nil
+	}
+
+	if searchArgs.IDsOnly && searchArgs.GroupBy == "" && !searchArgs.Explain && searchArgs.PostFilter == nil {
+		result := make([]common.DocMap, len(hits))
+		for i, h := range hits {
+			result[i] = common.DocMap{common.DocFieldID: h.ID, common.DocFieldScore: h.Score}
+		}
+		if searchArgs.NormalizeScores {
+			db.normalizeScores(result)
+		}
+		return result, stats, nil
+	}
+
+	ids := make([]uint64, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+
+	// Retrieve documents from scalar storage
+	documents, err := db.scalarStorage.MultiGetValue(scalar.NamespaceDocs, ids)
+	if err != nil {
+		return nil, QueryStats{}, fmt.Errorf("failed to retrieve documents: %w", err)
+	}
+
+	if searchArgs.PostFilter != nil {
+		hits, documents = postFilterHits(hits, documents, searchArgs.PostFilter)
+		if searchArgs.GroupBy == "" && len(hits) > k {
+			hits, documents = hits[:k], documents[:k]
 		}
 	}
+
+	if searchArgs.GroupBy != "" {
+		hits, documents = groupHits(hits, documents, searchArgs.GroupBy, k)
+	}
+
+	// Attach scores and keep only the requested fields
+	result := make([]common.DocMap, len(documents))
+	for i, doc := range documents {
+		result[i] = resultDoc(doc, hits[i], searchArgs)
+	}
+	if searchArgs.NormalizeScores {
+		db.normalizeScores(result)
+	}
+
+	return result, stats, nil
 }
 
-// Query searches the vector database
-func (db *VectorDatabase) Query(searchArgs common.VdbSearchArgs) ([]common.DocMap, error) {
+// resultDoc builds the result of a hit from its document, which is nil if missing:
+// the document with its score, or only the ID and score if IDsOnly is set
+func resultDoc(doc common.DocMap, hit common.SearchHit, searchArgs common.VdbSearchArgs) common.DocMap {
+	if doc == nil {
+		doc = common.DocMap{}
+	}
+
+	var result common.DocMap
+	if searchArgs.IDsOnly {
+		result = common.DocMap{common.DocFieldID: hit.ID, common.DocFieldScore: hit.Score}
+	} else {
+		doc[common.DocFieldScore] = hit.Score
+		result = projectFields(doc, searchArgs.Fields)
+	}
+	if searchArgs.Explain {
+		result[common.DocFieldExplain] = explainFilters(doc, searchArgs.FilterInputs)
+	}
+	return result
+}
+
+// normalizeScores replaces the score of each result with its similarity between 0 and 1
+// and keeps the raw score under DocFieldRawScore
+func (db *VectorDatabase) normalizeScores(results []common.DocMap) {
+	for _, doc := range results {
+		if score, ok := doc[common.DocFieldScore].(float32); ok {
+			doc[common.DocFieldRawScore] = score
+			doc[common.DocFieldScore] = db.params.MetricType.NormalizedScore(score)
+		}
+	}
+}
+
+// QueryIDs searches the vector database and returns only the IDs and scores of the
+// nearest vectors, best-first, skipping the document lookup in scalar storage. A
+// PostFilter needs the documents, so it is rejected with ErrInvalidArgument.
+func (db *VectorDatabase) QueryIDs(searchArgs common.VdbSearchArgs) ([]common.SearchHit, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	// Sync any pending WAL records before querying
-	if db.persistence.GetPendingCount() > 0 {
-		// Need to upgrade to write lock for sync
-		db.mu.RUnlock()
-		db.mu.Lock()
-
-		// Check again after acquiring write lock
-		if db.persistence.GetPendingCount() > 0 {
-			if err := db.persistence.Sync(
-				db.scalarStorage,
-				db.filterIndex,
-				db.vectorIndex,
-				db.params.Dim,
-			); err != nil {
-				db.mu.Unlock()
-				db.mu.RLock()
-				return nil, fmt.Errorf("failed to sync WAL before query: %w", err)
-			}
-		}
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
 
-		db.mu.Unlock()
-		db.mu.RLock()
+	if searchArgs.PostFilter != nil {
+		return nil, fmt.Errorf("%w: QueryIDs does not support a post-filter", ErrInvalidArgument)
 	}
 
-	// Create search query
-	query := index.NewSearchQuery(searchArgs.Query)
+	hits, _, err := db.search(searchArgs)
+	return hits, err
+}
 
-	// Validate query vector dimension
-	if len(query.Vector) != db.params.Dim {
-		return nil, fmt.Errorf("query vector length %d does not match index dimension %d",
-			len(query.Vector), db.params.Dim)
+// Vector returns the vector stored under id as it was upserted, also for the cosine
+// metric, whose index holds normalized copies. It returns ErrNotFound if the ID does
+// not exist or is soft-deleted, and common.ErrIDOutOfRange if it cannot be a FAISS
+// label; records written with UpsertAsync are found only once they are synced.
+func (db *VectorDatabase) Vector(id uint64) ([]float32, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
 	}
 
-	// Add HNSW parameters if provided
-	if searchArgs.HnswParams != nil {
-		hnswOpt := &index.HnswSearchOption{
-			EfSearch: searchArgs.HnswParams.EfSearch,
-		}
-		query = query.With(hnswOpt)
+	label, err := common.LabelFromID(id)
+	if err != nil {
+		return nil, err
+	}
+	vector, err := db.vectorIndex.Reconstruct(label)
+	if errors.Is(err, index.ErrLabelNotFound) || db.isSoftDeleted(id) {
+		return nil, fmt.Errorf("%w: vector %d", ErrNotFound, id)
+	}
+	return vector, err
+}
+
+// Exists reports whether a vector with id is stored and not soft-deleted, without
+// reading it; records written with UpsertAsync exist only once they are synced
+func (db *VectorDatabase) Exists(id uint64) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return false, ErrDatabaseClosed
+	}
+
+	return db.IsLive(id), nil
+}
+
+// search runs the vector search and returns the valid hits best-first, along with the
+// number of candidates the index considered (caller must hold read lock)
+func (db *VectorDatabase) search(searchArgs common.VdbSearchArgs) ([]common.SearchHit, int, error) {
+	if err := db.validateFilterInputs(searchArgs.FilterInputs); err != nil {
+		return nil, 0, err
+	}
+	// Custom indexes may hold compressed vectors, which cannot be compared exactly
+	if searchArgs.Exact && db.params.IndexType == common.IndexTypeCustom {
+		return nil, 0, fmt.Errorf("%w: exact search is not supported by custom indexes", ErrInvalidArgument)
+	}
+
+	vectors, err := db.queryVectors(searchArgs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if db.persistence.GetPendingCount() > 0 {
+		db.requestSync()
+	}
+
+	// Create search query; a multi-vector search swaps in each of its vectors.
+	// Tied results come back in label order, so grouping and pagination are repeatable.
+	query := index.NewSearchQuery(vectors[0]).WithStableOrder()
+
+	// Add HNSW parameters if provided, or else the default efSearch
+	efSearch := db.defaultEfSearch.Load()
+	if searchArgs.HnswParams != nil && searchArgs.HnswParams.EfSearch > 0 {
+		efSearch = searchArgs.HnswParams.EfSearch
+	}
+	if efSearch > 0 {
+		query = query.With(&index.HnswSearchOption{EfSearch: efSearch})
+	}
+	if searchArgs.Exact {
+		query = query.WithExact()
 	}
 
 	// Apply filters if provided
 	if len(searchArgs.FilterInputs) > 0 {
-		bitmap := filter.NewIdFilter().GetBitmap()
-
-		for _, filterInput := range searchArgs.FilterInputs {
-			var op filter.FilterOp
-			switch filterInput.Op {
-			case "equal":
-				op = filter.Equal
-			case "not_equal":
-				op = filter.NotEqual
-			default:
-				return nil, fmt.Errorf("unsupported filter operation: %s", filterInput.Op)
-			}
+		idFilter, err := db.resolveFilter(searchArgs.FilterInputs)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The index reads an empty filter as no filter, but no document matched these
+		if idFilter.IsEmpty() {
+			return []common.SearchHit{}, 0, nil
+		}
 
-			input := &filter.IntFilterInput{
-				Field:  filterInput.Field,
-				Op:     op,
-				Target: filterInput.Target,
-			}
+		query = query.WithFilter(idFilter)
+	}
 
-			bitmap = db.filterIndex.Apply(input, bitmap)
-		}
+	// Exclude soft-deleted IDs and specific IDs, e.g. the document the query vector came from
+	excludeFilter := db.tombstones.Load()
+	if len(searchArgs.ExcludeIDs) > 0 {
+		excludeFilter = excludeFilter.Clone()
+		excludeFilter.AddAll(searchArgs.ExcludeIDs)
+	}
+	if !excludeFilter.IsEmpty() {
+		query = query.WithExcludeFilter(excludeFilter)
+	}
+
+	// Execute search, bounded by the request timeout or else the database one,
+	// and registered so it can be listed and cancelled while it runs
+	timeout := db.params.QueryTimeoutMs
+	if searchArgs.TimeoutMs > 0 {
+		timeout = searchArgs.TimeoutMs
+	}
+
+	ctx, finish := db.queries.start(searchArgs)
+	defer finish()
 
-		query = query.WithFilter(filter.NewIdFilterFrom(bitmap))
+	if len(vectors) > 1 {
+		return db.searchMulti(ctx, query, vectors, searchArgs.K, searchArgs.Aggregation, time.Duration(timeout)*time.Millisecond)
 	}
 
-	// Execute search
-	searchResult, err := db.vectorIndex.Search(query, searchArgs.K)
+	searchResult, err := db.searchExpanding(ctx, query, searchArgs.K, time.Duration(timeout)*time.Millisecond)
 	if err != nil {
-		return nil, fmt.Errorf("unable to query vector data: %w", err)
+		return nil, 0, err
 	}
 
 	slog.Debug("Search completed", "result", searchResult)
 
-	if len(searchResult.Labels) == 0 {
-		return []common.DocMap{}, nil
+	// Pair the valid labels with their scores and order best-first for the metric:
+	// ascending distance for L2, descending score for IP and cosine
+	hits := searchResult.Hits()
+	sort.SliceStable(hits, func(i, j int) bool {
+		return db.params.MetricType.Better(hits[i].Score, hits[j].Score)
+	})
+
+	return hits, searchResult.Candidates, nil
+}
+
+// searchIndex runs the vector index search, giving up with ErrQueryTimeout once timeout
+// has passed, or with ErrQueryCanceled once ctx is cancelled; a zero timeout waits for
+// the search to finish unless cancelled.
+// With MaxConcurrentSearches set, the search first queues for a free slot, and the time
+// spent queuing counts against the timeout.
+// FAISS searches cannot be cancelled, so a timed-out or cancelled search keeps running in
+// its goroutine and holds the index and its slot until it finishes, but the caller gets a
+// timely error and releases the database lock.
+func (db *VectorDatabase) searchIndex(ctx context.Context, query *index.SearchQuery, k int, timeout time.Duration) (*index.SearchResult, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
 	}
 
-	// Convert labels to uint64 IDs, filtering out invalid labels (-1)
-	ids := make([]uint64, 0, len(searchResult.Labels))
-	for _, label := range searchResult.Labels {
-		if label >= 0 {
-			ids = append(ids, uint64(label))
+	if db.searchSlots != nil {
+		select {
+		case db.searchSlots <- struct{}{}:
+		case <-expired:
+			slog.Warn("Vector search timed out waiting for a search slot", "timeout", timeout)
+			return nil, fmt.Errorf("%w after %s", ErrQueryTimeout, timeout)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w while waiting for a search slot", ErrQueryCanceled)
 		}
 	}
 
-	// Retrieve documents from scalar storage
-	documents, err := db.scalarStorage.MultiGetValue(scalar.NamespaceDocs, ids)
+	type searchOutcome struct {
+		result *index.SearchResult
+		err    error
+	}
+
+	// Buffered so an abandoned search can still deliver its outcome and exit
+	done := make(chan searchOutcome, 1)
+	go func() {
+		defer db.releaseSearchSlot()
+		searchResult, err := db.vectorIndex.Search(query, k)
+		done <- searchOutcome{result: searchResult, err: err}
+	}()
+
+	select {
+	case outcome := <-done:
+		if outcome.err != nil {
+			return nil, fmt.Errorf("unable to query vector data: %w", outcome.err)
+		}
+		return outcome.result, nil
+	case <-expired:
+		slog.Warn("Vector search timed out", "timeout", timeout)
+		return nil, fmt.Errorf("%w after %s", ErrQueryTimeout, timeout)
+	case <-ctx.Done():
+		slog.Warn("Vector search canceled")
+		return nil, ErrQueryCanceled
+	}
+}
+
+// searchExpanding runs searchIndex and retries a filtered search on an HNSW index that
+// came back with fewer than k hits, although more indexed vectors pass its filters, with
+// double the efSearch each time up to HnswIndexOption.MaxFilteredEf. The graph
+// traversal stops after visiting efSearch nodes, so under a selective filter it can run
+// out before it finds k that pass. The retries share the timeout.
+func (db *VectorDatabase) searchExpanding(ctx context.Context, query *index.SearchQuery, k int, timeout time.Duration) (*index.SearchResult, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	result, err := db.searchIndex(ctx, query, k, timeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve documents: %w", err)
+		return nil, err
 	}
 
-	// Convert scalar.DocMap to common.DocMap
-	result := make([]common.DocMap, len(documents))
-	for i, doc := range documents {
-		result[i] = common.DocMap(doc)
+	if db.params.IndexType != common.IndexTypeHnsw || db.params.HnswParams == nil {
+		return result, nil
+	}
+	maxEfSearch := uint32(max(db.params.HnswParams.MaxFilteredEf, 0))
+	if maxEfSearch == 0 || (query.IdFilter == nil && query.ExcludeFilter == nil) {
+		return result, nil
+	}
+
+	efSearch := uint32(index.DefaultEfSearch)
+	if query.Hnsw != nil && query.Hnsw.EfSearch > 0 {
+		efSearch = query.Hnsw.EfSearch
+	}
+	for len(result.Hits()) < min(k, result.Candidates) && efSearch < maxEfSearch {
+		var remaining time.Duration
+		if !deadline.IsZero() {
+			if remaining = time.Until(deadline); remaining <= 0 {
+				return nil, fmt.Errorf("%w after %s", ErrQueryTimeout, timeout)
+			}
+		}
+
+		efSearch = min(efSearch*2, maxEfSearch)
+		slog.Debug("Retrying filtered search with a larger efSearch", "hits", len(result.Hits()), "k", k, "ef_search", efSearch)
+
+		retry := *query
+		retry.Hnsw = &index.HnswSearchOption{EfSearch: efSearch}
+		result, err = db.searchIndex(ctx, &retry, k, remaining)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return result, nil
 }
 
+// releaseSearchSlot frees the search slot taken by searchIndex, if searches are bounded
+func (db *VectorDatabase) releaseSearchSlot() {
+	if db.searchSlots != nil {
+		<-db.searchSlots
+	}
+}
+
+// resolveFilter returns the IDs matching any of the filter inputs (caller must hold read lock)
+func (db *VectorDatabase) resolveFilter(filterInputs []common.IntFilterInput) (*filter.IdFilter, error) {
+	bitmap := filter.NewIdFilter().GetBitmap()
+
+	for _, filterInput := range filterInputs {
+		op, ok := parseFilterOp(filterInput.Op)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedFilterOp, filterInput.Op)
+		}
+
+		target, err := filter.ParseTarget(filterInput.Target)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+		}
+
+		bitmap, err = db.filterIndex.ApplyTarget(filterInput.Field, op, target, bitmap)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+		}
+	}
+
+	return filter.NewIdFilterFrom(bitmap), nil
+}
+
+// parseFilterOp returns the filter operation named by op, with ok set to false for unknown names
+func parseFilterOp(op string) (filter.FilterOp, bool) {
+	switch op {
+	case "equal":
+		return filter.Equal, true
+	case "not_equal":
+		return filter.NotEqual, true
+	default:
+		return 0, false
+	}
+}
+
+// validateFilterInputs checks every filter input before any is applied, so that a request
+// with several mistakes reports all of them in one error joined with errors.Join. Each
+// problem wraps ErrUnsupportedFilterOp, ErrFieldNotFilterable or ErrInvalidArgument and
+// names its input's position.
+func (db *VectorDatabase) validateFilterInputs(filterInputs []common.IntFilterInput) error {
+	var errs []error
+	for i, filterInput := range filterInputs {
+		if filterInput.Field == "" {
+			errs = append(errs, fmt.Errorf("filter %d: %w: empty field name", i, ErrInvalidArgument))
+		} else if !db.filterIndex.Filterable(filterInput.Field) {
+			errs = append(errs, fmt.Errorf("filter %d: %w: %s is not in filterable_fields", i, ErrFieldNotFilterable, filterInput.Field))
+		}
+		if _, ok := parseFilterOp(filterInput.Op); !ok {
+			errs = append(errs, fmt.Errorf("filter %d: %w: %q", i, ErrUnsupportedFilterOp, filterInput.Op))
+		}
+		if target, err := filter.ParseTarget(filterInput.Target); err != nil {
+			errs = append(errs, fmt.Errorf("filter %d: %w: %v", i, ErrInvalidArgument, err))
+		} else if target.Kind == filter.TargetString {
+			errs = append(errs, fmt.Errorf("filter %d: %w: string target %q cannot match integer attributes", i, ErrInvalidArgument, target.Text))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// projectFields returns a copy of doc with only the given fields plus id and score,
+// or doc itself when no fields are given
+func projectFields(doc common.DocMap, fields []string) common.DocMap {
+	if len(fields) == 0 {
+		return doc
+	}
+
+	projected := make(common.DocMap, len(fields)+2)
+	for _, field := range fields {
+		if value, ok := doc[field]; ok {
+			projected[field] = value
+		}
+	}
+	for _, field := range []string{common.DocFieldID, common.DocFieldScore} {
+		if value, ok := doc[field]; ok {
+			projected[field] = value
+		}
+	}
+
+	return projected
+}
+
+// explainFilters returns the filter inputs doc satisfies, judged by the attributes stored
+// with it the way the filter index matches them
+func explainFilters(doc common.DocMap, filterInputs []common.IntFilterInput) []common.FilterMatch {
+	attributes, _ := doc[common.DocFieldAttributes].(map[string]any)
+	matches := make([]common.FilterMatch, 0)
+	for _, filterInput := range filterInputs {
+		value, ok := attributes[filterInput.Field]
+		if !ok {
+			continue
+		}
+		intValue, _, err := filter.AttributeValue(value)
+		if err != nil {
+			continue
+		}
+		target, err := filter.ParseTarget(filterInput.Target)
+		if err != nil {
+			continue
+		}
+		op, _ := parseFilterOp(filterInput.Op)
+		if !target.Matches(op, intValue) {
+			continue
+		}
+		matches = append(matches, common.FilterMatch{IntFilterInput: filterInput, Value: value})
+	}
+	return matches
+}
+
+// Delete removes the vectors with the given IDs along with their documents and attributes.
+// A Delete record is written to the WAL for each ID and synced before returning;
+// IDs that do not exist are ignored.
+func (db *VectorDatabase) Delete(ids []uint64) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return db.deleteIDs(ids)
+}
+
+// DeleteByFilter removes every vector whose attributes match any of the filter inputs,
+// using the same matching as Query, and returns how many were deleted.
+// Pending WAL records are synced first so that vectors written by UpsertAsync are matched too.
+func (db *VectorDatabase) DeleteByFilter(filterInputs []common.IntFilterInput) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if len(filterInputs) == 0 {
+		return 0, fmt.Errorf("%w: at least one filter input is required", ErrInvalidArgument)
+	}
+	if err := db.validateFilterInputs(filterInputs); err != nil {
+		return 0, err
+	}
+
+	if err := db.persistence.Sync(
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		return 0, fmt.Errorf("failed to sync WAL: %w", err)
+	}
+
+	idFilter, err := db.resolveFilter(filterInputs)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := idFilter.IDs()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := db.deleteIDs(ids); err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// deleteIDs writes a Delete WAL record per ID and syncs them (caller must hold read lock)
+func (db *VectorDatabase) deleteIDs(ids []uint64) error {
+	slog.Info("Deleting vector data", "ids", ids)
+
+	if err := db.persistence.WriteDeleteBatch(
+		ids,
+		true,
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		return fmt.Errorf("failed to write to WAL: %w", err)
+	}
+	db.countStorageGarbage(len(ids))
+
+	return nil
+}
+
+// Subscribe returns a channel that receives every WAL record after it is durably written,
+// along with a function to unsubscribe. Buffer size and the full-buffer policy
+// ("drop" or "block") come from the database CDC parameters; the default drops records
+// rather than stalling writers. The channel is closed on unsubscribe or database Close,
+// after the records Close flushes, and is returned already closed after Close.
+func (db *VectorDatabase) Subscribe() (<-chan persistence.WALRecord, func()) {
+	bufferSize := persistence.DefaultSubscriberBufferSize
+	policy := common.CDCPolicyDrop
+
+	if db.params.CDC != nil {
+		if db.params.CDC.BufferSize > 0 {
+			bufferSize = db.params.CDC.BufferSize
+		}
+		if db.params.CDC.Policy != "" {
+			policy = db.params.CDC.Policy
+		}
+	}
+
+	return db.persistence.Subscribe(bufferSize, policy)
+}
+
 // Close closes the database and releases resources
 func (db *VectorDatabase) Close() error {
+	// Reject new operations; operations already holding the read lock finish first
+	if !db.closed.CompareAndSwap(false, true) {
+		return ErrDatabaseClosed
+	}
+
 	// Stop background sync goroutine first (without holding lock)
 	close(db.stopSync)
 	db.syncDone.Wait()
@@ -402,52 +1309,61 @@ func (db *VectorDatabase) Close() error {
 	return nil
 }
 
-// backgroundSync periodically syncs pending WAL records
+// backgroundSync syncs pending WAL records periodically and whenever a sync is requested
 func (db *VectorDatabase) backgroundSync() {
 	defer db.syncDone.Done()
 
-	ticker := time.NewTicker(5 * time.Second) // Sync every 5 seconds
+	ticker := time.NewTicker(time.Duration(db.syncInterval.Load()))
 	defer ticker.Stop()
 
+	optimizeTicker := time.NewTicker(filterOptimizeInterval)
+	defer optimizeTicker.Stop()
+
 	for {
 		select {
 		case <-ticker.C:
-			// Check if there are pending records
-			if db.persistence.GetPendingCount() > 0 {
-				db.mu.Lock()
-
-				// Double-check after acquiring lock
-				if db.persistence.GetPendingCount() > 0 {
-					if err := db.persistence.Sync(
-						db.scalarStorage,
-						db.filterIndex,
-						db.vectorIndex,
-						db.params.Dim,
-					); err != nil {
-						slog.Error("Background sync failed", "error", err)
-					} else {
-						slog.Debug("Background sync completed", "records", db.persistence.GetPendingCount())
-					}
-				}
-
-				db.mu.Unlock()
+			db.syncPending("Background sync failed")
+
+		case <-optimizeTicker.C:
+			if db.filterIndex.Optimize() {
+				slog.Debug("Optimized filter index", "bytes", db.filterIndex.SizeInBytes())
+			}
+
+		case <-db.syncNow:
+			db.syncPending("Requested sync failed")
+
+		case <-db.syncIntervalChanged:
+			ticker.Reset(time.Duration(db.syncInterval.Load()))
+
+		case <-db.compactNow:
+			if err := db.compactStorage(); err != nil {
+				slog.Error("Background storage compaction failed", "error", err)
 			}
 
 		case <-db.stopSync:
 			// Perform final sync before stopping
-			db.mu.Lock()
-			if db.persistence.GetPendingCount() > 0 {
-				if err := db.persistence.Sync(
-					db.scalarStorage,
-					db.filterIndex,
-					db.vectorIndex,
-					db.params.Dim,
-				); err != nil {
-					slog.Error("Final sync failed", "error", err)
-				}
-			}
-			db.mu.Unlock()
+			db.syncPending("Final sync failed")
 			return
 		}
 	}
 }
+
+// syncPending applies pending WAL records, if any, logging failures with msg
+func (db *VectorDatabase) syncPending(msg string) {
+	pending := db.persistence.GetPendingCount()
+	if pending == 0 {
+		return
+	}
+
+	if err := db.persistence.Sync(
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		slog.Error(msg, "error", err)
+		return
+	}
+
+	slog.Debug("Background sync completed", "records", pending)
+}
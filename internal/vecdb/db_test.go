@@ -1,14 +1,25 @@
 package vecdb
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	gomath "math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"vecdb-go/internal/common"
 	"vecdb-go/internal/common/math"
+	"vecdb-go/internal/filter"
+	"vecdb-go/internal/index"
+	"vecdb-go/internal/persistence"
+	"vecdb-go/internal/scalar"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -251,6 +262,54 @@ func testVectorDatabaseUpsertWithWrongDim(t *testing.T, indexType common.IndexTy
 	assert.Error(t, err)
 }
 
+func TestVectorDatabaseUpsertMalformedMatrix(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Rows and Cols claim two vectors of dimension 3, but Data holds only one
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	assert.Contains(t, err.Error(), "has 3 elements, expected 6")
+
+	// Nothing was written
+	docs, _, err := db.ScanPage(0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, docs)
+}
+
+func TestVectorDatabaseEmptyInputs(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Empty query vectors are rejected as such, not as a dimension mismatch
+	_, err = db.Query(common.VdbSearchArgs{Query: []float32{}, K: 1})
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+	_, err = db.Query(common.VdbSearchArgs{K: 1})
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+	_, err = db.QueryIDs(common.VdbSearchArgs{Queries: [][]float32{{1, 0, 0}, {}}, K: 1})
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+	assert.Contains(t, err.Error(), "query vector 1")
+
+	// Upserts without rows fail instead of succeeding without writing anything
+	err = db.Upsert(common.VdbUpsertArgs{Vectors: math.Matrix32{Rows: 0, Cols: 3, Data: []float32{}}})
+	assert.ErrorIs(t, err, ErrNoRows)
+	err = db.UpsertAsync(common.VdbUpsertArgs{})
+	assert.ErrorIs(t, err, ErrNoRows)
+}
+
 func TestVectorDatabaseQueryWithNoResults_FlatL2(t *testing.T) {
 	testVectorDatabaseQueryWithNoResults(t, common.IndexTypeFlat, common.MetricTypeL2)
 }
@@ -285,6 +344,15 @@ func testVectorDatabaseQueryWithNoResults(t *testing.T, indexType common.IndexTy
 	results, err := db.Query(searchArgs)
 	require.NoError(t, err)
 	assert.Empty(t, results)
+
+	// The configured dimension is fixed before any vector is inserted, so an empty
+	// database still rejects query vectors of another length
+	for _, query := range [][]float32{{1.0, 2.0}, {1.0, 2.0, 3.0, 4.0}} {
+		_, err = db.Query(common.VdbSearchArgs{Query: query, K: 5})
+		assert.ErrorIs(t, err, ErrDimMismatch)
+	}
+	_, err = db.Query(common.VdbSearchArgs{Queries: [][]float32{{1.0, 2.0, 3.0}, {1.0, 2.0}}, K: 5})
+	assert.ErrorIs(t, err, ErrDimMismatch)
 }
 
 func TestVectorDatabaseQueryWithFilter_FlatL2(t *testing.T) {
@@ -423,3 +491,3398 @@ func testVectorDatabaseQueryWithFilter(t *testing.T, indexType common.IndexType,
 		assert.NotEqual(t, int64(2), catValue, "filtered results should not have category=2")
 	}
 }
+
+func TestVectorDatabaseUpsertNilDocsAndAttributes(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 3,
+			Cols: 3,
+			Data: []float32{
+				1.0, 0.0, 0.0,
+				0.0, 1.0, 0.0,
+				0.0, 0.0, 1.0,
+			},
+		},
+		Docs: []map[string]any{
+			{"name": "doc1"},
+			nil,
+			{"name": "doc3"},
+		},
+		Attributes: []map[string]any{
+			{"category": float64(1)},
+			{"category": float64(2)},
+			nil,
+		},
+	})
+	require.NoError(t, err)
+
+	docs, err := db.scalarStorage.MultiGetValue(scalar.NamespaceDocs, []uint64{1, 2, 3})
+	require.NoError(t, err)
+
+	// A nil doc stores only the id and its attributes
+	assert.Equal(t, common.DocMap{
+		"id":         float64(2),
+		"attributes": map[string]any{"category": float64(2)},
+	}, docs[1])
+
+	// Nil attributes are stored as an empty object
+	assert.Equal(t, common.DocMap{
+		"id":         float64(3),
+		"name":       "doc3",
+		"attributes": map[string]any{},
+	}, docs[2])
+
+	// The row with a nil doc is still indexed by its attributes
+	results, err := db.Query(common.VdbSearchArgs{
+		Query:        []float32{0.0, 0.0, 1.0},
+		K:            3,
+		FilterInputs: []common.IntFilterInput{{Field: "category", Op: "equal", Target: 2}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, float64(2), results[0][common.DocFieldID])
+}
+
+func TestVectorDatabaseDeleteByFilter_Flat(t *testing.T) {
+	testVectorDatabaseDeleteByFilter(t, common.IndexTypeFlat)
+}
+
+func TestVectorDatabaseDeleteByFilter_Hnsw(t *testing.T) {
+	testVectorDatabaseDeleteByFilter(t, common.IndexTypeHnsw)
+}
+
+func testVectorDatabaseDeleteByFilter(t *testing.T, indexType common.IndexType) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, indexType, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 4,
+			Cols: 3,
+			Data: []float32{
+				1.0, 0.0, 0.0,
+				0.9, 0.1, 0.0,
+				0.0, 1.0, 0.0,
+				0.0, 0.0, 1.0,
+			},
+		},
+		Docs: []map[string]any{
+			{"name": "doc1"},
+			{"name": "doc2"},
+			{"name": "doc3"},
+			{"name": "doc4"},
+		},
+		Attributes: []map[string]any{
+			{"tenant_id": float64(5)},
+			{"tenant_id": float64(7)},
+			{"tenant_id": float64(5)},
+			{"tenant_id": float64(7)},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = db.DeleteByFilter(nil)
+	assert.Error(t, err, "deleting without a filter should be rejected")
+
+	deleted, err := db.DeleteByFilter([]common.IntFilterInput{
+		{Field: "tenant_id", Op: "equal", Target: 5},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	assertRemaining := func(db *VectorDatabase) {
+		results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		for _, doc := range results {
+			assert.Contains(t, []string{"doc2", "doc4"}, doc["name"])
+		}
+	}
+	assertRemaining(db)
+
+	// Nothing is left to match, so a second delete is a no-op
+	deleted, err = db.DeleteByFilter([]common.IntFilterInput{
+		{Field: "tenant_id", Op: "equal", Target: 5},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	// The Delete records in the WAL are replayed on restart
+	require.NoError(t, db.Close())
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	assertRemaining(db)
+}
+
+func TestVectorDatabaseBatch(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:       []map[string]any{{"name": "old"}},
+		Attributes: []map[string]any{{"version": float64(1)}},
+	})
+	require.NoError(t, err)
+
+	// A bad operation rejects the whole batch before anything is written
+	err = db.Batch([]Operation{
+		{Type: OpDelete, ID: 1},
+		{Type: OpInsert, Vector: []float32{1.0, 0.0}},
+	})
+	assert.ErrorIs(t, err, ErrDimMismatch)
+
+	// Replace the vector in one step
+	err = db.Batch([]Operation{
+		{Type: OpDelete, ID: 1},
+		{Type: OpInsert, Vector: []float32{0.9, 0.1, 0.0}, Doc: map[string]any{"name": "new"}, Attributes: map[string]any{"version": float64(2)}},
+	})
+	require.NoError(t, err)
+
+	assertReplaced := func(db *VectorDatabase) {
+		results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 10})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "new", results[0]["name"])
+	}
+	assertReplaced(db)
+
+	// The batch is replayed from the WAL on restart
+	require.NoError(t, db.Close())
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	assertReplaced(db)
+}
+
+func TestVectorDatabaseUpsertAsync_FlatL2(t *testing.T) {
+	testVectorDatabaseUpsertAsync(t, common.IndexTypeFlat, common.MetricTypeL2)
+}
+
+func TestVectorDatabaseUpsertAsync_HnswL2(t *testing.T) {
+	testVectorDatabaseUpsertAsync(t, common.IndexTypeHnsw, common.MetricTypeL2)
+}
+
+func testVectorDatabaseUpsertAsync(t *testing.T, indexType common.IndexType, metricType common.MetricType) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(metricType, indexType, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	args := common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 2,
+			Cols: 3,
+			Data: []float32{1.0, 2.0, 3.0, 4.0, 5.0, 6.0},
+		},
+		Docs: []map[string]any{
+			{"name": "doc1"},
+			{"name": "doc2"},
+		},
+		Attributes: []map[string]any{
+			{"category": float64(1)},
+			{"category": float64(2)},
+		},
+	}
+
+	err = db.UpsertAsync(args)
+	require.NoError(t, err)
+
+	// Records stay pending until the next sync
+	assert.Equal(t, 2, db.persistence.GetPendingCount())
+
+	// Sync makes the records visible to Query
+	err = db.Sync()
+	require.NoError(t, err)
+	assert.Equal(t, 0, db.persistence.GetPendingCount())
+
+	results, err := db.Query(common.VdbSearchArgs{
+		Query: []float32{1.0, 2.0, 3.0},
+		K:     2,
+	})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+// blockingIndex wraps an index and blocks Insert until released
+type blockingIndex struct {
+	index.Index
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingIndex) Insert(params *index.InsertParams) error {
+	close(b.entered)
+	<-b.release
+	return b.Index.Insert(params)
+}
+
+func TestVectorDatabaseQueryDuringSync(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Seed one synced record
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 2.0, 3.0}},
+		Docs:    []map[string]any{{"name": "seed"}},
+	})
+	require.NoError(t, err)
+
+	blocking := &blockingIndex{
+		Index:   db.vectorIndex,
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	db.vectorIndex = blocking
+
+	// Queue a large batch and start syncing it; the sync stalls inside the vector insert
+	err = db.UpsertAsync(newBenchmarkUpsertArgs(5000, params.Dim))
+	require.NoError(t, err)
+
+	syncErr := make(chan error, 1)
+	go func() {
+		syncErr <- db.Sync()
+	}()
+	<-blocking.entered
+
+	// Reads proceed against the already-synced state while the sync is in progress
+	queried := make(chan []common.DocMap, 1)
+	go func() {
+		results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 2.0, 3.0}, K: 10})
+		assert.NoError(t, err)
+		queried <- results
+	}()
+
+	select {
+	case results := <-queried:
+		require.Len(t, results, 1)
+		assert.Equal(t, "seed", results[0]["name"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("query blocked by in-progress sync")
+	}
+
+	close(blocking.release)
+	require.NoError(t, <-syncErr)
+
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 2.0, 3.0}, K: 10})
+	require.NoError(t, err)
+	assert.Len(t, results, 10)
+}
+
+func TestVectorDatabaseSubscribe(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	records, unsubscribe := db.Subscribe()
+	defer unsubscribe()
+
+	args := common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 2,
+			Cols: 3,
+			Data: []float32{1.0, 2.0, 3.0, 4.0, 5.0, 6.0},
+		},
+		Docs: []map[string]any{
+			{"name": "doc1"},
+			{"name": "doc2"},
+		},
+	}
+
+	err = db.Upsert(args)
+	require.NoError(t, err)
+
+	for _, name := range []string{"doc1", "doc2"} {
+		select {
+		case record := <-records:
+			assert.Equal(t, name, record.Doc["name"])
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for record %s", name)
+		}
+	}
+}
+
+// newBenchmarkUpsertArgs creates an upsert batch of the given number of rows
+func newBenchmarkUpsertArgs(rows, dim int) common.VdbUpsertArgs {
+	data := make([]float32, rows*dim)
+	for i := range data {
+		data[i] = float32(i % 97)
+	}
+
+	docs := make([]map[string]any, rows)
+	attrs := make([]map[string]any, rows)
+	for i := 0; i < rows; i++ {
+		docs[i] = map[string]any{"name": fmt.Sprintf("doc%d", i)}
+		attrs[i] = map[string]any{"category": float64(i % 10)}
+	}
+
+	return common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: rows, Cols: dim, Data: data},
+		Docs:       docs,
+		Attributes: attrs,
+	}
+}
+
+func BenchmarkUpsert10k(b *testing.B) {
+	benchmarkUpsert(b, false)
+}
+
+func BenchmarkUpsertAsync10k(b *testing.B) {
+	benchmarkUpsert(b, true)
+}
+
+func benchmarkUpsert(b *testing.B, async bool) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(b, err)
+	defer db.Close()
+
+	args := newBenchmarkUpsertArgs(10000, params.Dim)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if async {
+			err = db.UpsertAsync(args)
+		} else {
+			err = db.Upsert(args)
+		}
+		require.NoError(b, err)
+	}
+}
+
+func TestVectorDatabaseQueryOrder_FlatL2(t *testing.T) {
+	testVectorDatabaseQueryOrder(t, common.IndexTypeFlat, common.MetricTypeL2, "near")
+}
+
+func TestVectorDatabaseQueryOrder_HnswL2(t *testing.T) {
+	testVectorDatabaseQueryOrder(t, common.IndexTypeHnsw, common.MetricTypeL2, "near")
+}
+
+func TestVectorDatabaseQueryOrder_FlatIP(t *testing.T) {
+	testVectorDatabaseQueryOrder(t, common.IndexTypeFlat, common.MetricTypeIP, "large")
+}
+
+func TestVectorDatabaseQueryOrder_HnswIP(t *testing.T) {
+	testVectorDatabaseQueryOrder(t, common.IndexTypeHnsw, common.MetricTypeIP, "large")
+}
+
+func TestVectorDatabaseQueryOrder_FlatCosine(t *testing.T) {
+	testVectorDatabaseQueryOrder(t, common.IndexTypeFlat, common.MetricTypeCosine, "near")
+}
+
+func TestVectorDatabaseQueryOrder_HnswCosine(t *testing.T) {
+	testVectorDatabaseQueryOrder(t, common.IndexTypeHnsw, common.MetricTypeCosine, "near")
+}
+
+func testVectorDatabaseQueryOrder(t *testing.T, indexType common.IndexType, metricType common.MetricType, expectedFirst string) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(metricType, indexType, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// For query (1, 0, 0), "near" has the smallest L2 distance and the largest
+	// cosine similarity, while "large" has the largest inner product
+	args := common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 3,
+			Cols: 3,
+			Data: []float32{
+				0.0, 1.0, 0.0,
+				1.0, 0.0, 0.0,
+				5.0, 5.0, 5.0,
+			},
+		},
+		Docs: []map[string]any{
+			{"name": "other"},
+			{"name": "near"},
+			{"name": "large"},
+		},
+	}
+
+	err = db.Upsert(args)
+	require.NoError(t, err)
+
+	results, err := db.Query(common.VdbSearchArgs{
+		Query: []float32{1.0, 0.0, 0.0},
+		K:     3,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, expectedFirst, results[0]["name"])
+
+	// Scores run best-first: ascending for L2, descending for IP and cosine
+	for i := 1; i < len(results); i++ {
+		prev, cur := results[i-1][common.DocFieldScore].(float32), results[i][common.DocFieldScore].(float32)
+		assert.False(t, metricType.Better(cur, prev), "result %d scores %v, better than %v before it", i, cur, prev)
+	}
+}
+
+func TestVectorDatabaseMaxDim(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	// Database dimension beyond the configured bound is rejected at construction
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.MaxDim = 2
+	_, err := NewVectorDatabase(&params)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "out of range")
+
+	// Oversized vectors are rejected on upsert before any other work
+	params.MaxDim = 4
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 5, Data: make([]float32, 5)},
+		Docs:    []map[string]any{{"name": "too big"}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds maximum dimension 4")
+}
+
+func TestVectorDatabaseErrors(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 2, Data: []float32{1.0, 2.0}},
+		Docs:    []map[string]any{{}},
+	})
+	assert.ErrorIs(t, err, ErrDimMismatch)
+
+	_, err = db.Query(common.VdbSearchArgs{Query: []float32{1.0, 2.0}, K: 1})
+	assert.ErrorIs(t, err, ErrDimMismatch)
+
+	_, err = db.Query(common.VdbSearchArgs{
+		Query:        []float32{1.0, 2.0, 3.0},
+		K:            1,
+		FilterInputs: []common.IntFilterInput{{Field: "category", Op: "greater", Target: 1}},
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedFilterOp)
+
+	_, err = db.DeleteByFilter([]common.IntFilterInput{{Field: "category", Op: "greater", Target: 1}})
+	assert.ErrorIs(t, err, ErrUnsupportedFilterOp)
+
+	require.NoError(t, db.Close())
+	assert.ErrorIs(t, db.Sync(), ErrClosed)
+}
+
+func TestVectorDatabaseFilterValidation(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:       []map[string]any{{"name": "a"}},
+		Attributes: []map[string]any{{"category": float64(1)}},
+	})
+	require.NoError(t, err)
+
+	filterInputs := []common.IntFilterInput{
+		{Field: "category", Op: "equal", Target: 1},
+		{Field: "category", Op: "greater", Target: 1},
+		{Field: "", Op: "equal", Target: 2},
+		{Field: "", Op: "lt", Target: 3},
+	}
+
+	// Every problem is reported in one error, not just the first
+	_, err = db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 1, FilterInputs: filterInputs})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrUnsupportedFilterOp)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	assert.Equal(t, strings.Join([]string{
+		`filter 1: unsupported filter operation: "greater"`,
+		`filter 2: invalid argument: empty field name`,
+		`filter 3: invalid argument: empty field name`,
+		`filter 3: unsupported filter operation: "lt"`,
+	}, "\n"), err.Error())
+
+	// Nothing is deleted when any filter input is invalid, even if others match
+	_, err = db.DeleteByFilter(filterInputs)
+	assert.ErrorIs(t, err, ErrUnsupportedFilterOp)
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 1})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestVectorDatabaseMaxDocBytes(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.MaxDocBytes = 1024
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The oversized doc rejects the whole upsert before anything is written
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1.0, 0.0, 0.0, 0.0, 1.0, 0.0}},
+		Docs: []map[string]any{
+			{"name": "small"},
+			{"name": strings.Repeat("x", 2048)},
+		},
+	})
+	assert.ErrorIs(t, err, ErrDocTooLarge)
+	assert.Equal(t, 0, db.persistence.GetPendingCount())
+
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 10})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	err = db.Batch([]Operation{
+		{Type: OpInsert, Vector: []float32{1.0, 0.0, 0.0}, Doc: map[string]any{"name": strings.Repeat("x", 2048)}},
+	})
+	assert.ErrorIs(t, err, ErrDocTooLarge)
+
+	// Docs within the limit are still accepted
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:    []map[string]any{{"name": strings.Repeat("x", 512)}},
+	})
+	require.NoError(t, err)
+}
+
+func TestVectorDatabaseRejectsNonFiniteVectors(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	nan := float32(gomath.NaN())
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1.0, 0.0, 0.0, 0.0, nan, 0.0}},
+		Docs:    []map[string]any{{"name": "ok"}, {"name": "bad"}},
+	})
+	assert.ErrorIs(t, err, ErrNonFiniteVector)
+	assert.ErrorContains(t, err, "row 1 has NaN at column 1")
+	assert.Equal(t, 0, db.persistence.GetPendingCount())
+
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{float32(gomath.Inf(-1)), 0.0, 0.0}},
+		Docs:    []map[string]any{{"name": "bad"}},
+	})
+	assert.ErrorIs(t, err, ErrNonFiniteVector)
+
+	err = db.Batch([]Operation{{Type: OpInsert, Vector: []float32{0.0, 0.0, float32(gomath.Inf(1))}}})
+	assert.ErrorIs(t, err, ErrNonFiniteVector)
+
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 10})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestVectorDatabaseSkipFiniteCheck(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.SkipFiniteCheck = true
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{float32(gomath.NaN()), 0.0, 0.0}},
+		Docs:    []map[string]any{{"name": "unchecked"}},
+	})
+	assert.NoError(t, err)
+}
+
+func TestVectorDatabaseAttributeTypes(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%t", strict), func(t *testing.T) {
+			tp := newTestPath()
+			defer tp.cleanup()
+
+			params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+			params.StrictAttributeTypes = strict
+			db, err := NewVectorDatabase(&params)
+			require.NoError(t, err)
+			defer db.Close()
+
+			err = db.Upsert(common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+				Docs:       []map[string]any{{"name": "int"}},
+				Attributes: []map[string]any{{"flag": float64(1)}},
+			})
+			require.NoError(t, err)
+
+			// A bool under a field first indexed as int
+			mixed := common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.0, 1.0, 0.0}},
+				Docs:       []map[string]any{{"name": "bool"}},
+				Attributes: []map[string]any{{"flag": true}},
+			}
+			err = db.Upsert(mixed)
+
+			filterArgs := common.VdbSearchArgs{
+				Query:        []float32{1.0, 0.0, 0.0},
+				K:            10,
+				FilterInputs: []common.IntFilterInput{{Field: "flag", Op: "equal", Target: 1}},
+			}
+
+			if !strict {
+				// Lenient mode indexes true as 1, so both docs match the same filter
+				require.NoError(t, err)
+				results, err := db.Query(filterArgs)
+				require.NoError(t, err)
+				assert.Len(t, results, 2)
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrAttributeTypeMismatch)
+			assert.ErrorContains(t, err, "field flag has a bool value, expected int")
+			assert.Equal(t, 0, db.persistence.GetPendingCount())
+
+			// Types are also checked within a single batch and a Batch call
+			err = db.Upsert(common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{0.0, 1.0, 0.0, 0.0, 0.0, 1.0}},
+				Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+				Attributes: []map[string]any{{"active": true}, {"active": float64(0)}},
+			})
+			assert.ErrorIs(t, err, ErrAttributeTypeMismatch)
+
+			err = db.Batch([]Operation{
+				{Type: OpInsert, Vector: []float32{0.0, 1.0, 0.0}, Attributes: map[string]any{"flag": false}},
+			})
+			assert.ErrorIs(t, err, ErrAttributeTypeMismatch)
+
+			results, err := db.Query(filterArgs)
+			require.NoError(t, err)
+			assert.Len(t, results, 1)
+
+			// A pending write reserves the type of a new field, so a conflicting write
+			// is rejected before it is logged rather than failing every later sync
+			err = db.UpsertAsync(common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.0, 1.0, 1.0}},
+				Docs:       []map[string]any{{"name": "tier-bool"}},
+				Attributes: []map[string]any{{"tier": true}},
+			})
+			require.NoError(t, err)
+			err = db.UpsertAsync(common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 1.0, 0.0}},
+				Docs:       []map[string]any{{"name": "tier-int"}},
+				Attributes: []map[string]any{{"tier": float64(1)}},
+			})
+			assert.ErrorIs(t, err, ErrAttributeTypeMismatch)
+			require.NoError(t, db.Sync())
+			assert.Equal(t, 0, db.persistence.GetPendingCount())
+
+			// Values of the registered type are still accepted
+			err = db.Upsert(common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.0, 0.0, 1.0}},
+				Docs:       []map[string]any{{"name": "int2"}},
+				Attributes: []map[string]any{{"flag": float64(2), "active": false}},
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVectorDatabaseUnsupportedAttribute(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Rejected before reaching the WAL, so a bad async write cannot block later syncs
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1.0, 0.0, 0.0, 0.0, 1.0, 0.0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+		Attributes: []map[string]any{{"category": float64(3)}, {"category": 3.5}},
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedAttribute)
+	assert.ErrorContains(t, err, "row 1: attribute category")
+	assert.Equal(t, 0, db.persistence.GetPendingCount())
+
+	err = db.Batch([]Operation{{Type: OpInsert, Vector: []float32{1.0, 0.0, 0.0}, Attributes: map[string]any{"name": "x"}}})
+	assert.ErrorIs(t, err, ErrUnsupportedAttribute)
+
+	// Integral JSON numbers are indexed as integers
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:       []map[string]any{{"name": "a"}},
+		Attributes: []map[string]any{{"category": 3.0}},
+	})
+	require.NoError(t, err)
+
+	results, err := db.Query(common.VdbSearchArgs{
+		Query:        []float32{1.0, 0.0, 0.0},
+		K:            1,
+		FilterInputs: []common.IntFilterInput{{Field: "category", Op: "equal", Target: 3}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestVectorDatabaseReadOnly(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1.0, 0.0, 0.0, 0.0, 1.0, 0.0}},
+		Docs:       []map[string]any{{"name": "doc1"}, {"name": "doc2"}},
+		Attributes: []map[string]any{{"category": float64(1)}, {"category": float64(2)}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	walPath := filepath.Join(tp.path(), WalFileSuffix)
+	walInfo, err := os.Stat(walPath)
+	require.NoError(t, err)
+
+	// Opening twice shows the first read-only open left the WAL in place
+	for range 2 {
+		readOnlyParams := params
+		readOnlyParams.ReadOnly = true
+		db, err = NewVectorDatabase(&readOnlyParams)
+		require.NoError(t, err)
+
+		results, err := db.Query(common.VdbSearchArgs{
+			Query:        []float32{1.0, 0.0, 0.0},
+			K:            2,
+			FilterInputs: []common.IntFilterInput{{Field: "category", Op: "equal", Target: 2}},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "doc2", results[0]["name"])
+
+		err = db.Upsert(common.VdbUpsertArgs{
+			Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.0, 0.0, 1.0}},
+			Docs:    []map[string]any{{"name": "doc3"}},
+		})
+		assert.ErrorIs(t, err, ErrReadOnly)
+		assert.ErrorIs(t, db.UpsertAsync(common.VdbUpsertArgs{}), ErrReadOnly)
+		assert.ErrorIs(t, db.Delete([]uint64{1}), ErrReadOnly)
+		_, err = db.DeleteByFilter([]common.IntFilterInput{{Field: "category", Op: "equal", Target: 1}})
+		assert.ErrorIs(t, err, ErrReadOnly)
+		assert.ErrorIs(t, db.Batch([]Operation{{Type: OpDelete, ID: 1}}), ErrReadOnly)
+
+		require.NoError(t, db.Close())
+
+		info, err := os.Stat(walPath)
+		require.NoError(t, err)
+		assert.Equal(t, walInfo.Size(), info.Size())
+	}
+}
+
+func TestScalarBuckets(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+
+	// Every database stores documents and soft deletes, and opening creates their buckets
+	assert.Equal(t, []string{scalar.NamespaceDocs, scalar.NamespaceTombstones}, scalarBuckets(&params))
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	for _, bucket := range scalarBuckets(&params) {
+		_, err := db.scalarStorage.Iterator(bucket)
+		assert.NoError(t, err, bucket)
+	}
+	require.NoError(t, db.Close())
+
+	// A feature's bucket is only opened when params enable the feature
+	defer func(features []storageFeature) { storageFeatures = features }(storageFeatures)
+	storageFeatures = append(slices.Clone(storageFeatures), storageFeature{
+		bucket:  "vectors",
+		enabled: func(params *common.DatabaseParams) bool { return !params.ReadOnly },
+	})
+
+	assert.Contains(t, scalarBuckets(&params), "vectors")
+	params.ReadOnly = true
+	assert.NotContains(t, scalarBuckets(&params), "vectors")
+}
+
+func TestVectorDatabaseScan(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 4,
+			Cols: 3,
+			Data: []float32{
+				1.0, 0.0, 0.0,
+				0.0, 1.0, 0.0,
+				0.0, 0.0, 1.0,
+				1.0, 1.0, 0.0,
+			},
+		},
+		Docs: []map[string]any{{"name": "doc1"}, {"name": "doc2"}, {"name": "doc3"}, {"name": "doc4"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Delete([]uint64{2}))
+
+	// Scan visits every remaining doc in ID order and skips the ID counter
+	var ids []uint64
+	err = db.Scan(func(id uint64, doc common.DocMap) bool {
+		assert.Equal(t, fmt.Sprintf("doc%d", id), doc["name"])
+		ids = append(ids, id)
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 3, 4}, ids)
+
+	// Returning false stops the scan
+	count := 0
+	err = db.Scan(func(id uint64, doc common.DocMap) bool {
+		count++
+		return false
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	docs, next, err := db.ScanPage(0, 2)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "doc3", docs[1]["name"])
+	assert.Equal(t, uint64(3), next)
+
+	docs, next, err = db.ScanPage(next, 2)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, "doc4", docs[0]["name"])
+	assert.Equal(t, uint64(0), next, "the last page has no next cursor")
+
+	// A page reads past soft-deleted documents until it is full
+	_, err = db.SoftDelete([]uint64{3})
+	require.NoError(t, err)
+	docs, next, err = db.ScanPage(1, 1)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, "doc4", docs[0]["name"])
+	assert.Equal(t, uint64(0), next)
+
+	// The cursor of the next page is always below the largest ID
+	_, _, err = db.ScanPage(common.MaxVectorID, 1)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, _, err = db.ScanPage(gomath.MaxUint64, 1)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestVectorDatabaseWALChecksum(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.WALChecksum = "md5"
+	_, err := NewVectorDatabase(&params)
+	assert.Error(t, err, "an unsupported checksum algorithm should be rejected")
+
+	params.WALChecksum = "xxhash"
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	require.NoError(t, db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:    []map[string]any{{"name": "doc1"}},
+	}))
+	require.NoError(t, db.Close())
+
+	// The records are verified and replayed on restart, also after switching algorithms
+	params.WALChecksum = "crc32c"
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "doc1", results[0]["name"])
+}
+
+func TestVectorDatabaseUseAfterClose(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	args := common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 2, 3}},
+		Docs:       []map[string]any{{"name": "doc"}},
+		Attributes: []map[string]any{{"category": float64(1)}},
+	}
+
+	assert.ErrorIs(t, db.Upsert(args), ErrDatabaseClosed)
+	assert.ErrorIs(t, db.UpsertAsync(args), ErrDatabaseClosed)
+	assert.ErrorIs(t, db.Sync(), ErrDatabaseClosed)
+
+	_, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 2, 3}, K: 1})
+	assert.ErrorIs(t, err, ErrDatabaseClosed)
+
+	ch, unsubscribe := db.Subscribe()
+	defer unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok, "Subscribe after Close should return a closed channel")
+
+	assert.ErrorIs(t, db.Close(), ErrDatabaseClosed)
+}
+
+func TestVectorDatabaseQueryExcludeIDs(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 3,
+			Cols: 3,
+			Data: []float32{
+				1.0, 0.0, 0.0,
+				0.9, 0.1, 0.0,
+				0.0, 1.0, 0.0,
+			},
+		},
+		Docs: []map[string]any{
+			{"name": "self"},
+			{"name": "neighbor"},
+			{"name": "far"},
+		},
+	})
+	require.NoError(t, err)
+
+	// Querying with a stored vector while excluding its own ID
+	results, err := db.Query(common.VdbSearchArgs{
+		Query:      []float32{1.0, 0.0, 0.0},
+		K:          3,
+		ExcludeIDs: []uint64{1},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "neighbor", results[0]["name"])
+	for _, doc := range results {
+		assert.NotEqual(t, "self", doc["name"])
+	}
+}
+
+func TestVectorDatabaseQueryFields(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs: []map[string]any{
+			{"title": "wide", "body": "a long body", "tags": []any{"x", "y"}},
+		},
+	})
+	require.NoError(t, err)
+
+	query := common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 1}
+
+	// Without fields the whole doc is returned along with its score
+	results, err := db.Query(query)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a long body", results[0]["body"])
+	assert.Contains(t, results[0], common.DocFieldScore)
+
+	// With fields only those keys plus id and score are kept; unknown fields are skipped
+	query.Fields = []string{"title", "missing"}
+	results, err = db.Query(query)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Len(t, results[0], 3)
+	assert.Equal(t, "wide", results[0]["title"])
+	assert.Equal(t, float64(1), results[0][common.DocFieldID])
+	assert.Equal(t, float32(0), results[0][common.DocFieldScore])
+}
+
+func TestVectorDatabaseQueryGroupBy(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The three nearest docs share category 1, so an ungrouped top-2 misses category 2
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 5,
+			Cols: 3,
+			Data: []float32{
+				1.0, 0.0, 0.0,
+				0.9, 0.1, 0.0,
+				0.8, 0.2, 0.0,
+				0.0, 1.0, 0.0,
+				0.0, 0.0, 1.0,
+			},
+		},
+		Docs: []map[string]any{
+			{"name": "a1", "kind": "x"},
+			{"name": "a2", "kind": "x"},
+			{"name": "a3", "kind": "y"},
+			{"name": "b1", "kind": "y"},
+			{"name": "c1"},
+		},
+		Attributes: []map[string]any{
+			{"category": float64(1)},
+			{"category": float64(1)},
+			{"category": float64(1)},
+			{"category": float64(2)},
+			{"category": float64(3)},
+		},
+	})
+	require.NoError(t, err)
+
+	query := common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 2, GroupBy: "category"}
+
+	// Grouping by an attribute returns the best hit of each category
+	results, err := db.Query(query)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a1", results[0]["name"])
+	assert.Equal(t, "b1", results[1]["name"])
+
+	// Grouping by a doc field; the doc without the field forms its own group
+	query.GroupBy = "kind"
+	query.K = 10
+	results, err = db.Query(query)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "a1", results[0]["name"])
+	assert.Equal(t, "a3", results[1]["name"])
+	assert.Equal(t, "c1", results[2]["name"])
+
+	// IDs-only results are grouped the same way
+	query.GroupBy = "category"
+	query.K = 2
+	query.IDsOnly = true
+	results, err = db.Query(query)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, uint64(1), results[0][common.DocFieldID])
+	assert.Equal(t, uint64(4), results[1][common.DocFieldID])
+}
+
+func TestVectorDatabaseIDOffset(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	// An offset that leaves no ID to assign is rejected
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.IDOffset = common.MaxVectorID
+	_, err := NewVectorDatabase(&params)
+	require.Error(t, err)
+
+	// Leave room for exactly two IDs below the boundary
+	params.IDOffset = common.MaxVectorID - 2
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Too many rows for the room left are rejected without consuming IDs
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: make([]float32, 9)},
+		Docs:    []map[string]any{{}, {}, {}},
+	})
+	require.ErrorIs(t, err, scalar.ErrIDsExhausted)
+	assert.ErrorContains(t, err, "exceed maximum vector ID")
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 2,
+			Cols: 3,
+			Data: []float32{
+				1.0, 0.0, 0.0,
+				0.0, 1.0, 0.0,
+			},
+		},
+		Docs:       []map[string]any{{"name": "first"}, {"name": "last"}},
+		Attributes: []map[string]any{{"category": float64(1)}, {"category": float64(2)}},
+	})
+	require.NoError(t, err)
+
+	// IDs at the boundary survive the label and filter round trip
+	results, err := db.Query(common.VdbSearchArgs{
+		Query:        []float32{0.0, 1.0, 0.0},
+		K:            2,
+		FilterInputs: []common.IntFilterInput{{Field: "category", Op: "equal", Target: 2}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "last", results[0]["name"])
+	assert.Equal(t, float64(common.MaxVectorID), results[0][common.DocFieldID])
+
+	// The next ID would cross the boundary
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.0, 0.0, 1.0}},
+		Docs:    []map[string]any{{"name": "overflow"}},
+	})
+	assert.ErrorIs(t, err, scalar.ErrIDsExhausted)
+}
+
+func TestVectorDatabaseIDsMonotonic(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+
+	upsertIDs := func(db *VectorDatabase, rows int) []uint64 {
+		data := make([]float32, rows*3)
+		docs := make([]map[string]any, rows)
+		for i := range docs {
+			data[i*3] = float32(i)
+			docs[i] = map[string]any{}
+		}
+		require.NoError(t, db.Upsert(common.VdbUpsertArgs{
+			Vectors: math.Matrix32{Rows: rows, Cols: 3, Data: data},
+			Docs:    docs,
+		}))
+
+		hits, err := db.QueryIDs(common.VdbSearchArgs{Query: []float32{0, 0, 0}, K: 100})
+		require.NoError(t, err)
+		ids := make([]uint64, len(hits))
+		for i, h := range hits {
+			ids[i] = h.ID
+		}
+		return ids
+	}
+
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, upsertIDs(db, 3))
+
+	// Deleted IDs are not reused
+	require.NoError(t, db.Delete([]uint64{3}))
+	assert.ElementsMatch(t, []uint64{1, 2, 4}, upsertIDs(db, 1))
+	require.NoError(t, db.Close())
+
+	// Losing the scalar store must not restart IDs below those replayed from the WAL
+	require.NoError(t, os.RemoveAll(filepath.Join(tp.path(), ScalarDBFileSuffix)))
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.ElementsMatch(t, []uint64{1, 2, 4, 5}, upsertIDs(db, 1))
+}
+
+// TestVectorDatabaseReplayConsistency checks that records applied eagerly by Upsert and
+// records replayed from the WAL on restart produce the same database state
+func TestVectorDatabaseReplayConsistency(t *testing.T) {
+	newArgs := func() common.VdbUpsertArgs {
+		return common.VdbUpsertArgs{
+			Vectors: math.Matrix32{
+				Rows: 4,
+				Cols: 3,
+				Data: []float32{
+					1.0, 0.0, 0.0,
+					0.8, 0.2, 0.0,
+					0.0, 1.0, 0.0,
+					0.0, 0.3, 0.9,
+				},
+			},
+			Docs: []map[string]any{
+				{"name": "doc1", "tags": []any{"a", "b"}},
+				nil,
+				{"name": "doc3", "nested": map[string]any{"score": 0.5}},
+				{"name": "doc4"},
+			},
+			Attributes: []map[string]any{
+				{"category": float64(1), "tenant": float64(7)},
+				{"category": float64(2)},
+				{"category": float64(1)},
+				nil,
+			},
+		}
+	}
+
+	// Eager path: each record is synced as part of Upsert
+	eagerPath := newTestPath()
+	defer eagerPath.cleanup()
+	eagerParams := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, eagerPath.path())
+	eagerDB, err := NewVectorDatabase(&eagerParams)
+	require.NoError(t, err)
+	defer eagerDB.Close()
+	require.NoError(t, eagerDB.Upsert(newArgs()))
+
+	// Replay path: records only reach the WAL, then are restored into an empty scalar store
+	replayPath := newTestPath()
+	defer replayPath.cleanup()
+	replayParams := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, replayPath.path())
+	replayDB, err := NewVectorDatabase(&replayParams)
+	require.NoError(t, err)
+	require.NoError(t, replayDB.UpsertAsync(newArgs()))
+	require.NoError(t, replayDB.Close())
+	require.NoError(t, os.RemoveAll(filepath.Join(replayPath.path(), ScalarDBFileSuffix)))
+	replayDB, err = NewVectorDatabase(&replayParams)
+	require.NoError(t, err)
+	defer replayDB.Close()
+
+	// Query results, including scores and doc contents, match
+	for _, args := range []common.VdbSearchArgs{
+		{Query: []float32{1.0, 0.0, 0.0}, K: 10},
+		{Query: []float32{0.0, 0.5, 0.5}, K: 2},
+		{Query: []float32{1.0, 0.0, 0.0}, K: 10, FilterInputs: []common.IntFilterInput{{Field: "category", Op: "equal", Target: 1}}},
+		{Query: []float32{1.0, 0.0, 0.0}, K: 10, FilterInputs: []common.IntFilterInput{{Field: "category", Op: "not_equal", Target: 1}}},
+		{Query: []float32{1.0, 0.0, 0.0}, K: 10, FilterInputs: []common.IntFilterInput{{Field: "tenant", Op: "equal", Target: 7}}},
+	} {
+		eagerResults, err := eagerDB.Query(args)
+		require.NoError(t, err)
+		replayResults, err := replayDB.Query(args)
+		require.NoError(t, err)
+		assert.Equal(t, eagerResults, replayResults, "query %+v", args)
+	}
+
+	// Stored docs match byte for byte
+	for id := uint64(1); id <= 4; id++ {
+		eagerRaw, err := eagerDB.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(id))
+		require.NoError(t, err)
+		replayRaw, err := replayDB.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(id))
+		require.NoError(t, err)
+		require.NotNil(t, eagerRaw, "doc %d", id)
+		assert.JSONEq(t, string(eagerRaw), string(replayRaw), "doc %d", id)
+	}
+
+	// Upserting the same doc into the restored database stores the same shape under the next ID
+	require.NoError(t, replayDB.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.0, 0.3, 0.9}},
+		Docs:    []map[string]any{{"name": "doc4"}},
+	}))
+	insertedRaw, err := replayDB.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(5))
+	require.NoError(t, err)
+	syncedRaw, err := eagerDB.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(4))
+	require.NoError(t, err)
+	var inserted, synced common.DocMap
+	require.NoError(t, json.Unmarshal(insertedRaw, &inserted))
+	require.NoError(t, json.Unmarshal(syncedRaw, &synced))
+	assert.Equal(t, float64(5), inserted[common.DocFieldID])
+	inserted[common.DocFieldID] = synced[common.DocFieldID]
+	assert.Equal(t, synced, inserted)
+}
+
+func TestVectorDatabaseStoredDocIdentical(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	doc := map[string]any{"name": "doc1", "tags": []any{"a", "b"}, "nested": map[string]any{"n": 1.5}}
+	attributes := map[string]any{"category": float64(3)}
+
+	require.NoError(t, db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:       []map[string]any{doc},
+		Attributes: []map[string]any{attributes},
+	}))
+	synced, err := db.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(1))
+	require.NoError(t, err)
+
+	built, err := common.BuildStoredDoc(doc, attributes, 1, common.DocCodecJSON)
+	require.NoError(t, err)
+	assert.Equal(t, string(synced), string(built))
+
+	// Writing the same doc through a background sync stores the same bytes
+	require.NoError(t, db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 0.0, 0.0}},
+		Docs:       []map[string]any{doc},
+		Attributes: []map[string]any{attributes},
+	}))
+	require.NoError(t, db.Sync())
+	inserted, err := db.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(2))
+	require.NoError(t, err)
+	built, err = common.BuildStoredDoc(doc, attributes, 2, common.DocCodecJSON)
+	require.NoError(t, err)
+	assert.Equal(t, string(built), string(inserted))
+
+	// The caller's doc is left untouched
+	assert.NotContains(t, doc, common.DocFieldID)
+	assert.NotContains(t, doc, common.DocFieldAttributes)
+}
+
+func TestVectorDatabaseQueryIDsOnly(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{
+			Rows: 2,
+			Cols: 3,
+			Data: []float32{
+				0.0, 1.0, 0.0,
+				1.0, 0.0, 0.0,
+			},
+		},
+		Docs: []map[string]any{{"name": "far"}, {"name": "near"}},
+	})
+	require.NoError(t, err)
+
+	args := common.VdbSearchArgs{Query: []float32{1.0, 0.0, 0.0}, K: 2}
+
+	hits, err := db.QueryIDs(args)
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	assert.Equal(t, common.SearchHit{ID: 2, Score: 0}, hits[0])
+	assert.Equal(t, uint64(1), hits[1].ID)
+
+	// Query with IDsOnly returns the same hits without documents
+	args.IDsOnly = true
+	results, err := db.Query(args)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for i, doc := range results {
+		assert.Len(t, doc, 2)
+		assert.Equal(t, hits[i].ID, doc[common.DocFieldID])
+		assert.Equal(t, hits[i].Score, doc[common.DocFieldScore])
+	}
+}
+
+// slowIndex wraps an index and delays every Search
+type slowIndex struct {
+	index.Index
+	delay time.Duration
+}
+
+func (s *slowIndex) Search(query *index.SearchQuery, k int) (*index.SearchResult, error) {
+	time.Sleep(s.delay)
+	return s.Index.Search(query, k)
+}
+
+func TestVectorDatabaseWarmUp(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeHnsw, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Reopening with warm-up restores the data and runs the warm-up queries
+	params.WarmUp = &common.WarmUpOption{Queries: 5}
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, 5, db.warmUp(params.WarmUp))
+
+	// The timeout bounds the warm-up even if queries are slow
+	db.vectorIndex = &slowIndex{Index: db.vectorIndex, delay: 10 * time.Millisecond}
+	ran := db.warmUp(&common.WarmUpOption{Queries: 1000, TimeoutMs: 30})
+	assert.Less(t, ran, 1000)
+}
+
+func TestVectorDatabaseQueryTimeout(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.QueryTimeoutMs = 20
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+		Docs:    []map[string]any{{"name": "a"}},
+	})
+	require.NoError(t, err)
+
+	db.vectorIndex = &slowIndex{Index: db.vectorIndex, delay: 200 * time.Millisecond}
+
+	// The configured timeout returns an error well before the search finishes
+	start := time.Now()
+	_, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1})
+	assert.ErrorIs(t, err, ErrQueryTimeout)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+
+	// A per-request timeout overrides the configured one
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1, TimeoutMs: 1000})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0]["name"])
+}
+
+func TestVectorDatabaseCancelQuery(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+		Docs:    []map[string]any{{"name": "a"}},
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, db.RunningQueries())
+	assert.ErrorIs(t, db.CancelQuery(1), ErrNotFound)
+
+	db.vectorIndex = &slowIndex{Index: db.vectorIndex, delay: 500 * time.Millisecond}
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2})
+		errs <- err
+	}()
+
+	var running []RunningQuery
+	require.Eventually(t, func() bool {
+		running = db.RunningQueries()
+		return len(running) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 2, running[0].K)
+	assert.Equal(t, 1, running[0].Vectors)
+	assert.False(t, running[0].Started.Before(start))
+
+	// The cancelled query returns without waiting for the search to finish
+	require.NoError(t, db.CancelQuery(running[0].ID))
+	assert.ErrorIs(t, <-errs, ErrQueryCanceled)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+
+	assert.Empty(t, db.RunningQueries())
+	assert.ErrorIs(t, db.CancelQuery(running[0].ID), ErrNotFound)
+}
+
+// concurrencyIndex wraps an index, delays every Search and records the most searches
+// that ran at once
+type concurrencyIndex struct {
+	index.Index
+	delay   time.Duration
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (c *concurrencyIndex) Search(query *index.SearchQuery, k int) (*index.SearchResult, error) {
+	running := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		peak := c.peak.Load()
+		if running <= peak || c.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(c.delay)
+	return c.Index.Search(query, k)
+}
+
+func TestVectorDatabaseMaxConcurrentSearches(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.MaxConcurrentSearches = 2
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+		Docs:    []map[string]any{{"name": "a"}},
+	})
+	require.NoError(t, err)
+
+	slow := &concurrencyIndex{Index: db.vectorIndex, delay: 20 * time.Millisecond}
+	db.vectorIndex = slow
+
+	// Every query completes, but no more than two search the index at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), slow.peak.Load())
+
+	// Time spent queuing for a slot counts against the query timeout
+	db.searchSlots <- struct{}{}
+	db.searchSlots <- struct{}{}
+	_, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1, TimeoutMs: 20})
+	assert.ErrorIs(t, err, ErrQueryTimeout)
+	<-db.searchSlots
+	<-db.searchSlots
+}
+
+func TestVectorDatabaseHnswParallelInsert(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name      string
+		indexType common.IndexType
+		option    *common.HnswIndexOption
+		want      bool
+	}{
+		{"hnsw default", common.IndexTypeHnsw, nil, true},
+		{"hnsw disabled", common.IndexTypeHnsw, &common.HnswIndexOption{EFConstruction: 200, M: 16, ParallelInsert: &disabled}, false},
+		{"flat", common.IndexTypeFlat, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := newTestPath()
+			defer tp.cleanup()
+
+			params := createTestIndexParams(common.MetricTypeL2, tt.indexType, tp.path())
+			if tt.option != nil {
+				params.HnswParams = tt.option
+			}
+			assert.Equal(t, tt.want, params.HnswParallelInsert())
+
+			db, err := NewVectorDatabase(&params)
+			require.NoError(t, err)
+			defer db.Close()
+
+			args := common.VdbUpsertArgs{
+				Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1.0, 2.0, 3.0, 4.0, 5.0, 6.0}},
+				Docs:    []map[string]any{{"name": "doc1"}, {"name": "doc2"}},
+			}
+			// Cover both the eager path and the sync path
+			require.NoError(t, db.Upsert(args))
+			require.NoError(t, db.UpsertAsync(args))
+			require.NoError(t, db.Sync())
+
+			results, err := db.Query(common.VdbSearchArgs{Query: []float32{4.0, 5.0, 6.0}, K: 4})
+			require.NoError(t, err)
+			assert.Len(t, results, 4)
+		})
+	}
+}
+
+func TestVectorDatabaseFilterFields(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	fields, err := db.FilterFields()
+	require.NoError(t, err)
+	assert.Empty(t, fields)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 4, Cols: 3, Data: []float32{
+			1.0, 0.0, 0.0,
+			0.0, 1.0, 0.0,
+			0.0, 0.0, 1.0,
+			1.0, 1.0, 0.0,
+		}},
+		Docs: []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}},
+		Attributes: []map[string]any{
+			{"price": float64(10), "active": true},
+			{"price": float64(-5), "active": false},
+			{"price": float64(10), "flag": float64(1)},
+			{"price": float64(42), "flag": true},
+		},
+	})
+	require.NoError(t, err)
+
+	fields, err = db.FilterFields()
+	require.NoError(t, err)
+	assert.Equal(t, []FieldInfo{
+		{Name: "active", Type: filter.FieldTypeBool, Min: 0, Max: 1, Distinct: 2},
+		{Name: "flag", Type: filter.FieldTypeMixed, Min: 1, Max: 1, Distinct: 1},
+		{Name: "price", Type: filter.FieldTypeInt, Min: -5, Max: 42, Distinct: 3},
+	}, fields)
+
+	// Deleting the extreme values shrinks the range; fields without values disappear
+	deleted, err := db.DeleteByFilter([]common.IntFilterInput{{Field: "price", Op: "equal", Target: 42}})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	deleted, err = db.DeleteByFilter([]common.IntFilterInput{{Field: "price", Op: "equal", Target: -5}})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	fields, err = db.FilterFields()
+	require.NoError(t, err)
+	assert.Equal(t, []FieldInfo{
+		{Name: "active", Type: filter.FieldTypeBool, Min: 1, Max: 1, Distinct: 1},
+		{Name: "flag", Type: filter.FieldTypeMixed, Min: 1, Max: 1, Distinct: 1},
+		{Name: "price", Type: filter.FieldTypeInt, Min: 10, Max: 10, Distinct: 1},
+	}, fields)
+
+	require.NoError(t, db.Close())
+	_, err = db.FilterFields()
+	assert.ErrorIs(t, err, ErrDatabaseClosed)
+}
+
+func TestVectorDatabaseCosineVector(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeCosine, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{3.0, 4.0, 0.0, 0.0, 0.0, 2.0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	hits, err := db.QueryIDs(common.VdbSearchArgs{Query: []float32{6.0, 8.0, 0.0}, K: 2})
+	require.NoError(t, err)
+	require.Len(t, hits, 2)
+	assert.InDelta(t, 1.0, hits[0].Score, 1e-6, "a parallel vector has cosine similarity 1")
+
+	// The index holds normalized copies, but Vector returns the upserted vector
+	vector, err := db.Vector(hits[0].ID)
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float32{3.0, 4.0, 0.0}, vector, 1e-5)
+
+	_, err = db.Vector(hits[0].ID + 100)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, db.Delete([]uint64{hits[0].ID}))
+	_, err = db.Vector(hits[0].ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestVectorDatabaseExists(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeHnsw, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+	})
+	require.NoError(t, err)
+
+	exists := func(id uint64) bool {
+		ok, err := db.Exists(id)
+		require.NoError(t, err)
+		return ok
+	}
+	assert.True(t, exists(1))
+	assert.False(t, exists(4))
+	assert.False(t, exists(common.MaxVectorID+1))
+
+	// Async writes exist once they are synced
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 1, 0}},
+		Docs:    []map[string]any{{"name": "d"}},
+	})
+	require.NoError(t, err)
+	assert.False(t, exists(4))
+	require.NoError(t, db.Sync())
+	assert.True(t, exists(4))
+
+	require.NoError(t, db.Delete([]uint64{2}))
+	_, err = db.SoftDelete([]uint64{3})
+	require.NoError(t, err)
+	assert.False(t, exists(2))
+	assert.False(t, exists(3))
+	assert.True(t, exists(1))
+
+	require.NoError(t, db.Close())
+	_, err = db.Exists(1)
+	assert.ErrorIs(t, err, ErrDatabaseClosed)
+}
+
+func TestVectorDatabaseLiveSet(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	// The live set always matches the labels of the vector index
+	assertLive := func(db *VectorDatabase, ids ...uint64) {
+		t.Helper()
+		labels := db.vectorIndex.Labels()
+		indexed := make([]uint64, len(labels))
+		for i, label := range labels {
+			indexed[i] = uint64(label)
+		}
+		assert.ElementsMatch(t, indexed, db.live.ids.IDs())
+
+		assert.Equal(t, len(ids), db.LiveCount())
+		for _, id := range ids {
+			assert.True(t, db.IsLive(id), "id %d", id)
+		}
+	}
+	assertLive(db)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 4, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}},
+	})
+	require.NoError(t, err)
+	assertLive(db, 1, 2, 3, 4)
+
+	require.NoError(t, db.Delete([]uint64{2}))
+	assert.False(t, db.IsLive(2))
+	assertLive(db, 1, 3, 4)
+
+	// Soft-deleted vectors stay in the index but are not live
+	marked, err := db.SoftDelete([]uint64{3, 2})
+	require.NoError(t, err)
+	assert.Equal(t, 1, marked)
+	assert.False(t, db.IsLive(3))
+	assertLive(db, 1, 4)
+
+	// Pending records are not live until they are synced
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 1, 1}},
+		Docs:    []map[string]any{{"name": "e"}},
+	})
+	require.NoError(t, err)
+	assert.False(t, db.IsLive(5))
+	require.NoError(t, db.Sync())
+	assertLive(db, 1, 4, 5)
+
+	// A batch that inserts and deletes keeps the deleted vector out
+	require.NoError(t, db.Batch([]Operation{
+		{Type: OpInsert, Vector: []float32{2, 0, 0}},
+		{Type: OpDelete, ID: 6},
+		{Type: OpDelete, ID: 1},
+	}))
+	assertLive(db, 4, 5)
+	require.NoError(t, db.Close())
+
+	// Replaying the WAL on open rebuilds the same set
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	assertLive(db, 4, 5)
+	assert.False(t, db.IsLive(3))
+	assert.False(t, db.IsLive(common.MaxVectorID+4))
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open(Config{Dim: 3})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	// The directory is created and unset parameters take their defaults
+	path := filepath.Join(t.TempDir(), "nested", "db")
+	db, err := Open(Config{Path: path, Dim: 3, Index: common.IndexTypeHnsw})
+	require.NoError(t, err)
+
+	assert.Equal(t, common.MetricTypeL2, db.params.MetricType)
+	require.NotNil(t, db.params.HnswParams)
+	assert.Equal(t, DefaultHnswM, db.params.HnswParams.M)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1.0, 2.0, 3.0}},
+		Docs:    []map[string]any{{"name": "a"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Options apply, while the fields of Config take precedence over theirs
+	db, err = Open(Config{
+		Path:    path,
+		Dim:     3,
+		Index:   common.IndexTypeHnsw,
+		Options: common.DatabaseParams{FilePath: "ignored", Dim: 5, ReadOnly: true},
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1.0, 2.0, 3.0}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0]["name"])
+	assert.ErrorIs(t, db.Delete([]uint64{1}), ErrReadOnly)
+}
+
+func TestVectorDatabaseMultiVectorQuery(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeIP, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 4, Cols: 3, Data: []float32{
+			1.0, 0.0, 0.0,
+			0.0, 1.0, 0.0,
+			0.6, 0.6, 0.0,
+			0.0, 0.0, 1.0,
+		}},
+		Docs: []map[string]any{{"name": "x"}, {"name": "y"}, {"name": "xy"}, {"name": "z"}},
+	})
+	require.NoError(t, err)
+
+	queries := [][]float32{{1.0, 0.0, 0.0}, {0.0, 1.0, 0.0}}
+	query := func(aggregation common.Aggregation, k int) ([]string, []float32) {
+		results, err := db.Query(common.VdbSearchArgs{Queries: queries, Aggregation: aggregation, K: k})
+		require.NoError(t, err)
+		var names []string
+		var scores []float32
+		for _, doc := range results {
+			names = append(names, doc["name"].(string))
+			scores = append(scores, doc[common.DocFieldScore].(float32))
+		}
+		return names, scores
+	}
+
+	// Max ranks by the best match to any query vector, mean by the match to all of them
+	names, scores := query(common.AggregationMax, 3)
+	assert.Equal(t, []string{"x", "y", "xy"}, names)
+	assert.InDeltaSlice(t, []float32{1.0, 1.0, 0.6}, scores, 1e-6)
+
+	names, _ = query("", 3)
+	assert.Equal(t, []string{"x", "y", "xy"}, names, "max is the default aggregation")
+
+	names, scores = query(common.AggregationMean, 3)
+	assert.Equal(t, []string{"xy", "x", "y"}, names)
+	assert.InDeltaSlice(t, []float32{0.6, 0.5, 0.5}, scores, 1e-6)
+
+	// Scores of candidates missing from a sub-query's hits are computed, not skipped
+	names, scores = query(common.AggregationMean, 1)
+	assert.Equal(t, []string{"xy"}, names)
+	assert.InDeltaSlice(t, []float32{0.6}, scores, 1e-6)
+
+	_, err = db.Query(common.VdbSearchArgs{Query: queries[0], Queries: queries, K: 1})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = db.Query(common.VdbSearchArgs{Queries: queries, Aggregation: "sum", K: 1})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = db.Query(common.VdbSearchArgs{Queries: [][]float32{{1.0, 0.0, 0.0}, {1.0}}, K: 1})
+	assert.ErrorIs(t, err, ErrDimMismatch)
+}
+
+func TestMetricScore(t *testing.T) {
+	query := []float32{1.0, 2.0, 2.0}
+	vector := []float32{2.0, 0.0, 0.0}
+
+	assert.InDelta(t, 9.0, metricScore(common.MetricTypeL2, query, vector), 1e-6)
+	assert.InDelta(t, 2.0, metricScore(common.MetricTypeIP, query, vector), 1e-6)
+	assert.InDelta(t, 1.0/3.0, metricScore(common.MetricTypeCosine, query, vector), 1e-6)
+	assert.Zero(t, metricScore(common.MetricTypeCosine, query, []float32{0, 0, 0}))
+}
+
+func TestVectorDatabaseLayoutVersion(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	versionPath := filepath.Join(tp.path(), LayoutVersionFile)
+
+	// A new directory is marked with the current version
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(versionPath)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\n", LayoutVersion), string(data))
+
+	// A matching version opens
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Other versions are refused without opening the directory
+	for _, version := range []string{"0", fmt.Sprint(LayoutVersion + 1), "garbage"} {
+		require.NoError(t, os.WriteFile(versionPath, []byte(version+"\n"), 0o644))
+		_, err = NewVectorDatabase(&params)
+		assert.ErrorIs(t, err, ErrLayoutVersion, "version %s", version)
+	}
+
+	// A directory written before the layout was versioned is version 1
+	require.NoError(t, os.Remove(versionPath))
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	assert.FileExists(t, versionPath)
+}
+
+// countingIndex wraps an index and counts Search calls
+type countingIndex struct {
+	index.Index
+	searches atomic.Int32
+}
+
+func (c *countingIndex) Search(query *index.SearchQuery, k int) (*index.SearchResult, error) {
+	c.searches.Add(1)
+	return c.Index.Search(query, k)
+}
+
+func TestVectorDatabaseQueryCache(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.QueryCache = &common.QueryCacheOption{Size: 2, TTLMs: 100}
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	counting := &countingIndex{Index: db.vectorIndex}
+	db.vectorIndex = counting
+	args := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1}
+
+	// The second identical query is a hit, even with another timeout
+	results, err := db.Query(args)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0]["name"])
+	results[0]["name"] = "modified"
+
+	withTimeout := args
+	withTimeout.TimeoutMs = 1000
+	results, err = db.Query(withTimeout)
+	require.NoError(t, err)
+	assert.Equal(t, "a", results[0]["name"], "cached results are not shared with callers")
+	assert.Equal(t, int32(1), counting.searches.Load())
+
+	// A different k misses
+	results, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, int32(2), counting.searches.Load())
+
+	// A write invalidates cached results
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0.01}},
+		Docs:    []map[string]any{{"name": "c"}},
+	})
+	require.NoError(t, err)
+	results, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "c", results[1]["name"])
+	assert.Equal(t, int32(3), counting.searches.Load())
+
+	// Results expire after the TTL
+	_, err = db.Query(args)
+	require.NoError(t, err)
+	searches := counting.searches.Load()
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Query(args)
+	require.NoError(t, err)
+	assert.Equal(t, searches+1, counting.searches.Load())
+}
+
+func TestVectorDatabaseNormalizeScores(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 2, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}},
+	})
+	require.NoError(t, err)
+
+	// Ranking is unchanged, scores become similarities and raw scores are kept
+	for _, idsOnly := range []bool{false, true} {
+		results, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2, IDsOnly: idsOnly, NormalizeScores: true})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, float32(0), results[0][common.DocFieldRawScore])
+		assert.Equal(t, float32(1), results[0][common.DocFieldScore])
+		assert.Equal(t, float32(5), results[1][common.DocFieldRawScore])
+		assert.InDelta(t, 1.0/6.0, results[1][common.DocFieldScore], 1e-6)
+	}
+
+	// Without the option scores stay raw
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2})
+	require.NoError(t, err)
+	assert.Equal(t, float32(5), results[1][common.DocFieldScore])
+	assert.NotContains(t, results[1], common.DocFieldRawScore)
+}
+
+func TestNormalizedScore(t *testing.T) {
+	assert.Equal(t, float32(1), common.MetricTypeL2.NormalizedScore(0))
+	assert.InDelta(t, 0.5, common.MetricTypeL2.NormalizedScore(1), 1e-6)
+	assert.InDelta(t, 0.5, common.MetricTypeIP.NormalizedScore(0), 1e-6)
+	assert.Greater(t, common.MetricTypeIP.NormalizedScore(10), float32(0.99))
+	assert.Less(t, common.MetricTypeIP.NormalizedScore(-10), float32(0.01))
+	assert.Equal(t, float32(1), common.MetricTypeCosine.NormalizedScore(1))
+	assert.Equal(t, float32(0.5), common.MetricTypeCosine.NormalizedScore(0))
+	assert.Equal(t, float32(0), common.MetricTypeCosine.NormalizedScore(-1))
+}
+
+func TestVectorDatabaseQueryByID(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+		Attributes: []map[string]any{
+			{"group": 1},
+			{"group": 2},
+			{"group": 1},
+		},
+	})
+	require.NoError(t, err)
+
+	// The document itself is the best match unless it is excluded
+	results, _, err := db.QueryByID(1, common.VdbSearchArgs{K: 2}, false)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "a", results[0]["name"])
+	assert.Equal(t, "b", results[1]["name"])
+
+	results, _, err = db.QueryByID(1, common.VdbSearchArgs{K: 2}, true)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "b", results[0]["name"])
+	assert.Equal(t, "c", results[1]["name"])
+
+	// Filters apply as in a normal search
+	results, _, err = db.QueryByID(1, common.VdbSearchArgs{
+		K:            2,
+		FilterInputs: []common.IntFilterInput{{Field: "group", Op: "equal", Target: 1}},
+	}, true)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "c", results[0]["name"])
+
+	_, _, err = db.QueryByID(42, common.VdbSearchArgs{K: 1}, true)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// IDs that do not fit a FAISS label are rejected rather than wrapped around
+	_, _, err = db.QueryByID(uint64(gomath.MaxInt64)+2, common.VdbSearchArgs{K: 1}, true)
+	assert.ErrorIs(t, err, common.ErrIDOutOfRange)
+	_, err = db.Vector(uint64(gomath.MaxInt64) + 2)
+	assert.ErrorIs(t, err, common.ErrIDOutOfRange)
+
+	_, _, err = db.QueryByID(1, common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1}, true)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestVectorDatabaseOpStats(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	stats, err := db.OpStats()
+	require.NoError(t, err)
+	assert.Equal(t, OpStats{}, stats)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Delete([]uint64{2}))
+
+	stats, err = db.OpStats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), stats.Inserts)
+	assert.Equal(t, uint64(1), stats.Deletes)
+	assert.Positive(t, stats.WALBytes)
+	assert.InDelta(t, 4/persistence.StatsWindow.Seconds(), stats.OpsPerSecond, 1e-9)
+
+	// Async records count once they are synced
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 1, 0}},
+		Docs:    []map[string]any{{"name": "d"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Sync())
+
+	stats, err = db.OpStats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), stats.Inserts)
+}
+
+func TestVectorDatabaseFilterLimits(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.MaxFilterFields = 2
+	params.MaxFilterValues = 2
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+		Attributes: []map[string]any{{"color": float64(1)}, {"color": float64(2), "size": float64(1)}},
+	})
+	require.NoError(t, err)
+	before, err := db.FilterFields()
+	require.NoError(t, err)
+
+	// A third value of color, a third field, and a batch that adds them in its second row
+	// are all rejected as a whole
+	rejected := []common.VdbUpsertArgs{
+		{
+			Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+			Docs:       []map[string]any{{}},
+			Attributes: []map[string]any{{"color": float64(3)}},
+		},
+		{
+			Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+			Docs:       []map[string]any{{}},
+			Attributes: []map[string]any{{"weight": float64(1)}},
+		},
+		{
+			Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{0, 0, 1, 1, 1, 0}},
+			Docs:       []map[string]any{{}, {}},
+			Attributes: []map[string]any{{"size": float64(2)}, {"size": float64(3)}},
+		},
+	}
+	for _, args := range rejected {
+		assert.ErrorIs(t, db.Upsert(args), ErrFilterLimit)
+	}
+	assert.ErrorIs(t, db.Batch([]Operation{{Type: OpInsert, Vector: []float32{0, 0, 1}, Attributes: map[string]any{"color": float64(3)}}}), ErrFilterLimit)
+
+	// The index, the stored docs and the vectors are left as they were
+	after, err := db.FilterFields()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{0, 0, 1}, K: 10})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	stats, err := db.OpStats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), stats.FilterLimitRejections)
+
+	// Indexed values are still accepted
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+		Docs:       []map[string]any{{}},
+		Attributes: []map[string]any{{"color": float64(2), "size": float64(1)}},
+	})
+	require.NoError(t, err)
+
+	// Values of pending writes count against the limits before they are synced
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 1, 0}},
+		Docs:       []map[string]any{{}},
+		Attributes: []map[string]any{{"size": float64(2)}},
+	})
+	require.NoError(t, err)
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 1}},
+		Docs:       []map[string]any{{}},
+		Attributes: []map[string]any{{"size": float64(3)}},
+	})
+	assert.ErrorIs(t, err, ErrFilterLimit)
+	require.NoError(t, db.Sync())
+
+	fields, err := db.FilterFields()
+	require.NoError(t, err)
+	require.Len(t, fields, 2)
+	assert.Equal(t, "size", fields[1].Name)
+	assert.Equal(t, 2, fields[1].Distinct)
+}
+
+func TestVectorDatabaseSoftDelete(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.QueryCache = &common.QueryCacheOption{Size: 10, TTLMs: 60000}
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+		Attributes: []map[string]any{{"group": float64(1)}, {"group": float64(1)}, {"group": float64(2)}},
+	})
+	require.NoError(t, err)
+
+	names := func(db *VectorDatabase, args common.VdbSearchArgs) []any {
+		results, err := db.Query(args)
+		require.NoError(t, err)
+		var names []any
+		for _, doc := range results {
+			names = append(names, doc["name"])
+		}
+		return names
+	}
+	search := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3}
+	filtered := common.VdbSearchArgs{
+		Query:        []float32{1, 0, 0},
+		K:            3,
+		FilterInputs: []common.IntFilterInput{{Field: "group", Op: "equal", Target: 1}},
+	}
+	require.Equal(t, []any{"a", "b", "c"}, names(db, search))
+
+	fieldsBefore, err := db.FilterFields()
+	require.NoError(t, err)
+
+	// IDs not assigned yet are skipped, or the vector later given one would arrive deleted
+	marked, err := db.SoftDelete([]uint64{1, 1, 4})
+	require.NoError(t, err)
+	assert.Equal(t, 1, marked)
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+		Docs:       []map[string]any{{"name": "d"}},
+		Attributes: []map[string]any{{"group": float64(3)}},
+	})
+	require.NoError(t, err)
+	assert.True(t, db.IsLive(4))
+	marked, err = db.SoftDelete([]uint64{4})
+	require.NoError(t, err)
+	assert.Equal(t, 1, marked)
+
+	// Searches, also cached and filtered ones, leave the soft-deleted vector out
+	assert.Equal(t, []any{"b", "c"}, names(db, search))
+	assert.Equal(t, []any{"b"}, names(db, filtered))
+	hits, err := db.QueryIDs(search)
+	require.NoError(t, err)
+	assert.Len(t, hits, 2)
+
+	// Its document is no longer returned
+	docs, err := db.GetDocs([]uint64{1, 2})
+	require.NoError(t, err)
+	assert.Nil(t, docs[0])
+	assert.Equal(t, "b", docs[1]["name"])
+	page, _, err := db.ScanPage(0, 10)
+	require.NoError(t, err)
+	assert.Len(t, page, 2)
+	_, err = db.Vector(1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, err = db.QueryByID(1, common.VdbSearchArgs{K: 1}, true)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Its attributes are no longer counted, so deleting by filter only reports b
+	fields, err := db.FilterFields()
+	require.NoError(t, err)
+	assert.Equal(t, fieldsBefore, fields, "group 3 was only held by d")
+	assertGroupOne := func(db *VectorDatabase) {
+		t.Helper()
+		idFilter, err := db.resolveFilter(filtered.FilterInputs)
+		require.NoError(t, err)
+		assert.Equal(t, []uint64{2}, idFilter.IDs())
+	}
+	assertGroupOne(db)
+
+	// The mark survives a restart, and the attributes stay out of the filter index
+	require.NoError(t, db.Close())
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []any{"b", "c"}, names(db, search))
+	assertGroupOne(db)
+
+	// Compact removes the vectors for good and clears the marks
+	removed, err := db.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.True(t, db.tombstones.Load().IsEmpty())
+	assert.Equal(t, []any{"b", "c"}, names(db, search))
+	assert.Equal(t, []any{"b"}, names(db, filtered))
+	_, err = db.vectorIndex.Reconstruct(1)
+	assert.ErrorIs(t, err, index.ErrLabelNotFound)
+	doc, err := db.scalarStorage.GetValue(scalar.NamespaceDocs, 1)
+	require.NoError(t, err)
+	assert.Nil(t, doc)
+
+	removed, err = db.Compact()
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestVectorDatabaseDocCodec(t *testing.T) {
+	doc := map[string]any{
+		"name":   "a",
+		"count":  float64(3),
+		"ratio":  0.25,
+		"tags":   []any{"x", float64(1)},
+		"nested": map[string]any{"ok": true, "n": float64(-2)},
+		"empty":  nil,
+	}
+	attributes := map[string]any{"group": float64(7)}
+	want := common.DocMap{
+		"name":                    "a",
+		"count":                   float64(3),
+		"ratio":                   0.25,
+		"tags":                    []any{"x", float64(1)},
+		"nested":                  map[string]any{"ok": true, "n": float64(-2)},
+		"empty":                   nil,
+		common.DocFieldID:         float64(1),
+		common.DocFieldAttributes: map[string]any{"group": float64(7)},
+	}
+
+	for _, codec := range []common.DocCodec{common.DocCodecJSON, common.DocCodecMsgpack} {
+		t.Run(string(codec), func(t *testing.T) {
+			tp := newTestPath()
+			defer tp.cleanup()
+
+			params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+			params.DocCodec = codec
+			db, err := NewVectorDatabase(&params)
+			require.NoError(t, err)
+			defer db.Close()
+
+			err = db.Upsert(common.VdbUpsertArgs{
+				Vectors:    math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+				Docs:       []map[string]any{doc},
+				Attributes: []map[string]any{attributes},
+			})
+			require.NoError(t, err)
+
+			stored, err := db.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(1))
+			require.NoError(t, err)
+			assert.Equal(t, codec == common.DocCodecJSON, stored[0] == '{')
+
+			docs, err := db.GetDocs([]uint64{1})
+			require.NoError(t, err)
+			assert.Equal(t, want, docs[0])
+
+			// Deleting reads the stored attributes back to unindex them
+			require.NoError(t, db.Delete([]uint64{1}))
+			fields, err := db.FilterFields()
+			require.NoError(t, err)
+			assert.Empty(t, fields)
+		})
+	}
+
+	t.Run("mixed", func(t *testing.T) {
+		tp := newTestPath()
+		defer tp.cleanup()
+
+		params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+		db, err := NewVectorDatabase(&params)
+		require.NoError(t, err)
+		err = db.Upsert(common.VdbUpsertArgs{
+			Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+			Docs:    []map[string]any{{"name": "json"}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		// Switching the codec keeps the docs already stored readable
+		params.DocCodec = common.DocCodecMsgpack
+		db, err = NewVectorDatabase(&params)
+		require.NoError(t, err)
+		defer db.Close()
+		err = db.Upsert(common.VdbUpsertArgs{
+			Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 1, 0}},
+			Docs:    []map[string]any{{"name": "msgpack"}},
+		})
+		require.NoError(t, err)
+
+		page, _, err := db.ScanPage(0, 10)
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, "json", page[0]["name"])
+		assert.Equal(t, "msgpack", page[1]["name"])
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		tp := newTestPath()
+		defer tp.cleanup()
+
+		params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+		params.DocCodec = "xml"
+		_, err := NewVectorDatabase(&params)
+		assert.ErrorContains(t, err, "unsupported doc codec: xml")
+	})
+}
+
+func TestVectorDatabaseVerify(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 4, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}},
+		Attributes: []map[string]any{{"group": float64(1)}, {"group": float64(1)}, {"group": float64(2)}, {"group": float64(2)}},
+	})
+	require.NoError(t, err)
+
+	report, err := db.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 4, report.Vectors)
+	assert.Equal(t, 4, report.Docs)
+
+	// Lose the document of 1 and the vector of 2, and index an attribute of an unknown ID
+	require.NoError(t, db.scalarStorage.Delete(scalar.NamespaceDocs, scalar.EncodeID(1)))
+	_, err = db.vectorIndex.Remove([]int64{2})
+	require.NoError(t, err)
+	db.filterIndex.Upsert("group", 5, 99)
+
+	report, err = db.Verify(false)
+	require.NoError(t, err)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, 3, report.Vectors)
+	assert.Equal(t, 3, report.Docs)
+	assert.Equal(t, []uint64{1}, report.MissingDocs)
+	assert.Equal(t, []uint64{2}, report.OrphanDocs)
+	assert.Equal(t, []uint64{2, 99}, report.DanglingFilterIDs)
+	assert.False(t, report.Repaired)
+
+	// Without repair nothing changes
+	report, err = db.Verify(false)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1}, report.MissingDocs)
+
+	report, err = db.Verify(true)
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+
+	report, err = db.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 2, report.Vectors)
+	assert.Equal(t, 2, report.Docs)
+
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 4})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	// The repair is logged in the WAL, so the database reopens consistent
+	require.NoError(t, db.Close())
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	report, err = db.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 2, report.Vectors)
+
+	_, err = db.Verify(true)
+	require.NoError(t, err)
+}
+
+func TestVectorDatabaseQueryExplain(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+		Attributes: []map[string]any{
+			{"group": float64(1), "active": true},
+			{"group": float64(1)},
+			{"group": float64(2), "active": true},
+		},
+	})
+	require.NoError(t, err)
+
+	filters := []common.IntFilterInput{
+		{Field: "group", Op: "equal", Target: 1},
+		{Field: "active", Op: "not_equal", Target: 0},
+	}
+	args := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3, FilterInputs: filters, Explain: true}
+
+	// A document passes if it matches any filter; each lists those it matched
+	results, err := db.Query(args)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, []common.FilterMatch{
+		{IntFilterInput: filters[0], Value: float64(1)},
+		{IntFilterInput: filters[1], Value: true},
+	}, results[0][common.DocFieldExplain])
+	assert.Equal(t, []common.FilterMatch{
+		{IntFilterInput: filters[0], Value: float64(1)},
+	}, results[1][common.DocFieldExplain])
+	assert.Equal(t, []common.FilterMatch{
+		{IntFilterInput: filters[1], Value: true},
+	}, results[2][common.DocFieldExplain])
+
+	// Explanations survive field projection and ids_only
+	args.Fields = []string{"name"}
+	results, err = db.Query(args)
+	require.NoError(t, err)
+	assert.NotContains(t, results[0], common.DocFieldAttributes)
+	assert.Len(t, results[0][common.DocFieldExplain], 2)
+
+	args.Fields = nil
+	args.IDsOnly = true
+	results, err = db.Query(args)
+	require.NoError(t, err)
+	assert.Equal(t, common.DocMap{
+		common.DocFieldID:    uint64(1),
+		common.DocFieldScore: float32(0),
+		common.DocFieldExplain: []common.FilterMatch{
+			{IntFilterInput: filters[0], Value: float64(1)},
+			{IntFilterInput: filters[1], Value: true},
+		},
+	}, results[0])
+
+	// Without explain nothing is added
+	results, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3, FilterInputs: filters})
+	require.NoError(t, err)
+	assert.NotContains(t, results[0], common.DocFieldExplain)
+}
+
+func TestVectorDatabaseQueryFewerHitsThanK(t *testing.T) {
+	for _, indexType := range []common.IndexType{common.IndexTypeFlat, common.IndexTypeHnsw} {
+		t.Run(string(indexType), func(t *testing.T) {
+			tp := newTestPath()
+			defer tp.cleanup()
+
+			params := createTestIndexParams(common.MetricTypeL2, indexType, tp.path())
+			db, err := NewVectorDatabase(&params)
+			require.NoError(t, err)
+			defer db.Close()
+
+			// Only the vectors at x = 1 and x = 3 are in group 1
+			err = db.Upsert(common.VdbUpsertArgs{
+				Vectors: math.Matrix32{Rows: 5, Cols: 3, Data: []float32{0, 0, 0, 1, 0, 0, 2, 0, 0, 3, 0, 0, 4, 0, 0}},
+				Docs:    []map[string]any{{"x": 0.0}, {"x": 1.0}, {"x": 2.0}, {"x": 3.0}, {"x": 4.0}},
+				Attributes: []map[string]any{
+					{"group": float64(2)}, {"group": float64(1)}, {"group": float64(2)}, {"group": float64(1)}, {"group": float64(2)},
+				},
+			})
+			require.NoError(t, err)
+
+			results, err := db.Query(common.VdbSearchArgs{
+				Query:        []float32{0, 0, 0},
+				K:            5,
+				FilterInputs: []common.IntFilterInput{{Field: "group", Op: "equal", Target: 1}},
+			})
+			require.NoError(t, err)
+			require.Len(t, results, 2)
+
+			// Each document carries the squared distance of its own vector
+			for _, doc := range results {
+				x := float32(doc["x"].(float64))
+				assert.Equal(t, x*x, doc[common.DocFieldScore], "doc at x = %v", x)
+			}
+			assert.Equal(t, 1.0, results[0]["x"])
+			assert.Equal(t, 3.0, results[1]["x"])
+		})
+	}
+}
+
+func TestReplicaDatabase(t *testing.T) {
+	primaryPath := newTestPath()
+	defer primaryPath.cleanup()
+	replicaPath := newTestPath()
+	defer replicaPath.cleanup()
+
+	primaryParams := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, primaryPath.path())
+	primary, err := NewVectorDatabase(&primaryParams)
+	require.NoError(t, err)
+
+	err = primary.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}},
+		Attributes: []map[string]any{{"group": float64(1)}, {"group": float64(2)}},
+	})
+	require.NoError(t, err)
+
+	replicaParams := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, replicaPath.path())
+	replica, err := OpenReplica(primaryPath.path(), &replicaParams, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer replica.Close()
+
+	names := func(db *VectorDatabase, args common.VdbSearchArgs) []any {
+		results, err := db.Query(args)
+		require.NoError(t, err)
+		names := make([]any, 0, len(results))
+		for _, doc := range results {
+			names = append(names, doc["name"])
+		}
+		return names
+	}
+	search := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 10}
+
+	// The replica has caught up once open, filters included
+	assert.Equal(t, []any{"a", "b"}, names(replica.VectorDatabase, search))
+	assert.Equal(t, []any{"b"}, names(replica.VectorDatabase, common.VdbSearchArgs{
+		Query:        []float32{1, 0, 0},
+		K:            10,
+		FilterInputs: []common.IntFilterInput{{Field: "group", Op: "equal", Target: 2}},
+	}))
+
+	// Writes on the primary reach the replica, deletes too
+	err = primary.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.9, 0.1, 0}},
+		Docs:    []map[string]any{{"name": "c"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, primary.Delete([]uint64{2}))
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]any{"a", "c"}, names(replica.VectorDatabase, search))
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// The replica rejects writes
+	err = replica.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+		Docs:    []map[string]any{{}},
+	})
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	// A primary restart truncates its WAL; the replica follows the records written after it
+	require.NoError(t, primary.Close())
+	primary, err = NewVectorDatabase(&primaryParams)
+	require.NoError(t, err)
+	defer primary.Close()
+	err = primary.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0.8, 0.2, 0}},
+		Docs:    []map[string]any{{"name": "d"}},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]any{"a", "c", "d"}, names(replica.VectorDatabase, search))
+	}, 2*time.Second, 10*time.Millisecond)
+
+	report, err := replica.Verify(false)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Equal(t, 3, report.Vectors)
+}
+
+func TestVectorDatabaseUpdateDoc(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}},
+		Attributes: []map[string]any{{"group": 1}, {"group": 1}, {"group": 2}},
+	})
+	require.NoError(t, err)
+
+	search := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3}
+	groupIDs := func(db *VectorDatabase, group int64) []uint64 {
+		args := search
+		args.FilterInputs = []common.IntFilterInput{{Field: "group", Op: "equal", Target: group}}
+		args.IDsOnly = true
+		results, err := db.Query(args)
+		require.NoError(t, err)
+		ids := make([]uint64, len(results))
+		for i, result := range results {
+			ids[i] = result[common.DocFieldID].(uint64)
+		}
+		return ids
+	}
+	scores := func(db *VectorDatabase) []any {
+		results, err := db.Query(search)
+		require.NoError(t, err)
+		ranking := make([]any, len(results))
+		for i, result := range results {
+			ranking[i] = []any{result[common.DocFieldID], result[common.DocFieldScore]}
+		}
+		return ranking
+	}
+	before := scores(db)
+
+	require.NoError(t, db.UpdateDoc(2, map[string]any{"name": "b2"}, map[string]any{"group": 2}))
+
+	// The filter follows the new attribute, and the old bucket no longer holds the ID
+	assert.Equal(t, []uint64{1}, groupIDs(db, 1))
+	assert.Equal(t, []uint64{2, 3}, groupIDs(db, 2))
+	assert.Equal(t, before, scores(db))
+
+	docs, err := db.GetDocs([]uint64{2})
+	require.NoError(t, err)
+	assert.Equal(t, "b2", docs[0]["name"])
+	assert.Equal(t, map[string]any{"group": float64(2)}, docs[0][common.DocFieldAttributes])
+
+	err = db.UpdateDoc(99, map[string]any{"name": "x"}, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Records written with UpsertAsync are synced before they are updated
+	err = db.UpsertAsync(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{0, 0, 1}},
+		Docs:    []map[string]any{{"name": "d"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateDoc(4, nil, map[string]any{"group": 1}))
+	assert.Equal(t, []uint64{1, 4}, groupIDs(db, 1))
+
+	// The update is replayed from the WAL together with the insert it follows
+	require.NoError(t, db.Close())
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.Equal(t, []uint64{1, 4}, groupIDs(db, 1))
+	assert.Equal(t, []uint64{2, 3}, groupIDs(db, 2))
+	docs, err = db.GetDocs([]uint64{2, 4})
+	require.NoError(t, err)
+	assert.Equal(t, "b2", docs[0]["name"])
+	assert.NotContains(t, docs[1], "name")
+}
+
+func TestVectorDatabaseCompactStorage(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.CompactStorageAfter = 3
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 4, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 0}},
+		Docs:    []map[string]any{{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}},
+	})
+	require.NoError(t, err)
+
+	compactions := func() uint64 {
+		stats, err := db.OpStats()
+		require.NoError(t, err)
+		return stats.StorageCompactions
+	}
+
+	require.NoError(t, db.CompactStorage())
+	assert.Equal(t, uint64(1), compactions())
+
+	// Two deletes and an update reach the threshold and compact in the background
+	require.NoError(t, db.Delete([]uint64{1, 2}))
+	assert.Equal(t, uint64(1), compactions())
+	require.NoError(t, db.UpdateDoc(3, map[string]any{"name": "c2"}, nil))
+	assert.Eventually(t, func() bool { return compactions() == 2 }, time.Second, 10*time.Millisecond)
+
+	docs, err := db.GetDocs([]uint64{1, 3, 4})
+	require.NoError(t, err)
+	assert.Nil(t, docs[0])
+	assert.Equal(t, "c2", docs[1]["name"])
+	assert.Equal(t, "d", docs[2]["name"])
+}
+
+func TestVectorDatabaseQueryPostFilter(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.QueryCache = &common.QueryCacheOption{Size: 10}
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// The vectors nearest the query belong to the places farthest from the origin
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 5, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0.8, 0.2, 0, 0.7, 0.3, 0, 0.6, 0.4, 0}},
+		Docs: []map[string]any{
+			{"lat": 40.0, "lng": 40.0},
+			{"lat": 30.0, "lng": 30.0},
+			{"lat": 0.1, "lng": 0.1},
+			{"lat": 20.0, "lng": 20.0},
+			{"lat": 0.2, "lng": 0.0},
+		},
+	})
+	require.NoError(t, err)
+
+	near := func(radius float64) common.PostFilter {
+		return func(doc common.DocMap, score float32) bool {
+			lat, _ := doc["lat"].(float64)
+			lng, _ := doc["lng"].(float64)
+			return lat*lat+lng*lng <= radius*radius
+		}
+	}
+	ids := func(results []common.DocMap) []any {
+		ids := make([]any, len(results))
+		for i, result := range results {
+			ids[i] = result[common.DocFieldID]
+		}
+		return ids
+	}
+
+	args := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2, PostFilter: near(1)}
+	results, err := db.Query(args)
+	require.NoError(t, err)
+	assert.Equal(t, []any{float64(3), float64(5)}, ids(results))
+
+	// Another predicate is not answered from the cache
+	args.PostFilter = near(35)
+	results, err = db.Query(args)
+	require.NoError(t, err)
+	assert.Equal(t, []any{float64(3), float64(4)}, ids(results))
+
+	// The scores are passed along, and ids_only still reads the docs for the predicate
+	args.PostFilter = func(doc common.DocMap, score float32) bool { return score > 0.05 && doc["lat"] != 20.0 }
+	args.IDsOnly = true
+	results, err = db.Query(args)
+	require.NoError(t, err)
+	assert.Equal(t, []any{uint64(3), uint64(5)}, ids(results))
+
+	_, err = db.QueryIDs(args)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestVectorDatabaseQueryPostFilterFetchFactor(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.PostFilterFetchFactor = 2
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 4, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0.8, 0.2, 0, 0.7, 0.3, 0}},
+		Docs:    []map[string]any{{"keep": false}, {"keep": false}, {"keep": true}, {"keep": true}},
+	})
+	require.NoError(t, err)
+
+	// Only the 2 nearest hits are fetched for K=1, and the predicate rejects both
+	keep := func(doc common.DocMap, _ float32) bool { return doc["keep"] == true }
+	results, err := db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1, PostFilter: keep})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 2, PostFilter: keep})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, float64(3), results[0][common.DocFieldID])
+}
+
+func TestVectorDatabaseQueryStream(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 4, Cols: 3, Data: []float32{1, 0, 0, 0.9, 0.1, 0, 0.8, 0.2, 0, 0, 0, 1}},
+		Docs:    []map[string]any{{"keep": true}, {"keep": false}, {"keep": true}, {"keep": true}},
+	})
+	require.NoError(t, err)
+
+	stream := func(args common.VdbSearchArgs) []common.DocMap {
+		var results []common.DocMap
+		_, err := db.QueryStream(args, func(result common.DocMap) error {
+			results = append(results, result)
+			return nil
+		})
+		require.NoError(t, err)
+		return results
+	}
+
+	// Streamed results match the collected ones
+	args := common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3, Fields: []string{"keep"}}
+	expected, err := db.Query(args)
+	require.NoError(t, err)
+	assert.Equal(t, expected, stream(args))
+
+	args.PostFilter = func(doc common.DocMap, _ float32) bool { return doc["keep"] == true }
+	args.K = 2
+	results := stream(args)
+	require.Len(t, results, 2)
+	assert.Equal(t, float64(1), results[0][common.DocFieldID])
+	assert.Equal(t, float64(3), results[1][common.DocFieldID])
+
+	// The read lock is not held while the callback runs, so a slow client cannot hold up Close
+	locked := 0
+	_, err = db.QueryStream(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3}, func(common.DocMap) error {
+		if db.mu.TryLock() {
+			db.mu.Unlock()
+			locked++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, locked)
+
+	// An error from the callback stops the stream
+	stop := errors.New("stop")
+	calls := 0
+	_, err = db.QueryStream(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3}, func(common.DocMap) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestVectorDatabaseMemoryStats(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	before, err := db.MemoryStats()
+	require.NoError(t, err)
+	assert.Zero(t, before.VectorIndexBytes)
+
+	args := common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 2, 3, 4, 5, 6}},
+		Docs:       []map[string]any{{"name": "doc1"}, {"name": "doc2"}},
+		Attributes: []map[string]any{{"category": float64(1)}, {"category": float64(2)}},
+	}
+	require.NoError(t, db.UpsertAsync(args))
+
+	pending, err := db.MemoryStats()
+	require.NoError(t, err)
+	assert.Positive(t, pending.PendingWALBytes)
+
+	require.NoError(t, db.Sync())
+
+	after, err := db.MemoryStats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2*3*4), after.VectorIndexBytes)
+	assert.Greater(t, after.FilterIndexBytes, before.FilterIndexBytes)
+	assert.Zero(t, after.PendingWALBytes)
+	assert.Equal(t, after.VectorIndexBytes+after.FilterIndexBytes, after.TotalBytes)
+	assert.Greater(t, after.TotalBytes, before.TotalBytes)
+
+	stats, err := db.OpStats()
+	require.NoError(t, err)
+	assert.Equal(t, after, stats.Memory)
+
+	require.NoError(t, db.Close())
+	_, err = db.MemoryStats()
+	assert.ErrorIs(t, err, ErrDatabaseClosed)
+}
+
+func TestVectorDatabaseFailOnRestoreError(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors: math.Matrix32{Rows: 1, Cols: 3, Data: []float32{1, 0, 0}},
+		Docs:    []map[string]any{{"name": "doc1"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Append a corrupt tail after the valid records
+	walPath := filepath.Join(tp.path(), WalFileSuffix)
+	corruptWAL := func() int64 {
+		file, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = file.Write(make([]byte, 32))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		info, err := os.Stat(walPath)
+		require.NoError(t, err)
+		return info.Size()
+	}
+	size := corruptWAL()
+
+	// A strict open fails and leaves the WAL as it was
+	strict := params
+	strict.FailOnRestoreError = true
+	_, err = NewVectorDatabase(&strict)
+	require.ErrorIs(t, err, ErrWALCorrupt)
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, size, info.Size())
+
+	// The lenient default restores the records before the corruption
+	db, err = NewVectorDatabase(&params)
+	require.NoError(t, err)
+	docs, err := db.GetDocs([]uint64{1})
+	require.NoError(t, err)
+	require.NotNil(t, docs[0])
+	assert.Equal(t, "doc1", docs[0]["name"])
+	require.NoError(t, db.Close())
+
+	// A strict open tolerates corruption up to MaxCorruptWALBytes
+	corruptWAL()
+	strict.MaxCorruptWALBytes = 31
+	_, err = NewVectorDatabase(&strict)
+	require.ErrorIs(t, err, ErrWALCorrupt)
+
+	strict.MaxCorruptWALBytes = 32
+	db, err = NewVectorDatabase(&strict)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestVectorDatabaseFilterableFields(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	params.FilterableFields = []string{"category"}
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 2, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0}},
+		Docs:       []map[string]any{{"name": "doc1"}, {"name": "doc2"}},
+		Attributes: []map[string]any{{"category": float64(1), "user_id": float64(100)}, {"category": float64(2), "user_id": float64(200)}},
+	})
+	require.NoError(t, err)
+
+	// Only the whitelisted field is indexed
+	fields, err := db.FilterFields()
+	require.NoError(t, err)
+	require.Len(t, fields, 1)
+	assert.Equal(t, "category", fields[0].Name)
+
+	results, err := db.Query(common.VdbSearchArgs{
+		Query:        []float32{1, 0, 0},
+		K:            2,
+		FilterInputs: []common.IntFilterInput{{Field: "category", Op: "equal", Target: 2}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "doc2", results[0]["name"])
+
+	// The other attributes are still stored with the doc
+	attributes, ok := results[0][common.DocFieldAttributes].(map[string]any)
+	require.True(t, ok, "attributes: %v", results[0])
+	assert.Equal(t, float64(200), attributes["user_id"])
+
+	// Filtering on them is rejected rather than matching nothing
+	_, err = db.Query(common.VdbSearchArgs{
+		Query:        []float32{1, 0, 0},
+		K:            2,
+		FilterInputs: []common.IntFilterInput{{Field: "user_id", Op: "equal", Target: 200}},
+	})
+	assert.ErrorIs(t, err, ErrFieldNotFilterable)
+	_, err = db.DeleteByFilter([]common.IntFilterInput{{Field: "user_id", Op: "equal", Target: 200}})
+	assert.ErrorIs(t, err, ErrFieldNotFilterable)
+}
+
+func TestVectorDatabaseQueryTypedTargets(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeFlat, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Upsert(common.VdbUpsertArgs{
+		Vectors:    math.Matrix32{Rows: 3, Cols: 3, Data: []float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		Docs:       []map[string]any{{"name": "doc1"}, {"name": "doc2"}, {"name": "doc3"}},
+		Attributes: []map[string]any{{"lng": float64(-122)}, {"lng": float64(13)}, {"lng": float64(-122)}},
+	})
+	require.NoError(t, err)
+
+	// Targets are decoded from JSON the way the API receives them
+	query := func(filters string) ([]common.DocMap, error) {
+		var filterInputs []common.IntFilterInput
+		require.NoError(t, json.Unmarshal([]byte(filters), &filterInputs))
+		return db.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 3, FilterInputs: filterInputs, IDsOnly: true})
+	}
+	ids := func(results []common.DocMap) []uint64 {
+		ids := make([]uint64, len(results))
+		for i, result := range results {
+			ids[i] = result[common.DocFieldID].(uint64)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	results, err := query(`[{"field": "lng", "op": "equal", "target": -122}]`)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 3}, ids(results))
+
+	// A fractional target equals no integer attribute, so it adds nothing to the other filters
+	results, err = query(`[{"field": "lng", "op": "equal", "target": -122.5}, {"field": "lng", "op": "equal", "target": 13}]`)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2}, ids(results))
+
+	// Filters that match nothing return nothing, as they delete nothing, rather than
+	// leaving the search unfiltered
+	for _, filters := range []string{
+		`[{"field": "lng", "op": "equal", "target": -122.5}]`,
+		`[{"field": "lng", "op": "equal", "target": 7}]`,
+	} {
+		results, err = query(filters)
+		require.NoError(t, err, filters)
+		assert.Empty(t, results, filters)
+
+		var filterInputs []common.IntFilterInput
+		require.NoError(t, json.Unmarshal([]byte(filters), &filterInputs))
+		deleted, err := db.DeleteByFilter(filterInputs)
+		require.NoError(t, err, filters)
+		assert.Equal(t, 0, deleted, filters)
+	}
+
+	results, err = query(`[{"field": "lng", "op": "not_equal", "target": 12.75}]`)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2, 3}, ids(results))
+
+	// Explain judges the fractional target the same way
+	explained, err := db.Query(common.VdbSearchArgs{
+		Query:        []float32{0, 1, 0},
+		K:            1,
+		FilterInputs: []common.IntFilterInput{{Field: "lng", Op: "not_equal", Target: 12.75}},
+		Explain:      true,
+	})
+	require.NoError(t, err)
+	require.Len(t, explained, 1)
+	assert.Len(t, explained[0][common.DocFieldExplain], 1)
+
+	_, err = query(`[{"field": "lng", "op": "equal", "target": "west"}]`)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+
+	// An omitted or null target compares as 0, as it did before targets were typed
+	for _, filters := range []string{
+		`[{"field": "lng", "op": "not_equal", "target": null}]`,
+		`[{"field": "lng", "op": "not_equal"}]`,
+	} {
+		results, err = query(filters)
+		require.NoError(t, err, filters)
+		assert.Equal(t, []uint64{1, 2, 3}, ids(results), filters)
+	}
+}
+
+// efLimitedIndex wraps an index and, like an HNSW traversal under a selective filter,
+// returns only one filtered hit for every eight nodes efSearch lets it visit
+type efLimitedIndex struct {
+	index.Index
+	efSearches []uint32
+}
+
+func (e *efLimitedIndex) Search(query *index.SearchQuery, k int) (*index.SearchResult, error) {
+	result, err := e.Index.Search(query, k)
+	if err != nil || query.IdFilter == nil {
+		return result, err
+	}
+
+	efSearch := uint32(index.DefaultEfSearch)
+	if query.Hnsw != nil && query.Hnsw.EfSearch > 0 {
+		efSearch = query.Hnsw.EfSearch
+	}
+	e.efSearches = append(e.efSearches, efSearch)
+	for i := int(efSearch / 8); i < len(result.Labels); i++ {
+		result.Labels[i] = -1
+	}
+	return result, nil
+}
+
+func TestVectorDatabaseQueryFilteredEfSearchRetry(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeHnsw, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Only 10 of the 2000 vectors are in group 1
+	const n = 2000
+	vectors := math.Matrix32{Rows: n, Cols: 3, Data: make([]float32, 0, n*3)}
+	docs := make([]map[string]any, n)
+	attributes := make([]map[string]any, n)
+	for i := range n {
+		vectors.Data = append(vectors.Data, float32(i), float32(i%7), float32(i%13))
+		docs[i] = map[string]any{"name": fmt.Sprintf("doc%d", i)}
+		attributes[i] = map[string]any{"group": float64(0)}
+		if i%200 == 0 {
+			attributes[i]["group"] = float64(1)
+		}
+	}
+	require.NoError(t, db.Upsert(common.VdbUpsertArgs{Vectors: vectors, Docs: docs, Attributes: attributes}))
+
+	limited := &efLimitedIndex{Index: db.vectorIndex}
+	db.vectorIndex = limited
+
+	query := func() []common.DocMap {
+		results, err := db.Query(common.VdbSearchArgs{
+			Query:        []float32{0, 0, 0},
+			K:            5,
+			FilterInputs: []common.IntFilterInput{{Field: "group", Op: "equal", Target: float64(1)}},
+			IDsOnly:      true,
+		})
+		require.NoError(t, err)
+		return results
+	}
+
+	// Without retries the search stops at the 2 hits the default efSearch reaches
+	assert.Len(t, query(), 2)
+	assert.Equal(t, []uint32{16}, limited.efSearches)
+
+	// The retries double efSearch until the search returns K hits
+	db.params.HnswParams.MaxFilteredEf = 512
+	limited.efSearches = nil
+	assert.Len(t, query(), 5)
+	assert.Equal(t, []uint32{16, 32, 64}, limited.efSearches)
+
+	// and stop at the cap even when they fall short
+	db.params.HnswParams.MaxFilteredEf = 24
+	limited.efSearches = nil
+	assert.Len(t, query(), 3)
+	assert.Equal(t, []uint32{16, 24}, limited.efSearches)
+}
+
+// lossyIndex wraps an index and, like an HNSW graph walk that misses a neighbour, drops
+// the best hit of every search that is not exact
+type lossyIndex struct {
+	index.Index
+}
+
+func (l *lossyIndex) Search(query *index.SearchQuery, k int) (*index.SearchResult, error) {
+	result, err := l.Index.Search(query, k)
+	if err != nil || query.Exact || len(result.Labels) == 0 {
+		return result, err
+	}
+	result.Labels, result.Distances = result.Labels[1:], result.Distances[1:]
+	return result, nil
+}
+
+func TestVectorDatabaseQueryExact(t *testing.T) {
+	tp := newTestPath()
+	defer tp.cleanup()
+
+	params := createTestIndexParams(common.MetricTypeL2, common.IndexTypeHnsw, tp.path())
+	db, err := NewVectorDatabase(&params)
+	require.NoError(t, err)
+	defer db.Close()
+
+	const n = 100
+	vectors := math.Matrix32{Rows: n, Cols: 3, Data: make([]float32, 0, n*3)}
+	docs := make([]map[string]any, n)
+	for i := range n {
+		vectors.Data = append(vectors.Data, float32(i), float32(i%7), float32(i%13))
+		docs[i] = map[string]any{"name": fmt.Sprintf("doc%d", i)}
+	}
+	require.NoError(t, db.Upsert(common.VdbUpsertArgs{Vectors: vectors, Docs: docs}))
+	db.vectorIndex = &lossyIndex{Index: db.vectorIndex}
+
+	query := func(exact bool) []string {
+		results, err := db.Query(common.VdbSearchArgs{Query: []float32{10, 3, 10}, K: 3, Exact: exact})
+		require.NoError(t, err)
+		names := make([]string, len(results))
+		for i, result := range results {
+			names[i] = result["name"].(string)
+		}
+		return names
+	}
+
+	exact := query(true)
+	assert.Equal(t, []string{"doc10", "doc9", "doc11"}, exact)
+	approximate := query(false)
+	assert.NotContains(t, approximate, "doc10")
+	assert.Equal(t, exact[1:], approximate)
+
+	// Custom indexes may compress their vectors, so they cannot search exactly
+	tpCustom := newTestPath()
+	defer tpCustom.cleanup()
+	customParams := createTestIndexParams(common.MetricTypeL2, common.IndexTypeCustom, tpCustom.path())
+	customParams.FactoryString = "IDMap2,Flat"
+	customDB, err := NewVectorDatabase(&customParams)
+	require.NoError(t, err)
+	defer customDB.Close()
+	_, err = customDB.Query(common.VdbSearchArgs{Query: []float32{1, 0, 0}, K: 1, Exact: true})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
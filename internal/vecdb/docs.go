@@ -0,0 +1,133 @@
+package vecdb
+
+import (
+	"fmt"
+	"log/slog"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/filter"
+	"vecdb-go/internal/scalar"
+)
+
+// GetDocs returns the stored documents with the given IDs in the order of ids, with nil
+// for IDs that do not exist or are soft-deleted. Pending WAL records are synced first,
+// so documents written with UpsertAsync are found as soon as the call returns. All
+// documents are read in one storage transaction.
+func (db *VectorDatabase) GetDocs(ids []uint64) ([]common.DocMap, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	if db.persistence.GetPendingCount() > 0 {
+		if err := db.persistence.Sync(
+			db.scalarStorage,
+			db.filterIndex,
+			db.vectorIndex,
+			db.params.Dim,
+		); err != nil {
+			return nil, fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	}
+
+	if len(ids) == 0 {
+		return []common.DocMap{}, nil
+	}
+
+	docs, err := db.scalarStorage.MultiGetValue(scalar.NamespaceDocs, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve documents: %w", err)
+	}
+	for i, id := range ids {
+		if db.isSoftDeleted(id) {
+			docs[i] = nil
+		}
+	}
+
+	return docs, nil
+}
+
+// UpdateDoc replaces the document and attributes of the vector with the given ID and
+// reindexes its attributes, leaving the vector itself and its ID untouched, which is
+// cheaper than deleting and re-inserting it. A nil doc or attributes is stored as empty,
+// as by Upsert. The change is written to the WAL as an Update record and synced before
+// UpdateDoc returns. It returns ErrNotFound if the ID does not exist or is soft-deleted;
+// pending WAL records are synced first, so documents written with UpsertAsync are found.
+func (db *VectorDatabase) UpdateDoc(id uint64, doc, attributes map[string]any) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if db.persistence.GetPendingCount() > 0 {
+		if err := db.persistence.Sync(
+			db.scalarStorage,
+			db.filterIndex,
+			db.vectorIndex,
+			db.params.Dim,
+		); err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	}
+
+	stored, err := db.scalarStorage.Get(scalar.NamespaceDocs, scalar.EncodeID(id))
+	if err != nil {
+		return fmt.Errorf("failed to read document %d: %w", id, err)
+	}
+	if stored == nil || db.isSoftDeleted(id) {
+		return fmt.Errorf("%w: vector %d", ErrNotFound, id)
+	}
+
+	if doc == nil {
+		doc = make(map[string]any)
+	}
+	if attributes == nil {
+		attributes = make(map[string]any)
+	}
+
+	if err := db.checkDocSize(id, doc, attributes); err != nil {
+		return err
+	}
+	if err := filter.ValidateAttributes(attributes); err != nil {
+		return err
+	}
+	fieldTypes := make(map[string]filter.FieldType)
+	if err := db.filterIndex.CheckTypes(attributes, fieldTypes); err != nil {
+		return err
+	}
+	newValues := make(map[string]map[int64]struct{})
+	if err := db.filterIndex.CheckLimits(attributes, newValues); err != nil {
+		return err
+	}
+	release, err := db.reserveAttributes(fieldTypes, newValues)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Updating document", "id", id)
+
+	if err := db.persistence.WriteUpdate(
+		id,
+		doc,
+		attributes,
+		true,
+		db.scalarStorage,
+		db.filterIndex,
+		db.vectorIndex,
+		db.params.Dim,
+	); err != nil {
+		release()
+		return fmt.Errorf("failed to write to WAL: %w", err)
+	}
+	db.countStorageGarbage(1)
+
+	return nil
+}
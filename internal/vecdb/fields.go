@@ -0,0 +1,21 @@
+package vecdb
+
+import "vecdb-go/internal/filter"
+
+// FieldInfo describes an attribute field that can be filtered on
+type FieldInfo = filter.FieldInfo
+
+// FilterFields lists the attribute fields with at least one indexed value, sorted by name,
+// with their type, value range and number of distinct values. The ranges are maintained
+// as attributes change, so this does not scan the index. Records written with
+// UpsertAsync are included only once they are synced.
+func (db *VectorDatabase) FilterFields() ([]FieldInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	return db.filterIndex.Fields(), nil
+}
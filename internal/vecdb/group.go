@@ -0,0 +1,56 @@
+package vecdb
+
+import (
+	"encoding/json"
+
+	"vecdb-go/internal/common"
+)
+
+// GroupByFetchFactor is how many hits per requested group a grouped query fetches.
+// Grouping happens after the vector search, so a group whose best hit ranks below
+// K*GroupByFetchFactor is missed, and fewer than K groups come back when the nearest
+// hits are dominated by a few values; recall improves as the factor grows.
+const GroupByFetchFactor = 5
+
+// groupHits keeps the first, and therefore best, hit for each distinct value of field
+// until k groups are found. The field is looked up in the doc first and then in its
+// attributes; docs without it form one group of their own.
+func groupHits(hits []common.SearchHit, docs []common.DocMap, field string, k int) ([]common.SearchHit, []common.DocMap) {
+	seen := make(map[string]struct{}, k)
+	groupedHits := make([]common.SearchHit, 0, k)
+	groupedDocs := make([]common.DocMap, 0, k)
+
+	for i, doc := range docs {
+		if len(groupedHits) == k {
+			break
+		}
+
+		key := groupKey(doc, field)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		groupedHits = append(groupedHits, hits[i])
+		groupedDocs = append(groupedDocs, doc)
+	}
+
+	return groupedHits, groupedDocs
+}
+
+// groupKey returns the JSON encoding of the field's value, so values of different
+// types (e.g. 1 and "1") never share a group and uncomparable values can be keys
+func groupKey(doc common.DocMap, field string) string {
+	value, ok := doc[field]
+	if !ok {
+		if attrs, isMap := doc[common.DocFieldAttributes].(map[string]any); isMap {
+			value = attrs[field]
+		}
+	}
+
+	key, err := json.Marshal(value)
+	if err != nil {
+		return "null"
+	}
+	return string(key)
+}
@@ -0,0 +1,62 @@
+package vecdb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LayoutVersion is the version of the on-disk directory layout this build reads and
+	// writes. Bump it whenever a release changes the format of a file in the directory.
+	LayoutVersion = 1
+	// LayoutVersionFile is the file in the database directory that records its layout version
+	LayoutVersionFile = "VERSION"
+)
+
+// ErrLayoutVersion is returned when a database directory has a layout version this build
+// cannot open
+var ErrLayoutVersion = fmt.Errorf("unsupported on-disk layout version")
+
+// checkLayoutVersion verifies that the directory has the layout version of this build.
+// It reports whether the version file is missing: either the directory is new, or it was
+// written before the layout was versioned, which is version 1.
+func checkLayoutVersion(dir string) (bool, error) {
+	path := filepath.Join(dir, LayoutVersionFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read layout version: %w", err)
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false, fmt.Errorf("%w: %s contains %q, not a version number", ErrLayoutVersion, path, data)
+	}
+
+	switch {
+	case version > LayoutVersion:
+		return false, fmt.Errorf("%w: %s has layout version %d, but this build reads version %d; upgrade to a release that supports it",
+			ErrLayoutVersion, dir, version, LayoutVersion)
+	case version < LayoutVersion:
+		return false, fmt.Errorf("%w: %s has layout version %d, but this build reads version %d; open it with the release that wrote it and export the data, or restore it from a backup",
+			ErrLayoutVersion, dir, version, LayoutVersion)
+	}
+
+	return false, nil
+}
+
+// writeLayoutVersion records the layout version of this build in the directory
+func writeLayoutVersion(dir string) error {
+	path := filepath.Join(dir, LayoutVersionFile)
+	if err := os.WriteFile(path, []byte(strconv.Itoa(LayoutVersion)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("failed to write layout version: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package vecdb
+
+import (
+	"sync"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/filter"
+)
+
+// liveSet holds the IDs of the vectors applied to the vector index and not deleted.
+// Persistence reports every chunk of records it applies, the WAL replayed on open
+// included, so like the indexes the set is rebuilt from the WAL and not stored itself.
+type liveSet struct {
+	mu  sync.RWMutex
+	ids *filter.IdFilter
+}
+
+func newLiveSet() *liveSet {
+	return &liveSet{ids: filter.NewIdFilter()}
+}
+
+// apply records the IDs inserted and then deleted by an applied chunk of WAL records
+func (s *liveSet) apply(inserted, deleted []uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ids.AddAll(inserted)
+	for _, id := range deleted {
+		s.ids.Remove(id)
+	}
+}
+
+// contains reports whether id is in the set
+func (s *liveSet) contains(id uint64) bool {
+	if id > common.MaxVectorID {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ids.Filter(id)
+}
+
+// countWithout returns the number of IDs in the set that are not in excluded
+func (s *liveSet) countWithout(excluded *filter.IdFilter) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := s.ids.Len()
+	if excluded != nil {
+		n -= int(s.ids.GetBitmap().AndCardinality(excluded.GetBitmap()))
+	}
+	return n
+}
+
+// IsLive reports whether the vector with id is applied to the index and neither deleted
+// nor soft-deleted; records written with UpsertAsync are live once they are synced
+func (db *VectorDatabase) IsLive(id uint64) bool {
+	return db.live.contains(id) && !db.isSoftDeleted(id)
+}
+
+// LiveCount returns the number of vectors IsLive reports
+func (db *VectorDatabase) LiveCount() int {
+	return db.live.countWithout(db.tombstones.Load())
+}
@@ -0,0 +1,186 @@
+package vecdb
+
+import (
+	"context"
+	"fmt"
+	gomath "math"
+	"sort"
+	"time"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/index"
+)
+
+// MultiQueryFetchFactor is how many hits per requested result each vector of a
+// multi-vector search fetches. Candidates are the union of those hits, so a document
+// that ranks below K*MultiQueryFetchFactor for every query vector is missed even if its
+// aggregated score would have placed it in the top K.
+const MultiQueryFetchFactor = 3
+
+// queryVectors returns the query vectors of a search, either its Query or its Queries,
+// after checking their dimensions and the aggregation mode
+func (db *VectorDatabase) queryVectors(searchArgs common.VdbSearchArgs) ([][]float32, error) {
+	vectors := searchArgs.Queries
+	switch {
+	case len(vectors) == 0:
+		vectors = [][]float32{searchArgs.Query}
+	case len(searchArgs.Query) > 0:
+		return nil, fmt.Errorf("%w: query and queries are mutually exclusive", ErrInvalidArgument)
+	}
+
+	switch searchArgs.Aggregation {
+	case "", common.AggregationMax, common.AggregationMean:
+	default:
+		return nil, fmt.Errorf("%w: unsupported aggregation %q", ErrInvalidArgument, searchArgs.Aggregation)
+	}
+
+	for i, vector := range vectors {
+		if len(vector) == 0 {
+			if len(vectors) == 1 {
+				return nil, ErrEmptyQuery
+			}
+			return nil, fmt.Errorf("%w: query vector %d", ErrEmptyQuery, i)
+		}
+		if len(vector) != db.params.Dim {
+			if len(vectors) == 1 {
+				return nil, fmt.Errorf("%w: query vector length %d does not match index dimension %d",
+					ErrDimMismatch, len(vector), db.params.Dim)
+			}
+			return nil, fmt.Errorf("%w: query vector %d has length %d, index dimension is %d",
+				ErrDimMismatch, i, len(vector), db.params.Dim)
+		}
+	}
+
+	return vectors, nil
+}
+
+// searchMulti runs one index search per query vector and ranks the union of their hits
+// by the aggregation of each candidate's scores across all query vectors, best-first.
+// Scores a query vector's search did not return are computed from the stored vector, so
+// every candidate is aggregated over the full query set. The timeout bounds the searches
+// together, and cancelling ctx stops them. Returns the hits along with the number of candidates the index considered
+// (caller must hold read lock).
+func (db *VectorDatabase) searchMulti(ctx context.Context, query *index.SearchQuery, vectors [][]float32, k int, aggregation common.Aggregation, timeout time.Duration) ([]common.SearchHit, int, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	// scores maps candidate ID -> score per query vector, NaN where not yet known
+	scores := make(map[uint64][]float32)
+	candidates := 0
+	for i, vector := range vectors {
+		var remaining time.Duration
+		if !deadline.IsZero() {
+			if remaining = time.Until(deadline); remaining <= 0 {
+				return nil, 0, fmt.Errorf("%w after %s", ErrQueryTimeout, timeout)
+			}
+		}
+
+		subQuery := *query
+		subQuery.Vector = vector
+		searchResult, err := db.searchExpanding(ctx, &subQuery, k*MultiQueryFetchFactor, remaining)
+		if err != nil {
+			return nil, 0, err
+		}
+		candidates = searchResult.Candidates
+
+		for _, hit := range searchResult.Hits() {
+			if _, ok := scores[hit.ID]; !ok {
+				scores[hit.ID] = make([]float32, len(vectors))
+				for q := range scores[hit.ID] {
+					scores[hit.ID][q] = float32(gomath.NaN())
+				}
+			}
+			scores[hit.ID][i] = hit.Score
+		}
+	}
+
+	hits := make([]common.SearchHit, 0, len(scores))
+	for id, idScores := range scores {
+		if !db.completeScores(id, idScores, vectors) {
+			continue
+		}
+		hits = append(hits, common.SearchHit{ID: id, Score: db.aggregate(idScores, aggregation)})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score == hits[j].Score {
+			return hits[i].ID < hits[j].ID
+		}
+		return db.params.MetricType.Better(hits[i].Score, hits[j].Score)
+	})
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+
+	return hits, candidates, nil
+}
+
+// completeScores fills in the NaN scores of candidate id from its stored vector, and
+// returns false if the vector is gone, e.g. deleted since the search
+func (db *VectorDatabase) completeScores(id uint64, scores []float32, vectors [][]float32) bool {
+	var stored []float32
+	for i, score := range scores {
+		if !gomath.IsNaN(float64(score)) {
+			continue
+		}
+		if stored == nil {
+			label, err := common.LabelFromID(id)
+			if err != nil {
+				return false
+			}
+			vector, err := db.vectorIndex.Reconstruct(label)
+			if err != nil {
+				return false
+			}
+			stored = vector
+		}
+		scores[i] = metricScore(db.params.MetricType, vectors[i], stored)
+	}
+	return true
+}
+
+// aggregate combines the scores of a candidate across the query vectors
+func (db *VectorDatabase) aggregate(scores []float32, aggregation common.Aggregation) float32 {
+	if aggregation == common.AggregationMean {
+		var sum float64
+		for _, score := range scores {
+			sum += float64(score)
+		}
+		return float32(sum / float64(len(scores)))
+	}
+
+	best := scores[0]
+	for _, score := range scores[1:] {
+		if db.params.MetricType.Better(score, best) {
+			best = score
+		}
+	}
+	return best
+}
+
+// metricScore returns the score the index reports for vector against query: the squared
+// distance for L2, as FAISS computes it, the inner product for IP, and the cosine similarity
+func metricScore(metric common.MetricType, query, vector []float32) float32 {
+	var dot, sum, queryNorm, vectorNorm float64
+	for i := range query {
+		q, v := float64(query[i]), float64(vector[i])
+		dot += q * v
+		sum += (q - v) * (q - v)
+		queryNorm += q * q
+		vectorNorm += v * v
+	}
+
+	switch metric {
+	case common.MetricTypeL2:
+		return float32(sum)
+	case common.MetricTypeCosine:
+		if queryNorm == 0 || vectorNorm == 0 {
+			return 0
+		}
+		return float32(dot / gomath.Sqrt(queryNorm*vectorNorm))
+	default:
+		return float32(dot)
+	}
+}
@@ -0,0 +1,69 @@
+package vecdb
+
+import (
+	"fmt"
+	"os"
+
+	"vecdb-go/internal/common"
+)
+
+// Default HNSW parameters used by Open when Config.Hnsw is nil
+const (
+	DefaultHnswEFConstruction = 200
+	DefaultHnswM              = 16
+)
+
+// Config describes a database for Open, so that it can be embedded as a library without
+// a config file or the HTTP server. Only Path and Dim are required.
+type Config struct {
+	// Path is the directory holding the database files; it is created if missing
+	Path string
+	// Dim is the dimension of every vector
+	Dim int
+	// Metric defaults to common.MetricTypeL2
+	Metric common.MetricType
+	// Index defaults to common.IndexTypeFlat
+	Index common.IndexType
+	// Hnsw holds the parameters of an HNSW index, defaulting to DefaultHnswEFConstruction
+	// and DefaultHnswM
+	Hnsw *common.HnswIndexOption
+	// Options sets the remaining database parameters, such as the encoder, timeouts or
+	// read-only mode; its FilePath, Dim, MetricType, IndexType and HnswParams are ignored
+	Options common.DatabaseParams
+}
+
+// Open opens the database described by cfg, creating it if the directory is empty.
+// It is the entry point for using the database in-process; close it with Close.
+func Open(cfg Config) (*VectorDatabase, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("%w: database path is required", ErrInvalidArgument)
+	}
+
+	params := cfg.Options
+	params.FilePath = cfg.Path
+	params.Dim = cfg.Dim
+	params.MetricType = cfg.Metric
+	if params.MetricType == "" {
+		params.MetricType = common.MetricTypeL2
+	}
+	params.IndexType = cfg.Index
+	if params.IndexType == "" {
+		params.IndexType = common.IndexTypeFlat
+	}
+	params.HnswParams = cfg.Hnsw
+	if params.IndexType == common.IndexTypeHnsw && params.HnswParams == nil {
+		params.HnswParams = &common.HnswIndexOption{
+			EFConstruction: DefaultHnswEFConstruction,
+			M:              DefaultHnswM,
+		}
+	}
+
+	// A read-only database must already exist, so its directory is never created
+	if !params.ReadOnly {
+		if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	return NewVectorDatabase(&params)
+}
@@ -0,0 +1,20 @@
+package vecdb
+
+import "vecdb-go/internal/common"
+
+// postFilterHits keeps the hits whose documents postFilter accepts, in order; hits whose
+// document is missing are dropped
+func postFilterHits(hits []common.SearchHit, docs []common.DocMap, postFilter common.PostFilter) ([]common.SearchHit, []common.DocMap) {
+	keptHits := make([]common.SearchHit, 0, len(hits))
+	keptDocs := make([]common.DocMap, 0, len(docs))
+
+	for i, doc := range docs {
+		if doc == nil || !postFilter(doc, hits[i].Score) {
+			continue
+		}
+		keptHits = append(keptHits, hits[i])
+		keptDocs = append(keptDocs, doc)
+	}
+
+	return keptHits, keptDocs
+}
@@ -0,0 +1,103 @@
+package vecdb
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vecdb-go/internal/common"
+)
+
+// RunningQuery describes a vector search in flight
+type RunningQuery struct {
+	ID      uint64    `json:"id"`
+	Started time.Time `json:"started"`
+	K       int       `json:"k"`
+	Vectors int       `json:"vectors"` // number of query vectors, more than 1 for multi-vector searches
+	Filters int       `json:"filters"`
+}
+
+// queryRegistry tracks the searches in flight so they can be listed and cancelled.
+// Registering a search costs an atomic increment and a sync.Map store, and no lock is
+// shared between searches. The zero value is ready to use.
+type queryRegistry struct {
+	nextID  atomic.Uint64
+	running sync.Map // query ID -> *runningQuery
+}
+
+type runningQuery struct {
+	info   RunningQuery
+	cancel context.CancelFunc
+}
+
+// start registers a search and returns its context, which is cancelled by cancel, and
+// the function that unregisters it once the search is over
+func (r *queryRegistry) start(searchArgs common.VdbSearchArgs) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	vectors := len(searchArgs.Queries)
+	if vectors == 0 {
+		vectors = 1
+	}
+	q := &runningQuery{
+		info: RunningQuery{
+			ID:      r.nextID.Add(1),
+			Started: time.Now(),
+			K:       searchArgs.K,
+			Vectors: vectors,
+			Filters: len(searchArgs.FilterInputs),
+		},
+		cancel: cancel,
+	}
+	r.running.Store(q.info.ID, q)
+
+	return ctx, func() {
+		r.running.Delete(q.info.ID)
+		cancel()
+	}
+}
+
+// list returns the searches in flight, oldest first
+func (r *queryRegistry) list() []RunningQuery {
+	queries := make([]RunningQuery, 0)
+	r.running.Range(func(_, value any) bool {
+		queries = append(queries, value.(*runningQuery).info)
+		return true
+	})
+	slices.SortFunc(queries, func(a, b RunningQuery) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return queries
+}
+
+// cancel cancels the search with the given ID and reports whether it was in flight
+func (r *queryRegistry) cancel(id uint64) bool {
+	value, ok := r.running.Load(id)
+	if !ok {
+		return false
+	}
+	value.(*runningQuery).cancel()
+	return true
+}
+
+// RunningQueries returns the vector searches in flight, oldest first. Queries answered
+// from the query cache never reach the index and are not listed.
+func (db *VectorDatabase) RunningQueries() []RunningQuery {
+	return db.queries.list()
+}
+
+// CancelQuery cancels the vector search with the given ID, which then returns
+// ErrQueryCanceled. It returns ErrNotFound if no search with that ID is in flight.
+// Like a timed-out search, a cancelled FAISS search keeps running in the background until
+// it finishes. It does not take the database lock, so a search holding Close up can
+// still be cancelled.
+func (db *VectorDatabase) CancelQuery(id uint64) error {
+	if !db.queries.cancel(id) {
+		return fmt.Errorf("%w: query %d is not running", ErrNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package vecdb
+
+import (
+	"container/list"
+	"encoding/json"
+	"maps"
+	"sync"
+	"time"
+
+	"vecdb-go/internal/common"
+)
+
+// DefaultQueryCacheTTL is how long a query result stays cached when QueryCacheOption.TTLMs is unset
+const DefaultQueryCacheTTL = time.Second
+
+// queryCache keeps the results of recent queries, so identical queries arriving in a
+// burst run the index search only once. Each result is stored with the persistence
+// epoch it was computed at and is only served while the epoch is unchanged, so any
+// applied write or soft delete invalidates every cached result.
+type queryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // most recently used at the front
+}
+
+type queryCacheEntry struct {
+	key     string
+	epoch   uint64
+	expires time.Time
+	docs    []common.DocMap
+	stats   QueryStats
+}
+
+// newQueryCache returns a cache for the option, or nil if it is disabled
+func newQueryCache(opt *common.QueryCacheOption) *queryCache {
+	if opt == nil || opt.Size <= 0 {
+		return nil
+	}
+
+	ttl := DefaultQueryCacheTTL
+	if opt.TTLMs > 0 {
+		ttl = time.Duration(opt.TTLMs) * time.Millisecond
+	}
+
+	return &queryCache{
+		size:    opt.Size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// queryCacheKey returns the cache key of a query, or false if it cannot be cached.
+// The timeout does not change the result, so it is left out; the default efSearch does.
+func queryCacheKey(searchArgs common.VdbSearchArgs, defaultEfSearch uint32) (string, bool) {
+	// Functions cannot be told apart, so post-filtered results are never cached
+	if searchArgs.PostFilter != nil {
+		return "", false
+	}
+	searchArgs.TimeoutMs = 0
+	key, err := json.Marshal(struct {
+		Args     common.VdbSearchArgs
+		EfSearch uint32
+	}{searchArgs, defaultEfSearch})
+	if err != nil {
+		return "", false
+	}
+	return string(key), true
+}
+
+// get returns a copy of the result cached under key at epoch
+func (c *queryCache) get(key string, epoch uint64) ([]common.DocMap, QueryStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, QueryStats{}, false
+	}
+
+	entry := elem.Value.(*queryCacheEntry)
+	if entry.epoch != epoch || time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, QueryStats{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return copyDocs(entry.docs), entry.stats, true
+}
+
+// put caches a copy of the result computed at epoch, evicting the least recently used
+// result if the cache is full
+func (c *queryCache) put(key string, epoch uint64, docs []common.DocMap, stats QueryStats) {
+	entry := &queryCacheEntry{
+		key:     key,
+		epoch:   epoch,
+		expires: time.Now().Add(c.ttl),
+		docs:    copyDocs(docs),
+		stats:   stats,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryCacheEntry).key)
+	}
+}
+
+// copyDocs copies the result docs, so callers can modify them, e.g. to round scores,
+// without touching the cached ones
+func copyDocs(docs []common.DocMap) []common.DocMap {
+	copied := make([]common.DocMap, len(docs))
+	for i, doc := range docs {
+		copied[i] = maps.Clone(doc)
+	}
+	return copied
+}
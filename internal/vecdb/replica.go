@@ -0,0 +1,143 @@
+package vecdb
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/persistence"
+)
+
+// DefaultReplicaPollInterval is how often a replica checks the primary WAL for new
+// records unless OpenReplica is given another interval
+const DefaultReplicaPollInterval = 100 * time.Millisecond
+
+// ReplicaDatabase is a read-only copy of a database that follows the WAL of the primary
+// database as it grows, for read scaling and as a warm standby. It applies the records
+// appended to the primary WAL to its own indexes and scalar storage, in its own
+// directory, and serves queries like any VectorDatabase; writes fail with ErrReadOnly.
+//
+// Soft deletes are kept in scalar storage rather than the WAL, so a replica does not see
+// them until the primary compacts them away. A replica starts over from the beginning of
+// the primary WAL when it is opened, which, like a primary restart, only restores what
+// the WAL still holds.
+type ReplicaDatabase struct {
+	*VectorDatabase
+
+	tailer *persistence.WALTailer
+	// catchUpMu serializes CatchUp, since a WALTailer is not safe for concurrent use
+	catchUpMu sync.Mutex
+
+	stopFollow chan struct{}
+	stopOnce   sync.Once
+	followDone sync.WaitGroup
+}
+
+// OpenReplica opens a replica of the database in primaryPath, which may also be a
+// directory holding a copy of its WAL that is kept up to date. params describes the
+// replica: FilePath is its own directory, and the dimension, metric and WAL encoder
+// must match the primary's; ReadOnly is ignored. The replica catches up with the
+// primary WAL before OpenReplica returns and then checks it for new records every
+// pollInterval, or DefaultReplicaPollInterval if it is not positive.
+func OpenReplica(primaryPath string, params *common.DatabaseParams, pollInterval time.Duration) (*ReplicaDatabase, error) {
+	if pollInterval <= 0 {
+		pollInterval = DefaultReplicaPollInterval
+	}
+
+	checksum, err := persistence.ParseChecksumAlgorithm(params.WALChecksum)
+	if err != nil {
+		return nil, err
+	}
+	encoder := persistence.EncoderFactory(params.EncoderType, persistence.WALVersion, checksum)
+
+	// The replica writes the primary's records to its own scalar storage, so it is opened
+	// writable and only made read-only once open
+	replicaParams := *params
+	replicaParams.ReadOnly = false
+	db, err := NewVectorDatabase(&replicaParams)
+	if err != nil {
+		return nil, err
+	}
+	replicaParams.ReadOnly = true
+
+	r := &ReplicaDatabase{
+		VectorDatabase: db,
+		tailer:         persistence.NewWALTailer(filepath.Join(primaryPath, WalFileSuffix), encoder),
+		stopFollow:     make(chan struct{}),
+	}
+
+	if _, err := r.CatchUp(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to catch up with primary: %w", err)
+	}
+
+	r.followDone.Add(1)
+	go r.follow(pollInterval)
+
+	return r, nil
+}
+
+// CatchUp applies the records appended to the primary WAL since the last check and
+// returns how many were applied. Records written by the primary become visible to the
+// replica once the primary has flushed them, which Upsert does before it returns.
+func (r *ReplicaDatabase) CatchUp() (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+
+	r.catchUpMu.Lock()
+	defer r.catchUpMu.Unlock()
+
+	records, err := r.tailer.Next()
+	// Records read before a decode error are still applied
+	if applyErr := r.persistence.Replay(
+		records,
+		r.scalarStorage,
+		r.filterIndex,
+		r.vectorIndex,
+		r.params.Dim,
+	); applyErr != nil {
+		return 0, fmt.Errorf("failed to apply primary WAL records: %w", applyErr)
+	}
+	if err != nil {
+		return len(records), fmt.Errorf("failed to read primary WAL: %w", err)
+	}
+
+	return len(records), nil
+}
+
+// follow catches up with the primary WAL every interval until the replica is closed
+func (r *ReplicaDatabase) follow(interval time.Duration) {
+	defer r.followDone.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := r.CatchUp(); err != nil {
+				slog.Warn("Replica failed to catch up with primary", "error", err)
+			} else if n > 0 {
+				slog.Debug("Replica caught up with primary", "records", n)
+			}
+
+		case <-r.stopFollow:
+			return
+		}
+	}
+}
+
+// Close stops following the primary and closes the replica
+func (r *ReplicaDatabase) Close() error {
+	r.stopOnce.Do(func() { close(r.stopFollow) })
+	r.followDone.Wait()
+
+	return r.VectorDatabase.Close()
+}
@@ -0,0 +1,117 @@
+package vecdb
+
+import (
+	"fmt"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/scalar"
+)
+
+// DefaultScanLimit is the page size ScanPage uses when the limit is not positive
+const DefaultScanLimit = 100
+
+// Scan calls fn for every stored document in ID order until fn returns false,
+// skipping soft-deleted ones.
+// It iterates a snapshot taken when the scan starts, so fn may call back into the
+// database; records written with UpsertAsync appear only once they are synced.
+func (db *VectorDatabase) Scan(fn func(id uint64, doc common.DocMap) bool) error {
+	return db.scanFrom(0, 0, fn)
+}
+
+// ScanPage returns up to limit documents with IDs greater than cursor, in ID order,
+// along with the cursor of the next page, which is 0 when there are no more documents.
+// Start with cursor 0 to read from the beginning; a cursor of MaxVectorID or more is
+// rejected with ErrInvalidArgument.
+func (db *VectorDatabase) ScanPage(cursor uint64, limit int) ([]common.DocMap, uint64, error) {
+	if cursor >= common.MaxVectorID {
+		return nil, 0, fmt.Errorf("%w: cursor %d must be below %d", ErrInvalidArgument, cursor, uint64(common.MaxVectorID))
+	}
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	docs := make([]common.DocMap, 0, limit)
+	var next uint64
+	// Reading one document past the page tells whether another page follows
+	err := db.scanFrom(cursor+1, limit+1, func(id uint64, doc common.DocMap) bool {
+		if len(docs) == limit {
+			// One more document exists, so the last one returned is the next cursor
+			next = cursor
+			return false
+		}
+		docs = append(docs, doc)
+		cursor = id
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return docs, next, nil
+}
+
+// scanFrom calls fn for every stored document with an ID of at least start, in ID order.
+// A positive chunk reads the documents that many at a time, so a caller stopping early
+// reads about as many as it consumed; otherwise all of them are read up front.
+func (db *VectorDatabase) scanFrom(start uint64, chunk int, fn func(id uint64, doc common.DocMap) bool) error {
+	for start <= common.MaxVectorID {
+		iter, err := db.scanIterator(start, chunk)
+		if err != nil {
+			return err
+		}
+
+		read, from := 0, start
+		for pair := range iter {
+			read++
+			// Skip keys that are not document IDs, such as the ID counter
+			id, err := scalar.DecodeIDChecked(pair.Key)
+			if err != nil {
+				continue
+			}
+			start = id + 1
+			if db.isSoftDeleted(id) {
+				continue
+			}
+
+			doc, err := common.DecodeDoc(pair.Value)
+			if err != nil {
+				return fmt.Errorf("failed to deserialize doc for id %d: %w", id, err)
+			}
+
+			if !fn(id, doc) {
+				return nil
+			}
+		}
+
+		// A chunk that came back short was the end of the documents, and one without any
+		// document ID cannot move the scan forward
+		if chunk <= 0 || read < chunk || start == from {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// scanIterator snapshots up to limit documents with an ID of at least start, all of them
+// if limit is not positive; the lock is released before the snapshot is iterated
+func (db *VectorDatabase) scanIterator(start uint64, limit int) (scalar.ScalarIterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	iter, err := db.scalarStorage.RangeIterator(
+		scalar.NamespaceDocs,
+		scalar.EncodeID(start),
+		scalar.EncodeID(common.MaxVectorID),
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan docs: %w", err)
+	}
+
+	return iter, nil
+}
@@ -0,0 +1,133 @@
+package vecdb
+
+import (
+	"fmt"
+	"log/slog"
+
+	"vecdb-go/internal/filter"
+	"vecdb-go/internal/scalar"
+)
+
+// SoftDelete marks the vectors with the given IDs as deleted without removing them:
+// searches leave them out and their documents are no longer returned, while the
+// vectors, documents and attributes stay in place until Compact removes them. This is
+// much cheaper than Delete, which rewrites every index. The marks are stored in scalar
+// storage, so they survive restarts. The IDs also leave the filter index, so that
+// DeleteByFilter and FilterFields no longer count them.
+//
+// Only live vectors are marked, and SoftDelete returns how many were newly marked. An ID
+// not assigned yet is skipped, since its mark would hide the document that later gets
+// the ID; so are those of records written with UpsertAsync and not synced yet.
+func (db *VectorDatabase) SoftDelete(ids []uint64) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	db.tombstoneMu.Lock()
+	defer db.tombstoneMu.Unlock()
+
+	marked := filter.NewIdFilter()
+	for _, id := range ids {
+		if db.IsLive(id) {
+			marked.Add(id)
+		}
+	}
+	if marked.IsEmpty() {
+		return 0, nil
+	}
+	ids = marked.IDs()
+
+	db.tombstoneEpoch.Add(1)
+	defer db.tombstoneEpoch.Add(1)
+
+	keys := make([][]byte, len(ids))
+	values := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = scalar.EncodeID(id)
+		values[i] = []byte{1}
+	}
+	if err := db.scalarStorage.MultiPut(scalar.NamespaceTombstones, keys, values); err != nil {
+		return 0, fmt.Errorf("failed to store soft deletes: %w", err)
+	}
+
+	// Searches may hold the current set, so publish a new one instead of changing it
+	tombstones := db.tombstones.Load().Clone()
+	tombstones.AddAll(ids)
+	db.tombstones.Store(tombstones)
+	db.filterIndex.RemoveIDs(marked)
+
+	slog.Info("Soft deleted vector data", "ids", ids)
+	return len(ids), nil
+}
+
+// Compact removes the soft-deleted vectors like Delete and clears their marks,
+// returning how many were removed
+func (db *VectorDatabase) Compact() (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	db.tombstoneMu.Lock()
+	defer db.tombstoneMu.Unlock()
+
+	ids := db.tombstones.Load().IDs()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := db.deleteIDs(ids); err != nil {
+		return 0, err
+	}
+
+	// The vectors are gone, so a failure here only leaves marks that match nothing
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = scalar.EncodeID(id)
+	}
+	if err := db.scalarStorage.MultiDelete(scalar.NamespaceTombstones, keys); err != nil {
+		return 0, fmt.Errorf("failed to clear soft deletes: %w", err)
+	}
+	db.tombstoneEpoch.Add(1)
+	db.tombstones.Store(filter.NewIdFilter())
+	db.tombstoneEpoch.Add(1)
+
+	slog.Info("Compacted soft deleted vector data", "count", len(ids))
+	return len(ids), nil
+}
+
+// isSoftDeleted reports whether id is marked by SoftDelete
+func (db *VectorDatabase) isSoftDeleted(id uint64) bool {
+	return db.tombstones.Load().Filter(id)
+}
+
+// loadTombstones reads the IDs marked by SoftDelete from scalar storage
+func loadTombstones(scalarStorage scalar.ScalarStorage) (*filter.IdFilter, error) {
+	iter, err := scalarStorage.Iterator(scalar.NamespaceTombstones)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read soft deletes: %w", err)
+	}
+
+	tombstones := filter.NewIdFilter()
+	for pair := range iter {
+		id, err := scalar.DecodeIDChecked(pair.Key)
+		if err != nil {
+			continue
+		}
+		tombstones.Add(id)
+	}
+	return tombstones, nil
+}
@@ -0,0 +1,84 @@
+package vecdb
+
+import "vecdb-go/internal/persistence"
+
+// OpStats counts applied inserts and deletes and WAL bytes written, with the recent ingest
+// rate, the attributes rejected for exceeding the filter index limits, the scalar
+// storage compactions run and the estimated memory use
+type OpStats struct {
+	persistence.OpStats
+	FilterLimitRejections uint64      `json:"filter_limit_rejections"`
+	StorageCompactions    uint64      `json:"storage_compactions"`
+	Memory                MemoryStats `json:"memory"`
+}
+
+// MemoryStats estimates the bytes of RAM the in-memory structures of the database use.
+// Document storage is left out, since NutsDB keeps it on disk.
+type MemoryStats struct {
+	VectorIndexBytes uint64 `json:"vector_index_bytes"` // vectors held by FAISS, at 4 bytes per dimension
+	FilterIndexBytes uint64 `json:"filter_index_bytes"` // attribute bitmaps
+	PendingWALBytes  uint64 `json:"pending_wal_bytes"`  // WAL records not yet synced
+	TotalBytes       uint64 `json:"total_bytes"`
+}
+
+// OpStats returns the number of inserts and deletes applied and WAL bytes written since
+// the database was opened, along with the operations applied per second over the last
+// persistence.StatsWindow. Records replayed from the WAL on open count as applied, and
+// records written with UpsertAsync count once they are synced.
+// FilterLimitRejections counts the upserts rejected with ErrFilterLimit, and
+// StorageCompactions the CompactStorage calls and background compactions that succeeded.
+// Memory is estimated as in MemoryStats.
+func (db *VectorDatabase) OpStats() (OpStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return OpStats{}, ErrDatabaseClosed
+	}
+
+	return OpStats{
+		OpStats:               db.persistence.Stats(),
+		FilterLimitRejections: db.filterIndex.LimitRejections(),
+		StorageCompactions:    db.storageCompactions.Load(),
+		Memory:                db.memoryStats(),
+	}, nil
+}
+
+// PendingRecords summarizes the WAL records not yet applied to the indexes and storage,
+// which searches do not see until the next sync, and lists up to limit of them
+func (db *VectorDatabase) PendingRecords(limit int) (persistence.PendingSnapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return persistence.PendingSnapshot{}, ErrDatabaseClosed
+	}
+
+	return db.persistence.PendingRecords(limit), nil
+}
+
+// MemoryStats estimates the memory used by the vector index, the filter index and the
+// pending WAL records. The vector index is sized as a flat index, from the number of
+// vectors FAISS holds and the dimension; an HNSW index needs more for its graph links,
+// and a compressing custom index less.
+func (db *VectorDatabase) MemoryStats() (MemoryStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return MemoryStats{}, ErrDatabaseClosed
+	}
+
+	return db.memoryStats(), nil
+}
+
+// memoryStats estimates the memory use (caller must hold read lock)
+func (db *VectorDatabase) memoryStats() MemoryStats {
+	stats := MemoryStats{
+		VectorIndexBytes: uint64(db.vectorIndex.Ntotal()) * uint64(db.params.Dim) * 4,
+		FilterIndexBytes: db.filterIndex.SizeInBytes(),
+		PendingWALBytes:  db.persistence.PendingBytes(),
+	}
+	stats.TotalBytes = stats.VectorIndexBytes + stats.FilterIndexBytes + stats.PendingWALBytes
+	return stats
+}
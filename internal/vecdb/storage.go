@@ -0,0 +1,57 @@
+package vecdb
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// CompactStorage merges the scalar storage files, dropping the entries that deleted and
+// replaced documents leave behind, to reclaim their disk space. NutsDB only merges once
+// it has more than one data file, so a small database is left as it is. Writes to
+// scalar storage wait while the merge runs, so it is best run when the database is idle.
+func (db *VectorDatabase) CompactStorage() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	if db.params.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return db.compactStorage()
+}
+
+// compactStorage merges the scalar storage files (caller must hold read lock, or be the
+// background sync goroutine, which Close waits for)
+func (db *VectorDatabase) compactStorage() error {
+	start := time.Now()
+	if err := db.scalarStorage.Merge(); err != nil {
+		return fmt.Errorf("failed to compact scalar storage: %w", err)
+	}
+	db.storageGarbage.Store(0)
+	db.storageCompactions.Add(1)
+
+	slog.Info("Compacted scalar storage", "took", time.Since(start))
+	return nil
+}
+
+// countStorageGarbage counts n documents deleted or replaced and asks the background sync
+// goroutine to compact the storage once CompactStorageAfter of them have accumulated
+func (db *VectorDatabase) countStorageGarbage(n int) {
+	after := db.params.CompactStorageAfter
+	if after <= 0 || n <= 0 {
+		return
+	}
+	if db.storageGarbage.Add(int64(n)) < int64(after) {
+		return
+	}
+
+	select {
+	case db.compactNow <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,99 @@
+package vecdb
+
+import (
+	"fmt"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/scalar"
+)
+
+// QueryStream searches the vector database like QueryWithStats, but instead of collecting
+// the results it calls fn with each one, best-first, as soon as its document is fetched
+// from scalar storage, and stops at the first error fn returns.
+// Grouped queries and ids-only queries that need no documents are run in full before the
+// first call, since there is nothing to gain from streaming them. Streamed queries bypass
+// the query cache. The database read lock is taken for the search and for each document
+// but released while fn runs, so a slow fn, such as a write to a slow client, does not
+// hold up Close.
+func (db *VectorDatabase) QueryStream(searchArgs common.VdbSearchArgs, fn func(common.DocMap) error) (QueryStats, error) {
+	k := searchArgs.K
+	results, hits, stats, err := db.streamSearch(&searchArgs)
+	if err != nil {
+		return QueryStats{}, err
+	}
+	for _, result := range results {
+		if err := fn(result); err != nil {
+			return stats, err
+		}
+	}
+
+	sent := 0
+	for _, hit := range hits {
+		if sent == k {
+			break
+		}
+
+		doc, err := db.streamDoc(hit.ID)
+		if err != nil {
+			return stats, err
+		}
+		if searchArgs.PostFilter != nil && (doc == nil || !searchArgs.PostFilter(doc, hit.Score)) {
+			continue
+		}
+
+		result := resultDoc(doc, hit, searchArgs)
+		if searchArgs.NormalizeScores {
+			db.normalizeScores([]common.DocMap{result})
+		}
+		if err := fn(result); err != nil {
+			return stats, err
+		}
+		sent++
+	}
+
+	return stats, nil
+}
+
+// streamSearch runs the search of QueryStream under the read lock. Queries that are not
+// streamed return their results, the others the hits whose documents are to be fetched;
+// searchArgs.K is raised to fetch enough hits for a post-filter.
+func (db *VectorDatabase) streamSearch(searchArgs *common.VdbSearchArgs) ([]common.DocMap, []common.SearchHit, QueryStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, nil, QueryStats{}, ErrDatabaseClosed
+	}
+
+	if searchArgs.GroupBy != "" || (searchArgs.IDsOnly && !searchArgs.Explain && searchArgs.PostFilter == nil) {
+		results, stats, err := db.queryCached(*searchArgs)
+		return results, nil, stats, err
+	}
+
+	if searchArgs.PostFilter != nil {
+		searchArgs.K *= db.params.EffectivePostFilterFetchFactor()
+	}
+
+	hits, candidates, err := db.search(*searchArgs)
+	if err != nil {
+		return nil, nil, QueryStats{}, err
+	}
+	return nil, hits, QueryStats{Candidates: candidates}, nil
+}
+
+// streamDoc fetches the document of a streamed hit under the read lock; it is nil if
+// the document is gone
+func (db *VectorDatabase) streamDoc(id uint64) (common.DocMap, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	doc, err := db.scalarStorage.GetValue(scalar.NamespaceDocs, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve document %d: %w", id, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,104 @@
+package vecdb
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"vecdb-go/internal/filter"
+	"vecdb-go/internal/scalar"
+)
+
+// VerifyReport describes how the vector index, the stored documents and the filter
+// index agree. Every vector is expected to have a stored document and every ID in the
+// filter index a vector; the lists hold the IDs that break this, in ascending order.
+type VerifyReport struct {
+	Vectors           int      `json:"vectors"`             // vectors in the index
+	Docs              int      `json:"docs"`                // stored documents
+	MissingDocs       []uint64 `json:"missing_docs"`        // vectors without a stored document
+	OrphanDocs        []uint64 `json:"orphan_docs"`         // stored documents without a vector
+	DanglingFilterIDs []uint64 `json:"dangling_filter_ids"` // IDs in the filter index without a vector
+	Repaired          bool     `json:"repaired"`            // whether the IDs above were deleted
+}
+
+// Consistent reports whether Verify found no inconsistency
+func (r *VerifyReport) Consistent() bool {
+	return len(r.MissingDocs) == 0 && len(r.OrphanDocs) == 0 && len(r.DanglingFilterIDs) == 0
+}
+
+// Verify cross-checks the vector index against the stored documents and the filter
+// index, which a crash or a bug can leave disagreeing. Pending WAL records are synced
+// first. With repair set, every inconsistent ID is deleted as by Delete, which removes
+// whatever is left of it from all three and logs the delete in the WAL. Verify holds
+// the database lock exclusively, so other operations wait until it finishes.
+func (db *VectorDatabase) Verify(repair bool) (*VerifyReport, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	if repair && db.params.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	if db.persistence.GetPendingCount() > 0 {
+		if err := db.persistence.Sync(
+			db.scalarStorage,
+			db.filterIndex,
+			db.vectorIndex,
+			db.params.Dim,
+		); err != nil {
+			return nil, fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	}
+
+	vectors := filter.NewIdFilter()
+	for _, label := range db.vectorIndex.Labels() {
+		vectors.Add(uint64(label))
+	}
+
+	iter, err := db.scalarStorage.Iterator(scalar.NamespaceDocs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read documents: %w", err)
+	}
+	docs := filter.NewIdFilter()
+	for pair := range iter {
+		// Skip keys that are not document IDs, such as the ID counter
+		id, err := scalar.DecodeIDChecked(pair.Key)
+		if err != nil {
+			continue
+		}
+		docs.Add(id)
+	}
+
+	report := &VerifyReport{
+		Vectors:           vectors.Len(),
+		Docs:              docs.Len(),
+		MissingDocs:       vectors.Without(docs).IDs(),
+		OrphanDocs:        docs.Without(vectors).IDs(),
+		DanglingFilterIDs: db.filterIndex.IDs().Without(vectors).IDs(),
+	}
+	if report.Consistent() {
+		return report, nil
+	}
+
+	slog.Warn("Found inconsistent vector data",
+		"missing_docs", len(report.MissingDocs),
+		"orphan_docs", len(report.OrphanDocs),
+		"dangling_filter_ids", len(report.DanglingFilterIDs))
+
+	if !repair {
+		return report, nil
+	}
+
+	ids := slices.Concat(report.MissingDocs, report.OrphanDocs, report.DanglingFilterIDs)
+	slices.Sort(ids)
+	if err := db.deleteIDs(slices.Compact(ids)); err != nil {
+		return nil, fmt.Errorf("failed to repair: %w", err)
+	}
+	report.Repaired = true
+
+	return report, nil
+}
@@ -0,0 +1,50 @@
+package vecdb
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/index"
+)
+
+const (
+	defaultWarmUpQueries = 32
+	defaultWarmUpTimeout = time.Second
+	warmUpK              = 10
+)
+
+// warmUp runs random searches against the vector index until the configured number
+// of queries has run or the timeout has passed, returning the number of queries run
+func (db *VectorDatabase) warmUp(opt *common.WarmUpOption) int {
+	queries := opt.Queries
+	if queries <= 0 {
+		queries = defaultWarmUpQueries
+	}
+	timeout := defaultWarmUpTimeout
+	if opt.TimeoutMs > 0 {
+		timeout = time.Duration(opt.TimeoutMs) * time.Millisecond
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	rng := rand.New(rand.NewPCG(uint64(start.UnixNano()), 0))
+
+	ran := 0
+	for ran < queries && time.Now().Before(deadline) {
+		vector := make([]float32, db.params.Dim)
+		for i := range vector {
+			vector[i] = rng.Float32()*2 - 1
+		}
+
+		if _, err := db.vectorIndex.Search(index.NewSearchQuery(vector), warmUpK); err != nil {
+			slog.Warn("Warm-up query failed, stopping warm-up", "error", err)
+			break
+		}
+		ran++
+	}
+
+	slog.Info("Warmed up vector index", "queries", ran, "duration", time.Since(start))
+	return ran
+}
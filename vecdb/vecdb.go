@@ -0,0 +1,62 @@
+// Package vecdb is the public entry point for using the vector database in-process.
+// It re-exports the database, its Open function and the argument types it takes from the
+// internal packages, which other modules cannot import.
+package vecdb
+
+import (
+	"vecdb-go/internal/common"
+	"vecdb-go/internal/common/math"
+	"vecdb-go/internal/vecdb"
+)
+
+// DB is an open vector database; close it with Close
+type DB = vecdb.VectorDatabase
+
+// Config describes a database for Open. Only Path and Dim are required.
+type Config = vecdb.Config
+
+// Types of the arguments and results of DB methods
+type (
+	Params      = common.DatabaseParams
+	HnswOption  = common.HnswIndexOption
+	MetricType  = common.MetricType
+	IndexType   = common.IndexType
+	Matrix32    = math.Matrix32
+	Doc         = common.DocMap
+	UpsertArgs  = common.VdbUpsertArgs
+	SearchArgs  = common.VdbSearchArgs
+	FilterInput = common.IntFilterInput
+	QueryStats  = vecdb.QueryStats
+)
+
+// Metric and index types of Config
+const (
+	MetricL2     = common.MetricTypeL2
+	MetricIP     = common.MetricTypeIP
+	MetricCosine = common.MetricTypeCosine
+
+	IndexFlat   = common.IndexTypeFlat
+	IndexHnsw   = common.IndexTypeHnsw
+	IndexCustom = common.IndexTypeCustom
+)
+
+// Reserved fields of the documents returned by DB
+const (
+	DocFieldID         = common.DocFieldID
+	DocFieldAttributes = common.DocFieldAttributes
+	DocFieldScore      = common.DocFieldScore
+)
+
+// Errors returned by DB; match them with errors.Is
+var (
+	ErrClosed          = vecdb.ErrClosed
+	ErrDimMismatch     = vecdb.ErrDimMismatch
+	ErrNotFound        = vecdb.ErrNotFound
+	ErrInvalidArgument = vecdb.ErrInvalidArgument
+	ErrReadOnly        = vecdb.ErrReadOnly
+)
+
+// Open opens the database described by cfg, creating it if the directory is empty
+func Open(cfg Config) (*DB, error) {
+	return vecdb.Open(cfg)
+}
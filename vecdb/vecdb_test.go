@@ -0,0 +1,31 @@
+package vecdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	db, err := Open(Config{Path: t.TempDir(), Dim: 2, Metric: MetricIP})
+	require.NoError(t, err)
+
+	err = db.Upsert(UpsertArgs{
+		Vectors: Matrix32{Rows: 2, Cols: 2, Data: []float32{1, 0, 0, 1}},
+		Docs:    []map[string]any{{"title": "x"}, {"title": "y"}},
+	})
+	require.NoError(t, err)
+
+	results, err := db.Query(SearchArgs{Query: []float32{0, 1}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "y", results[0]["title"])
+
+	require.NoError(t, db.Close())
+	_, err = db.Query(SearchArgs{Query: []float32{0, 1}, K: 1})
+	assert.ErrorIs(t, err, ErrClosed)
+
+	_, err = Open(Config{Dim: 2})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}